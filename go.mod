@@ -7,6 +7,7 @@ require (
 	github.com/go-playground/validator/v10 v10.24.0
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-sqlite3 v1.14.32
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/ulule/limiter/v3 v3.11.2
 	golang.org/x/crypto v0.32.0
 	gorm.io/driver/sqlite v1.5.7
@@ -2,7 +2,6 @@ package app
 
 import (
 	"embed"
-	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
@@ -21,57 +20,162 @@ type Application struct {
 	Database             *config.Database
 	Cache               *cache.Cache
 	AuthService          *services.AuthService
+	TwoFactorService     *services.TwoFactorService
+	LockoutService       *services.LockoutService
 	SessionService       *services.SessionService
 	ActivityService      *services.ActivityService
 	PasswordResetService *services.PasswordResetService
+	InvitationService    *services.InvitationService
+	UserArchiveService   *services.UserArchiveService
+	UserImportService    *services.UserImportService
+	UserExportService    *services.UserExportService
+	CompanyService       *services.CompanyService
+	APITokenService      *services.APITokenService
+	SSOService           *services.SSOService
+	PasskeyService       *services.PasskeyService
+	PermissionService    *services.PermissionService
+	ImpersonationService *services.ImpersonationService
+	SecurityAlertService *services.SecurityAlertService
+	IPRuleService        *services.IPRuleService
+	RateLimitService     *services.RateLimitService
 	CachedStatsService   *services.CachedStatsService
 	
 	WebAuthController      *controllers.WebAuthController
 	WebDashboardController *controllers.WebDashboardController
 	WebUserController      *controllers.WebUserController
+	WebPasswordResetController *controllers.WebPasswordResetController
+	WebInvitationController *controllers.WebInvitationController
+	WebUserImportController *controllers.WebUserImportController
+	WebUserExportController *controllers.WebUserExportController
+	WebAPITokenController  *controllers.WebAPITokenController
+	WebPasskeyController   *controllers.WebPasskeyController
+	WebRoleController      *controllers.WebRoleController
+	WebRateLimitController *controllers.WebRateLimitController
+	WebIPRuleController    *controllers.WebIPRuleController
+	WebCompanyController   *controllers.WebCompanyController
+	
+	AuthController          *controllers.AuthController
+	UserController          *controllers.UserController
+	ActivityController      *controllers.ActivityController
+	PasswordResetController *controllers.PasswordResetController
 	
 	AuthMiddleware *middleware.AuthMiddleware
 	WebMiddleware  *middleware.WebMiddleware
+	RateLimiter    *middleware.RateLimiter
 	
 	templatesFS embed.FS
 	staticFS    embed.FS
 }
 
 func New(dbPath string, templatesFS, staticFS embed.FS) (*Application, error) {
+	passwordPolicy := config.LoadPasswordPolicy()
+	models.SetPasswordPolicy(passwordPolicy)
+	middleware.SetSecureCookies(config.SecureCookies())
+	
 	database, err := config.NewDatabase(dbPath)
 	if err != nil {
 		return nil, err
 	}
 	
+	appKey, err := config.LoadAppKey(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	
 	// Initialize cache with 5-minute cleanup interval
 	appCache := cache.New(5 * time.Minute)
 	
+	mailer := config.LoadMailer()
 	sessionService := services.NewSessionService(database.DB)
 	activityService := services.NewActivityService(database.DB)
-	passwordResetService := services.NewPasswordResetService(database.DB, activityService)
-	authService := services.NewAuthService(database.DB, sessionService, activityService)
+	passwordPolicyService := services.NewPasswordPolicyService(database.DB)
+	passwordResetService := services.NewPasswordResetService(database.DB, sessionService, activityService, passwordPolicyService, mailer, config.BaseURL())
+	userArchiveService := services.NewUserArchiveService(database.DB, activityService, config.DeletedUserRetention())
+	invitationService := services.NewInvitationService(database.DB, activityService, passwordPolicyService, mailer, config.BaseURL(), appKey)
+	userImportService := services.NewUserImportService(database.DB, activityService, invitationService)
+	userExportService := services.NewUserExportService(database.DB, activityService)
+	companyService := services.NewCompanyService(database.DB, activityService)
+	passwordPolicy.CompanyNames = companyService.Names
+	models.SetPasswordPolicy(passwordPolicy)
+	twoFactorService := services.NewTwoFactorService(database.DB, activityService, appKey)
+	lockoutService := services.NewLockoutService(database.DB, activityService, config.LoadLockoutPolicy())
+	securityAlertService := services.NewSecurityAlertService(database.DB, sessionService, activityService, mailer, config.BaseURL(), config.LoadSecurityAlertPolicy())
+	ipRuleService := services.NewIPRuleService(database.DB, sessionService, activityService, config.IPRuleBreakGlassEmail())
+	authService := services.NewAuthService(database.DB, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, securityAlertService, ipRuleService)
+	apiTokenService := services.NewAPITokenService(database.DB, activityService)
+	ssoService := services.NewSSOService(database.DB, authService, activityService, config.LoadSSOProviders())
+	permissionService := services.NewPermissionService(database.DB, activityService)
+	impersonationService := services.NewImpersonationService(database.DB, sessionService, activityService)
+	rateLimitService := services.NewRateLimitService(database.DB, activityService)
+	passkeyService := services.NewPasskeyService(database.DB, authService, activityService, config.LoadWebAuthn())
 	cachedStatsService := services.NewCachedStatsService(database.DB, appCache)
+	rateLimiter := middleware.NewRateLimiter(rateLimitService, config.LoadRateLimitPolicies())
 	
-	webAuthController := controllers.NewWebAuthController(authService)
-	webDashboardController := controllers.NewWebDashboardController(database.DB, activityService)
-	webUserController := controllers.NewWebUserController(database.DB, activityService, passwordResetService)
+	webAuthController := controllers.NewWebAuthController(authService, twoFactorService, sessionService, activityService, ssoService, impersonationService, securityAlertService)
+	webDashboardController := controllers.NewWebDashboardController(database.DB, activityService, securityAlertService)
+	webUserController := controllers.NewWebUserController(database.DB, activityService, passwordResetService, lockoutService, sessionService, impersonationService, invitationService, userArchiveService, companyService)
+	webPasswordResetController := controllers.NewWebPasswordResetController(passwordResetService)
+	webInvitationController := controllers.NewWebInvitationController(invitationService)
+	webUserImportController := controllers.NewWebUserImportController(userImportService)
+	webUserExportController := controllers.NewWebUserExportController(userExportService)
+	webAPITokenController := controllers.NewWebAPITokenController(apiTokenService)
+	webPasskeyController := controllers.NewWebPasskeyController(passkeyService)
+	webRoleController := controllers.NewWebRoleController(permissionService)
+	webRateLimitController := controllers.NewWebRateLimitController(rateLimitService, rateLimiter)
+	webIPRuleController := controllers.NewWebIPRuleController(ipRuleService, companyService)
+	webCompanyController := controllers.NewWebCompanyController(companyService)
 	
-	authMiddleware := middleware.NewAuthMiddleware(authService, activityService)
+	authController := controllers.NewAuthController(authService)
+	userController := controllers.NewUserController(database.DB, activityService, passwordResetService, invitationService, userArchiveService, companyService)
+	activityController := controllers.NewActivityController(activityService)
+	passwordResetController := controllers.NewPasswordResetController(database.DB, passwordResetService)
+	
+	authMiddleware := middleware.NewAuthMiddleware(authService, activityService, apiTokenService, impersonationService, ipRuleService)
 	webMiddleware := middleware.NewWebMiddleware()
 	
 	app := &Application{
 		Database:                database,
 		Cache:                   appCache,
 		AuthService:             authService,
+		TwoFactorService:        twoFactorService,
+		LockoutService:          lockoutService,
 		SessionService:          sessionService,
 		ActivityService:         activityService,
 		PasswordResetService:    passwordResetService,
+		InvitationService:       invitationService,
+		UserArchiveService:      userArchiveService,
+		UserImportService:       userImportService,
+		UserExportService:       userExportService,
+		CompanyService:          companyService,
+		APITokenService:         apiTokenService,
+		SSOService:              ssoService,
+		PasskeyService:          passkeyService,
+		PermissionService:       permissionService,
+		ImpersonationService:    impersonationService,
+		SecurityAlertService:    securityAlertService,
+		IPRuleService:           ipRuleService,
+		RateLimitService:        rateLimitService,
 		CachedStatsService:      cachedStatsService,
 		WebAuthController:       webAuthController,
 		WebDashboardController:  webDashboardController,
 		WebUserController:       webUserController,
+		WebPasswordResetController: webPasswordResetController,
+		WebInvitationController: webInvitationController,
+		WebUserImportController: webUserImportController,
+		WebUserExportController: webUserExportController,
+		WebAPITokenController:   webAPITokenController,
+		WebPasskeyController:    webPasskeyController,
+		WebRoleController:       webRoleController,
+		WebRateLimitController:  webRateLimitController,
+		WebIPRuleController:     webIPRuleController,
+		WebCompanyController:    webCompanyController,
+		AuthController:          authController,
+		UserController:          userController,
+		ActivityController:      activityController,
+		PasswordResetController: passwordResetController,
 		AuthMiddleware:          authMiddleware,
 		WebMiddleware:           webMiddleware,
+		RateLimiter:             rateLimiter,
 		templatesFS:             templatesFS,
 		staticFS:                staticFS,
 	}
@@ -80,6 +184,14 @@ func New(dbPath string, templatesFS, staticFS embed.FS) (*Application, error) {
 		log.Printf("Warning: Failed to seed database: %v", err)
 	}
 	
+	if err := permissionService.Load(); err != nil {
+		return nil, err
+	}
+	
+	if err := twoFactorService.EncryptStoredSecrets(); err != nil {
+		return nil, err
+	}
+	
 	go app.startBackgroundTasks()
 	
 	return app, nil
@@ -87,8 +199,11 @@ func New(dbPath string, templatesFS, staticFS embed.FS) (*Application, error) {
 
 func (app *Application) SetupRoutes(r *gin.Engine) {
 	// Load embedded templates
-	templ := template.Must(template.New("").ParseFS(app.templatesFS, "templates/**/*.html"))
-	r.SetHTMLTemplate(templ)
+	renderer, err := newPageRenderer(app.templatesFS, "templates", templateFuncs())
+	if err != nil {
+		log.Fatalf("Failed to load templates: %v", err)
+	}
+	r.HTMLRender = renderer
 	log.Printf("Loaded embedded template files")
 	
 	// Serve embedded static files
@@ -119,44 +234,180 @@ func (app *Application) SetupRoutes(r *gin.Engine) {
 	r.Use(middleware.StaticFileHeaders())
 	r.Use(middleware.SecurityHeaders())
 	r.Use(middleware.InputSanitizer())
+	r.Use(middleware.RefreshPermissions(app.PermissionService))
 	r.Use(app.WebMiddleware.FlashMessages())
 	r.Use(app.AuthMiddleware.OptionalAuth())
 	r.Use(app.AuthMiddleware.ActivityLogger())
 
 	// Authentication routes
-	r.GET("/login", app.WebAuthController.ShowLogin)
-	r.POST("/login", middleware.LoginRateLimit(), app.WebAuthController.HandleLogin)
-	r.GET("/logout", app.WebAuthController.HandleLogout)
+	r.GET("/login", middleware.CSRFProtection(), app.WebAuthController.ShowLogin)
+	r.POST("/login", app.RateLimiter.Limit("login", "login_ip"), middleware.CSRFProtection(), app.WebAuthController.HandleLogin)
+	r.GET("/login/two-factor", middleware.CSRFProtection(), app.WebAuthController.ShowTwoFactorLogin)
+	r.POST("/login/two-factor", app.RateLimiter.Limit("sign_in"), middleware.CSRFProtection(), app.WebAuthController.HandleTwoFactorLogin)
+	r.GET("/login/sso/:provider", app.RateLimiter.Limit("sign_in"), app.WebAuthController.HandleSSOLogin)
+	r.GET("/login/sso/:provider/callback", app.RateLimiter.Limit("sign_in"), app.WebAuthController.HandleSSOCallback)
+	r.POST("/login/passkey/options", app.RateLimiter.Limit("sign_in"), middleware.CSRFProtection(), app.WebPasskeyController.HandleLoginOptions)
+	r.POST("/login/passkey", app.RateLimiter.Limit("sign_in"), middleware.CSRFProtection(), app.WebPasskeyController.HandleLogin)
+	r.POST("/logout", middleware.CSRFProtection(), app.WebAuthController.HandleLogout)
+	r.GET("/logout", app.WebAuthController.RejectLogoutLink)
+	r.GET("/forgot-password", middleware.CSRFProtection(), app.WebPasswordResetController.ShowForgotPassword)
+	r.POST("/forgot-password", app.RateLimiter.Limit("password_reset"), middleware.CSRFProtection(), app.WebPasswordResetController.HandleForgotPassword)
+	r.GET("/reset-password/:token", middleware.CSRFProtection(), app.WebPasswordResetController.ShowResetPassword)
+	r.POST("/reset-password/:token", app.RateLimiter.Limit("password_reset"), middleware.CSRFProtection(), app.WebPasswordResetController.HandleResetPassword)
+	r.GET("/invite/:token", middleware.CSRFProtection(), app.WebInvitationController.ShowAcceptInvitation)
+	r.POST("/invite/:token", app.RateLimiter.Limit("password_reset"), middleware.CSRFProtection(), app.WebInvitationController.HandleAcceptInvitation)
 
 	// Protected routes
 	protected := r.Group("/")
 	protected.Use(middleware.RequireWebAuth())
+	protected.Use(app.RateLimiter.Limit("authenticated"))
+	protected.Use(middleware.RequirePasswordChange())
+	protected.Use(middleware.RequireTwoFactorEnrollment(app.TwoFactorService))
+	protected.Use(middleware.CSRFProtection())
+	protected.Use(middleware.LoadCompanyContext(app.CompanyService))
 	{
 		// Dashboard
 		protected.GET("/", middleware.SetActiveNav("dashboard"), app.WebDashboardController.ShowDashboard)
 		
+		// Company the directory and dashboard are narrowed to
+		protected.POST("/company-context", middleware.RequireWebPermission(models.PermUsersAllCompanies), app.WebCompanyController.HandleSetContext)
+		
 		// Profile routes
 		protected.GET("/profile", middleware.SetActiveNav("profile"), app.WebAuthController.ShowProfile)
-		protected.GET("/profile/password", middleware.SetActiveNav("profile"), app.WebAuthController.ShowChangePassword)
-		protected.POST("/profile/password", app.WebAuthController.HandleChangePassword)
+		protected.GET("/profile/password", middleware.RequireOwnSession(), middleware.SetActiveNav("profile"), app.WebAuthController.ShowChangePassword)
+		protected.POST("/profile/password", middleware.RequireOwnSession(), app.WebAuthController.HandleChangePassword)
+		protected.GET("/profile/sessions", middleware.SetActiveNav("profile"), app.WebAuthController.ShowSessions)
+		protected.POST("/profile/sessions/revoke-others", middleware.RequireOwnSession(), app.WebAuthController.HandleRevokeOtherSessions)
+		protected.POST("/profile/sessions/:id/revoke", middleware.RequireOwnSession(), app.WebAuthController.HandleRevokeSession)
+		protected.POST("/profile/alerts/:id/confirm", middleware.RequireOwnSession(), app.WebAuthController.HandleConfirmAlert)
+		protected.POST("/profile/alerts/:id/revoke", middleware.RequireOwnSession(), app.WebAuthController.HandleRevokeAlert)
+		protected.GET("/profile/tokens", middleware.SetActiveNav("profile"), app.WebAPITokenController.ShowTokens)
+		protected.POST("/profile/tokens", middleware.RequireOwnSession(), app.WebAPITokenController.HandleCreateToken)
+		protected.POST("/profile/tokens/:id/revoke", middleware.RequireOwnSession(), app.WebAPITokenController.HandleRevokeToken)
+		protected.GET("/profile/passkeys", middleware.SetActiveNav("profile"), app.WebPasskeyController.ShowPasskeys)
+		protected.POST("/profile/passkeys/options", middleware.RequireOwnSession(), app.WebPasskeyController.HandleRegistrationOptions)
+		protected.POST("/profile/passkeys", middleware.RequireOwnSession(), app.WebPasskeyController.HandleRegister)
+		protected.POST("/profile/passkeys/:id/delete", middleware.RequireOwnSession(), app.WebPasskeyController.HandleDeletePasskey)
+		protected.POST("/impersonation/stop", app.WebAuthController.HandleStopImpersonation)
+		protected.POST("/profile/sso/:provider/link", middleware.RequireOwnSession(), app.WebAuthController.HandleSSOLink)
+		protected.GET("/profile/two-factor", middleware.RequireOwnSession(), middleware.SetActiveNav("profile"), app.WebAuthController.ShowTwoFactorSetup)
+		protected.POST("/profile/two-factor", middleware.RequireOwnSession(), app.WebAuthController.HandleEnableTwoFactor)
+		protected.POST("/profile/two-factor/disable", middleware.RequireOwnSession(), app.WebAuthController.HandleDisableTwoFactor)
 
 		// User management routes
 		userRoutes := protected.Group("/users")
-		userRoutes.Use(middleware.RequireWebRole(models.RoleManager))
+		userRoutes.Use(middleware.RequireWebPermission(models.PermUsersView))
 		userRoutes.Use(middleware.SetActiveNav("users"))
 		{
 			userRoutes.GET("/", app.WebUserController.ListUsers)
+			userRoutes.GET("/export", app.WebUserExportController.ExportUsers)
+			userRoutes.GET("/deleted", middleware.RequireWebPermission(models.PermUsersDelete), app.WebUserController.ListDeletedUsers)
 			userRoutes.GET("/:id", app.WebUserController.ShowUser)
-			userRoutes.GET("/new", app.WebUserController.ShowCreateUser)
-			userRoutes.POST("/", app.WebUserController.HandleCreateUser)
-			userRoutes.GET("/:id/edit", app.WebUserController.ShowEditUser)
-			userRoutes.POST("/:id", app.WebUserController.HandleEditUser)
-			userRoutes.GET("/:id/delete", app.WebUserController.HandleDeleteUser)
-			userRoutes.GET("/:id/toggle-status", app.WebUserController.HandleToggleStatus)
-			userRoutes.POST("/:id/reset-password", app.WebUserController.HandleResetPassword)
+			userRoutes.GET("/new", middleware.RequireWebPermission(models.PermUsersCreate), app.WebUserController.ShowCreateUser)
+			userRoutes.POST("/", middleware.RequireWebPermission(models.PermUsersCreate), app.WebUserController.HandleCreateUser)
+			userRoutes.GET("/import", middleware.RequireWebPermission(models.PermUsersCreate), app.WebUserImportController.ShowImport)
+			userRoutes.GET("/import/template", middleware.RequireWebPermission(models.PermUsersCreate), app.WebUserImportController.DownloadTemplate)
+			userRoutes.POST("/import/preview", middleware.RequireWebPermission(models.PermUsersCreate), app.WebUserImportController.HandlePreviewImport)
+			userRoutes.POST("/import", middleware.RequireWebPermission(models.PermUsersCreate), app.WebUserImportController.HandleApplyImport)
+			userRoutes.POST("/invitations/:id/resend", middleware.RequireWebPermission(models.PermUsersCreate), app.WebUserController.HandleResendInvitation)
+			userRoutes.POST("/invitations/:id/revoke", middleware.RequireWebPermission(models.PermUsersCreate), app.WebUserController.HandleRevokeInvitation)
+			userRoutes.GET("/:id/edit", middleware.RequireWebPermission(models.PermUsersUpdate), app.WebUserController.ShowEditUser)
+			userRoutes.POST("/:id", middleware.RequireWebPermission(models.PermUsersUpdate), app.WebUserController.HandleEditUser)
+			userRoutes.GET("/:id/delete", middleware.MethodNotAllowed(http.MethodPost))
+			userRoutes.GET("/:id/delete/confirm", middleware.RequireWebPermission(models.PermUsersDelete), app.WebUserController.ShowConfirmDeleteUser)
+			userRoutes.POST("/:id/delete", middleware.RequireWebPermission(models.PermUsersDelete), app.WebUserController.HandleDeleteUser)
+			userRoutes.POST("/:id/restore", middleware.RequireWebPermission(models.PermUsersDelete), app.WebUserController.HandleRestoreUser)
+			userRoutes.GET("/:id/toggle-status", middleware.MethodNotAllowed(http.MethodPost))
+			userRoutes.GET("/:id/toggle-status/confirm", middleware.RequireWebPermission(models.PermUsersDisable), app.WebUserController.ShowConfirmToggleStatus)
+			userRoutes.POST("/:id/toggle-status", middleware.RequireWebPermission(models.PermUsersDisable), app.WebUserController.HandleToggleStatus)
+			userRoutes.POST("/:id/reset-password", middleware.RequireWebPermission(models.PermUsersResetPassword), app.WebUserController.HandleResetPassword)
+			userRoutes.POST("/:id/password-reveal", middleware.RequireWebPermission(models.PermUsersResetPassword), app.WebUserController.HandleRevealPassword)
+			userRoutes.POST("/:id/unlock", middleware.RequireWebPermission(models.PermUsersUnlock), app.WebUserController.HandleUnlockUser)
+			userRoutes.POST("/:id/sessions/revoke", middleware.RequireWebPermission(models.PermUsersRevokeSessions), app.WebUserController.HandleRevokeSessions)
+			userRoutes.POST("/:id/impersonate", middleware.RequireWebPermission(models.PermUsersImpersonate), app.WebUserController.HandleImpersonate)
+		}
+
+		// Role permissions
+		roleRoutes := protected.Group("/roles")
+		roleRoutes.Use(middleware.RequireWebPermission(models.PermRolesManage))
+		roleRoutes.Use(middleware.SetActiveNav("roles"))
+		{
+			roleRoutes.GET("", app.WebRoleController.ShowRoles)
+			roleRoutes.POST("/:role", app.WebRoleController.HandleUpdateRole)
+		}
+
+		// Rate limit throttles
+		rateLimitRoutes := protected.Group("/rate-limits")
+		rateLimitRoutes.Use(middleware.RequireWebPermission(models.PermRateLimitsManage))
+		rateLimitRoutes.Use(middleware.SetActiveNav("rate_limits"))
+		{
+			rateLimitRoutes.GET("", app.WebRateLimitController.ShowRateLimits)
+			rateLimitRoutes.POST("/clear", app.WebRateLimitController.HandleClear)
+			rateLimitRoutes.POST("/clear-all", app.WebRateLimitController.HandleClearAll)
+		}
+
+		// Network allow and deny rules
+		ipRuleRoutes := protected.Group("/ip-rules")
+		ipRuleRoutes.Use(middleware.RequireWebPermission(models.PermIPRulesManage))
+		ipRuleRoutes.Use(middleware.SetActiveNav("ip_rules"))
+		{
+			ipRuleRoutes.GET("", app.WebIPRuleController.ShowIPRules)
+			ipRuleRoutes.POST("", app.WebIPRuleController.HandleCreateRule)
+			ipRuleRoutes.POST("/:id/delete", app.WebIPRuleController.HandleDeleteRule)
+		}
+
+		// Companies and their settings; logos are shown to everyone
+		protected.GET("/companies/:id/logo", app.WebCompanyController.ServeLogo)
+		companyRoutes := protected.Group("/companies")
+		companyRoutes.Use(middleware.RequireWebPermission(models.PermCompaniesManage))
+		companyRoutes.Use(middleware.SetActiveNav("companies"))
+		{
+			companyRoutes.GET("", app.WebCompanyController.ShowCompanies)
+			companyRoutes.GET("/new", app.WebCompanyController.ShowNewCompany)
+			companyRoutes.POST("", app.WebCompanyController.HandleCreateCompany)
+			companyRoutes.GET("/:id/edit", app.WebCompanyController.ShowEditCompany)
+			companyRoutes.POST("/:id", app.WebCompanyController.HandleUpdateCompany)
+			companyRoutes.POST("/:id/delete", app.WebCompanyController.HandleDeleteCompany)
 		}
 	}
 
+	// JSON API, authenticated with a session or a personal access token
+	api := r.Group("/api/v1")
+	api.Use(app.AuthMiddleware.APITokenAuth())
+	api.Use(app.RateLimiter.Limit("authenticated"))
+	{
+		api.POST("/auth/login", app.RateLimiter.Limit("login", "login_ip"), app.AuthController.Login)
+		api.POST("/auth/login/two-factor", app.RateLimiter.Limit("sign_in"), app.AuthController.VerifyTwoFactor)
+		api.DELETE("/auth/logout", app.AuthMiddleware.RequireAuth(), middleware.RequireSession(), app.AuthController.Logout)
+		api.GET("/auth/me", app.AuthMiddleware.RequireAuth(), app.AuthController.GetCurrentUser)
+		api.PATCH("/auth/password", app.AuthMiddleware.RequireAuth(), middleware.RequireSession(), app.AuthController.ChangePassword)
+
+		api.POST("/passwords", app.RateLimiter.Limit("password_reset"), app.PasswordResetController.RequestPasswordReset)
+		api.PATCH("/passwords/reset", app.RateLimiter.Limit("password_reset"), app.PasswordResetController.ResetPasswordWithToken)
+
+		apiUsers := api.Group("/users")
+		{
+			can := app.AuthMiddleware.RequirePermission
+			read := middleware.RequireScope(models.ScopeUsersRead)
+			write := middleware.RequireScope(models.ScopeUsersWrite)
+			apiUsers.GET("", can(models.PermUsersView), read, app.UserController.ListUsers)
+			apiUsers.GET("/password_reset_events", can(models.PermUsersResetPassword), read, app.PasswordResetController.GetPasswordResetEvents)
+			apiUsers.GET("/:id", can(models.PermUsersView), read, app.UserController.GetUser)
+			apiUsers.POST("", can(models.PermUsersCreate), write, app.UserController.CreateUser)
+			apiUsers.PATCH("/:id", can(models.PermUsersUpdate), write, app.UserController.UpdateUser)
+			apiUsers.DELETE("/:id", can(models.PermUsersDelete), write, app.UserController.DeleteUser)
+			apiUsers.POST("/:id/reset_password", can(models.PermUsersResetPassword), write, app.UserController.ResetPassword)
+			apiUsers.POST("/:id/password_reveal", can(models.PermUsersResetPassword), write, app.UserController.RevealPassword)
+			apiUsers.PATCH("/:id/toggle_enabled", can(models.PermUsersDisable), write, app.UserController.ToggleEnabled)
+			apiUsers.POST("/bulk_reset_passwords", can(models.PermUsersResetPassword), write, app.UserController.BulkResetPasswords)
+			apiUsers.POST("/bulk_toggle_enabled", can(models.PermUsersDisable), write, app.UserController.BulkToggleEnabled)
+		}
+
+		activitiesRead := middleware.RequireScope(models.ScopeActivitiesRead)
+		api.GET("/activities", app.AuthMiddleware.RequirePermission(models.PermActivitiesView), activitiesRead, app.ActivityController.GetAllActivities)
+		api.GET("/activities/users/:user_id", app.AuthMiddleware.RequireAuth(), activitiesRead, app.ActivityController.GetUserActivities)
+	}
+
 	// Health check with performance metrics
 	r.GET("/health", middleware.HealthCheck())
 	
@@ -173,8 +424,30 @@ func (app *Application) startBackgroundTasks() {
 			log.Printf("Failed to cleanup expired sessions: %v", err)
 		}
 		
-		if err := app.PasswordResetService.AutoResetExpiredPasswords(); err != nil {
-			log.Printf("Failed to auto-reset expired passwords: %v", err)
+		if err := app.AuthService.CleanupExpiredChallenges(); err != nil {
+			log.Printf("Failed to cleanup expired two-factor challenges: %v", err)
+		}
+		
+		if err := app.SSOService.CleanupExpiredStates(); err != nil {
+			log.Printf("Failed to cleanup expired SSO sign-in states: %v", err)
+		}
+		
+		if err := app.PasskeyService.CleanupExpiredChallenges(); err != nil {
+			log.Printf("Failed to cleanup expired passkey challenges: %v", err)
+		}
+		
+		if err := app.RateLimitService.CleanupExpiredCounters(); err != nil {
+			log.Printf("Failed to cleanup expired rate limit counters: %v", err)
+		}
+		
+		if err := app.PasswordResetService.ClearExpiredReveals(); err != nil {
+			log.Printf("Failed to clear expired password reveals: %v", err)
+		}
+		
+		if purged, err := app.UserArchiveService.PurgeExpired(); err != nil {
+			log.Printf("Failed to purge deleted users: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted users", purged)
 		}
 		
 		if err := app.PasswordResetService.AutoResetInactiveUsers(); err != nil {
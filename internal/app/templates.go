@@ -0,0 +1,123 @@
+package app
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"github.com/gin-gonic/gin/render"
+)
+
+// pageRenderer keeps one template set per page. Every page is parsed together
+// with the shared layouts and partials, so pages that each define "content"
+// no longer overwrite one another in a single global set.
+type pageRenderer struct {
+	pages map[string]*template.Template
+	entry map[string]string
+}
+
+func newPageRenderer(fsys fs.FS, root string, funcs template.FuncMap) (*pageRenderer, error) {
+	shared := template.New("").Funcs(funcs)
+	for _, dir := range []string{"layouts", "partials"} {
+		matches, err := fs.Glob(fsys, path.Join(root, dir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		if shared, err = shared.ParseFS(fsys, matches...); err != nil {
+			return nil, err
+		}
+	}
+
+	pageFiles, err := fs.Glob(fsys, path.Join(root, "*", "*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	renderer := &pageRenderer{
+		pages: make(map[string]*template.Template),
+		entry: make(map[string]string),
+	}
+
+	for _, file := range pageFiles {
+		name := strings.TrimPrefix(file, root+"/")
+		if strings.HasPrefix(name, "layouts/") || strings.HasPrefix(name, "partials/") {
+			continue
+		}
+
+		tmpl, err := shared.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if tmpl, err = tmpl.ParseFS(fsys, file); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+
+		// Pages that only define "content" are rendered inside the base
+		// layout; full documents such as the login page render themselves.
+		entry := path.Base(file)
+		if tmpl.Lookup("content") != nil {
+			entry = "base.html"
+		}
+
+		renderer.pages[name] = tmpl
+		renderer.entry[name] = entry
+	}
+
+	return renderer, nil
+}
+
+// Instance implements render.HTMLRender. Names are paths relative to the
+// templates directory, e.g. "users/show.html".
+func (r *pageRenderer) Instance(name string, data interface{}) render.Render {
+	tmpl, ok := r.pages[name]
+	if !ok {
+		panic(fmt.Sprintf("template %q not found", name))
+	}
+	return render.HTML{
+		Template: tmpl,
+		Name:     r.entry[name],
+		Data:     data,
+	}
+}
+
+// templateFuncs are available to every page.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"passwordPolicy": models.GetPasswordPolicy,
+		"can":            can,
+		"canManage":      canManage,
+		"csrfField":      csrfField,
+	}
+}
+
+// csrfField renders the hidden input every POST form has to include:
+// {{csrfField $.CSRFToken}}.
+func csrfField(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + middleware.CSRFFieldName + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+// can reports whether the user holds the permission, so pages can hide
+// actions the user would be refused, e.g. {{if can .User "users.delete"}}.
+func can(user *models.User, permission string) bool {
+	return user != nil && user.HasPermission(permission)
+}
+
+// canManage is can narrowed to one target user, who must also be within
+// the user's reach: {{if canManage $.User . "users.update"}}.
+func canManage(user *models.User, target interface{}, permission string) bool {
+	var targetUser *models.User
+	switch t := target.(type) {
+	case *models.User:
+		targetUser = t
+	case models.User:
+		targetUser = &t
+	}
+	return targetUser != nil && can(user, permission) && user.CanManageUser(targetUser)
+}
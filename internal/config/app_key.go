@@ -0,0 +1,51 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// appKeySize is the length of a generated app key, and the least an
+// APP_KEY must decode to.
+const appKeySize = 32
+
+// LoadAppKey returns the secret that signs invitation links and encrypts
+// two-factor secrets. It is taken from APP_KEY, base64-encoded, or else from
+// app.key next to the database, which is created on first start. Instances
+// sharing a database must share the key.
+func LoadAppKey(dbPath string) ([]byte, error) {
+	if value := os.Getenv("APP_KEY"); value != "" {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(key) < appKeySize {
+			return nil, fmt.Errorf("APP_KEY must be at least %d bytes, base64-encoded", appKeySize)
+		}
+		return key, nil
+	}
+
+	path := filepath.Join(filepath.Dir(dbPath), "app.key")
+	if data, err := os.ReadFile(path); err == nil {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < appKeySize {
+			return nil, fmt.Errorf("%s does not hold a valid app key", path)
+		}
+		return key, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, appKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated a new app key in %s", path)
+	return key, nil
+}
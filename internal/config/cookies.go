@@ -0,0 +1,10 @@
+package config
+
+// SecureCookies reports whether the session, CSRF and sign-in cookies are
+// marked Secure so browsers only send them over HTTPS. It is on unless
+// SECURE_COOKIES=false turns it off for development over plain HTTP.
+func SecureCookies() bool {
+	secure := true
+	envBool("SECURE_COOKIES", &secure)
+	return secure
+}
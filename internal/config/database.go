@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"log"
 	"time"
 
 	"alsafwanmarine.com/todo-app/internal/models"
@@ -53,14 +55,141 @@ func NewDatabase(dbPath string) (*Database, error) {
 }
 
 func (d *Database) migrate() error {
+	if err := d.invalidatePlaintextTokens(); err != nil {
+		return err
+	}
+	if err := d.convertCompanyNames(); err != nil {
+		return err
+	}
+	
 	return d.DB.AutoMigrate(
+		&models.Company{},
+		&models.CompanyLogo{},
 		&models.User{},
 		&models.Session{},
 		&models.UserActivity{},
 		&models.PasswordResetEvent{},
+		&models.TwoFactorChallenge{},
+		&models.PasswordHistory{},
+		&models.RememberToken{},
+		&models.APIToken{},
+		&models.UserIdentity{},
+		&models.SSOLoginState{},
+		&models.Passkey{},
+		&models.PasskeyChallenge{},
+		&models.RolePermission{},
+		&models.KnownPermission{},
+		&models.PermissionsRevision{},
+		&models.RateLimitCounter{},
+		&models.SecurityAlert{},
+		&models.Invitation{},
+		&models.IPRule{},
 	)
 }
 
+// invalidatePlaintextTokens removes tokens stored before they were hashed.
+// Sessions and two-factor challenges are short-lived, so their tables are
+// dropped and everyone has to sign in again. Reset events are kept for the
+// audit trail and only lose their token, which voids any pending links.
+func (d *Database) invalidatePlaintextTokens() error {
+	migrator := d.DB.Migrator()
+	
+	for _, table := range []string{"sessions", "two_factor_challenges"} {
+		if migrator.HasTable(table) && migrator.HasColumn(table, "token") {
+			log.Printf("Invalidating plaintext tokens in %s", table)
+			if err := migrator.DropTable(table); err != nil {
+				return err
+			}
+		}
+	}
+	
+	if migrator.HasTable(&models.PasswordResetEvent{}) && migrator.HasColumn(&models.PasswordResetEvent{}, "token") {
+		log.Printf("Invalidating plaintext tokens in password_reset_events")
+		if err := d.DB.Exec("DROP INDEX IF EXISTS idx_password_reset_events_token").Error; err != nil {
+			return err
+		}
+		if err := migrator.DropColumn(&models.PasswordResetEvent{}, "token"); err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// legacyCompanies are the companies users could be assigned to before
+// companies were managed in the app.
+var legacyCompanies = []string{"Al Safwan Marine", "Louis Safety", "Data Grid Labs"}
+
+// convertCompanyNames replaces the free-text company columns of users and
+// IP rules with references to companies. Every name in use becomes a
+// company, as do the ones the app used to offer. The columns are changed in
+// place rather than through AutoMigrate, which would rebuild the users
+// table and cascade its deletion to sessions and activity.
+func (d *Database) convertCompanyNames() error {
+	migrator := d.DB.Migrator()
+	
+	var tables []string
+	for _, table := range []string{"users", "ip_rules"} {
+		// HasColumn matches the table's SQL loosely, so "company" would also
+		// match the fk_<table>_company constraint added below.
+		var columns int64
+		if err := d.DB.Raw("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'company'", table).Scan(&columns).Error; err != nil {
+			return err
+		}
+		if columns > 0 {
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	
+	log.Printf("Converting company names in %v to companies", tables)
+	if err := migrator.AutoMigrate(&models.Company{}); err != nil {
+		return err
+	}
+	
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		names := append([]string{}, legacyCompanies...)
+		for _, table := range tables {
+			var used []string
+			if err := tx.Table(table).Where("TRIM(company) <> ''").Distinct().Pluck("TRIM(company)", &used).Error; err != nil {
+				return err
+			}
+			names = append(names, used...)
+		}
+		
+		var companies []models.Company
+		if err := tx.Find(&companies).Error; err != nil {
+			return err
+		}
+		for _, name := range names {
+			if models.FindCompany(companies, name) != nil {
+				continue
+			}
+			company := models.Company{Name: name, DefaultRole: models.RoleSalesperson}
+			if err := tx.Create(&company).Error; err != nil {
+				return err
+			}
+			companies = append(companies, company)
+		}
+		
+		for _, table := range tables {
+			statements := []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN company_id integer CONSTRAINT fk_%s_company REFERENCES companies(id)", table, table),
+				fmt.Sprintf("UPDATE %s SET company_id = (SELECT id FROM companies WHERE companies.name = TRIM(%s.company) COLLATE NOCASE)", table, table),
+				fmt.Sprintf("ALTER TABLE %s DROP COLUMN company", table),
+			}
+			for _, statement := range statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
 func (d *Database) createIndexes() error {
 	// Performance-critical indexes for common queries
 	indexes := []string{
@@ -68,12 +197,14 @@ func (d *Database) createIndexes() error {
 		"CREATE INDEX IF NOT EXISTS idx_users_role_enabled ON users(role, enabled);",
 		"CREATE INDEX IF NOT EXISTS idx_users_enabled_created_at ON users(enabled, created_at DESC);",
 		"CREATE INDEX IF NOT EXISTS idx_sessions_user_id_expires_at ON sessions(user_id, expires_at);",
-		"CREATE INDEX IF NOT EXISTS idx_sessions_token_expires_at ON sessions(token, expires_at);",
+		"CREATE INDEX IF NOT EXISTS idx_sessions_token_digest_expires_at ON sessions(token_digest, expires_at);",
 		"CREATE INDEX IF NOT EXISTS idx_user_activities_user_id_performed_at ON user_activities(user_id, performed_at DESC);",
 		"CREATE INDEX IF NOT EXISTS idx_user_activities_type_performed_at ON user_activities(activity_type, performed_at DESC);",
 		"CREATE INDEX IF NOT EXISTS idx_user_activities_performed_at ON user_activities(performed_at DESC);",
 		"CREATE INDEX IF NOT EXISTS idx_password_reset_events_user_id ON password_reset_events(user_id, created_at DESC);",
 		"CREATE INDEX IF NOT EXISTS idx_password_reset_events_expires_at ON password_reset_events(expires_at);",
+		"CREATE INDEX IF NOT EXISTS idx_password_histories_user_id_created_at ON password_histories(user_id, created_at DESC);",
+		"CREATE INDEX IF NOT EXISTS idx_remember_tokens_expires_at ON remember_tokens(expires_at);",
 	}
 
 	for _, index := range indexes {
@@ -86,78 +217,83 @@ func (d *Database) createIndexes() error {
 }
 
 func (d *Database) Seed() error {
+	companyID, err := d.seedCompanies()
+	if err != nil {
+		return err
+	}
+	
 	seedUsers := []models.User{
 		{
-			ID:      2,
-			Email:   "sales4@alsafwanmarine.com",
-			Name:    "Tom Charley",
-			Role:    models.RoleSalesperson,
-			Company: stringPtr("Al Safwan Marine"),
-			Enabled: true,
+			ID:        2,
+			Email:     "sales4@alsafwanmarine.com",
+			Name:      "Tom Charley",
+			Role:      models.RoleSalesperson,
+			CompanyID: companyID,
+			Enabled:   true,
 		},
 		{
-			ID:      8,
-			Email:   "manager@example.com",
-			Name:    "Sales Manager",
-			Role:    models.RoleManager,
-			Company: stringPtr("Al Safwan Marine"),
-			Enabled: true,
+			ID:        8,
+			Email:     "manager@example.com",
+			Name:      "Sales Manager",
+			Role:      models.RoleManager,
+			CompanyID: companyID,
+			Enabled:   true,
 		},
 		{
-			ID:      9,
-			Email:   "operations@alsafwanmarine.com",
-			Name:    "Noushad Moidunny",
-			Role:    models.RoleAdmin,
-			Company: stringPtr("Al Safwan Marine"),
-			Enabled: true,
+			ID:        9,
+			Email:     "operations@alsafwanmarine.com",
+			Name:      "Noushad Moidunny",
+			Role:      models.RoleAdmin,
+			CompanyID: companyID,
+			Enabled:   true,
 		},
 		{
-			ID:      10,
-			Email:   "sales1@alsafwanmarine.com",
-			Name:    "Lubdha Vipin",
-			Role:    models.RoleSalesperson,
-			Company: stringPtr("Al Safwan Marine"),
-			Enabled: true,
+			ID:        10,
+			Email:     "sales1@alsafwanmarine.com",
+			Name:      "Lubdha Vipin",
+			Role:      models.RoleSalesperson,
+			CompanyID: companyID,
+			Enabled:   true,
 		},
 		{
-			ID:      11,
-			Email:   "bd@alsafwanmarine.com",
-			Name:    "Sandra Santosh",
-			Role:    models.RoleSalesperson,
-			Company: stringPtr("Al Safwan Marine"),
-			Enabled: true,
+			ID:        11,
+			Email:     "bd@alsafwanmarine.com",
+			Name:      "Sandra Santosh",
+			Role:      models.RoleSalesperson,
+			CompanyID: companyID,
+			Enabled:   true,
 		},
 		{
-			ID:      12,
-			Email:   "marketing@alsafwanmarine.com",
-			Name:    "Thomas Siby",
-			Role:    models.RoleSalesperson,
-			Company: stringPtr("Al Safwan Marine"),
-			Enabled: true,
+			ID:        12,
+			Email:     "marketing@alsafwanmarine.com",
+			Name:      "Thomas Siby",
+			Role:      models.RoleSalesperson,
+			CompanyID: companyID,
+			Enabled:   true,
 		},
 		{
-			ID:      13,
-			Email:   "business@alsafwanmarine.com",
-			Name:    "Silpa Chelathur",
-			Role:    models.RoleSalesperson,
-			Company: stringPtr("Al Safwan Marine"),
-			Enabled: true,
+			ID:        13,
+			Email:     "business@alsafwanmarine.com",
+			Name:      "Silpa Chelathur",
+			Role:      models.RoleSalesperson,
+			CompanyID: companyID,
+			Enabled:   true,
 		},
 		{
-			ID:      14,
-			Email:   "sales5@alsafwanmarine.com",
-			Name:    "Krishna Swaroop",
-			Role:    models.RoleSalesperson,
-			Company: stringPtr("Al Safwan Marine"),
-			Enabled: true,
+			ID:        14,
+			Email:     "sales5@alsafwanmarine.com",
+			Name:      "Krishna Swaroop",
+			Role:      models.RoleSalesperson,
+			CompanyID: companyID,
+			Enabled:   true,
 		},
 		{
-			ID:      18,
-			Email:   "admin@example.com",
-			Name:    "Admin User",
-			Role:    models.RoleAdmin,
-			Company: nil,
-			Enabled: true,
+			ID:        18,
+			Email:     "admin@example.com",
+			Name:      "Admin User",
+			Role:      models.RoleAdmin,
+			CompanyID: nil,
+			Enabled:   true,
 		},
 	}
 	
@@ -165,7 +301,7 @@ func (d *Database) Seed() error {
 		var existingUser models.User
 		if err := d.DB.Where("id = ?", user.ID).First(&existingUser).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
-				defaultPassword := "password123"
+				defaultPassword := "Welcome@2024"
 				if err := user.SetPassword(defaultPassword); err != nil {
 					return err
 				}
@@ -182,8 +318,33 @@ func (d *Database) Seed() error {
 	return nil
 }
 
-func stringPtr(s string) *string {
-	return &s
+// seedCompanies creates the legacy companies if there are none yet, and
+// returns the ID of Al Safwan Marine.
+func (d *Database) seedCompanies() (*uint, error) {
+	var count int64
+	if err := d.DB.Model(&models.Company{}).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		for _, name := range legacyCompanies {
+			company := models.Company{Name: name, DefaultRole: models.RoleSalesperson}
+			if name == "Al Safwan Marine" {
+				company.EmailDomain = "alsafwanmarine.com"
+			}
+			if err := d.DB.Create(&company).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+	
+	var company models.Company
+	if err := d.DB.Where("name = ?", "Al Safwan Marine").First(&company).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &company.ID, nil
 }
 
 func (d *Database) Close() error {
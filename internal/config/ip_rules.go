@@ -0,0 +1,9 @@
+package config
+
+import "os"
+
+// IPRuleBreakGlassEmail names the one admin who may sign in from anywhere
+// whatever the IP rules say, e.g. IP_RULES_BREAK_GLASS_EMAIL=ops@example.com.
+func IPRuleBreakGlassEmail() string {
+	return os.Getenv("IP_RULES_BREAK_GLASS_EMAIL")
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"os"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+// LoadLockoutPolicy starts from the default policy and applies any
+// LOCKOUT_* overrides from the environment.
+func LoadLockoutPolicy() models.LockoutPolicy {
+	policy := models.DefaultLockoutPolicy()
+
+	if n, ok := envInt("LOCKOUT_THRESHOLD"); ok && n > 0 {
+		policy.Threshold = n
+	}
+	if duration, err := time.ParseDuration(os.Getenv("LOCKOUT_BASE_DURATION")); err == nil && duration > 0 {
+		policy.BaseDuration = duration
+	}
+	if duration, err := time.ParseDuration(os.Getenv("LOCKOUT_MAX_DURATION")); err == nil && duration > 0 {
+		policy.MaxDuration = duration
+	}
+
+	return policy
+}
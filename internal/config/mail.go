@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"strings"
+
+	"alsafwanmarine.com/todo-app/internal/mailer"
+)
+
+const defaultMailFrom = "ASM Tracker <no-reply@alsafwanmarine.com>"
+
+// LoadMailer picks the mail transport from MAIL_DRIVER. "smtp" sends through
+// SMTP_HOST, "file" writes messages to MAIL_DIR and anything else logs them.
+func LoadMailer() mailer.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = defaultMailFrom
+	}
+
+	switch strings.ToLower(os.Getenv("MAIL_DRIVER")) {
+	case "smtp":
+		port, ok := envInt("SMTP_PORT")
+		if !ok {
+			port = 587
+		}
+		return &mailer.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "./data/mail"
+		}
+		return &mailer.FileMailer{Dir: dir, From: from}
+	default:
+		return &mailer.LogMailer{From: from}
+	}
+}
+
+// BaseURL is the public address used in links sent by email. It is
+// configured rather than taken from the request so a forged Host header
+// cannot redirect reset links elsewhere.
+func BaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8001"
+	}
+	return "http://localhost:" + port
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+// LoadPasswordPolicy starts from the default policy and applies any
+// PASSWORD_* overrides from the environment.
+func LoadPasswordPolicy() models.PasswordPolicy {
+	policy := models.DefaultPasswordPolicy()
+
+	if n, ok := envInt("PASSWORD_MIN_LENGTH"); ok && n > 0 {
+		policy.MinLength = n
+	}
+	if n, ok := envInt("PASSWORD_HISTORY_SIZE"); ok && n >= 0 {
+		policy.HistorySize = n
+	}
+
+	envBool("PASSWORD_REQUIRE_UPPERCASE", &policy.RequireUpper)
+	envBool("PASSWORD_REQUIRE_LOWERCASE", &policy.RequireLower)
+	envBool("PASSWORD_REQUIRE_DIGIT", &policy.RequireDigit)
+	envBool("PASSWORD_REQUIRE_SYMBOL", &policy.RequireSymbol)
+
+	for _, word := range strings.Split(os.Getenv("PASSWORD_BANNED_WORDS"), ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			policy.BannedWords = append(policy.BannedWords, word)
+		}
+	}
+
+	return policy
+}
+
+func envInt(key string) (int, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func envBool(key string, target *bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		*target = b
+	}
+}
@@ -0,0 +1,20 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// TrustedProxies lists the reverse proxies whose X-Forwarded-For header is
+// believed, e.g. TRUSTED_PROXIES=10.0.0.1,10.1.0.0/16. Without any, client
+// addresses come from the connection itself, since the header can be sent
+// by anyone.
+func TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+// LoadRateLimitPolicies starts from the default policies and applies any
+// overrides from the environment:
+//
+//	RATE_LIMIT_LOGIN=10/3m         # requests per period
+//	RATE_LIMIT_LOGIN_KEY=ip,email  # what the limit is counted by
+func LoadRateLimitPolicies() []models.RateLimitPolicy {
+	policies := models.DefaultRateLimitPolicies()
+
+	for i := range policies {
+		prefix := "RATE_LIMIT_" + strings.ToUpper(policies[i].Name)
+
+		if limit, period, ok := parseRate(os.Getenv(prefix)); ok {
+			policies[i].Limit = limit
+			policies[i].Period = period
+		}
+
+		if value := os.Getenv(prefix + "_KEY"); value != "" {
+			var keyBy []string
+			for _, part := range strings.Split(value, ",") {
+				part = strings.ToLower(strings.TrimSpace(part))
+				if !models.IsValidRateLimitKeyPart(part) {
+					keyBy = nil
+					break
+				}
+				keyBy = append(keyBy, part)
+			}
+			if len(keyBy) > 0 {
+				policies[i].KeyBy = keyBy
+			}
+		}
+	}
+
+	return policies
+}
+
+// parseRate reads "<limit>/<period>", e.g. "10/3m".
+func parseRate(value string) (int64, time.Duration, bool) {
+	limitText, periodText, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	limit, err := strconv.ParseInt(strings.TrimSpace(limitText), 10, 64)
+	if err != nil || limit <= 0 {
+		return 0, 0, false
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(periodText))
+	if err != nil || period <= 0 {
+		return 0, 0, false
+	}
+	return limit, period, true
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"os"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+// LoadSecurityAlertPolicy starts from the default policy and applies any
+// SECURITY_ALERT_* overrides from the environment.
+func LoadSecurityAlertPolicy() models.SecurityAlertPolicy {
+	policy := models.DefaultSecurityAlertPolicy()
+
+	if window, err := time.ParseDuration(os.Getenv("SECURITY_ALERT_DISTANT_LOGIN_WINDOW")); err == nil && window > 0 {
+		policy.DistantLoginWindow = window
+	}
+	if window, err := time.ParseDuration(os.Getenv("SECURITY_ALERT_HISTORY_WINDOW")); err == nil && window > 0 {
+		policy.HistoryWindow = window
+	}
+	envBool("SECURITY_ALERT_EMAIL", &policy.EmailUser)
+
+	return policy
+}
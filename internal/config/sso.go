@@ -0,0 +1,109 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/oidc"
+	"alsafwanmarine.com/todo-app/internal/services"
+)
+
+// LoadSSOProviders reads the OpenID Connect providers listed in
+// SSO_PROVIDERS. Each key K is configured through SSO_<K>_* variables:
+//
+//	SSO_<K>_NAME           button label, defaults to the company
+//	SSO_<K>_ISSUER         issuer URL (required)
+//	SSO_<K>_CLIENT_ID      client ID (required)
+//	SSO_<K>_CLIENT_SECRET  client secret, empty for public clients
+//	SSO_<K>_SCOPES         defaults to "openid email profile"
+//	SSO_<K>_COMPANY        name of the company for users from this provider
+//	SSO_<K>_COMPANY_CLAIM  claim that overrides the company, if it names one
+//	SSO_<K>_ROLE_CLAIM     claim mapped to a role, defaults to "groups"
+//	SSO_<K>_ROLE_MAP       e.g. "asm-admins=admin,asm-managers=manager"
+//	SSO_<K>_DEFAULT_ROLE   role when nothing maps, defaults to salesperson
+//	SSO_<K>_PROVISION      create unknown users on first sign-in (true)
+//
+// Providers with missing or invalid settings are skipped with a warning.
+func LoadSSOProviders() []services.SSOProvider {
+	var providers []services.SSOProvider
+
+	for _, key := range strings.Split(os.Getenv("SSO_PROVIDERS"), ",") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+		if strings.Trim(key, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+			log.Printf("Warning: SSO provider key %q may only use letters, digits and underscores; skipping it", key)
+			continue
+		}
+		prefix := "SSO_" + strings.ToUpper(key) + "_"
+		env := func(name string) string {
+			return strings.TrimSpace(os.Getenv(prefix + name))
+		}
+
+		provider := services.SSOProvider{
+			Key:          key,
+			Name:         env("NAME"),
+			Company:      env("COMPANY"),
+			CompanyClaim: env("COMPANY_CLAIM"),
+			RoleClaim:    env("ROLE_CLAIM"),
+			RoleMap:      make(map[string]models.UserRole),
+			DefaultRole:  models.RoleSalesperson,
+			Provision:    true,
+			OIDC: oidc.Config{
+				Issuer:       env("ISSUER"),
+				ClientID:     env("CLIENT_ID"),
+				ClientSecret: env("CLIENT_SECRET"),
+				RedirectURL:  BaseURL() + "/login/sso/" + key + "/callback",
+				Scopes:       strings.Fields(env("SCOPES")),
+			},
+		}
+
+		if provider.OIDC.Issuer == "" || provider.OIDC.ClientID == "" {
+			log.Printf("Warning: SSO provider %q needs %sISSUER and %sCLIENT_ID; skipping it", key, prefix, prefix)
+			continue
+		}
+		if provider.Name == "" {
+			provider.Name = provider.Company
+		}
+		if provider.Name == "" {
+			provider.Name = key
+		}
+		if provider.RoleClaim == "" {
+			provider.RoleClaim = "groups"
+		}
+		if name := env("DEFAULT_ROLE"); name != "" {
+			role, ok := models.ParseRole(name)
+			if !ok {
+				log.Printf("Warning: SSO provider %q has unknown default role %q; skipping it", key, name)
+				continue
+			}
+			provider.DefaultRole = role
+		}
+		envBool(prefix+"PROVISION", &provider.Provision)
+
+		valid := true
+		for _, entry := range strings.Split(env("ROLE_MAP"), ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			value, name, _ := strings.Cut(entry, "=")
+			role, ok := models.ParseRole(name)
+			if !ok || strings.TrimSpace(value) == "" {
+				log.Printf("Warning: SSO provider %q has an invalid role mapping %q; skipping it", key, entry)
+				valid = false
+				break
+			}
+			provider.RoleMap[strings.TrimSpace(value)] = role
+		}
+		if !valid {
+			continue
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers
+}
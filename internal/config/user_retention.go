@@ -0,0 +1,16 @@
+package config
+
+import (
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+// DeletedUserRetention is how long deleted users can be restored before
+// their personal details are purged, from DELETED_USER_RETENTION_DAYS.
+func DeletedUserRetention() time.Duration {
+	if days, ok := envInt("DELETED_USER_RETENTION_DAYS"); ok && days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return models.DefaultDeletedUserRetention
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"log"
+	"net/url"
+	"os"
+
+	"alsafwanmarine.com/todo-app/internal/webauthn"
+)
+
+// LoadWebAuthn derives the passkey relying party from BaseURL. Passkeys are
+// bound to the RP ID, which defaults to the host name and can be widened to
+// a parent domain with WEBAUTHN_RP_ID.
+func LoadWebAuthn() webauthn.Config {
+	base, err := url.Parse(BaseURL())
+	if err != nil || base.Host == "" {
+		log.Printf("Warning: APP_BASE_URL is not a valid URL; passkeys will not work")
+		return webauthn.Config{RPName: "ASM Tracker"}
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = base.Hostname()
+	}
+
+	return webauthn.Config{
+		RPID:   rpID,
+		RPName: "ASM Tracker",
+		Origin: base.Scheme + "://" + base.Host,
+	}
+}
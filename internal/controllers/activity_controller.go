@@ -27,7 +27,7 @@ func (ac *ActivityController) GetAllActivities(c *gin.Context) {
 		return
 	}
 	
-	if currentUser.Role != models.RoleAdmin && currentUser.Role != models.RoleManager {
+	if !currentUser.HasPermission(models.PermActivitiesView) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
 		return
 	}
@@ -38,7 +38,7 @@ func (ac *ActivityController) GetAllActivities(c *gin.Context) {
 		limit = 50
 	}
 	
-	activities, err := ac.activityService.GetAllActivities(limit)
+	activities, err := ac.activityService.GetCompanyActivities(middleware.GetCompanyScope(c), limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch activities"})
 		return
@@ -60,11 +60,24 @@ func (ac *ActivityController) GetUserActivities(c *gin.Context) {
 		return
 	}
 	
-	if currentUser.ID != uint(userID) && currentUser.Role != models.RoleAdmin && currentUser.Role != models.RoleManager {
+	if currentUser.ID != uint(userID) && !currentUser.HasPermission(models.PermActivitiesView) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
 		return
 	}
 	
+	// Other companies' activity is out of reach of users limited to their own
+	if currentUser.ID != uint(userID) {
+		inScope, err := ac.activityService.InCompanyScope(uint(userID), middleware.GetCompanyScope(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch activities"})
+			return
+		}
+		if !inScope {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return
+		}
+	}
+	
 	limitStr := c.DefaultQuery("limit", "50")
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil {
@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"alsafwanmarine.com/todo-app/internal/middleware"
@@ -28,30 +30,86 @@ func (ac *AuthController) Login(c *gin.Context) {
 	
 	result, err := ac.authService.Login(credentials, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
+		if remaining, locked := services.LockoutRemaining(err); locked {
+			ac.respondLocked(c, remaining)
+			return
+		}
+		
 		switch err {
 		case services.ErrInvalidCredentials:
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		case services.ErrRateLimited:
 			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts"})
+		case services.ErrIPNotAllowed:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Sign-in is not allowed from this network"})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
 		}
 		return
 	}
 	
-	c.SetCookie(
-		"session_token",
-		result.Token,
-		int(30*time.Minute.Seconds()),
-		"/",
-		"",
-		true,
-		true,
-	)
+	if result.TwoFactorRequired {
+		c.JSON(http.StatusAccepted, gin.H{
+			"two_factor_required": true,
+			"challenge_token": result.ChallengeToken,
+		})
+		return
+	}
+	
+	ac.respondWithSession(c, result)
+}
+
+func (ac *AuthController) respondLocked(c *gin.Context, remaining time.Duration) {
+	retryAfter := int(math.Ceil(remaining.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Account locked after too many failed attempts",
+		"retry_after": retryAfter,
+	})
+}
+
+type VerifyTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+func (ac *AuthController) VerifyTwoFactor(c *gin.Context) {
+	var req VerifyTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	result, err := ac.authService.CompleteTwoFactorLogin(req.ChallengeToken, req.Code, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if remaining, locked := services.LockoutRemaining(err); locked {
+			ac.respondLocked(c, remaining)
+			return
+		}
+		
+		switch err {
+		case services.ErrInvalidTwoFactorCode:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+		case services.ErrTwoFactorChallengeInvalid, services.ErrInvalidCredentials:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Challenge is invalid or has expired"})
+		case services.ErrIPNotAllowed:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Sign-in is not allowed from this network"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		}
+		return
+	}
+	
+	ac.respondWithSession(c, result)
+}
+
+func (ac *AuthController) respondWithSession(c *gin.Context, result *services.LoginResult) {
+	middleware.SetSessionCookies(c, result)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"user": result.User,
 		"message": "Login successful",
+		"password_change_required": result.PasswordChangeRequired,
 	})
 }
 
@@ -68,15 +126,7 @@ func (ac *AuthController) Logout(c *gin.Context) {
 		return
 	}
 	
-	c.SetCookie(
-		"session_token",
-		"",
-		-1,
-		"/",
-		"",
-		true,
-		true,
-	)
+	middleware.ClearSessionCookies(c)
 	
 	c.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
 }
@@ -109,7 +159,11 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
 		return
 	}
 	
-	err := ac.authService.ChangePassword(user.ID, req.CurrentPassword, req.NewPassword)
+	err := ac.authService.ChangePassword(user.ID, req.CurrentPassword, req.NewPassword, middleware.GetSessionToken(c))
+	if err == services.ErrImpersonating {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -1,8 +1,8 @@
 package controllers
 
 import (
+	"log"
 	"net/http"
-	"strings"
 
 	"alsafwanmarine.com/todo-app/internal/middleware"
 	"alsafwanmarine.com/todo-app/internal/models"
@@ -34,38 +34,12 @@ func (prc *PasswordResetController) RequestPasswordReset(c *gin.Context) {
 		return
 	}
 	
-	normalizeEmail := func(email string) string {
-		return strings.ToLower(strings.TrimSpace(email))
+	if err := prc.passwordResetService.RequestReset(req.Email, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		log.Printf("Failed to send password reset email: %v", err)
 	}
 	
-	var user models.User
-	if err := prc.db.Where("email = ?", normalizeEmail(req.Email)).First(&user).Error; err != nil {
-		c.JSON(http.StatusOK, gin.H{"message": "If the email exists, a reset link has been sent"})
-		return
-	}
-	
-	if !user.Enabled {
-		c.JSON(http.StatusOK, gin.H{"message": "If the email exists, a reset link has been sent"})
-		return
-	}
-	
-	resetEvent, err := prc.passwordResetService.CreateResetEvent(
-		user.ID,
-		nil,
-		"User requested password reset",
-		models.ResetTypeManual,
-		c.ClientIP(),
-		c.Request.UserAgent(),
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset request"})
-		return
-	}
-	
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Password reset link has been sent",
-		"token": *resetEvent.Token,
-	})
+	// The same answer is given whether or not the account exists.
+	c.JSON(http.StatusOK, gin.H{"message": "If the email exists, a reset link has been sent"})
 }
 
 type ResetPasswordRequest struct {
@@ -80,7 +54,7 @@ func (prc *PasswordResetController) ResetPasswordWithToken(c *gin.Context) {
 		return
 	}
 	
-	err := prc.passwordResetService.ResetPasswordWithToken(req.Token, req.NewPassword)
+	err := prc.passwordResetService.ResetPasswordWithToken(req.Token, req.NewPassword, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -96,7 +70,7 @@ func (prc *PasswordResetController) GetPasswordResetEvents(c *gin.Context) {
 		return
 	}
 	
-	if currentUser.Role != models.RoleAdmin && currentUser.Role != models.RoleManager {
+	if !currentUser.HasPermission(models.PermUsersResetPassword) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
 		return
 	}
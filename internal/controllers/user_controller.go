@@ -15,13 +15,19 @@ type UserController struct {
 	db                   *gorm.DB
 	activityService      *services.ActivityService
 	passwordResetService *services.PasswordResetService
+	invitationService    *services.InvitationService
+	userArchiveService   *services.UserArchiveService
+	companyService       *services.CompanyService
 }
 
-func NewUserController(db *gorm.DB, activityService *services.ActivityService, passwordResetService *services.PasswordResetService) *UserController {
+func NewUserController(db *gorm.DB, activityService *services.ActivityService, passwordResetService *services.PasswordResetService, invitationService *services.InvitationService, userArchiveService *services.UserArchiveService, companyService *services.CompanyService) *UserController {
 	return &UserController{
 		db:                   db,
 		activityService:      activityService,
 		passwordResetService: passwordResetService,
+		invitationService:    invitationService,
+		userArchiveService:   userArchiveService,
+		companyService:       companyService,
 	}
 }
 
@@ -33,11 +39,14 @@ func (uc *UserController) ListUsers(c *gin.Context) {
 	}
 	
 	var users []models.User
-	query := uc.db
+	query := uc.db.Preload("Company")
 	
-	if currentUser.Role == models.RoleManager {
+	if !currentUser.HasPermission(models.PermUsersManagePrivileged) {
 		query = query.Where("role = ?", models.RoleSalesperson)
 	}
+	if condition, args := currentUser.CompanyScope(nil).Condition("company_id"); condition != "" {
+		query = query.Where(condition, args...)
+	}
 	
 	if err := query.Find(&users).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
@@ -61,7 +70,7 @@ func (uc *UserController) GetUser(c *gin.Context) {
 	}
 	
 	var user models.User
-	if err := uc.db.First(&user, userID).Error; err != nil {
+	if err := uc.db.Preload("Company").First(&user, userID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		} else {
@@ -78,6 +87,8 @@ func (uc *UserController) GetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
+// CreateUserRequest names the company, if any. Without one the user joins
+// the company their email domain belongs to.
 type CreateUserRequest struct {
 	Email    string            `json:"email" binding:"required,email"`
 	Name     string            `json:"name" binding:"required"`
@@ -99,8 +110,8 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 		return
 	}
 	
-	if currentUser.Role == models.RoleManager && req.Role != models.RoleSalesperson {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Managers can only create salespeople"})
+	if !currentUser.CanAssignRole(req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only create salespeople"})
 		return
 	}
 	
@@ -114,8 +125,30 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 		return
 	}
 	
-	if err := models.ValidateCompany(req.Company); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Users limited to their own company create users in it.
+	var company *models.Company
+	var err error
+	if req.Company != nil && *req.Company != "" {
+		company, err = uc.companyService.FindByName(*req.Company)
+	} else if currentUser.HasPermission(models.PermUsersAllCompanies) {
+		company, err = uc.companyService.ForEmail(req.Email)
+	} else if currentUser.CompanyID != nil {
+		company, err = uc.companyService.GetCompany(*currentUser.CompanyID)
+	}
+	if err == gorm.ErrRecordNotFound && req.Company != nil && *req.Company != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No company is named " + *req.Company})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch companies"})
+		return
+	}
+	
+	var companyID *uint
+	if company != nil {
+		companyID = &company.ID
+	}
+	if !currentUser.CanAssignCompany(companyID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only create users in your own company"})
 		return
 	}
 	
@@ -123,16 +156,19 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 		Email:   req.Email,
 		Name:    req.Name,
 		Role:    req.Role,
-		Company: req.Company,
+		Company: company,
 		Enabled: true,
 	}
+	if company != nil {
+		user.CompanyID = &company.ID
+	}
 	
 	if err := user.SetPassword(req.Password); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set password"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	
-	if err := uc.db.Create(&user).Error; err != nil {
+	if err := uc.db.Omit("Company").Create(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
@@ -142,6 +178,8 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"user": user})
 }
 
+// UpdateUserRequest names the company; an empty name removes the user from
+// theirs.
 type UpdateUserRequest struct {
 	Email   *string          `json:"email"`
 	Name    *string          `json:"name"`
@@ -184,11 +222,20 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		return
 	}
 	
-	if req.Role != nil && currentUser.Role == models.RoleManager {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Managers cannot change user roles"})
+	if req.Role != nil && !currentUser.CanAssignRole(*req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change user roles"})
 		return
 	}
 	
+	// Invited users are enabled by accepting their invitation.
+	if req.Enabled != nil && invitationPending(uc.invitationService, &user) {
+		if *req.Enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "The user has not accepted their invitation yet"})
+			return
+		}
+		req.Enabled = nil
+	}
+	
 	if req.Enabled != nil && !currentUser.CanDisableUser(&user) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot disable this user"})
 		return
@@ -208,17 +255,28 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		user.Role = *req.Role
 	}
 	if req.Company != nil {
-		if err := models.ValidateCompany(req.Company); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		user.CompanyID, user.Company = nil, nil
+		if *req.Company != "" {
+			company, err := uc.companyService.FindByName(*req.Company)
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "No company is named " + *req.Company})
+				return
+			} else if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch companies"})
+				return
+			}
+			user.CompanyID, user.Company = &company.ID, company
+		}
+		if !currentUser.CanAssignCompany(user.CompanyID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only move users into your own company"})
 			return
 		}
-		user.Company = req.Company
 	}
 	if req.Enabled != nil {
 		user.Enabled = *req.Enabled
 	}
 	
-	if err := uc.db.Save(&user).Error; err != nil {
+	if err := uc.db.Omit("Company").Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
@@ -251,24 +309,22 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 		return
 	}
 	
-	if !currentUser.CanManageUser(&user) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
-		return
-	}
-	
-	if currentUser.ID == user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete your own account"})
-		return
-	}
-	
-	if err := uc.db.Delete(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+	if err := uc.userArchiveService.Archive(currentUser, &user, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		switch err {
+		case services.ErrPermissionDenied:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		case services.ErrDeleteSelf:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete your own account"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		}
 		return
 	}
 	
-	uc.activityService.LogUserCRUD(currentUser, &user, "delete", c.ClientIP(), c.Request.UserAgent())
-	
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "User deleted successfully",
+		"restorable_until": user.PurgeAt(uc.userArchiveService.Retention()),
+	})
 }
 
 type AdminResetPasswordRequest struct {
@@ -309,15 +365,56 @@ func (uc *UserController) ResetPassword(c *gin.Context) {
 		return
 	}
 	
-	newPassword, err := uc.passwordResetService.ManualReset(uint(userID), currentUser.ID, req.Reason, c.ClientIP(), c.Request.UserAgent())
+	// The temporary password is not returned here; the caller fetches it
+	// once from RevealPassword with the token.
+	resetEvent, token, err := uc.passwordResetService.ManualResetWithReveal(uint(userID), currentUser.ID, req.Reason, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
 		return
 	}
 	
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Password reset successfully",
-		"new_password": newPassword,
+		"message":           "Password reset successfully",
+		"reveal_token":      token,
+		"reveal_expires_at": resetEvent.RevealExpiresAt,
+	})
+}
+
+type RevealPasswordRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RevealPassword returns the temporary password from a reset made by the
+// same user, once.
+func (uc *UserController) RevealPassword(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	
+	var req RevealPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	resetEvent, err := uc.passwordResetService.FindReveal(currentUser, req.Token)
+	if err != nil || strconv.Itoa(int(resetEvent.UserID)) != c.Param("id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrInvalidRevealLink.Error()})
+		return
+	}
+	
+	password, _, err := uc.passwordResetService.RevealPassword(currentUser, req.Token, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrInvalidRevealLink.Error()})
+		return
+	}
+	
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      resetEvent.UserID,
+		"new_password": password,
 	})
 }
 
@@ -349,6 +446,11 @@ func (uc *UserController) ToggleEnabled(c *gin.Context) {
 		return
 	}
 	
+	if !user.Enabled && invitationPending(uc.invitationService, &user) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The user has not accepted their invitation yet"})
+		return
+	}
+	
 	user.Enabled = !user.Enabled
 	if err := uc.db.Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user status"})
@@ -386,7 +488,20 @@ func (uc *UserController) BulkResetPasswords(c *gin.Context) {
 		return
 	}
 	
-	results, err := uc.passwordResetService.BulkResetPasswords(req.UserIDs, currentUser.ID, req.Reason, c.ClientIP(), c.Request.UserAgent())
+	var users []models.User
+	if err := uc.db.Where("id IN ?", req.UserIDs).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	
+	userIDs := []uint{}
+	for _, user := range users {
+		if currentUser.CanManageUser(&user) {
+			userIDs = append(userIDs, user.ID)
+		}
+	}
+	
+	results, err := uc.passwordResetService.BulkResetPasswords(userIDs, currentUser.ID, req.Reason, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset passwords"})
 		return
@@ -424,7 +539,8 @@ func (uc *UserController) BulkToggleEnabled(c *gin.Context) {
 	
 	updatedUsers := []models.User{}
 	for _, user := range users {
-		if currentUser.CanDisableUser(&user) {
+		// Invited users are skipped: they are enabled by accepting.
+		if currentUser.CanDisableUser(&user) && !(req.Enabled && !user.Enabled && invitationPending(uc.invitationService, &user)) {
 			user.Enabled = req.Enabled
 			if err := uc.db.Save(&user).Error; err == nil {
 				updatedUsers = append(updatedUsers, user)
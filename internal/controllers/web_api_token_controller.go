@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// apiTokenExpiryOptions are the lifetimes offered when creating a token, in
+// days. Zero means the token never expires.
+var apiTokenExpiryOptions = []int{30, 90, 365, 0}
+
+type WebAPITokenController struct {
+	apiTokenService *services.APITokenService
+}
+
+func NewWebAPITokenController(apiTokenService *services.APITokenService) *WebAPITokenController {
+	return &WebAPITokenController{
+		apiTokenService: apiTokenService,
+	}
+}
+
+func (tc *WebAPITokenController) ShowTokens(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	tc.renderTokens(c, http.StatusOK, user, gin.H{})
+}
+
+func (tc *WebAPITokenController) HandleCreateToken(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	// Tokens outlive the session, so an admin logged in as someone else
+	// must not be able to take one away with them.
+	if session := middleware.GetSession(c); session != nil && session.ImpersonatorID != nil {
+		middleware.SetFlashError(c, "You cannot create tokens while logged in as another user.")
+		c.Redirect(http.StatusFound, "/profile/tokens")
+		return
+	}
+
+	name := c.PostForm("name")
+	scopes := c.PostFormArray("scopes")
+	expiresInDays, _ := strconv.Atoi(c.PostForm("expires_in"))
+
+	var expiresAt *time.Time
+	if expiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, expiresInDays)
+		expiresAt = &t
+	}
+
+	apiToken, token, err := tc.apiTokenService.CreateToken(user, name, scopes, expiresAt, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		errors := make(map[string]string)
+		switch err {
+		case services.ErrAPITokenNameRequired:
+			errors["Name"] = "Please give the token a name of up to 100 characters"
+		case services.ErrAPITokenScopeInvalid:
+			errors["Scopes"] = "Please choose at least one scope"
+		default:
+			errors["General"] = "Failed to create token"
+		}
+		tc.renderTokens(c, http.StatusBadRequest, user, gin.H{
+			"Errors":         errors,
+			"FormData":       gin.H{"name": name, "expires_in": expiresInDays},
+			"SelectedScopes": selectedScopes(scopes),
+		})
+		return
+	}
+
+	// The token is rendered straight away rather than passed through a flash
+	// cookie, and is never shown again.
+	tc.renderTokens(c, http.StatusOK, user, gin.H{
+		"NewToken":     token,
+		"NewTokenName": apiToken.Name,
+	})
+}
+
+func (tc *WebAPITokenController) HandleRevokeToken(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid token")
+		c.Redirect(http.StatusFound, "/profile/tokens")
+		return
+	}
+
+	if err := tc.apiTokenService.RevokeToken(user, uint(tokenID), c.ClientIP(), c.Request.UserAgent()); err != nil {
+		middleware.SetFlashError(c, "That token no longer exists")
+		c.Redirect(http.StatusFound, "/profile/tokens")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Token revoked. Scripts using it will stop working immediately.")
+	c.Redirect(http.StatusFound, "/profile/tokens")
+}
+
+func (tc *WebAPITokenController) renderTokens(c *gin.Context, status int, user *models.User, data gin.H) {
+	tokens, err := tc.apiTokenService.GetUserTokens(user.ID)
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load your API tokens")
+		c.Redirect(http.StatusFound, "/profile")
+		return
+	}
+
+	data["Title"] = "API Tokens"
+	data["User"] = user
+	data["ActiveNav"] = "profile"
+	data["Tokens"] = tokens
+	data["Scopes"] = models.APITokenScopes
+	data["ExpiryOptions"] = apiTokenExpiryOptions
+	if _, set := data["FormData"]; !set {
+		data["FormData"] = gin.H{"expires_in": 90}
+		data["SelectedScopes"] = selectedScopes([]string{models.ScopeUsersRead})
+	}
+	middleware.RenderHTML(c, status, "auth/api_tokens.html", data)
+}
+
+func selectedScopes(scopes []string) map[string]bool {
+	selected := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		selected[scope] = true
+	}
+	return selected
+}
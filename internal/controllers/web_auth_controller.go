@@ -1,27 +1,44 @@
 package controllers
 
 import (
+	"crypto/subtle"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
 	"alsafwanmarine.com/todo-app/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
 type WebAuthController struct {
-	authService *services.AuthService
+	authService      *services.AuthService
+	twoFactorService *services.TwoFactorService
+	sessionService   *services.SessionService
+	activityService  *services.ActivityService
+	ssoService       *services.SSOService
+	impersonationService *services.ImpersonationService
+	securityAlertService *services.SecurityAlertService
 }
 
-func NewWebAuthController(authService *services.AuthService) *WebAuthController {
+func NewWebAuthController(authService *services.AuthService, twoFactorService *services.TwoFactorService, sessionService *services.SessionService, activityService *services.ActivityService, ssoService *services.SSOService, impersonationService *services.ImpersonationService, securityAlertService *services.SecurityAlertService) *WebAuthController {
 	return &WebAuthController{
-		authService: authService,
+		authService:      authService,
+		twoFactorService: twoFactorService,
+		sessionService:   sessionService,
+		activityService:  activityService,
+		ssoService:       ssoService,
+		impersonationService: impersonationService,
+		securityAlertService: securityAlertService,
 	}
 }
 
 func (ac *WebAuthController) ShowLogin(c *gin.Context) {
 	// Try to render template, fall back to simple HTML if template fails
-	c.HTML(http.StatusOK, "login.html", gin.H{
+	ac.renderLogin(c, http.StatusOK, gin.H{
 		"Title": "Login",
 		"Errors": make(map[string]string),
 		"FormData": gin.H{
@@ -43,6 +60,7 @@ func (ac *WebAuthController) HandleLogin(c *gin.Context) {
 	credentials.Email = c.PostForm("email")
 	credentials.Password = c.PostForm("password")
 	remember := c.PostForm("remember") == "on"
+	credentials.Remember = remember
 
 	errors := make(map[string]string)
 	formData := gin.H{
@@ -59,7 +77,7 @@ func (ac *WebAuthController) HandleLogin(c *gin.Context) {
 	}
 
 	if len(errors) > 0 {
-		c.HTML(http.StatusBadRequest, "login.html", gin.H{
+		ac.renderLogin(c, http.StatusBadRequest, gin.H{
 			"Title":    "Login",
 			"Errors":   errors,
 			"FormData": formData,
@@ -69,16 +87,25 @@ func (ac *WebAuthController) HandleLogin(c *gin.Context) {
 
 	result, err := ac.authService.Login(credentials, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
-		switch err {
-		case services.ErrInvalidCredentials:
-			errors["General"] = "Invalid email or password"
-		case services.ErrRateLimited:
-			errors["General"] = "Too many login attempts. Please try again later."
-		default:
-			errors["General"] = "Login failed. Please try again."
+		status := http.StatusBadRequest
+		if remaining, locked := services.LockoutRemaining(err); locked {
+			status = http.StatusTooManyRequests
+			errors["General"] = "This account is locked after too many failed attempts. Please try again in " + formatLockout(remaining) + "."
+		} else {
+			switch err {
+			case services.ErrInvalidCredentials:
+				errors["General"] = "Invalid email or password"
+			case services.ErrRateLimited:
+				errors["General"] = "Too many login attempts. Please try again later."
+			case services.ErrIPNotAllowed:
+				status = http.StatusForbidden
+				errors["General"] = "Signing in from this network is not allowed for your account. Please contact an administrator."
+			default:
+				errors["General"] = "Login failed. Please try again."
+			}
 		}
 
-		c.HTML(http.StatusBadRequest, "login.html", gin.H{
+		ac.renderLogin(c, status, gin.H{
 			"Title":    "Login",
 			"Errors":   errors,
 			"FormData": formData,
@@ -86,47 +113,323 @@ func (ac *WebAuthController) HandleLogin(c *gin.Context) {
 		return
 	}
 
-	// Set session cookie
-	cookieMaxAge := int(30 * time.Minute.Seconds())
-	if remember {
-		cookieMaxAge = int(24 * 7 * time.Hour.Seconds()) // 1 week
+	if result.TwoFactorRequired {
+		ac.beginTwoFactorChallenge(c, result)
+		return
 	}
 
+	ac.startSession(c, result)
+}
+
+func (ac *WebAuthController) completeSSOLink(c *gin.Context, user *models.User, state string) {
+	if middleware.GetImpersonator(c) != nil {
+		middleware.SetFlashError(c, "You cannot change sign-in settings while logged in as another user.")
+		c.Redirect(http.StatusFound, "/profile")
+		return
+	}
+
+	err := ac.ssoService.CompleteLink(c.Request.Context(), c.Param("provider"), state, c.Query("code"), user, c.ClientIP(), c.Request.UserAgent())
+	switch err {
+	case nil:
+		middleware.SetFlashSuccess(c, "You can now sign in with this provider.")
+	case services.ErrSSOStateInvalid:
+		middleware.SetFlashError(c, "Your link attempt has expired. Please try again.")
+	case services.ErrSSOIdentityTaken:
+		middleware.SetFlashError(c, "This provider account is already linked to another user.")
+	default:
+		log.Printf("SSO link with %q failed: %v", c.Param("provider"), err)
+		middleware.SetFlashError(c, "Linking the provider failed. Please try again.")
+	}
+	c.Redirect(http.StatusFound, "/profile")
+}
+
+func (ac *WebAuthController) renderLogin(c *gin.Context, status int, data gin.H) {
+	data["SSOProviders"] = ac.ssoService.Providers()
+	middleware.RenderHTML(c, status, "auth/login.html", data)
+}
+
+func (ac *WebAuthController) beginTwoFactorChallenge(c *gin.Context, result *services.LoginResult) {
 	c.SetCookie(
-		"session_token",
-		result.Token,
-		cookieMaxAge,
-		"/",
+		"two_factor_challenge",
+		result.ChallengeToken,
+		int(5 * time.Minute.Seconds()),
+		"/login/two-factor",
 		"",
-		true,  // Secure
-		true,  // HttpOnly
+		middleware.SecureCookies(),
+		true,
 	)
+	c.Redirect(http.StatusFound, "/login/two-factor")
+}
+
+const ssoStateCookie = "sso_state"
+
+// HandleSSOLogin sends the browser to the company's identity provider. The
+// state is also kept in a cookie so the callback only completes a sign-in
+// that this browser started.
+func (ac *WebAuthController) HandleSSOLogin(c *gin.Context) {
+	if user := middleware.GetCurrentUser(c); user != nil {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	authURL, state, err := ac.ssoService.BeginLogin(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		if err == services.ErrSSOProviderUnknown {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+		log.Printf("SSO login with %q failed to start: %v", c.Param("provider"), err)
+		middleware.SetFlashError(c, "Single sign-on is not available right now. Please try again later.")
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	c.SetCookie(ssoStateCookie, state, 600, "/login/sso", "", middleware.SecureCookies(), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// HandleSSOLink sends a signed-in user to the provider to link it to their
+// account. The callback is shared with HandleSSOLogin.
+func (ac *WebAuthController) HandleSSOLink(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	authURL, state, err := ac.ssoService.BeginLink(c.Request.Context(), c.Param("provider"), user)
+	if err != nil {
+		if err != services.ErrSSOProviderUnknown {
+			log.Printf("SSO link with %q failed to start: %v", c.Param("provider"), err)
+		}
+		middleware.SetFlashError(c, "Single sign-on is not available right now. Please try again later.")
+		c.Redirect(http.StatusFound, "/profile")
+		return
+	}
+
+	c.SetCookie(ssoStateCookie, state, 600, "/login/sso", "", middleware.SecureCookies(), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (ac *WebAuthController) HandleSSOCallback(c *gin.Context) {
+	state := c.Query("state")
+	cookieState, _ := c.Cookie(ssoStateCookie)
+	c.SetCookie(ssoStateCookie, "", -1, "/login/sso", "", middleware.SecureCookies(), true)
+
+	// Signed-in users only come back here from HandleSSOLink.
+	user := middleware.GetCurrentUser(c)
+	failureURL := "/login"
+	if user != nil {
+		failureURL = "/profile"
+	}
+
+	if c.Query("error") != "" {
+		middleware.SetFlashError(c, "Sign-in was cancelled or refused by your identity provider.")
+		c.Redirect(http.StatusFound, failureURL)
+		return
+	}
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		middleware.SetFlashError(c, "Your sign-in attempt has expired. Please sign in again.")
+		c.Redirect(http.StatusFound, failureURL)
+		return
+	}
+
+	if user != nil {
+		ac.completeSSOLink(c, user, state)
+		return
+	}
+
+	result, err := ac.ssoService.CompleteLogin(c.Request.Context(), c.Param("provider"), state, c.Query("code"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch err {
+		case services.ErrSSOStateInvalid:
+			middleware.SetFlashError(c, "Your sign-in attempt has expired. Please sign in again.")
+		case services.ErrSSOAccountNotFound:
+			middleware.SetFlashError(c, "There is no account for your company login. Please contact an administrator.")
+		case services.ErrSSOEmailUnverified:
+			middleware.SetFlashError(c, "Your identity provider has not verified your email address.")
+		case services.ErrSSOCompanyMismatch, services.ErrUserDisabled:
+			middleware.SetFlashError(c, "This account cannot sign in with this provider. Please contact an administrator.")
+		case services.ErrSSOLinkRequired:
+			middleware.SetFlashError(c, "Please sign in with your password and link this provider from your profile first.")
+		case services.ErrSSOCompanyUnknown:
+			log.Printf("SSO login with %q failed: %v", c.Param("provider"), err)
+			middleware.SetFlashError(c, "This provider is not set up correctly. Please contact an administrator.")
+		case services.ErrIPNotAllowed:
+			middleware.SetFlashError(c, "Signing in from this network is not allowed for your account. Please contact an administrator.")
+		default:
+			log.Printf("SSO login with %q failed: %v", c.Param("provider"), err)
+			middleware.SetFlashError(c, "Single sign-on failed. Please try again.")
+		}
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if result.TwoFactorRequired {
+		ac.beginTwoFactorChallenge(c, result)
+		return
+	}
+
+	ac.startSession(c, result)
+}
+
+func (ac *WebAuthController) ShowTwoFactorLogin(c *gin.Context) {
+	if _, err := c.Cookie("two_factor_challenge"); err != nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "auth/two_factor_login.html", gin.H{
+		"Title":  "Two-Factor Authentication",
+		"Errors": make(map[string]string),
+	})
+}
+
+func (ac *WebAuthController) HandleTwoFactorLogin(c *gin.Context) {
+	challengeToken, err := c.Cookie("two_factor_challenge")
+	if err != nil || challengeToken == "" {
+		middleware.SetFlashError(c, "Your sign-in attempt has expired. Please sign in again.")
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	errors := make(map[string]string)
+	code := c.PostForm("code")
+	if code == "" {
+		errors["Code"] = "Authentication code is required"
+		middleware.RenderHTML(c, http.StatusBadRequest, "auth/two_factor_login.html", gin.H{
+			"Title":  "Two-Factor Authentication",
+			"Errors": errors,
+		})
+		return
+	}
+
+	result, err := ac.authService.CompleteTwoFactorLogin(challengeToken, code, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if remaining, locked := services.LockoutRemaining(err); locked {
+			ac.clearTwoFactorChallenge(c)
+			middleware.SetFlashError(c, "This account is locked after too many failed attempts. Please try again in "+formatLockout(remaining)+".")
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		if err == services.ErrInvalidTwoFactorCode {
+			errors["Code"] = "Invalid authentication code"
+			middleware.RenderHTML(c, http.StatusBadRequest, "auth/two_factor_login.html", gin.H{
+				"Title":  "Two-Factor Authentication",
+				"Errors": errors,
+			})
+			return
+		}
+
+		ac.clearTwoFactorChallenge(c)
+		if err == services.ErrIPNotAllowed {
+			middleware.SetFlashError(c, "Signing in from this network is not allowed for your account. Please contact an administrator.")
+		} else {
+			middleware.SetFlashError(c, "Your sign-in attempt has expired. Please sign in again.")
+		}
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	ac.clearTwoFactorChallenge(c)
+	ac.startSession(c, result)
+}
+
+func (ac *WebAuthController) clearTwoFactorChallenge(c *gin.Context) {
+	c.SetCookie("two_factor_challenge", "", -1, "/login/two-factor", "", middleware.SecureCookies(), true)
+}
+
+// formatLockout renders the remaining lockout time for the login page,
+// rounded up so users are never told to retry too early.
+func formatLockout(remaining time.Duration) string {
+	minutes := int(math.Ceil(remaining.Minutes()))
+	if minutes <= 1 {
+		return "1 minute"
+	}
+	if minutes < 60 {
+		return strconv.Itoa(minutes) + " minutes"
+	}
+
+	hours := (minutes + 59) / 60
+	if hours == 1 {
+		return "1 hour"
+	}
+	return strconv.Itoa(hours) + " hours"
+}
+
+func (ac *WebAuthController) startSession(c *gin.Context, result *services.LoginResult) {
+	middleware.SetSessionCookies(c, result)
+
+	if result.PasswordChangeRequired {
+		middleware.SetFlashWarning(c, "Your password has expired or was reset by an administrator. Please choose a new one.")
+		c.Redirect(http.StatusFound, "/profile/password")
+		return
+	}
 
 	middleware.SetFlashSuccess(c, "Welcome back, "+result.User.Name+"!")
 	c.Redirect(http.StatusFound, "/")
 }
 
+// HandleLogout signs the browser out. It only answers POST with a CSRF
+// token, so another site cannot sign users out with a link or an image.
 func (ac *WebAuthController) HandleLogout(c *gin.Context) {
 	token := middleware.GetSessionToken(c)
 	if token != "" {
-		ac.authService.Logout(token, c.ClientIP(), c.Request.UserAgent())
+		// An impersonated session is stopped rather than logged out, so the
+		// admin gets the end of the impersonation recorded.
+		if _, err := ac.impersonationService.Stop(token, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			ac.authService.Logout(token, c.ClientIP(), c.Request.UserAgent())
+		}
 	}
 
-	// Clear session cookie
-	c.SetCookie(
-		"session_token",
-		"",
-		-1,
-		"/",
-		"",
-		true,
-		true,
-	)
+	// Logging out while impersonating ends the admin's own session too,
+	// instead of leaving it behind in the browser.
+	if ownToken := middleware.StopImpersonation(c); ownToken != "" {
+		ac.authService.Logout(ownToken, c.ClientIP(), c.Request.UserAgent())
+	}
+
+	// Forget this device even if its session had already timed out.
+	if rememberToken := middleware.GetRememberToken(c); rememberToken != "" {
+		ac.sessionService.ForgetRememberToken(rememberToken)
+	}
+
+	middleware.ClearSessionCookies(c)
 
 	middleware.SetFlashInfo(c, "You have been logged out successfully.")
 	c.Redirect(http.StatusFound, "/login")
 }
 
+// RejectLogoutLink answers GET /logout, left over from when signing out was
+// a link.
+func (ac *WebAuthController) RejectLogoutLink(c *gin.Context) {
+	c.Header("Allow", http.MethodPost)
+	c.String(http.StatusMethodNotAllowed, "Use the Sign Out button to sign out.")
+}
+
+// HandleStopImpersonation ends the impersonated session and puts the admin
+// back into their own one, or back to the login page if it has expired.
+func (ac *WebAuthController) HandleStopImpersonation(c *gin.Context) {
+	impersonator, err := ac.impersonationService.Stop(middleware.GetSessionToken(c), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	ownToken := middleware.StopImpersonation(c)
+	if user, _, err := ac.authService.GetCurrentSession(ownToken); err == nil && user.ID == impersonator.ID {
+		middleware.SetSessionCookies(c, &services.LoginResult{User: user, Token: ownToken})
+		middleware.SetFlashSuccess(c, "Welcome back, "+user.Name+".")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	middleware.ClearSessionCookies(c)
+	middleware.SetFlashInfo(c, "Your own session has ended. Please sign in again.")
+	c.Redirect(http.StatusFound, "/login")
+}
+
 func (ac *WebAuthController) ShowProfile(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 	if user == nil {
@@ -134,14 +437,254 @@ func (ac *WebAuthController) ShowProfile(c *gin.Context) {
 		return
 	}
 
-	c.HTML(http.StatusOK, "base.html", gin.H{
+	middleware.RenderHTML(c, http.StatusOK, "auth/profile.html", gin.H{
 		"Title":    "My Profile",
 		"User":     user,
 		"ActiveNav": "profile",
 		"ViewUser": user,
+		"TwoFactorRequired": ac.twoFactorService.IsRequired(user),
+		"SSOProviders":      ac.ssoService.Providers(),
+		"SSOIdentities":     ac.linkedIdentities(user),
+	})
+}
+
+// linkedIdentities maps provider keys to the user's identity there.
+func (ac *WebAuthController) linkedIdentities(user *models.User) map[string]*models.UserIdentity {
+	identities, err := ac.ssoService.GetUserIdentities(user.ID)
+	if err != nil {
+		log.Printf("Failed to load linked identities of user %d: %v", user.ID, err)
+	}
+	linked := make(map[string]*models.UserIdentity, len(identities))
+	for i := range identities {
+		linked[identities[i].Provider] = &identities[i]
+	}
+	return linked
+}
+
+func (ac *WebAuthController) ShowSessions(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	sessions, err := ac.sessionService.GetActiveUserSessions(user.ID)
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load your sessions")
+		c.Redirect(http.StatusFound, "/profile")
+		return
+	}
+
+	alerts, err := ac.securityAlertService.GetUserOpenAlerts(user.ID)
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load your security alerts")
+		c.Redirect(http.StatusFound, "/profile")
+		return
+	}
+
+	var currentSessionID uint
+	if current, _ := ac.sessionService.GetSessionByToken(middleware.GetSessionToken(c)); current != nil {
+		currentSessionID = current.ID
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "auth/sessions.html", gin.H{
+		"Title":            "My Sessions",
+		"User":             user,
+		"ActiveNav":        "profile",
+		"Sessions":         sessions,
+		"Alerts":           alerts,
+		"CurrentSessionID": currentSessionID,
 	})
 }
 
+func (ac *WebAuthController) HandleConfirmAlert(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	alertID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid alert")
+		c.Redirect(http.StatusFound, "/profile/sessions")
+		return
+	}
+
+	if err := ac.securityAlertService.ConfirmAlert(user, uint(alertID), c.ClientIP(), c.Request.UserAgent()); err != nil {
+		middleware.SetFlashError(c, "That alert has already been dealt with")
+		c.Redirect(http.StatusFound, "/profile/sessions")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Thanks for confirming. The alert has been closed.")
+	c.Redirect(http.StatusFound, "/profile/sessions")
+}
+
+func (ac *WebAuthController) HandleRevokeAlert(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	alertID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid alert")
+		c.Redirect(http.StatusFound, "/profile/sessions")
+		return
+	}
+
+	current, _ := ac.sessionService.GetSessionByToken(middleware.GetSessionToken(c))
+
+	alert, err := ac.securityAlertService.RevokeAlert(user, uint(alertID), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		middleware.SetFlashError(c, "That alert has already been dealt with")
+		c.Redirect(http.StatusFound, "/profile/sessions")
+		return
+	}
+
+	if current != nil && alert.SessionID != nil && *alert.SessionID == current.ID {
+		middleware.ClearSessionCookies(c)
+		middleware.SetFlashSuccess(c, "That session has been signed out. Sign in again and change your password.")
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "That session has been signed out. We recommend changing your password.")
+	c.Redirect(http.StatusFound, "/profile/sessions")
+}
+
+func (ac *WebAuthController) HandleRevokeSession(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid session")
+		c.Redirect(http.StatusFound, "/profile/sessions")
+		return
+	}
+
+	if current, _ := ac.sessionService.GetSessionByToken(middleware.GetSessionToken(c)); current != nil && current.ID == uint(sessionID) {
+		middleware.SetFlashError(c, "Use Sign Out to end the session you are using.")
+		c.Redirect(http.StatusFound, "/profile/sessions")
+		return
+	}
+
+	if err := ac.sessionService.RevokeUserSession(user.ID, uint(sessionID)); err != nil {
+		middleware.SetFlashError(c, "That session no longer exists")
+		c.Redirect(http.StatusFound, "/profile/sessions")
+		return
+	}
+
+	ac.activityService.LogSessionsRevoked(user, user, 1, "single", c.ClientIP(), c.Request.UserAgent())
+
+	middleware.SetFlashSuccess(c, "Session revoked.")
+	c.Redirect(http.StatusFound, "/profile/sessions")
+}
+
+func (ac *WebAuthController) HandleRevokeOtherSessions(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	count, err := ac.sessionService.DestroyOtherUserSessions(user.ID, middleware.GetSessionToken(c))
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to sign out your other sessions")
+		c.Redirect(http.StatusFound, "/profile/sessions")
+		return
+	}
+
+	ac.activityService.LogSessionsRevoked(user, user, count, "others", c.ClientIP(), c.Request.UserAgent())
+
+	middleware.SetFlashSuccess(c, "Signed out of "+strconv.FormatInt(count, 10)+" other session(s).")
+	c.Redirect(http.StatusFound, "/profile/sessions")
+}
+
+func (ac *WebAuthController) ShowTwoFactorSetup(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	ac.renderTwoFactorSetup(c, http.StatusOK, user, make(map[string]string))
+}
+
+func (ac *WebAuthController) HandleEnableTwoFactor(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	errors := make(map[string]string)
+	err := ac.twoFactorService.ConfirmEnrollment(user, c.PostForm("code"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if err == services.ErrInvalidTwoFactorCode {
+			errors["Code"] = "That code did not match. Check the time on your device and try again."
+		} else {
+			errors["General"] = err.Error()
+		}
+		ac.renderTwoFactorSetup(c, http.StatusBadRequest, user, errors)
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Two-factor authentication is now enabled.")
+	c.Redirect(http.StatusFound, "/profile")
+}
+
+func (ac *WebAuthController) HandleDisableTwoFactor(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	errors := make(map[string]string)
+	err := ac.twoFactorService.Disable(user, c.PostForm("password"), c.PostForm("code"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if err == services.ErrInvalidTwoFactorCode {
+			errors["Code"] = "Invalid authentication code"
+		} else {
+			errors["General"] = err.Error()
+		}
+		ac.renderTwoFactorSetup(c, http.StatusBadRequest, user, errors)
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Two-factor authentication has been disabled.")
+	c.Redirect(http.StatusFound, "/profile")
+}
+
+func (ac *WebAuthController) renderTwoFactorSetup(c *gin.Context, status int, user *models.User, errors map[string]string) {
+	data := gin.H{
+		"Title":             "Two-Factor Authentication",
+		"User":              user,
+		"ActiveNav":         "profile",
+		"Errors":            errors,
+		"TwoFactorRequired": ac.twoFactorService.IsRequired(user),
+	}
+
+	if !user.TOTPEnabled {
+		enrollment, err := ac.twoFactorService.BeginEnrollment(user)
+		if err != nil {
+			middleware.SetFlashError(c, "Failed to start two-factor setup")
+			c.Redirect(http.StatusFound, "/profile")
+			return
+		}
+		data["Enrollment"] = enrollment
+	}
+
+	middleware.RenderHTML(c, status, "auth/two_factor_setup.html", data)
+}
+
 func (ac *WebAuthController) ShowChangePassword(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 	if user == nil {
@@ -149,11 +692,12 @@ func (ac *WebAuthController) ShowChangePassword(c *gin.Context) {
 		return
 	}
 
-	c.HTML(http.StatusOK, "base.html", gin.H{
+	middleware.RenderHTML(c, http.StatusOK, "auth/change_password.html", gin.H{
 		"Title":    "Change Password",
 		"User":     user,
 		"ActiveNav": "profile",
 		"Errors":   make(map[string]string),
+		"PasswordChangeRequired": user.NeedsPasswordChange(),
 	})
 }
 
@@ -176,31 +720,37 @@ func (ac *WebAuthController) HandleChangePassword(c *gin.Context) {
 	}
 	if newPassword == "" {
 		errors["NewPassword"] = "New password is required"
-	} else if len(newPassword) < 6 {
-		errors["NewPassword"] = "Password must be at least 6 characters"
+	} else if err := models.GetPasswordPolicy().Validate(newPassword, user); err != nil {
+		errors["NewPassword"] = err.Error()
 	}
 	if newPassword != confirmPassword {
 		errors["ConfirmPassword"] = "Passwords do not match"
 	}
 
 	if len(errors) > 0 {
-		c.HTML(http.StatusBadRequest, "base.html", gin.H{
+		middleware.RenderHTML(c, http.StatusBadRequest, "auth/change_password.html", gin.H{
 			"Title":    "Change Password",
 			"User":     user,
 			"ActiveNav": "profile",
 			"Errors":   errors,
+			"PasswordChangeRequired": user.NeedsPasswordChange(),
 		})
 		return
 	}
 
-	err := ac.authService.ChangePassword(user.ID, currentPassword, newPassword)
+	err := ac.authService.ChangePassword(user.ID, currentPassword, newPassword, middleware.GetSessionToken(c))
 	if err != nil {
-		errors["General"] = err.Error()
-		c.HTML(http.StatusBadRequest, "base.html", gin.H{
+		if err == models.ErrPasswordReused {
+			errors["NewPassword"] = err.Error()
+		} else {
+			errors["General"] = err.Error()
+		}
+		middleware.RenderHTML(c, http.StatusBadRequest, "auth/change_password.html", gin.H{
 			"Title":    "Change Password",
 			"User":     user,
 			"ActiveNav": "profile",
 			"Errors":   errors,
+			"PasswordChangeRequired": user.NeedsPasswordChange(),
 		})
 		return
 	}
@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WebCompanyController struct {
+	companyService *services.CompanyService
+}
+
+func NewWebCompanyController(companyService *services.CompanyService) *WebCompanyController {
+	return &WebCompanyController{
+		companyService: companyService,
+	}
+}
+
+func (cc *WebCompanyController) ShowCompanies(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	companies, err := cc.companyService.GetCompanies()
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load companies")
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+	userCounts, err := cc.companyService.CountUsers()
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load companies")
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "companies/index.html", gin.H{
+		"Title":      "Companies",
+		"User":       user,
+		"ActiveNav":  "companies",
+		"Companies":  companies,
+		"UserCounts": userCounts,
+	})
+}
+
+func (cc *WebCompanyController) ShowNewCompany(c *gin.Context) {
+	if middleware.GetCurrentUser(c) == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	cc.renderForm(c, http.StatusOK, &models.Company{DefaultRole: models.RoleSalesperson}, "")
+}
+
+// HandleCreateCompany adds a company and, if one was uploaded, its logo.
+func (cc *WebCompanyController) HandleCreateCompany(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	company := companyFromForm(c)
+	logo, err := readLogo(c)
+	if err != nil {
+		cc.renderForm(c, http.StatusBadRequest, company, err.Error())
+		return
+	}
+
+	if err := cc.companyService.CreateCompany(user, company, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		cc.renderForm(c, http.StatusBadRequest, company, companyErrorMessage(err))
+		return
+	}
+
+	if logo != nil {
+		if err := cc.companyService.SetLogo(user, company.ID, logo, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			middleware.SetFlashError(c, "Company added, but its logo was not saved: "+companyErrorMessage(err))
+			c.Redirect(http.StatusFound, "/companies/"+strconv.Itoa(int(company.ID))+"/edit")
+			return
+		}
+	}
+
+	middleware.SetFlashSuccess(c, "Company "+company.Name+" added")
+	c.Redirect(http.StatusFound, "/companies")
+}
+
+func (cc *WebCompanyController) ShowEditCompany(c *gin.Context) {
+	if middleware.GetCurrentUser(c) == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	company, ok := cc.loadCompany(c)
+	if !ok {
+		return
+	}
+
+	cc.renderForm(c, http.StatusOK, company, "")
+}
+
+// HandleUpdateCompany saves the company's settings, then replaces or
+// removes its logo if asked to.
+func (cc *WebCompanyController) HandleUpdateCompany(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	existing, ok := cc.loadCompany(c)
+	if !ok {
+		return
+	}
+
+	company := companyFromForm(c)
+	company.ID = existing.ID
+	company.LogoUpdatedAt = existing.LogoUpdatedAt
+	logo, err := readLogo(c)
+	if err != nil {
+		cc.renderForm(c, http.StatusBadRequest, company, err.Error())
+		return
+	}
+
+	if err := cc.companyService.UpdateCompany(user, company, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		cc.renderForm(c, http.StatusBadRequest, company, companyErrorMessage(err))
+		return
+	}
+
+	if logo != nil {
+		err = cc.companyService.SetLogo(user, company.ID, logo, c.ClientIP(), c.Request.UserAgent())
+	} else if c.PostForm("remove_logo") == "true" {
+		err = cc.companyService.RemoveLogo(user, company.ID, c.ClientIP(), c.Request.UserAgent())
+	}
+	if err != nil {
+		cc.renderForm(c, http.StatusBadRequest, company, "Company saved, but its logo was not: "+companyErrorMessage(err))
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Company "+company.Name+" updated")
+	c.Redirect(http.StatusFound, "/companies")
+}
+
+func (cc *WebCompanyController) HandleDeleteCompany(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	companyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid company")
+		c.Redirect(http.StatusFound, "/companies")
+		return
+	}
+
+	if err := cc.companyService.DeleteCompany(user, uint(companyID), c.ClientIP(), c.Request.UserAgent()); err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			middleware.SetFlashError(c, "That company no longer exists")
+		case services.ErrCompanyInUse:
+			middleware.SetFlashError(c, "Move its users and IP rules to another company before deleting it")
+		default:
+			middleware.SetFlashError(c, "Failed to delete the company")
+		}
+		c.Redirect(http.StatusFound, "/companies")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Company deleted")
+	c.Redirect(http.StatusFound, "/companies")
+}
+
+// ServeLogo sends a company's logo to any signed-in user.
+func (cc *WebCompanyController) ServeLogo(c *gin.Context) {
+	companyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	logo, err := cc.companyService.GetLogo(uint(companyID))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// The URL changes with the logo, so it can be cached for long.
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, logo.ContentType, logo.Data)
+}
+
+// HandleSetContext narrows the directory and dashboard to one company, or
+// widens them to every company again, and returns to the page it was
+// posted from.
+func (cc *WebCompanyController) HandleSetContext(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	session := middleware.GetSession(c)
+	if session == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	var companyID *uint
+	if value := c.PostForm("company_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			middleware.SetFlashError(c, "Invalid company")
+			c.Redirect(http.StatusFound, middleware.SameSiteReferer(c))
+			return
+		}
+		contextID := uint(id)
+		companyID = &contextID
+	}
+
+	if err := cc.companyService.SetContext(user, session, companyID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			middleware.SetFlashError(c, "That company no longer exists")
+		} else {
+			middleware.SetFlashError(c, "Failed to switch company")
+		}
+	}
+	c.Redirect(http.StatusFound, middleware.SameSiteReferer(c))
+}
+
+func (cc *WebCompanyController) loadCompany(c *gin.Context) (*models.Company, bool) {
+	companyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid company")
+		c.Redirect(http.StatusFound, "/companies")
+		return nil, false
+	}
+
+	company, err := cc.companyService.GetCompany(uint(companyID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			middleware.SetFlashError(c, "That company no longer exists")
+		} else {
+			middleware.SetFlashError(c, "Failed to load the company")
+		}
+		c.Redirect(http.StatusFound, "/companies")
+		return nil, false
+	}
+	return company, true
+}
+
+func (cc *WebCompanyController) renderForm(c *gin.Context, status int, company *models.Company, errorMessage string) {
+	title := "Add Company"
+	if company.ID != 0 {
+		title = "Edit Company"
+	}
+
+	middleware.RenderHTML(c, status, "companies/form.html", gin.H{
+		"Title":        title,
+		"User":         middleware.GetCurrentUser(c),
+		"ActiveNav":    "companies",
+		"Company":      company,
+		"Error":        errorMessage,
+		"DefaultRoles": []models.UserRole{models.RoleSalesperson, models.RoleManager},
+	})
+}
+
+func companyFromForm(c *gin.Context) *models.Company {
+	company := &models.Company{
+		Name:        c.PostForm("name"),
+		EmailDomain: c.PostForm("email_domain"),
+		DefaultRole: models.RoleSalesperson,
+	}
+	if role, ok := models.ParseRole(c.PostForm("default_role")); ok {
+		company.DefaultRole = role
+	}
+	return company
+}
+
+// readLogo returns the uploaded logo, or nil if none was chosen.
+func readLogo(c *gin.Context) ([]byte, error) {
+	header, err := c.FormFile("logo")
+	if err == http.ErrMissingFile || err == http.ErrNotMultipart {
+		return nil, nil
+	}
+	if err != nil || header.Size > models.MaxCompanyLogoSize {
+		return nil, services.ErrCompanyLogoInvalid
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, services.ErrCompanyLogoInvalid
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, models.MaxCompanyLogoSize+1))
+}
+
+func companyErrorMessage(err error) string {
+	switch err {
+	case services.ErrCompanyNameInvalid, services.ErrCompanyNameTaken,
+		services.ErrCompanyDomainInvalid, services.ErrCompanyDomainTaken,
+		services.ErrCompanyRoleInvalid, services.ErrCompanyLogoInvalid:
+		return err.Error()
+	case services.ErrPermissionDenied:
+		return "Access denied"
+	case gorm.ErrRecordNotFound:
+		return "That company no longer exists"
+	}
+	return "Failed to save the company"
+}
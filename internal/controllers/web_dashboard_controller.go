@@ -4,20 +4,23 @@ import (
 	"time"
 
 	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
 	"alsafwanmarine.com/todo-app/internal/services"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type WebDashboardController struct {
-	db              *gorm.DB
-	activityService *services.ActivityService
+	db                   *gorm.DB
+	activityService      *services.ActivityService
+	securityAlertService *services.SecurityAlertService
 }
 
-func NewWebDashboardController(db *gorm.DB, activityService *services.ActivityService) *WebDashboardController {
+func NewWebDashboardController(db *gorm.DB, activityService *services.ActivityService, securityAlertService *services.SecurityAlertService) *WebDashboardController {
 	return &WebDashboardController{
-		db:              db,
-		activityService: activityService,
+		db:                   db,
+		activityService:      activityService,
+		securityAlertService: securityAlertService,
 	}
 }
 
@@ -39,6 +42,17 @@ func (dc *WebDashboardController) ShowDashboard(c *gin.Context) {
 	stats := DashboardStats{}
 	today := time.Now().Truncate(24 * time.Hour)
 	
+	// Statistics, activity and alerts cover the user's company scope, and
+	// archived users are left out of the counts
+	scope := middleware.GetCompanyScope(c)
+	userWhere, userAnd, activityAnd := " WHERE deleted_at IS NULL", " AND deleted_at IS NULL", ""
+	condition, companyArgs := scope.Condition("company_id")
+	if condition != "" {
+		userWhere += " AND " + condition
+		userAnd += " AND " + condition
+		activityAnd = " AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL AND " + condition + ")"
+	}
+	
 	// Use a single transaction to reduce database roundtrips
 	tx := dc.db.Begin()
 	defer func() {
@@ -51,11 +65,12 @@ func (dc *WebDashboardController) ShowDashboard(c *gin.Context) {
 	queries := []struct {
 		name  string
 		query string
+		args  []interface{}
 	}{
-		{"total_users", "SELECT 'total_users' as query_type, COUNT(*) as count FROM users"},
-		{"active_users", "SELECT 'active_users' as query_type, COUNT(*) as count FROM users WHERE enabled = 1"},
-		{"sessions_today", "SELECT 'sessions_today' as query_type, COUNT(*) as count FROM user_activities WHERE activity_type = 'login' AND performed_at >= ?"},
-		{"failed_logins_today", "SELECT 'failed_logins_today' as query_type, COUNT(*) as count FROM user_activities WHERE activity_type = 'failed_login' AND performed_at >= ?"},
+		{"total_users", "SELECT 'total_users' as query_type, COUNT(*) as count FROM users" + userWhere, companyArgs},
+		{"active_users", "SELECT 'active_users' as query_type, COUNT(*) as count FROM users WHERE enabled = 1" + userAnd, companyArgs},
+		{"sessions_today", "SELECT 'sessions_today' as query_type, COUNT(*) as count FROM user_activities WHERE activity_type = 'login' AND performed_at >= ?" + activityAnd, append([]interface{}{today}, companyArgs...)},
+		{"failed_logins_today", "SELECT 'failed_logins_today' as query_type, COUNT(*) as count FROM user_activities WHERE activity_type = 'failed_login' AND performed_at >= ?" + activityAnd, append([]interface{}{today}, companyArgs...)},
 	}
 	
 	for _, q := range queries {
@@ -64,11 +79,7 @@ func (dc *WebDashboardController) ShowDashboard(c *gin.Context) {
 			Count     int64
 		}
 		
-		if q.name == "sessions_today" || q.name == "failed_logins_today" {
-			tx.Raw(q.query, today).Scan(&result)
-		} else {
-			tx.Raw(q.query).Scan(&result)
-		}
+		tx.Raw(q.query, q.args...).Scan(&result)
 		
 		switch result.QueryType {
 		case "total_users":
@@ -85,13 +96,21 @@ func (dc *WebDashboardController) ShowDashboard(c *gin.Context) {
 	tx.Commit()
 
 	// Get recent activities with preloading for better performance
-	recentActivities, _ := dc.activityService.GetAllActivities(10)
+	recentActivities, _ := dc.activityService.GetCompanyActivities(scope, 10)
+
+	myAlerts, _ := dc.securityAlertService.GetUserOpenAlerts(user.ID)
+	var securityAlerts []models.SecurityAlert
+	if user.HasPermission(models.PermSecurityAlertsView) {
+		securityAlerts, _ = dc.securityAlertService.GetOpenAlerts(scope, 10)
+	}
 
-	c.HTML(200, "base.html", gin.H{
+	middleware.RenderHTML(c, 200, "dashboard/index.html", gin.H{
 		"Title":            "Dashboard",
 		"User":             user,
 		"ActiveNav":        "dashboard",
 		"Stats":            stats,
 		"RecentActivities": recentActivities,
+		"MyAlerts":         myAlerts,
+		"SecurityAlerts":   securityAlerts,
 	})
 }
\ No newline at end of file
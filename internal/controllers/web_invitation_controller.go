@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"net/http"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type WebInvitationController struct {
+	invitationService *services.InvitationService
+}
+
+func NewWebInvitationController(invitationService *services.InvitationService) *WebInvitationController {
+	return &WebInvitationController{
+		invitationService: invitationService,
+	}
+}
+
+func (ic *WebInvitationController) ShowAcceptInvitation(c *gin.Context) {
+	token := c.Param("token")
+	invitation, err := ic.invitationService.FindPendingInvitation(token)
+	if err != nil {
+		ic.rejectToken(c)
+		return
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "auth/accept_invitation.html", gin.H{
+		"Title":      "Accept Invitation",
+		"Token":      token,
+		"Invitation": invitation,
+		"Errors":     make(map[string]string),
+	})
+}
+
+func (ic *WebInvitationController) HandleAcceptInvitation(c *gin.Context) {
+	token := c.Param("token")
+	newPassword := c.PostForm("new_password")
+	confirmPassword := c.PostForm("confirm_password")
+
+	invitation, err := ic.invitationService.FindPendingInvitation(token)
+	if err != nil {
+		ic.rejectToken(c)
+		return
+	}
+
+	errors := make(map[string]string)
+	if newPassword == "" {
+		errors["NewPassword"] = "Password is required"
+	} else if err := models.GetPasswordPolicy().Validate(newPassword, &invitation.User); err != nil {
+		errors["NewPassword"] = err.Error()
+	}
+	if newPassword != confirmPassword {
+		errors["ConfirmPassword"] = "Passwords do not match"
+	}
+
+	if len(errors) == 0 {
+		_, err := ic.invitationService.Accept(token, newPassword, c.ClientIP(), c.Request.UserAgent())
+		if err == nil {
+			middleware.SetFlashSuccess(c, "Your account is ready. You can now sign in.")
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		if err == services.ErrInvalidInvitation {
+			ic.rejectToken(c)
+			return
+		}
+		errors["General"] = "Failed to activate your account. Please try again."
+	}
+
+	middleware.RenderHTML(c, http.StatusBadRequest, "auth/accept_invitation.html", gin.H{
+		"Title":      "Accept Invitation",
+		"Token":      token,
+		"Invitation": invitation,
+		"Errors":     errors,
+	})
+}
+
+func (ic *WebInvitationController) rejectToken(c *gin.Context) {
+	middleware.SetFlashError(c, "This invitation link is invalid or has expired. Please ask an administrator to resend it.")
+	c.Redirect(http.StatusFound, "/login")
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WebIPRuleController struct {
+	ipRuleService  *services.IPRuleService
+	companyService *services.CompanyService
+}
+
+func NewWebIPRuleController(ipRuleService *services.IPRuleService, companyService *services.CompanyService) *WebIPRuleController {
+	return &WebIPRuleController{
+		ipRuleService:  ipRuleService,
+		companyService: companyService,
+	}
+}
+
+func (rc *WebIPRuleController) ShowIPRules(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	rules, err := rc.ipRuleService.GetRules()
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load IP rules")
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+	companies, err := rc.companyService.GetCompanies()
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load IP rules")
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "ip_rules/index.html", gin.H{
+		"Title":           "IP Rules",
+		"User":            user,
+		"ActiveNav":       "ip_rules",
+		"Rules":           rules,
+		"Roles":           models.Roles,
+		"Companies":       companies,
+		"ClientIP":        c.ClientIP(),
+		"BreakGlassEmail": rc.ipRuleService.BreakGlassEmail(),
+	})
+}
+
+func (rc *WebIPRuleController) HandleCreateRule(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	rule := &models.IPRule{
+		Action:      c.PostForm("action"),
+		CIDR:        c.PostForm("cidr"),
+		Description: c.PostForm("description"),
+	}
+	if roleName := c.PostForm("role"); roleName != "" {
+		role, ok := models.ParseRole(roleName)
+		if !ok {
+			middleware.SetFlashError(c, "Invalid role")
+			c.Redirect(http.StatusFound, "/ip-rules")
+			return
+		}
+		rule.Role = &role
+	}
+	if company := c.PostForm("company"); company != "" {
+		companyID, err := strconv.ParseUint(company, 10, 32)
+		if err != nil {
+			middleware.SetFlashError(c, "Invalid company")
+			c.Redirect(http.StatusFound, "/ip-rules")
+			return
+		}
+		id := uint(companyID)
+		rule.CompanyID = &id
+	}
+
+	if err := rc.ipRuleService.CreateRule(user, rule, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		switch err {
+		case services.ErrIPRuleInvalid:
+			middleware.SetFlashError(c, "Enter a network such as 10.0.0.0/8 or a single IP address, and pick a known company")
+		case services.ErrIPRuleLocksOut:
+			middleware.SetFlashError(c, "That rule would stop you signing in from your current address ("+c.ClientIP()+")")
+		default:
+			middleware.SetFlashError(c, "Failed to add the rule")
+		}
+		c.Redirect(http.StatusFound, "/ip-rules")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Rule added: "+rule.Action+" "+rule.CIDR+" for "+rule.Scope())
+	c.Redirect(http.StatusFound, "/ip-rules")
+}
+
+func (rc *WebIPRuleController) HandleDeleteRule(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid rule")
+		c.Redirect(http.StatusFound, "/ip-rules")
+		return
+	}
+
+	if err := rc.ipRuleService.DeleteRule(user, uint(ruleID), c.ClientIP(), c.Request.UserAgent()); err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			middleware.SetFlashError(c, "That rule no longer exists")
+		case services.ErrIPRuleLocksOut:
+			middleware.SetFlashError(c, "Removing that rule would stop you signing in from your current address ("+c.ClientIP()+")")
+		default:
+			middleware.SetFlashError(c, "Failed to remove the rule")
+		}
+		c.Redirect(http.StatusFound, "/ip-rules")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Rule removed")
+	c.Redirect(http.StatusFound, "/ip-rules")
+}
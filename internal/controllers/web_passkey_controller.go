@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"alsafwanmarine.com/todo-app/internal/webauthn"
+	"github.com/gin-gonic/gin"
+)
+
+// WebPasskeyController serves the passkey pages. Registration and sign-in
+// talk JSON to the browser's WebAuthn API in static/js/passkeys.js.
+type WebPasskeyController struct {
+	passkeyService *services.PasskeyService
+}
+
+func NewWebPasskeyController(passkeyService *services.PasskeyService) *WebPasskeyController {
+	return &WebPasskeyController{
+		passkeyService: passkeyService,
+	}
+}
+
+func (pc *WebPasskeyController) ShowPasskeys(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	passkeys, err := pc.passkeyService.GetUserPasskeys(user.ID)
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load your passkeys")
+		c.Redirect(http.StatusFound, "/profile")
+		return
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "auth/passkeys.html", gin.H{
+		"Title":     "Passkeys",
+		"User":      user,
+		"ActiveNav": "profile",
+		"Passkeys":  passkeys,
+	})
+}
+
+func (pc *WebPasskeyController) HandleRegistrationOptions(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please sign in again"})
+		return
+	}
+
+	options, err := pc.passkeyService.BeginRegistration(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (pc *WebPasskeyController) HandleRegister(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please sign in again"})
+		return
+	}
+
+	// A passkey registered while logged in as someone else would let the
+	// admin sign in as them later.
+	if session := middleware.GetSession(c); session != nil && session.ImpersonatorID != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot add passkeys while logged in as another user"})
+		return
+	}
+
+	var req struct {
+		Name       string                       `json:"name"`
+		Credential webauthn.AttestationResponse `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey response"})
+		return
+	}
+
+	passkey, err := pc.passkeyService.FinishRegistration(user, req.Name, &req.Credential, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPasskeyNameInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Please use a name of up to 100 characters"})
+		case errors.Is(err, services.ErrPasskeyExists):
+			c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+		case errors.Is(err, services.ErrPasskeyChallengeInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The registration request has expired. Please try again."})
+		case errors.Is(err, webauthn.ErrUserNotVerified):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Your device did not verify you. Please use a passkey protected by a PIN or biometrics."})
+		case errors.Is(err, webauthn.ErrUnsupportedKey):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This authenticator uses a key type that is not supported"})
+		default:
+			log.Printf("Passkey registration for user %d failed: %v", user.ID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The passkey could not be verified"})
+		}
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Passkey \""+passkey.Name+"\" added. You can now sign in with it.")
+	c.JSON(http.StatusCreated, gin.H{"redirect": "/profile/passkeys"})
+}
+
+func (pc *WebPasskeyController) HandleDeletePasskey(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	passkeyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid passkey")
+		c.Redirect(http.StatusFound, "/profile/passkeys")
+		return
+	}
+
+	if err := pc.passkeyService.DeletePasskey(user, uint(passkeyID), c.ClientIP(), c.Request.UserAgent()); err != nil {
+		middleware.SetFlashError(c, "That passkey no longer exists")
+		c.Redirect(http.StatusFound, "/profile/passkeys")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Passkey removed")
+	c.Redirect(http.StatusFound, "/profile/passkeys")
+}
+
+func (pc *WebPasskeyController) HandleLoginOptions(c *gin.Context) {
+	options, err := pc.passkeyService.BeginLogin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkey sign-in is not available right now"})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (pc *WebPasskeyController) HandleLogin(c *gin.Context) {
+	var response webauthn.AssertionResponse
+	if err := c.ShouldBindJSON(&response); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey response"})
+		return
+	}
+
+	result, err := pc.passkeyService.FinishLogin(&response, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPasskeyChallengeInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Your sign-in attempt has expired. Please try again."})
+		case errors.Is(err, services.ErrPasskeyNotFound):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "This passkey is not registered. Please sign in with your password."})
+		case errors.Is(err, services.ErrUserDisabled):
+			c.JSON(http.StatusForbidden, gin.H{"error": "This account is disabled. Please contact an administrator."})
+		case errors.Is(err, services.ErrIPNotAllowed):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Signing in from this network is not allowed for your account. Please contact an administrator."})
+		default:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "The passkey could not be verified"})
+		}
+		return
+	}
+
+	middleware.SetSessionCookies(c, result)
+
+	redirect := "/"
+	if result.PasswordChangeRequired {
+		middleware.SetFlashWarning(c, "Your password has expired or was reset by an administrator. Please choose a new one.")
+		redirect = "/profile/password"
+	} else {
+		middleware.SetFlashSuccess(c, "Welcome back, "+result.User.Name+"!")
+	}
+	c.JSON(http.StatusOK, gin.H{"redirect": redirect})
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type WebPasswordResetController struct {
+	passwordResetService *services.PasswordResetService
+}
+
+func NewWebPasswordResetController(passwordResetService *services.PasswordResetService) *WebPasswordResetController {
+	return &WebPasswordResetController{
+		passwordResetService: passwordResetService,
+	}
+}
+
+func (prc *WebPasswordResetController) ShowForgotPassword(c *gin.Context) {
+	middleware.RenderHTML(c, http.StatusOK, "auth/forgot_password.html", gin.H{
+		"Title":  "Forgot Password",
+		"Errors": make(map[string]string),
+	})
+}
+
+func (prc *WebPasswordResetController) HandleForgotPassword(c *gin.Context) {
+	email := strings.TrimSpace(c.PostForm("email"))
+	if email == "" {
+		middleware.RenderHTML(c, http.StatusBadRequest, "auth/forgot_password.html", gin.H{
+			"Title":  "Forgot Password",
+			"Errors": map[string]string{"Email": "Email is required"},
+		})
+		return
+	}
+
+	// The email is sent in the background so neither the response nor its
+	// timing reveals whether the account exists.
+	ipAddress, userAgent := c.ClientIP(), c.Request.UserAgent()
+	go func() {
+		if err := prc.passwordResetService.RequestReset(email, ipAddress, userAgent); err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		}
+	}()
+
+	middleware.RenderHTML(c, http.StatusOK, "auth/forgot_password.html", gin.H{
+		"Title":  "Forgot Password",
+		"Errors": make(map[string]string),
+		"Sent":   true,
+	})
+}
+
+func (prc *WebPasswordResetController) ShowResetPassword(c *gin.Context) {
+	token := c.Param("token")
+	if _, err := prc.passwordResetService.FindValidResetEvent(token); err != nil {
+		prc.rejectToken(c)
+		return
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "auth/reset_password.html", gin.H{
+		"Title":  "Reset Password",
+		"Token":  token,
+		"Errors": make(map[string]string),
+	})
+}
+
+func (prc *WebPasswordResetController) HandleResetPassword(c *gin.Context) {
+	token := c.Param("token")
+	newPassword := c.PostForm("new_password")
+	confirmPassword := c.PostForm("confirm_password")
+
+	resetEvent, err := prc.passwordResetService.FindValidResetEvent(token)
+	if err != nil {
+		prc.rejectToken(c)
+		return
+	}
+
+	errors := make(map[string]string)
+	if newPassword == "" {
+		errors["NewPassword"] = "New password is required"
+	} else if err := models.GetPasswordPolicy().Validate(newPassword, &resetEvent.User); err != nil {
+		errors["NewPassword"] = err.Error()
+	}
+	if newPassword != confirmPassword {
+		errors["ConfirmPassword"] = "Passwords do not match"
+	}
+
+	if len(errors) == 0 {
+		err := prc.passwordResetService.ResetPasswordWithToken(token, newPassword, c.ClientIP(), c.Request.UserAgent())
+		if err == nil {
+			middleware.SetFlashSuccess(c, "Your password has been reset. You can now sign in.")
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		switch err {
+		case services.ErrInvalidResetToken:
+			prc.rejectToken(c)
+			return
+		case models.ErrPasswordReused:
+			errors["NewPassword"] = err.Error()
+		default:
+			errors["General"] = "Failed to reset password. Please try again."
+		}
+	}
+
+	middleware.RenderHTML(c, http.StatusBadRequest, "auth/reset_password.html", gin.H{
+		"Title":  "Reset Password",
+		"Token":  token,
+		"Errors": errors,
+	})
+}
+
+func (prc *WebPasswordResetController) rejectToken(c *gin.Context) {
+	middleware.SetFlashError(c, "This password reset link is invalid or has expired. Please request a new one.")
+	c.Redirect(http.StatusFound, "/forgot-password")
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WebRateLimitController struct {
+	rateLimitService *services.RateLimitService
+	rateLimiter      *middleware.RateLimiter
+}
+
+func NewWebRateLimitController(rateLimitService *services.RateLimitService, rateLimiter *middleware.RateLimiter) *WebRateLimitController {
+	return &WebRateLimitController{
+		rateLimitService: rateLimitService,
+		rateLimiter:      rateLimiter,
+	}
+}
+
+func (rc *WebRateLimitController) ShowRateLimits(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	counters, err := rc.rateLimitService.GetActiveCounters()
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load rate limits")
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "rate_limits/index.html", gin.H{
+		"Title":     "Rate Limits",
+		"User":      user,
+		"ActiveNav": "rate_limits",
+		"Policies":  rc.rateLimiter.Policies(),
+		"Counters":  counters,
+	})
+}
+
+func (rc *WebRateLimitController) HandleClear(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	err := rc.rateLimitService.ClearCounter(user, c.PostForm("key"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			middleware.SetFlashError(c, "That throttle has already expired")
+		} else {
+			middleware.SetFlashError(c, "Failed to clear the throttle")
+		}
+		c.Redirect(http.StatusFound, "/rate-limits")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Throttle cleared")
+	c.Redirect(http.StatusFound, "/rate-limits")
+}
+
+func (rc *WebRateLimitController) HandleClearAll(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	cleared, err := rc.rateLimitService.ClearAllCounters(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to clear throttles")
+		c.Redirect(http.StatusFound, "/rate-limits")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, fmt.Sprintf("Cleared %d rate limit counter(s)", cleared))
+	c.Redirect(http.StatusFound, "/rate-limits")
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type WebRoleController struct {
+	permissionService *services.PermissionService
+}
+
+func NewWebRoleController(permissionService *services.PermissionService) *WebRoleController {
+	return &WebRoleController{
+		permissionService: permissionService,
+	}
+}
+
+// roleView is one role with its permissions, as shown on the roles page.
+type roleView struct {
+	Role    models.UserRole
+	Granted map[string]bool
+}
+
+func (rc *WebRoleController) ShowRoles(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	sets, err := rc.permissionService.GetRolePermissions()
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load role permissions")
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	roles := make([]roleView, 0, len(models.Roles))
+	for _, role := range models.Roles {
+		granted := make(map[string]bool, len(sets[role]))
+		for _, permission := range sets[role] {
+			granted[permission] = true
+		}
+		roles = append(roles, roleView{Role: role, Granted: granted})
+	}
+
+	middleware.RenderHTML(c, http.StatusOK, "roles/index.html", gin.H{
+		"Title":       "Roles",
+		"User":        user,
+		"ActiveNav":   "roles",
+		"Roles":       roles,
+		"Permissions": models.Permissions,
+	})
+}
+
+func (rc *WebRoleController) HandleUpdateRole(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	role, err := strconv.Atoi(c.Param("role"))
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid role")
+		c.Redirect(http.StatusFound, "/roles")
+		return
+	}
+
+	err = rc.permissionService.UpdateRolePermissions(user, models.UserRole(role), c.PostFormArray("permissions"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch err {
+		case services.ErrRolesManageRequired:
+			middleware.SetFlashError(c, "The admin role must keep the permission to manage roles")
+		case services.ErrRoleInvalid, services.ErrPermissionInvalid:
+			middleware.SetFlashError(c, "Invalid role or permission")
+		default:
+			middleware.SetFlashError(c, "Failed to update role permissions")
+		}
+		c.Redirect(http.StatusFound, "/roles")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "Permissions for the "+models.UserRole(role).String()+" role updated")
+	c.Redirect(http.StatusFound, "/roles")
+}
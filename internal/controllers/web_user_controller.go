@@ -16,13 +16,25 @@ type WebUserController struct {
 	db                   *gorm.DB
 	activityService      *services.ActivityService
 	passwordResetService *services.PasswordResetService
+	lockoutService       *services.LockoutService
+	sessionService       *services.SessionService
+	impersonationService *services.ImpersonationService
+	invitationService    *services.InvitationService
+	userArchiveService   *services.UserArchiveService
+	companyService       *services.CompanyService
 }
 
-func NewWebUserController(db *gorm.DB, activityService *services.ActivityService, passwordResetService *services.PasswordResetService) *WebUserController {
+func NewWebUserController(db *gorm.DB, activityService *services.ActivityService, passwordResetService *services.PasswordResetService, lockoutService *services.LockoutService, sessionService *services.SessionService, impersonationService *services.ImpersonationService, invitationService *services.InvitationService, userArchiveService *services.UserArchiveService, companyService *services.CompanyService) *WebUserController {
 	return &WebUserController{
 		db:                   db,
 		activityService:      activityService,
 		passwordResetService: passwordResetService,
+		lockoutService:       lockoutService,
+		sessionService:       sessionService,
+		impersonationService: impersonationService,
+		invitationService:    invitationService,
+		userArchiveService:   userArchiveService,
+		companyService:       companyService,
 	}
 }
 
@@ -37,6 +49,7 @@ func (uc *WebUserController) ListUsers(c *gin.Context) {
 	searchQuery := c.Query("search")
 	filterRole := c.Query("role")
 	filterStatus := c.Query("status")
+	filterCompany := c.Query("company")
 	
 	// Pagination parameters
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -49,40 +62,11 @@ func (uc *WebUserController) ListUsers(c *gin.Context) {
 	var users []models.User
 	var totalUsers int64
 	
-	// Start with base query and count
-	query := uc.db.Model(&models.User{})
-	countQuery := uc.db.Model(&models.User{})
-
-	// Apply role-based filtering
-	if currentUser.Role == models.RoleManager {
-		query = query.Where("role = ?", models.RoleSalesperson)
-		countQuery = countQuery.Where("role = ?", models.RoleSalesperson)
-	}
-
-	// Apply search filter (optimized with index)
-	if searchQuery != "" {
-		searchCondition := "name LIKE ? OR email LIKE ?"
-		searchParam := "%" + searchQuery + "%"
-		query = query.Where(searchCondition, searchParam, searchParam)
-		countQuery = countQuery.Where(searchCondition, searchParam, searchParam)
-	}
-
-	// Apply role filter
-	if filterRole != "" {
-		if role, err := strconv.Atoi(filterRole); err == nil {
-			query = query.Where("role = ?", role)
-			countQuery = countQuery.Where("role = ?", role)
-		}
-	}
-
-	// Apply status filter (uses index)
-	if filterStatus == "enabled" {
-		query = query.Where("enabled = ?", true)
-		countQuery = countQuery.Where("enabled = ?", true)
-	} else if filterStatus == "disabled" {
-		query = query.Where("enabled = ?", false)
-		countQuery = countQuery.Where("enabled = ?", false)
-	}
+	// Apply the same filters to the page and the count
+	scope := middleware.GetCompanyScope(c)
+	filter := services.UserFilter{Search: searchQuery, Role: filterRole, Status: filterStatus, Company: filterCompany, CompanyContext: middleware.GetCompanyContextID(c)}
+	query := filter.Apply(uc.db.Model(&models.User{}), currentUser)
+	countQuery := filter.Apply(uc.db.Model(&models.User{}), currentUser)
 
 	// Get total count for pagination
 	if err := countQuery.Count(&totalUsers).Error; err != nil {
@@ -93,7 +77,8 @@ func (uc *WebUserController) ListUsers(c *gin.Context) {
 
 	// Get users with pagination, select only needed fields for list view
 	if err := query.
-		Select("id, name, email, role, company, enabled, created_at, last_sign_in_at").
+		Select("id, name, email, role, company_id, enabled, created_at, last_sign_in_at").
+		Preload("Company").
 		Order("created_at DESC").
 		Limit(limit).
 		Offset(offset).
@@ -103,6 +88,21 @@ func (uc *WebUserController) ListUsers(c *gin.Context) {
 		return
 	}
 
+	// Invitations the current user could resend or revoke
+	var invitations []models.Invitation
+	invitedUserIDs := make(map[uint]bool)
+	if currentUser.HasPermission(models.PermUsersCreate) {
+		openInvitations, _ := uc.invitationService.GetOpenInvitations()
+		for _, invitation := range openInvitations {
+			if currentUser.CanManageUser(&invitation.User) && scope.Includes(&invitation.User) {
+				invitations = append(invitations, invitation)
+				invitedUserIDs[invitation.UserID] = true
+			}
+		}
+	}
+
+	companies, _ := uc.companyService.GetCompanies()
+
 	// Calculate pagination info
 	totalPages := int((totalUsers + int64(limit) - 1) / int64(limit))
 	hasNext := page < totalPages
@@ -116,6 +116,11 @@ func (uc *WebUserController) ListUsers(c *gin.Context) {
 		"SearchQuery":  searchQuery,
 		"FilterRole":   filterRole,
 		"FilterStatus": filterStatus,
+		"FilterCompany": filterCompany,
+		"Companies":    companies,
+		"CompanyScope": scope,
+		"Invitations":  invitations,
+		"InvitedUserIDs": invitedUserIDs,
 		"Pagination": gin.H{
 			"CurrentPage": page,
 			"TotalPages":  totalPages,
@@ -127,7 +132,7 @@ func (uc *WebUserController) ListUsers(c *gin.Context) {
 		},
 	}
 
-	c.HTML(http.StatusOK, "base.html", data)
+	middleware.RenderHTML(c, http.StatusOK, "users/index.html", data)
 }
 
 func (uc *WebUserController) ShowUser(c *gin.Context) {
@@ -145,7 +150,7 @@ func (uc *WebUserController) ShowUser(c *gin.Context) {
 	}
 
 	var viewUser models.User
-	if err := uc.db.First(&viewUser, userID).Error; err != nil {
+	if err := uc.db.Preload("Company").First(&viewUser, userID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			middleware.SetFlashError(c, "User not found")
 		} else {
@@ -167,20 +172,25 @@ func (uc *WebUserController) ShowUser(c *gin.Context) {
 
 	// Get password reset events (if allowed)
 	var passwordResets []models.PasswordResetEvent
-	if currentUser.CanManageUser(&viewUser) {
+	if currentUser.CanManageUser(&viewUser) && currentUser.HasPermission(models.PermUsersResetPassword) {
 		passwordResets, _ = uc.passwordResetService.GetResetEvents(viewUser.ID)
 	}
 
+	activeSessions, _ := uc.sessionService.GetActiveUserSessions(viewUser.ID)
+	invited, _ := uc.invitationService.HasOpenInvitation(viewUser.ID)
+
 	data := gin.H{
-		"Title":          "User Details",
-		"User":           currentUser,
-		"ActiveNav":      "users",
-		"ViewUser":       viewUser,
-		"UserActivities": userActivities,
-		"PasswordResets": passwordResets,
+		"Title":             "User Details",
+		"User":              currentUser,
+		"ActiveNav":         "users",
+		"ViewUser":          &viewUser,
+		"UserActivities":    userActivities,
+		"PasswordResets":    passwordResets,
+		"ActiveSessions":    activeSessions,
+		"InvitationPending": invited,
 	}
 
-	c.HTML(http.StatusOK, "base.html", data)
+	middleware.RenderHTML(c, http.StatusOK, "users/show.html", data)
 }
 
 func (uc *WebUserController) ShowCreateUser(c *gin.Context) {
@@ -190,18 +200,23 @@ func (uc *WebUserController) ShowCreateUser(c *gin.Context) {
 		return
 	}
 
+	companies := uc.assignableCompanies(currentUser)
+
 	data := gin.H{
-		"Title":    "Create User",
+		"Title":    "Invite User",
 		"User":     currentUser,
 		"ActiveNav": "users",
 		"IsEdit":   false,
 		"Errors":   make(map[string]string),
 		"FormData": make(map[string]interface{}),
+		"Companies": companies,
 	}
 
-	c.HTML(http.StatusOK, "base.html", data)
+	middleware.RenderHTML(c, http.StatusOK, "users/form.html", data)
 }
 
+// HandleCreateUser invites a new user. The account stays disabled until
+// they accept the emailed invitation and choose a password.
 func (uc *WebUserController) HandleCreateUser(c *gin.Context) {
 	currentUser := middleware.GetCurrentUser(c)
 	if currentUser == nil {
@@ -214,9 +229,6 @@ func (uc *WebUserController) HandleCreateUser(c *gin.Context) {
 	email := strings.TrimSpace(strings.ToLower(c.PostForm("email")))
 	roleStr := c.PostForm("role")
 	company := c.PostForm("company")
-	password := c.PostForm("password")
-	passwordConfirm := c.PostForm("password_confirm")
-	enabled := c.PostForm("enabled") == "true"
 
 	// Validate form data
 	errors := make(map[string]string)
@@ -225,7 +237,6 @@ func (uc *WebUserController) HandleCreateUser(c *gin.Context) {
 		"Email":    email,
 		"Role":     roleStr,
 		"Company":  company,
-		"Enabled":  enabled,
 	}
 
 	if err := models.ValidateName(name); err != nil {
@@ -236,94 +247,160 @@ func (uc *WebUserController) HandleCreateUser(c *gin.Context) {
 		errors["Email"] = "Email is required"
 	}
 
-	role, err := strconv.Atoi(roleStr)
-	if err != nil || role < 0 || role > 2 {
-		errors["Role"] = "Please select a valid role"
-	}
-
-	// Check manager permissions
-	if currentUser.Role == models.RoleManager && models.UserRole(role) != models.RoleSalesperson {
-		errors["Role"] = "Managers can only create salespeople"
-	}
-
+	// Without a choice, the company follows the email domain, or is the
+	// current user's own if they are limited to it, and the role is the
+	// company's default
+	companies := uc.assignableCompanies(currentUser)
+	var userCompany *models.Company
 	if company != "" {
-		if err := models.ValidateCompany(&company); err != nil {
-			errors["Company"] = err.Error()
+		for i := range companies {
+			if strconv.Itoa(int(companies[i].ID)) == company {
+				userCompany = &companies[i]
+			}
+		}
+		if userCompany == nil {
+			errors["Company"] = "Please select a valid company"
 		}
+	} else if currentUser.HasPermission(models.PermUsersAllCompanies) {
+		userCompany = models.CompanyForEmail(companies, email)
+	} else {
+		userCompany = models.CompanyByID(companies, currentUser.CompanyID)
 	}
 
-	if err := models.ValidatePassword(password); err != nil {
-		errors["Password"] = err.Error()
+	role := int(models.RoleSalesperson)
+	if roleStr != "" {
+		var err error
+		if role, err = strconv.Atoi(roleStr); err != nil || role < 0 || role > 2 {
+			errors["Role"] = "Please select a valid role"
+		}
+	} else if userCompany != nil && currentUser.CanAssignRole(userCompany.DefaultRole) {
+		role = int(userCompany.DefaultRole)
 	}
 
-	if password != passwordConfirm {
-		errors["PasswordConfirm"] = "Passwords do not match"
+	// Check role permissions
+	if !currentUser.CanAssignRole(models.UserRole(role)) {
+		errors["Role"] = "You can only create salespeople"
 	}
 
-	// Check for existing email
+	// Check for existing email, including deleted users who still hold it
 	var existingUser models.User
-	if uc.db.Where("email = ?", email).First(&existingUser).Error == nil {
-		errors["Email"] = "Email address is already in use"
+	if uc.db.Unscoped().Where("email = ?", email).First(&existingUser).Error == nil {
+		if existingUser.DeletedAt.Valid {
+			errors["Email"] = "Email address belongs to a deleted user; restore them from Deleted Users instead"
+		} else {
+			errors["Email"] = "Email address is already in use"
+		}
 	}
 
 	if len(errors) > 0 {
 		data := gin.H{
-			"Title":    "Create User",
+			"Title":    "Invite User",
 			"User":     currentUser,
 			"ActiveNav": "users",
 			"IsEdit":   false,
 			"Errors":   errors,
 			"FormData": formData,
+			"Companies": companies,
 		}
-		c.HTML(http.StatusBadRequest, "base.html", data)
+		middleware.RenderHTML(c, http.StatusBadRequest, "users/form.html", data)
 		return
 	}
 
-	// Create user
+	// Create the invited user
 	user := models.User{
-		Name:    name,
-		Email:   email,
-		Role:    models.UserRole(role),
-		Enabled: enabled,
+		Name:  name,
+		Email: email,
+		Role:  models.UserRole(role),
 	}
 
-	if company != "" {
-		user.Company = &company
+	if userCompany != nil {
+		user.CompanyID = &userCompany.ID
 	}
 
-	if err := user.SetPassword(password); err != nil {
-		errors["General"] = "Failed to set password"
+	if _, err := uc.invitationService.Invite(currentUser, &user, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		if err == services.ErrInvitationNotSent {
+			middleware.SetFlashError(c, "User invited, but the email to "+user.Email+" could not be sent. Use Resend under Pending Invitations.")
+			c.Redirect(http.StatusFound, "/users")
+			return
+		}
+
+		errors["General"] = "Failed to invite user"
 		data := gin.H{
-			"Title":    "Create User",
+			"Title":    "Invite User",
 			"User":     currentUser,
 			"ActiveNav": "users",
 			"IsEdit":   false,
 			"Errors":   errors,
 			"FormData": formData,
+			"Companies": companies,
 		}
-		c.HTML(http.StatusInternalServerError, "base.html", data)
+		middleware.RenderHTML(c, http.StatusInternalServerError, "users/form.html", data)
 		return
 	}
 
-	if err := uc.db.Create(&user).Error; err != nil {
-		errors["General"] = "Failed to create user"
-		data := gin.H{
-			"Title":    "Create User",
-			"User":     currentUser,
-			"ActiveNav": "users",
-			"IsEdit":   false,
-			"Errors":   errors,
-			"FormData": formData,
-		}
-		c.HTML(http.StatusInternalServerError, "base.html", data)
+	middleware.SetFlashSuccess(c, "Invitation sent to "+user.Email)
+	c.Redirect(http.StatusFound, "/users")
+}
+
+// HandleResendInvitation emails a fresh invite link, replacing the old one.
+func (uc *WebUserController) HandleResendInvitation(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
 		return
 	}
 
-	// Log activity
-	uc.activityService.LogUserCRUD(currentUser, &user, "create", c.ClientIP(), c.Request.UserAgent())
+	invitationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid invitation")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
 
-	middleware.SetFlashSuccess(c, "User created successfully!")
-	c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(user.ID)))
+	invitation, err := uc.invitationService.Resend(currentUser, uint(invitationID), c.ClientIP(), c.Request.UserAgent())
+	switch err {
+	case nil:
+		middleware.SetFlashSuccess(c, "Invitation resent to "+invitation.User.Email)
+	case gorm.ErrRecordNotFound:
+		middleware.SetFlashError(c, "That invitation has already been accepted or revoked")
+	case services.ErrPermissionDenied:
+		middleware.SetFlashError(c, "Access denied")
+	case services.ErrInvitationNotSent:
+		middleware.SetFlashError(c, "The email to "+invitation.User.Email+" could not be sent")
+	default:
+		middleware.SetFlashError(c, "Failed to resend the invitation")
+	}
+	c.Redirect(http.StatusFound, "/users")
+}
+
+// HandleRevokeInvitation withdraws an invitation and deletes the unused
+// account it created.
+func (uc *WebUserController) HandleRevokeInvitation(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	invitationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid invitation")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	invitation, err := uc.invitationService.Revoke(currentUser, uint(invitationID), c.ClientIP(), c.Request.UserAgent())
+	switch err {
+	case nil:
+		middleware.SetFlashSuccess(c, "Invitation for "+invitation.User.Email+" revoked")
+	case gorm.ErrRecordNotFound:
+		middleware.SetFlashError(c, "That invitation has already been accepted or revoked")
+	case services.ErrPermissionDenied:
+		middleware.SetFlashError(c, "Access denied")
+	default:
+		middleware.SetFlashError(c, "Failed to revoke the invitation")
+	}
+	c.Redirect(http.StatusFound, "/users")
 }
 
 func (uc *WebUserController) ShowEditUser(c *gin.Context) {
@@ -358,16 +435,21 @@ func (uc *WebUserController) ShowEditUser(c *gin.Context) {
 		return
 	}
 
+	companies := uc.assignableCompanies(currentUser)
+	invited, _ := uc.invitationService.HasOpenInvitation(editUser.ID)
+
 	data := gin.H{
 		"Title":    "Edit User",
 		"User":     currentUser,
 		"ActiveNav": "users",
 		"IsEdit":   true,
-		"EditUser": editUser,
+		"EditUser": &editUser,
 		"Errors":   make(map[string]string),
+		"Companies": companies,
+		"InvitationPending": invited,
 	}
 
-	c.HTML(http.StatusOK, "base.html", data)
+	middleware.RenderHTML(c, http.StatusOK, "users/form.html", data)
 }
 
 func (uc *WebUserController) HandleEditUser(c *gin.Context) {
@@ -421,25 +503,40 @@ func (uc *WebUserController) HandleEditUser(c *gin.Context) {
 		errors["Role"] = "Please select a valid role"
 	}
 
-	// Check manager permissions
-	if currentUser.Role == models.RoleManager && models.UserRole(role) != models.RoleSalesperson {
-		errors["Role"] = "Managers cannot change user roles"
+	// Check role permissions
+	if !currentUser.CanAssignRole(models.UserRole(role)) {
+		errors["Role"] = "You cannot change user roles"
 	}
 
+	companies := uc.assignableCompanies(currentUser)
+	var companyID *uint
 	if company != "" {
-		if err := models.ValidateCompany(&company); err != nil {
-			errors["Company"] = err.Error()
+		for i := range companies {
+			if strconv.Itoa(int(companies[i].ID)) == company {
+				companyID = &companies[i].ID
+			}
 		}
+		if companyID == nil {
+			errors["Company"] = "Please select a valid company"
+		}
+	} else if !currentUser.CanAssignCompany(nil) {
+		errors["Company"] = "Please select a company"
 	}
 
 	// Check for email conflicts
 	var existingUser models.User
-	if uc.db.Where("email = ? AND id != ?", email, editUser.ID).First(&existingUser).Error == nil {
+	if uc.db.Unscoped().Where("email = ? AND id != ?", email, editUser.ID).First(&existingUser).Error == nil {
 		errors["Email"] = "Email address is already in use"
 	}
 
+	// Invited users are enabled by accepting, whatever the form says.
+	invited := invitationPending(uc.invitationService, &editUser)
+	if invited {
+		enabled = editUser.Enabled
+	}
+
 	// Check disable permissions
-	if !enabled && !currentUser.CanDisableUser(&editUser) {
+	if !invited && !enabled && !currentUser.CanDisableUser(&editUser) {
 		errors["Enabled"] = "Cannot disable this user"
 		enabled = true // Force enable
 	}
@@ -450,10 +547,12 @@ func (uc *WebUserController) HandleEditUser(c *gin.Context) {
 			"User":     currentUser,
 			"ActiveNav": "users",
 			"IsEdit":   true,
-			"EditUser": editUser,
+			"EditUser": &editUser,
 			"Errors":   errors,
+			"Companies": companies,
+			"InvitationPending": invited,
 		}
-		c.HTML(http.StatusBadRequest, "base.html", data)
+		middleware.RenderHTML(c, http.StatusBadRequest, "users/form.html", data)
 		return
 	}
 
@@ -463,11 +562,7 @@ func (uc *WebUserController) HandleEditUser(c *gin.Context) {
 	editUser.Role = models.UserRole(role)
 	editUser.Enabled = enabled
 
-	if company != "" {
-		editUser.Company = &company
-	} else {
-		editUser.Company = nil
-	}
+	editUser.CompanyID = companyID
 
 	if err := uc.db.Save(&editUser).Error; err != nil {
 		errors["General"] = "Failed to update user"
@@ -476,10 +571,12 @@ func (uc *WebUserController) HandleEditUser(c *gin.Context) {
 			"User":     currentUser,
 			"ActiveNav": "users",
 			"IsEdit":   true,
-			"EditUser": editUser,
+			"EditUser": &editUser,
 			"Errors":   errors,
+			"Companies": companies,
+			"InvitationPending": invited,
 		}
-		c.HTML(http.StatusInternalServerError, "base.html", data)
+		middleware.RenderHTML(c, http.StatusInternalServerError, "users/form.html", data)
 		return
 	}
 
@@ -490,6 +587,77 @@ func (uc *WebUserController) HandleEditUser(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(editUser.ID)))
 }
 
+// ShowConfirmDeleteUser shows what deleting the user will affect. The page
+// posts to HandleDeleteUser.
+func (uc *WebUserController) ShowConfirmDeleteUser(c *gin.Context) {
+	uc.showConfirmation(c, "delete")
+}
+
+// ShowConfirmToggleStatus shows what enabling or disabling the user will
+// affect. The page posts to HandleToggleStatus.
+func (uc *WebUserController) ShowConfirmToggleStatus(c *gin.Context) {
+	uc.showConfirmation(c, "toggle-status")
+}
+
+func (uc *WebUserController) showConfirmation(c *gin.Context, action string) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid user ID")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	var targetUser models.User
+	if err := uc.db.First(&targetUser, userID).Error; err != nil {
+		middleware.SetFlashError(c, "User not found")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	var title string
+	switch {
+	case action == "delete":
+		if !currentUser.CanManageUser(&targetUser) || currentUser.ID == targetUser.ID {
+			middleware.SetFlashError(c, "Cannot delete this user")
+			c.Redirect(http.StatusFound, "/users")
+			return
+		}
+		title = "Delete User"
+	case !currentUser.CanDisableUser(&targetUser):
+		middleware.SetFlashError(c, "Cannot modify this user's status")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	case !targetUser.Enabled && invitationPending(uc.invitationService, &targetUser):
+		middleware.SetFlashError(c, targetUser.Name+" has not accepted their invitation yet. Their account is enabled when they do.")
+		c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
+		return
+	case targetUser.Enabled:
+		title = "Disable User"
+	default:
+		title = "Enable User"
+	}
+
+	activeSessions, _ := uc.sessionService.GetActiveUserSessions(targetUser.ID)
+	activityCount, _ := uc.activityService.CountUserActivities(targetUser.ID)
+
+	middleware.RenderHTML(c, http.StatusOK, "users/confirm.html", gin.H{
+		"Title":         title,
+		"User":          currentUser,
+		"ActiveNav":     "users",
+		"ViewUser":      &targetUser,
+		"Action":        action,
+		"SessionCount":  len(activeSessions),
+		"ActivityCount": activityCount,
+		"RetentionDays": int(uc.userArchiveService.Retention().Hours() / 24),
+	})
+}
+
 func (uc *WebUserController) HandleDeleteUser(c *gin.Context) {
 	currentUser := middleware.GetCurrentUser(c)
 	if currentUser == nil {
@@ -511,24 +679,85 @@ func (uc *WebUserController) HandleDeleteUser(c *gin.Context) {
 		return
 	}
 
-	// Check permissions
-	if !currentUser.CanManageUser(&deleteUser) || currentUser.ID == deleteUser.ID {
-		middleware.SetFlashError(c, "Cannot delete this user")
+	if err := uc.userArchiveService.Archive(currentUser, &deleteUser, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		switch err {
+		case services.ErrDeleteSelf, services.ErrPermissionDenied:
+			middleware.SetFlashError(c, "Cannot delete this user")
+		default:
+			middleware.SetFlashError(c, "Failed to delete user")
+		}
 		c.Redirect(http.StatusFound, "/users")
 		return
 	}
 
-	if err := uc.db.Delete(&deleteUser).Error; err != nil {
-		middleware.SetFlashError(c, "Failed to delete user")
+	middleware.SetFlashSuccess(c, deleteUser.Name+" was deleted. They can be restored from Deleted Users until "+deleteUser.PurgeAt(uc.userArchiveService.Retention()).Format("Jan 2, 2006")+".")
+	c.Redirect(http.StatusFound, "/users")
+}
+
+// ListDeletedUsers shows archived users that can still be restored.
+func (uc *WebUserController) ListDeletedUsers(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	archived, err := uc.userArchiveService.GetArchivedUsers()
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to load deleted users")
 		c.Redirect(http.StatusFound, "/users")
 		return
 	}
 
-	// Log activity
-	uc.activityService.LogUserCRUD(currentUser, &deleteUser, "delete", c.ClientIP(), c.Request.UserAgent())
+	scope := middleware.GetCompanyScope(c)
+	var users []models.User
+	for _, user := range archived {
+		if currentUser.CanManageUser(&user) && scope.Includes(&user) {
+			users = append(users, user)
+		}
+	}
 
-	middleware.SetFlashSuccess(c, "User deleted successfully!")
-	c.Redirect(http.StatusFound, "/users")
+	middleware.RenderHTML(c, http.StatusOK, "users/deleted.html", gin.H{
+		"Title":     "Deleted Users",
+		"User":      currentUser,
+		"ActiveNav": "users",
+		"Users":     users,
+		"Retention": uc.userArchiveService.Retention(),
+	})
+}
+
+func (uc *WebUserController) HandleRestoreUser(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid user ID")
+		c.Redirect(http.StatusFound, "/users/deleted")
+		return
+	}
+
+	user, err := uc.userArchiveService.Restore(currentUser, uint(userID), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			middleware.SetFlashError(c, "User not found")
+		case services.ErrPermissionDenied:
+			middleware.SetFlashError(c, "Access denied")
+		case services.ErrRestoreWindowPassed:
+			middleware.SetFlashError(c, "This user was deleted too long ago to be restored")
+		default:
+			middleware.SetFlashError(c, "Failed to restore user")
+		}
+		c.Redirect(http.StatusFound, "/users/deleted")
+		return
+	}
+
+	middleware.SetFlashSuccess(c, user.Name+" has been restored")
+	c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(user.ID)))
 }
 
 func (uc *WebUserController) HandleToggleStatus(c *gin.Context) {
@@ -559,6 +788,13 @@ func (uc *WebUserController) HandleToggleStatus(c *gin.Context) {
 		return
 	}
 
+	// Invited users choose their password before their account is enabled.
+	if !targetUser.Enabled && invitationPending(uc.invitationService, &targetUser) {
+		middleware.SetFlashError(c, targetUser.Name+" has not accepted their invitation yet. Their account is enabled when they do.")
+		c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
+		return
+	}
+
 	// Toggle status
 	targetUser.Enabled = !targetUser.Enabled
 	if err := uc.db.Save(&targetUser).Error; err != nil {
@@ -582,6 +818,14 @@ func (uc *WebUserController) HandleToggleStatus(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
 }
 
+// invitationPending reports whether the user has yet to accept their
+// invitation. Errors count as pending, so a failed lookup never enables an
+// account nobody has chosen a password for.
+func invitationPending(invitationService *services.InvitationService, user *models.User) bool {
+	pending, err := invitationService.HasOpenInvitation(user.ID)
+	return pending || err != nil
+}
+
 func (uc *WebUserController) HandleResetPassword(c *gin.Context) {
 	currentUser := middleware.GetCurrentUser(c)
 	if currentUser == nil {
@@ -615,13 +859,202 @@ func (uc *WebUserController) HandleResetPassword(c *gin.Context) {
 		reason = "Admin initiated password reset"
 	}
 
-	newPassword, err := uc.passwordResetService.ManualReset(targetUser.ID, currentUser.ID, reason, c.ClientIP(), c.Request.UserAgent())
+	// The temporary password is never put in a cookie or URL. The admin gets
+	// a page that can show it once; the reveal token only travels in that
+	// page's form, so it stays out of request logs and page views.
+	resetEvent, token, err := uc.passwordResetService.ManualResetWithReveal(targetUser.ID, currentUser.ID, reason, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		middleware.SetFlashError(c, "Failed to reset password")
 		c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
 		return
 	}
 
-	middleware.SetFlashSuccess(c, "Password reset successfully! New password: "+newPassword)
+	c.Header("Cache-Control", "no-store")
+	middleware.RenderHTML(c, http.StatusOK, "users/password_reveal.html", gin.H{
+		"Title":      "Temporary Password",
+		"User":       currentUser,
+		"ActiveNav":  "users",
+		"ViewUser":   &targetUser,
+		"ResetEvent": resetEvent,
+		"Token":      token,
+	})
+}
+
+// HandleRevealPassword shows the admin who reset a password the temporary
+// password, once. Viewing is a POST so link previews and prefetching cannot
+// use up the one view.
+func (uc *WebUserController) HandleRevealPassword(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	token := c.PostForm("token")
+	resetEvent, err := uc.passwordResetService.FindReveal(currentUser, token)
+	if err != nil || strconv.Itoa(int(resetEvent.UserID)) != c.Param("id") {
+		uc.rejectReveal(c)
+		return
+	}
+
+	password, resetEvent, err := uc.passwordResetService.RevealPassword(currentUser, token, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		uc.rejectReveal(c)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	middleware.RenderHTML(c, http.StatusOK, "users/password_reveal.html", gin.H{
+		"Title":      "Temporary Password",
+		"User":       currentUser,
+		"ActiveNav":  "users",
+		"ViewUser":   &resetEvent.User,
+		"ResetEvent": resetEvent,
+		"Password":   password,
+	})
+}
+
+func (uc *WebUserController) rejectReveal(c *gin.Context) {
+	middleware.SetFlashError(c, "This password has already been viewed or is no longer available. Reset the password again to get a new one.")
+	c.Redirect(http.StatusFound, "/users/"+c.Param("id"))
+}
+
+func (uc *WebUserController) HandleUnlockUser(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid user ID")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	var targetUser models.User
+	if err := uc.db.First(&targetUser, userID).Error; err != nil {
+		middleware.SetFlashError(c, "User not found")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	if !currentUser.CanManageUser(&targetUser) {
+		middleware.SetFlashError(c, "Access denied")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	if err := uc.lockoutService.Unlock(currentUser, &targetUser, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		middleware.SetFlashError(c, "Failed to unlock user")
+		c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
+		return
+	}
+
+	middleware.SetFlashSuccess(c, "User unlocked successfully!")
+	c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
+}
+
+func (uc *WebUserController) HandleRevokeSessions(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid user ID")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	var targetUser models.User
+	if err := uc.db.First(&targetUser, userID).Error; err != nil {
+		middleware.SetFlashError(c, "User not found")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	if !currentUser.CanManageUser(&targetUser) && targetUser.ID != currentUser.ID {
+		middleware.SetFlashError(c, "Access denied")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	var count int64
+	if targetUser.ID == currentUser.ID {
+		// Keep the admin's own session; /profile/sessions is the place to
+		// end that one.
+		count, err = uc.sessionService.DestroyOtherUserSessions(targetUser.ID, middleware.GetSessionToken(c))
+	} else {
+		count, err = uc.sessionService.DestroyUserSessions(targetUser.ID)
+	}
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to sign the user out")
+		c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
+		return
+	}
+
+	uc.activityService.LogSessionsRevoked(currentUser, &targetUser, count, "all", c.ClientIP(), c.Request.UserAgent())
+
+	middleware.SetFlashSuccess(c, "Ended "+strconv.FormatInt(count, 10)+" session(s) for "+targetUser.Name+".")
 	c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
-}
\ No newline at end of file
+}
+
+// HandleImpersonate logs the admin in as the user. Their own session is
+// kept so the banner's "Return to my account" can switch back.
+func (uc *WebUserController) HandleImpersonate(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		middleware.SetFlashError(c, "Invalid user ID")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	var targetUser models.User
+	if err := uc.db.First(&targetUser, userID).Error; err != nil {
+		middleware.SetFlashError(c, "User not found")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+
+	result, err := uc.impersonationService.Start(currentUser, middleware.GetSessionToken(c), &targetUser, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch err {
+		case services.ErrPermissionDenied:
+			middleware.SetFlashError(c, "Access denied")
+		case services.ErrImpersonateSelf, services.ErrAlreadyImpersonating:
+			middleware.SetFlashError(c, err.Error())
+		case services.ErrUserDisabled:
+			middleware.SetFlashError(c, "Disabled users cannot be logged in as")
+		default:
+			middleware.SetFlashError(c, "Failed to log in as the user")
+		}
+		c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(int(targetUser.ID)))
+		return
+	}
+
+	middleware.StartImpersonation(c, result)
+	middleware.SetFlashInfo(c, "You are now logged in as "+targetUser.Name+".")
+	c.Redirect(http.StatusFound, "/")
+}
+
+// assignableCompanies returns the companies the user may put users in.
+func (uc *WebUserController) assignableCompanies(user *models.User) []models.Company {
+	companies, _ := uc.companyService.GetCompanies()
+	assignable := companies[:0]
+	for _, company := range companies {
+		if user.CanAssignCompany(&company.ID) {
+			assignable = append(assignable, company)
+		}
+	}
+	return assignable
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type WebUserExportController struct {
+	userExportService *services.UserExportService
+}
+
+func NewWebUserExportController(userExportService *services.UserExportService) *WebUserExportController {
+	return &WebUserExportController{
+		userExportService: userExportService,
+	}
+}
+
+// ExportUsers downloads the users list in the format given by the format
+// query parameter, with the same search, role and status filters as
+// ListUsers.
+func (ec *WebUserExportController) ExportUsers(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	contentType, ok := services.ExportFormats[format]
+	if !ok {
+		middleware.SetFlashError(c, "Unknown export format")
+		c.Redirect(http.StatusFound, "/users")
+		return
+	}
+	filter := services.UserFilter{
+		Search:  c.Query("search"),
+		Role:    c.Query("role"),
+		Status:  c.Query("status"),
+		Company: c.Query("company"),
+		// Exports cover the same companies as the list they are made from.
+		CompanyContext: middleware.GetCompanyContextID(c),
+	}
+
+	filename := fmt.Sprintf("users-%s.%s", time.Now().Format("2006-01-02"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	// The file is streamed, so a failure part way through can only cut it
+	// short.
+	if _, err := ec.userExportService.Export(currentUser, filter, format, c.Writer, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		log.Printf("Failed to export users: %v", err)
+	}
+}
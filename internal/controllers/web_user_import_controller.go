@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"alsafwanmarine.com/todo-app/internal/middleware"
+	"alsafwanmarine.com/todo-app/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type WebUserImportController struct {
+	userImportService *services.UserImportService
+}
+
+func NewWebUserImportController(userImportService *services.UserImportService) *WebUserImportController {
+	return &WebUserImportController{
+		userImportService: userImportService,
+	}
+}
+
+func (ic *WebUserImportController) ShowImport(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	ic.renderImport(c, http.StatusOK, gin.H{})
+}
+
+// HandlePreviewImport reads the uploaded file and shows what importing it
+// would do. The parsed rows are carried to HandleApplyImport in the form,
+// which plans them again before saving anything.
+func (ic *WebUserImportController) HandlePreviewImport(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		ic.renderImport(c, http.StatusBadRequest, gin.H{"Error": "Choose a CSV or XLSX file to import"})
+		return
+	}
+	if header.Size > services.MaxImportFileSize {
+		ic.renderImport(c, http.StatusBadRequest, gin.H{"Error": services.ErrImportFileTooLarge.Error()})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		ic.renderImport(c, http.StatusBadRequest, gin.H{"Error": services.ErrImportUnreadable.Error()})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, services.MaxImportFileSize+1))
+	if err != nil {
+		ic.renderImport(c, http.StatusBadRequest, gin.H{"Error": services.ErrImportUnreadable.Error()})
+		return
+	}
+
+	rows, err := ic.userImportService.ParseFile(header.Filename, data)
+	if err != nil {
+		ic.renderImport(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	plan, err := ic.userImportService.Preview(currentUser, rows)
+	if err != nil {
+		ic.renderImport(c, http.StatusInternalServerError, gin.H{"Error": "Failed to check the file"})
+		return
+	}
+
+	ic.renderPreview(c, http.StatusOK, header.Filename, rows, plan)
+}
+
+func (ic *WebUserImportController) HandleApplyImport(c *gin.Context) {
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	filename := c.PostForm("filename")
+	var rows []services.ImportRow
+	if err := json.Unmarshal([]byte(c.PostForm("rows")), &rows); err != nil || len(rows) == 0 || len(rows) > services.MaxImportRows {
+		middleware.SetFlashError(c, "The import could not be read. Please upload the file again.")
+		c.Redirect(http.StatusFound, "/users/import")
+		return
+	}
+
+	plan, err := ic.userImportService.Apply(currentUser, rows, c.ClientIP(), c.Request.UserAgent())
+	if err == services.ErrImportHasErrors {
+		// Users changed since the preview; show the new plan.
+		ic.renderPreview(c, http.StatusBadRequest, filename, rows, plan)
+		return
+	}
+	if err != nil {
+		middleware.SetFlashError(c, "Failed to import users. Nothing was changed.")
+		c.Redirect(http.StatusFound, "/users/import")
+		return
+	}
+
+	message := fmt.Sprintf("Import complete: %d invited, %d updated, %d unchanged.", plan.Created, plan.Updated, plan.Skipped)
+	if plan.InvitationsNotSent > 0 {
+		middleware.SetFlashError(c, fmt.Sprintf("%s %d invitation email(s) could not be sent. Use Resend under Pending Invitations.", message, plan.InvitationsNotSent))
+	} else {
+		middleware.SetFlashSuccess(c, message)
+	}
+	c.Redirect(http.StatusFound, "/users")
+}
+
+// DownloadTemplate sends a CSV with the expected columns and an example
+// row.
+func (ic *WebUserImportController) DownloadTemplate(c *gin.Context) {
+	c.Header("Content-Disposition", `attachment; filename="users-import.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", []byte("name,email,role,company\nJane Doe,jane.doe@example.com,salesperson,Al Safwan Marine\n"))
+}
+
+func (ic *WebUserImportController) renderPreview(c *gin.Context, status int, filename string, rows []services.ImportRow, plan *services.ImportPlan) {
+	encoded, err := json.Marshal(rows)
+	if err != nil {
+		ic.renderImport(c, http.StatusInternalServerError, gin.H{"Error": "Failed to check the file"})
+		return
+	}
+
+	ic.renderImport(c, status, gin.H{
+		"Filename": filename,
+		"Plan":     plan,
+		"Rows":     string(encoded),
+	})
+}
+
+func (ic *WebUserImportController) renderImport(c *gin.Context, status int, data gin.H) {
+	data["Title"] = "Import Users"
+	data["User"] = middleware.GetCurrentUser(c)
+	data["ActiveNav"] = "users"
+	data["MaxRows"] = services.MaxImportRows
+	middleware.RenderHTML(c, status, "users/import.html", data)
+}
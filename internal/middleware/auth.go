@@ -12,12 +12,18 @@ import (
 type AuthMiddleware struct {
 	authService *services.AuthService
 	activityService *services.ActivityService
+	apiTokenService *services.APITokenService
+	impersonationService *services.ImpersonationService
+	ipRuleService *services.IPRuleService
 }
 
-func NewAuthMiddleware(authService *services.AuthService, activityService *services.ActivityService) *AuthMiddleware {
+func NewAuthMiddleware(authService *services.AuthService, activityService *services.ActivityService, apiTokenService *services.APITokenService, impersonationService *services.ImpersonationService, ipRuleService *services.IPRuleService) *AuthMiddleware {
 	return &AuthMiddleware{
 		authService:     authService,
 		activityService: activityService,
+		apiTokenService: apiTokenService,
+		impersonationService: impersonationService,
+		ipRuleService: ipRuleService,
 	}
 }
 
@@ -35,6 +41,72 @@ func (m *AuthMiddleware) getSessionToken(c *gin.Context) string {
 	return ""
 }
 
+// authenticate resolves a bearer or cookie token to its user. Personal
+// access tokens are accepted alongside session tokens and are stored in the
+// context as "api_token" instead of "session_token".
+func (m *AuthMiddleware) authenticate(c *gin.Context, token string) (*models.User, error) {
+	if services.IsAPIToken(token) {
+		user, apiToken, err := m.apiTokenService.Authenticate(token, c.ClientIP())
+		if err != nil {
+			return nil, err
+		}
+		if err := m.ipRuleService.CheckAccess(user, c.ClientIP(), c.Request.UserAgent(), "api"); err != nil {
+			return nil, err
+		}
+		c.Set("current_user", user)
+		c.Set("api_token", apiToken)
+		return user, nil
+	}
+
+	return m.setSession(c, token)
+}
+
+// authenticated reuses the outcome of APITokenAuth for the request's
+// personal access token, or else authenticates the token.
+func (m *AuthMiddleware) authenticated(c *gin.Context, token string) (*models.User, error) {
+	if GetAPIToken(c) != nil {
+		return GetCurrentUser(c), nil
+	}
+	if err, ok := c.Get("api_token_error"); ok {
+		return nil, err.(error)
+	}
+	return m.authenticate(c, token)
+}
+
+// setSession loads the session's user into the context, together with the
+// admin behind it if the session is impersonated.
+func (m *AuthMiddleware) setSession(c *gin.Context, token string) (*models.User, error) {
+	user, session, err := m.authService.GetCurrentSession(token)
+	if err != nil {
+		return nil, err
+	}
+	
+	impersonator, err := m.impersonationService.GetImpersonator(session)
+	if err != nil {
+		return nil, err
+	}
+	
+	// The rules are checked on every request, so a session cannot be
+	// carried to a network its user may not sign in from.
+	if err := m.ipRuleService.CheckSession(user, impersonator, session, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		return nil, err
+	}
+	
+	if impersonator != nil {
+		c.Set("impersonator", impersonator)
+		// The admin is active, so their own session should not time out
+		// while it is set aside.
+		if ownToken, err := c.Cookie(impersonatorCookieName); err == nil && ownToken != "" {
+			m.authService.GetCurrentSession(ownToken)
+		}
+	}
+	
+	c.Set("current_user", user)
+	c.Set("session", session)
+	c.Set("session_token", token)
+	return user, nil
+}
+
 func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := m.getSessionToken(c)
@@ -44,20 +116,24 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 		
-		user, err := m.authService.RequireAuth(token)
+		user, err := m.authenticated(c, token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
 			c.Abort()
 			return
 		}
 		
-		c.Set("current_user", user)
-		c.Set("session_token", token)
+		if !checkPasswordChange(c, user) {
+			return
+		}
+		
 		c.Next()
 	}
 }
 
-func (m *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
+// RequirePermission authenticates the request and rejects users whose role
+// lacks the permission.
+func (m *AuthMiddleware) RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := m.getSessionToken(c)
 		if token == "" {
@@ -66,37 +142,73 @@ func (m *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
 			return
 		}
 		
-		user, err := m.authService.RequireRole(token, models.RoleAdmin)
+		user, err := m.authenticated(c, token)
 		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+			c.Abort()
+			return
+		}
+		
+		if !checkPasswordChange(c, user) {
+			return
+		}
+		
+		if !user.HasPermission(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied: " + permission + " required"})
 			c.Abort()
 			return
 		}
 		
-		c.Set("current_user", user)
-		c.Set("session_token", token)
 		c.Next()
 	}
 }
 
-func (m *AuthMiddleware) RequireManagerOrAdmin() gin.HandlerFunc {
+// passwordChangeRoutes are the API endpoints left to users who have to
+// change their password, like the change password page and logout are on
+// the web.
+var passwordChangeRoutes = map[string]bool{
+	"PATCH /api/v1/auth/password": true,
+	"DELETE /api/v1/auth/logout":  true,
+}
+
+// checkPasswordChange answers 403 and returns false while the user's
+// password is expired or was issued by an admin, unless the request is for
+// one of passwordChangeRoutes.
+func checkPasswordChange(c *gin.Context, user *models.User) bool {
+	if GetImpersonator(c) != nil || !user.NeedsPasswordChange() || passwordChangeRoutes[c.Request.Method+" "+c.FullPath()] {
+		return true
+	}
+	
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "You need to choose a new password before continuing",
+		"password_change_required": true,
+	})
+	c.Abort()
+	return false
+}
+
+// RequireScope rejects requests made with a personal access token that was
+// not granted the scope. Session-authenticated requests are not affected.
+func RequireScope(scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := m.getSessionToken(c)
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		if apiToken := GetAPIToken(c); apiToken != nil && !apiToken.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API token is missing the " + scope + " scope"})
 			c.Abort()
 			return
 		}
-		
-		user, err := m.authService.RequireRoleOrHigher(token, models.RoleManager)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Manager or Admin access required"})
+		c.Next()
+	}
+}
+
+// RequireSession keeps personal access tokens away from endpoints that only
+// make sense for an interactive session, such as logging out.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetAPIToken(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API token"})
 			c.Abort()
 			return
 		}
-		
-		c.Set("current_user", user)
-		c.Set("session_token", token)
 		c.Next()
 	}
 }
@@ -105,19 +217,154 @@ func (m *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := m.getSessionToken(c)
 		if token != "" {
-			if user, err := m.authService.GetCurrentUser(token); err == nil {
-				c.Set("current_user", user)
-				c.Set("session_token", token)
+			if _, err := m.setSession(c, token); err == nil {
+				c.Next()
+				return
 			}
 		}
+		m.resumeRememberedSession(c)
 		c.Next()
 	}
 }
 
+// APITokenAuth accepts personal access tokens ahead of the API's rate
+// limiter, so that it can count requests by their token. Sessions are
+// already loaded by OptionalAuth, and invalid tokens are left for the
+// route to refuse.
+func (m *AuthMiddleware) APITokenAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := m.getSessionToken(c); services.IsAPIToken(token) {
+			if _, err := m.authenticate(c, token); err != nil {
+				c.Set("api_token_error", err)
+			}
+		}
+		c.Next()
+	}
+}
+
+// resumeRememberedSession starts a new session from the "remember me"
+// cookie when the browser has no valid session.
+func (m *AuthMiddleware) resumeRememberedSession(c *gin.Context) {
+	rememberToken, err := c.Cookie(rememberCookieName)
+	if err != nil || rememberToken == "" {
+		return
+	}
+
+	result, err := m.authService.ResumeSession(rememberToken, c.ClientIP(), c.Request.UserAgent())
+	if err == nil {
+		err = m.ipRuleService.CheckSession(result.User, nil, result.Session, c.ClientIP(), c.Request.UserAgent())
+	}
+	if err != nil {
+		c.SetCookie(rememberCookieName, "", -1, "/", "", secureCookies, true)
+		return
+	}
+
+	SetSessionCookies(c, result)
+	c.Set("current_user", result.User)
+	c.Set("session", result.Session)
+	c.Set("session_token", result.Token)
+}
+
+const rememberCookieName = "remember_token"
+
+// secureCookies marks the cookies set by this package, and by the
+// controllers through SecureCookies, as Secure.
+var secureCookies = true
+
+// SetSecureCookies chooses whether cookies are limited to HTTPS; see
+// config.SecureCookies.
+func SetSecureCookies(secure bool) {
+	secureCookies = secure
+}
+
+// SecureCookies reports whether cookies should be limited to HTTPS.
+func SecureCookies() bool {
+	return secureCookies
+}
+
+// SetSessionCookies stores the session token and, when one was issued, the
+// rotated "remember me" token in the browser.
+func SetSessionCookies(c *gin.Context, result *services.LoginResult) {
+	c.SetCookie(
+		"session_token",
+		result.Token,
+		int(models.SessionAbsoluteTimeout.Seconds()),
+		"/",
+		"",
+		secureCookies, // Secure
+		true,          // HttpOnly
+	)
+
+	if result.RememberToken != "" {
+		c.SetCookie(
+			rememberCookieName,
+			result.RememberToken,
+			int(models.RememberTokenLifetime.Seconds()),
+			"/",
+			"",
+			secureCookies,
+			true,
+		)
+	}
+}
+
+func ClearSessionCookies(c *gin.Context) {
+	c.SetCookie("session_token", "", -1, "/", "", secureCookies, true)
+	c.SetCookie(rememberCookieName, "", -1, "/", "", secureCookies, true)
+	c.SetCookie(impersonatorCookieName, "", -1, "/", "", secureCookies, true)
+}
+
+// impersonatorCookieName holds the admin's own session token while they
+// are logged in as someone else.
+const impersonatorCookieName = "impersonator_session_token"
+
+// StartImpersonation swaps the browser over to the impersonated session and
+// keeps the admin's own session token aside for StopImpersonation.
+func StartImpersonation(c *gin.Context, result *services.LoginResult) {
+	c.SetCookie(
+		impersonatorCookieName,
+		GetSessionToken(c),
+		int(models.SessionAbsoluteTimeout.Seconds()),
+		"/",
+		"",
+		secureCookies,
+		true,
+	)
+	SetSessionCookies(c, result)
+}
+
+// StopImpersonation returns the admin's own session token, if one was kept,
+// and forgets it.
+func StopImpersonation(c *gin.Context) string {
+	token, _ := c.Cookie(impersonatorCookieName)
+	c.SetCookie(impersonatorCookieName, "", -1, "/", "", secureCookies, true)
+	return token
+}
+
+// GetRememberToken returns the raw "remember me" cookie, if any.
+func GetRememberToken(c *gin.Context) string {
+	token, _ := c.Cookie(rememberCookieName)
+	return token
+}
+
 func (m *AuthMiddleware) ActivityLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
 		
+		if apiToken := GetAPIToken(c); apiToken != nil {
+			if u := GetCurrentUser(c); u != nil {
+				m.activityService.LogAPIRequest(u, apiToken, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), c.ClientIP(), c.Request.UserAgent())
+			}
+			return
+		}
+		
+		if impersonator := GetImpersonator(c); impersonator != nil {
+			if u := GetCurrentUser(c); u != nil {
+				m.activityService.LogImpersonatedRequest(u, impersonator, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), c.ClientIP(), c.Request.UserAgent())
+			}
+			return
+		}
+		
 		if c.Request.Method == "GET" && !strings.Contains(c.GetHeader("Accept"), "application/json") {
 			if user, exists := c.Get("current_user"); exists {
 				if u, ok := user.(*models.User); ok {
@@ -137,6 +384,17 @@ func GetCurrentUser(c *gin.Context) *models.User {
 	return nil
 }
 
+// GetImpersonator returns the admin who is logged in as the current user,
+// or nil when the session is not impersonated.
+func GetImpersonator(c *gin.Context) *models.User {
+	if user, exists := c.Get("impersonator"); exists {
+		if u, ok := user.(*models.User); ok {
+			return u
+		}
+	}
+	return nil
+}
+
 func GetSessionToken(c *gin.Context) string {
 	if token, exists := c.Get("session_token"); exists {
 		if t, ok := token.(string); ok {
@@ -144,4 +402,26 @@ func GetSessionToken(c *gin.Context) string {
 		}
 	}
 	return ""
+}
+
+// GetSession returns the session the request was authenticated with, or nil
+// for API tokens.
+func GetSession(c *gin.Context) *models.Session {
+	if session, exists := c.Get("session"); exists {
+		if s, ok := session.(*models.Session); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// GetAPIToken returns the personal access token the request was
+// authenticated with, or nil for session-authenticated requests.
+func GetAPIToken(c *gin.Context) *models.APIToken {
+	if token, exists := c.Get("api_token"); exists {
+		if t, ok := token.(*models.APIToken); ok {
+			return t
+		}
+	}
+	return nil
 }
\ No newline at end of file
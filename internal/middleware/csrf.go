@@ -1,84 +1,112 @@
 package middleware
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"crypto/subtle"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"alsafwanmarine.com/todo-app/internal/models"
 	"github.com/gin-gonic/gin"
 )
 
-type CSRFConfig struct {
-	TokenHeader string
-	CookieName  string
-	TokenLength int
-	MaxAge      int
-}
+const (
+	// CSRFFieldName is the hidden form field the token is posted in.
+	CSRFFieldName = "csrf_token"
+	// CSRFHeaderName carries the token for requests sent from JavaScript.
+	CSRFHeaderName = "X-CSRF-Token"
 
-func DefaultCSRFConfig() CSRFConfig {
-	return CSRFConfig{
-		TokenHeader: "X-CSRF-Token",
-		CookieName:  "csrf_token",
-		TokenLength: 32,
-		MaxAge:      3600,
-	}
-}
-
-func generateCSRFToken(length int) (string, error) {
-	bytes := make([]byte, length)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
-	}
-	return base64.URLEncoding.EncodeToString(bytes), nil
-}
+	csrfCookieName = "csrf_token"
+	csrfContextKey = "csrf_token"
+)
 
-func CSRFProtection(config ...CSRFConfig) gin.HandlerFunc {
-	cfg := DefaultCSRFConfig()
-	if len(config) > 0 {
-		cfg = config[0]
-	}
-	
+// CSRFProtection checks the synchronizer token on every request that can
+// change state. Signed-in users get a token bound to their session; before
+// sign-in the token is kept in a cookie so the login form is covered too.
+// The token is accepted from the csrf_token form field or the X-CSRF-Token
+// header.
+func CSRFProtection() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.Method == "GET" {
-			token, err := generateCSRFToken(cfg.TokenLength)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSRF token"})
+		token := sessionCSRFToken(c)
+		if token == "" {
+			var err error
+			if token, err = cookieCSRFToken(c); err != nil {
+				c.String(http.StatusInternalServerError, "Failed to generate CSRF token")
 				c.Abort()
 				return
 			}
-			
-			c.SetCookie(
-				cfg.CookieName,
-				token,
-				cfg.MaxAge,
-				"/",
-				"",
-				true,
-				false,
-			)
-			
-			c.Header(cfg.TokenHeader, token)
+		}
+		c.Set(csrfContextKey, token)
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
 			c.Next()
 			return
 		}
-		
-		if c.Request.Method == "POST" || c.Request.Method == "PUT" || c.Request.Method == "PATCH" || c.Request.Method == "DELETE" {
-			headerToken := c.GetHeader(cfg.TokenHeader)
-			cookieToken, err := c.Cookie(cfg.CookieName)
-			
-			if err != nil || headerToken == "" || cookieToken == "" || headerToken != cookieToken {
-				c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token validation failed"})
-				c.Abort()
-				return
-			}
+
+		submitted := c.GetHeader(CSRFHeaderName)
+		if submitted == "" {
+			submitted = c.PostForm(CSRFFieldName)
 		}
-		
+		if submitted == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+			rejectCSRF(c)
+			return
+		}
+
 		c.Next()
 	}
 }
 
-func CSRFSkipper() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Next()
+// GetCSRFToken returns the token forms on the current page must submit.
+func GetCSRFToken(c *gin.Context) string {
+	return c.GetString(csrfContextKey)
+}
+
+func sessionCSRFToken(c *gin.Context) string {
+	if session, exists := c.Get("session"); exists {
+		if s, ok := session.(*models.Session); ok {
+			return s.CSRFToken
+		}
+	}
+	return ""
+}
+
+func cookieCSRFToken(c *gin.Context) (string, error) {
+	if token, err := c.Cookie(csrfCookieName); err == nil && token != "" {
+		return token, nil
+	}
+
+	token, err := models.GenerateSecureToken()
+	if err != nil {
+		return "", err
 	}
-}
\ No newline at end of file
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(csrfCookieName, token, 0, "/", "", secureCookies, true)
+	return token, nil
+}
+
+func rejectCSRF(c *gin.Context) {
+	if strings.Contains(c.GetHeader("Accept"), "application/json") || strings.Contains(c.ContentType(), "application/json") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token is missing or invalid"})
+		c.Abort()
+		return
+	}
+
+	SetFlashError(c, "Your form has expired. Please try again.")
+	c.Redirect(http.StatusFound, SameSiteReferer(c))
+	c.Abort()
+}
+
+// SameSiteReferer returns the path of the page the form was posted from,
+// or the dashboard if the referer is missing or points elsewhere.
+func SameSiteReferer(c *gin.Context) string {
+	referer, err := url.Parse(c.Request.Referer())
+	if err != nil || referer.Host != c.Request.Host || !strings.HasPrefix(referer.Path, "/") ||
+		strings.HasPrefix(referer.Path, "//") || strings.HasPrefix(referer.Path, "/\\") {
+		return "/"
+	}
+	if referer.RawQuery != "" {
+		return referer.Path + "?" + referer.RawQuery
+	}
+	return referer.Path
+}
@@ -1,51 +1,143 @@
 package middleware
 
 import (
-	"context"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"time"
+	"strings"
 
+	"alsafwanmarine.com/todo-app/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/ulule/limiter/v3"
-	"github.com/ulule/limiter/v3/drivers/store/memory"
 )
 
-func RateLimitMiddleware(rate limiter.Rate) gin.HandlerFunc {
-	store := memory.NewStore()
-	instance := limiter.New(store, rate)
-	
+// RateLimiter applies named policies to routes, counting requests in a
+// store that may be shared by several instances.
+type RateLimiter struct {
+	store    limiter.Store
+	policies map[string]models.RateLimitPolicy
+}
+
+func NewRateLimiter(store limiter.Store, policies []models.RateLimitPolicy) *RateLimiter {
+	byName := make(map[string]models.RateLimitPolicy, len(policies))
+	for _, policy := range policies {
+		byName[policy.Name] = policy
+	}
+	return &RateLimiter{
+		store:    store,
+		policies: byName,
+	}
+}
+
+// Limit refuses the request once any of the named policies is exhausted.
+func (r *RateLimiter) Limit(policyNames ...string) gin.HandlerFunc {
+	type instance struct {
+		policy  models.RateLimitPolicy
+		limiter *limiter.Limiter
+	}
+	instances := make([]instance, 0, len(policyNames))
+	for _, name := range policyNames {
+		policy, ok := r.policies[name]
+		if !ok {
+			log.Fatalf("Unknown rate limit policy %q", name)
+		}
+		instances = append(instances, instance{
+			policy:  policy,
+			limiter: limiter.New(r.store, limiter.Rate{Period: policy.Period, Limit: policy.Limit}),
+		})
+	}
+
 	return func(c *gin.Context) {
-		key := c.ClientIP()
-		
-		ctx := context.Background()
-		res, err := instance.Get(ctx, key)
+		for _, inst := range instances {
+			res, err := inst.limiter.Get(c.Request.Context(), RateLimitKey(c, inst.policy))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Rate limiting error"})
+				c.Abort()
+				return
+			}
+
+			c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", res.Limit))
+			c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", res.Remaining))
+			c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", res.Reset))
+
+			if res.Reached {
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error":       "Rate limit exceeded",
+					"retry_after": res.Reset,
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimitKey builds the counter key for the request under the policy,
+// e.g. "login:ip=10.0.0.1 email=a@example.com".
+func RateLimitKey(c *gin.Context, policy models.RateLimitPolicy) string {
+	parts := make([]string, 0, len(policy.KeyBy))
+	for _, part := range policy.KeyBy {
+		switch part {
+		case models.RateLimitKeyIP:
+			parts = append(parts, "ip="+c.ClientIP())
+		case models.RateLimitKeyEmail:
+			if email := attemptedEmail(c); email != "" {
+				parts = append(parts, "email="+email)
+			}
+		case models.RateLimitKeyUser:
+			parts = append(parts, rateLimitSubject(c))
+		}
+	}
+	return policy.Name + ":" + strings.Join(parts, " ")
+}
+
+func rateLimitSubject(c *gin.Context) string {
+	// Tokens are only counted on their own once they have been accepted;
+	// otherwise every made-up token would get a fresh allowance.
+	if apiToken := GetAPIToken(c); apiToken != nil {
+		return fmt.Sprintf("token=%d", apiToken.ID)
+	}
+	if user := GetCurrentUser(c); user != nil {
+		return fmt.Sprintf("user=%d", user.ID)
+	}
+	return "ip=" + c.ClientIP()
+}
+
+// attemptedEmail reads the email being signed in with from a form or a
+// JSON body, leaving the body in place for the handler.
+func attemptedEmail(c *gin.Context) string {
+	var email string
+	if strings.Contains(c.ContentType(), "application/json") {
+		body, err := io.ReadAll(c.Request.Body)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Rate limiting error"})
-			c.Abort()
-			return
+			return ""
 		}
-		
-		c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", res.Limit))
-		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", res.Remaining))
-		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", res.Reset))
-		
-		if res.Reached {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":   "Rate limit exceeded",
-				"retry_after": res.Reset,
-			})
-			c.Abort()
-			return
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var payload struct {
+			Email string `json:"email"`
 		}
-		
-		c.Next()
+		if json.Unmarshal(body, &payload) == nil {
+			email = payload.Email
+		}
+	} else {
+		email = c.PostForm("email")
 	}
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
-func LoginRateLimit() gin.HandlerFunc {
-	return RateLimitMiddleware(limiter.Rate{
-		Period: 3 * time.Minute,
-		Limit:  10,
-	})
-}
\ No newline at end of file
+// Policies returns the configured policies in their default order.
+func (r *RateLimiter) Policies() []models.RateLimitPolicy {
+	policies := make([]models.RateLimitPolicy, 0, len(r.policies))
+	for _, policy := range models.DefaultRateLimitPolicies() {
+		if configured, ok := r.policies[policy.Name]; ok {
+			policies = append(policies, configured)
+		}
+	}
+	return policies
+}
@@ -2,9 +2,9 @@ package middleware
 
 import (
 	"net/http"
-	"regexp"
 	"strings"
 
+	"alsafwanmarine.com/todo-app/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 )
@@ -15,42 +15,10 @@ func init() {
 	validate = validator.New()
 	
 	validate.RegisterValidation("strong_password", validateStrongPassword)
-	validate.RegisterValidation("valid_company", validateValidCompany)
 }
 
 func validateStrongPassword(fl validator.FieldLevel) bool {
-	password := fl.Field().String()
-	if len(password) < 6 {
-		return false
-	}
-	
-	hasUpper := regexp.MustCompile(`[A-Z]`).MatchString(password)
-	hasLower := regexp.MustCompile(`[a-z]`).MatchString(password)
-	hasDigit := regexp.MustCompile(`\d`).MatchString(password)
-	hasSpecial := regexp.MustCompile(`[^a-zA-Z0-9]`).MatchString(password)
-	
-	return hasUpper && hasLower && hasDigit && hasSpecial
-}
-
-func validateValidCompany(fl validator.FieldLevel) bool {
-	company := fl.Field().String()
-	if company == "" {
-		return true
-	}
-	
-	validCompanies := []string{
-		"Al Safwan Marine",
-		"Louis Safety",
-		"Data Grid Labs",
-	}
-	
-	for _, valid := range validCompanies {
-		if company == valid {
-			return true
-		}
-	}
-	
-	return false
+	return models.ValidatePassword(fl.Field().String()) == nil
 }
 
 func InputSanitizer() gin.HandlerFunc {
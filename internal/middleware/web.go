@@ -2,11 +2,13 @@ package middleware
 
 import (
 	"html/template"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
@@ -124,6 +126,42 @@ func RenderPage(c *gin.Context, templateName string, title string, data interfac
 	})
 }
 
+var flashFields = map[string]string{
+	"flash_success": "FlashSuccess",
+	"flash_error":   "FlashError",
+	"flash_warning": "FlashWarning",
+	"flash_info":    "FlashInfo",
+}
+
+// RenderHTML renders a page template with the flash messages of the current
+// request merged into its data.
+func RenderHTML(c *gin.Context, code int, name string, data gin.H) {
+	if data == nil {
+		data = gin.H{}
+	}
+	if _, set := data["CSRFToken"]; !set {
+		data["CSRFToken"] = GetCSRFToken(c)
+	}
+	if _, set := data["Impersonator"]; !set {
+		if impersonator := GetImpersonator(c); impersonator != nil {
+			data["Impersonator"] = impersonator
+		}
+	}
+	if companies, exists := c.Get("context_companies"); exists {
+		data["ContextCompanies"] = companies
+		data["CompanyContext"] = GetCompanyContext(c)
+	}
+	for key, field := range flashFields {
+		if _, set := data[field]; set {
+			continue
+		}
+		if flash, exists := c.Get(key); exists {
+			data[field] = flash
+		}
+	}
+	c.HTML(code, name, data)
+}
+
 func SetFlash(c *gin.Context, flashType, message string) {
 	c.SetCookie(
 		"flash_"+flashType,
@@ -164,7 +202,23 @@ func RequireWebAuth() gin.HandlerFunc {
 	}
 }
 
-func RequireWebRole(minRole models.UserRole) gin.HandlerFunc {
+// RefreshPermissions makes sure the role permission sets are current before
+// the request is handled, so that changes on the roles page apply at once on
+// every instance sharing the database. Static files need no permissions.
+func RefreshPermissions(permissionService *services.PermissionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, "/static/") {
+			if err := permissionService.Refresh(); err != nil {
+				log.Printf("Failed to refresh role permissions: %v", err)
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireWebPermission sends users whose role lacks the permission back to
+// the dashboard.
+func RequireWebPermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := GetCurrentUser(c)
 		if user == nil {
@@ -173,7 +227,7 @@ func RequireWebRole(minRole models.UserRole) gin.HandlerFunc {
 			return
 		}
 
-		if int(user.Role) > int(minRole) {
+		if !user.HasPermission(permission) {
 			SetFlashError(c, "Access denied. Insufficient permissions.")
 			c.Redirect(http.StatusFound, "/")
 			c.Abort()
@@ -184,6 +238,81 @@ func RequireWebRole(minRole models.UserRole) gin.HandlerFunc {
 	}
 }
 
+// RequireTwoFactorEnrollment keeps users whose role mandates two-factor
+// authentication on the setup page until they have enrolled.
+func RequireTwoFactorEnrollment(twoFactorService *services.TwoFactorService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetCurrentUser(c)
+		if user == nil || GetImpersonator(c) != nil || !twoFactorService.NeedsEnrollment(user) {
+			c.Next()
+			return
+		}
+
+		// The password change page stays reachable so a forced password
+		// change can be completed first.
+		if strings.HasPrefix(c.Request.URL.Path, "/profile/two-factor") || c.Request.URL.Path == "/profile/password" {
+			c.Next()
+			return
+		}
+
+		SetFlashWarning(c, "Your role requires two-factor authentication. Please set it up to continue.")
+		c.Redirect(http.StatusFound, "/profile/two-factor")
+		c.Abort()
+	}
+}
+
+// RequirePasswordChange restricts the session to the change password page
+// while the user's password is expired or was issued by an admin.
+func RequirePasswordChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetCurrentUser(c)
+		if user == nil || GetImpersonator(c) != nil || !user.NeedsPasswordChange() {
+			c.Next()
+			return
+		}
+
+		if c.Request.URL.Path == "/profile/password" {
+			c.Next()
+			return
+		}
+
+		SetFlashWarning(c, "You need to choose a new password before continuing.")
+		c.Redirect(http.StatusFound, "/profile/password")
+		c.Abort()
+	}
+}
+
+// RequireOwnSession keeps admins who are logged in as another user away
+// from pages that change that user's credentials or sessions, so nothing
+// they do there outlasts the impersonation.
+func RequireOwnSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetImpersonator(c) != nil {
+			const message = "You cannot change sign-in settings while logged in as another user."
+			if strings.Contains(c.GetHeader("Accept"), "application/json") || strings.Contains(c.ContentType(), "application/json") {
+				c.JSON(http.StatusForbidden, gin.H{"error": message})
+				c.Abort()
+				return
+			}
+			SetFlashError(c, message)
+			c.Redirect(http.StatusFound, "/profile")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// MethodNotAllowed answers requests to a URL that only accepts the given
+// methods, such as old links to actions that used to be GET requests.
+func MethodNotAllowed(allowed ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Allow", strings.Join(allowed, ", "))
+		c.String(http.StatusMethodNotAllowed, "Method Not Allowed")
+		c.Abort()
+	}
+}
+
 func ParseFormErrors(c *gin.Context, err error) map[string]string {
 	errors := make(map[string]string)
 	
@@ -229,4 +358,52 @@ func SetActiveNav(nav string) gin.HandlerFunc {
 		c.Set("active_nav", nav)
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// LoadCompanyContext gives users who may see every company the companies
+// to switch between and the one they have chosen, if any, for the navbar.
+func LoadCompanyContext(companyService *services.CompanyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetCurrentUser(c)
+		if user == nil || !user.HasPermission(models.PermUsersAllCompanies) {
+			c.Next()
+			return
+		}
+
+		companies, err := companyService.GetCompanies()
+		if err == nil {
+			c.Set("context_companies", companies)
+			if session := GetSession(c); session != nil {
+				if company := models.CompanyByID(companies, session.CompanyContextID); company != nil {
+					c.Set("company_context", company)
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// GetCompanyContext returns the company the current user has chosen to work
+// in, or nil while they see every company.
+func GetCompanyContext(c *gin.Context) *models.Company {
+	if company, exists := c.Get("company_context"); exists {
+		if co, ok := company.(*models.Company); ok {
+			return co
+		}
+	}
+	return nil
+}
+
+// GetCompanyContextID returns the ID of the company context, or nil.
+func GetCompanyContextID(c *gin.Context) *uint {
+	if company := GetCompanyContext(c); company != nil {
+		return &company.ID
+	}
+	return nil
+}
+
+// GetCompanyScope returns the part of the directory the current user's
+// lists and statistics cover.
+func GetCompanyScope(c *gin.Context) models.CompanyScope {
+	return GetCurrentUser(c).CompanyScope(GetCompanyContextID(c))
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APITokenPrefix marks personal access tokens so they can be told apart
+// from session tokens in an Authorization header.
+const APITokenPrefix = "asm_"
+
+const (
+	ScopeUsersRead      = "users:read"
+	ScopeUsersWrite     = "users:write"
+	ScopeActivitiesRead = "activities:read"
+)
+
+// APITokenScopes lists every scope a token can be granted, with the
+// description shown on the profile page.
+var APITokenScopes = []struct {
+	Name        string
+	Description string
+}{
+	{ScopeUsersRead, "List and view users"},
+	{ScopeUsersWrite, "Create, update, disable and reset users"},
+	{ScopeActivitiesRead, "Read activity logs"},
+}
+
+func IsValidAPITokenScope(scope string) bool {
+	for _, s := range APITokenScopes {
+		if s.Name == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken is a named, long-lived personal access token for scripts. Only
+// the digest is stored; the token itself is shown once when it is created.
+type APIToken struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"not null;index" json:"user_id"`
+	Name   string `gorm:"not null;size:100" json:"name"`
+	// Prefix is the start of the token, enough to recognise it in a list.
+	Prefix      string `gorm:"not null;size:20" json:"prefix"`
+	TokenDigest string `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	// Scopes is a comma-separated list of scope names.
+	Scopes     string     `gorm:"not null;size:255" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `gorm:"size:45" json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (t *APIToken) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+func (t *APIToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return nil
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
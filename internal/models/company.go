@@ -0,0 +1,154 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxCompanyLogoSize bounds uploaded company logos.
+const MaxCompanyLogoSize = 512 << 10
+
+// CompanyLogoTypes are the image types a company logo may have. SVG is left
+// out because it can carry scripts.
+var CompanyLogoTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// Company is an organisation users belong to. New users whose email is at
+// the company's domain join it unless another company is chosen, and are
+// given its default role when none is.
+type Company struct {
+	ID          uint     `gorm:"primaryKey" json:"id"`
+	Name        string   `gorm:"not null;size:100;uniqueIndex" json:"name"`
+	EmailDomain string   `gorm:"size:255" json:"email_domain"`
+	DefaultRole UserRole `gorm:"not null;default:2" json:"default_role"`
+	// LogoUpdatedAt is set while the company has a logo, and versions its
+	// URL so browsers fetch a replaced logo.
+	LogoUpdatedAt *time.Time `json:"logo_updated_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// CompanyScope is the part of the user directory someone works with:
+// every company, or the users of one company. A scope limited to a nil
+// CompanyID covers the users without a company.
+type CompanyScope struct {
+	All       bool
+	CompanyID *uint
+}
+
+// Includes reports whether the user is within the scope.
+func (s CompanyScope) Includes(user *User) bool {
+	return s.All || sameCompany(s.CompanyID, user.CompanyID)
+}
+
+// Condition returns the SQL condition limiting rows whose company is held
+// in column to the scope, and its arguments. It returns "" for every
+// company.
+func (s CompanyScope) Condition(column string) (string, []interface{}) {
+	if s.All {
+		return "", nil
+	}
+	if s.CompanyID == nil {
+		return column + " IS NULL", nil
+	}
+	return column + " = ?", []interface{}{*s.CompanyID}
+}
+
+// CompanyLogo holds the image apart from Company, so loading companies with
+// their users does not load logos.
+type CompanyLogo struct {
+	CompanyID   uint      `gorm:"primaryKey;autoIncrement:false" json:"company_id"`
+	ContentType string    `gorm:"not null;size:50" json:"content_type"`
+	Data        []byte    `gorm:"not null" json:"-"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (c *Company) HasLogo() bool {
+	return c.LogoUpdatedAt != nil
+}
+
+// LogoURL is where the company's logo is served, or "" without a logo.
+func (c *Company) LogoURL() string {
+	if c.LogoUpdatedAt == nil {
+		return ""
+	}
+	return fmt.Sprintf("/companies/%d/logo?v=%d", c.ID, c.LogoUpdatedAt.Unix())
+}
+
+// MatchesEmail reports whether the address is at the company's email
+// domain.
+func (c *Company) MatchesEmail(email string) bool {
+	if c.EmailDomain == "" {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	return at >= 0 && strings.EqualFold(email[at+1:], c.EmailDomain)
+}
+
+// IsValidDefaultRole reports whether new users may be given the role by
+// default. Admins are only ever made deliberately.
+func IsValidDefaultRole(role UserRole) bool {
+	return role == RoleManager || role == RoleSalesperson
+}
+
+// NormalizeEmailDomain turns "@Example.COM" into "example.com". It reports
+// false for values that are not a plausible domain.
+func NormalizeEmailDomain(domain string) (string, bool) {
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+	if domain == "" {
+		return "", true
+	}
+	if len(domain) > 255 || !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") || strings.Contains(domain, "..") {
+		return "", false
+	}
+	for _, r := range domain {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return "", false
+		}
+	}
+	return domain, true
+}
+
+// FindCompany returns the company with the name, ignoring case and
+// surrounding spaces, or nil.
+func FindCompany(companies []Company, name string) *Company {
+	name = strings.TrimSpace(name)
+	for i := range companies {
+		if strings.EqualFold(companies[i].Name, name) {
+			return &companies[i]
+		}
+	}
+	return nil
+}
+
+// CompanyByID returns the company with the ID, or nil if there is none or
+// the ID is nil.
+func CompanyByID(companies []Company, companyID *uint) *Company {
+	if companyID == nil {
+		return nil
+	}
+	for i := range companies {
+		if companies[i].ID == *companyID {
+			return &companies[i]
+		}
+	}
+	return nil
+}
+
+// CompanyForEmail returns the company whose email domain the address is
+// at, or nil.
+func CompanyForEmail(companies []Company, email string) *Company {
+	for i := range companies {
+		if companies[i].MatchesEmail(email) {
+			return &companies[i]
+		}
+	}
+	return nil
+}
+
+func sameCompany(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// InvitationTTL is how long an invite link stays valid after it is sent.
+const InvitationTTL = 7 * 24 * time.Hour
+
+// Invitation is the pending sign-up of an account an admin created. The
+// account stays disabled until the user opens the emailed link and picks a
+// password. The link token is signed with the app key and names the user
+// and its expiry; only its digest is stored.
+type Invitation struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;uniqueIndex" json:"user_id"`
+	InvitedByID *uint      `gorm:"index" json:"invited_by_id"`
+	TokenDigest *string    `gorm:"uniqueIndex;size:64" json:"-"`
+	SentAt      time.Time  `json:"sent_at"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	User      User  `gorm:"foreignKey:UserID" json:"-"`
+	InvitedBy *User `gorm:"foreignKey:InvitedByID" json:"-"`
+}
+
+func (i *Invitation) IsExpired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+// IsPending reports whether the invite can still be accepted.
+func (i *Invitation) IsPending() bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && i.TokenDigest != nil && !i.IsExpired()
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	IPRuleAllow = "allow"
+	IPRuleDeny  = "deny"
+)
+
+// IPRule allows or denies sign-ins from a network. A rule with no role or
+// company applies to everyone.
+//
+// Once any allow rule applies to a user, they may only sign in from the
+// networks those rules list. A matching deny rule always wins.
+type IPRule struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Action      string    `gorm:"not null;size:10" json:"action"`
+	CIDR        string    `gorm:"column:cidr;not null;size:50" json:"cidr"`
+	Role        *UserRole `json:"role"`
+	CompanyID   *uint     `json:"company_id"`
+	Description string    `gorm:"size:255" json:"description"`
+	CreatedByID *uint     `json:"created_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	Company *Company `json:"company,omitempty"`
+}
+
+// ParseCIDR normalises a network such as "10.0.0.0/8", or a single address,
+// to the form stored in IPRule.CIDR.
+func ParseCIDR(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if ip := net.ParseIP(value); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", true
+		}
+		return ip.String() + "/128", true
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", false
+	}
+	return network.String(), true
+}
+
+// AppliesTo reports whether the rule is scoped to the user's role and
+// company.
+func (r *IPRule) AppliesTo(user *User) bool {
+	if r.Role != nil && *r.Role != user.Role {
+		return false
+	}
+	if r.CompanyID != nil {
+		if user.CompanyID == nil || *user.CompanyID != *r.CompanyID {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether the address is inside the rule's network.
+func (r *IPRule) Matches(ipAddress string) bool {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return false
+	}
+	_, network, err := net.ParseCIDR(r.CIDR)
+	if err != nil {
+		return false
+	}
+	return network.Contains(ip)
+}
+
+// Scope describes who the rule applies to, e.g. "Admins at Al Safwan Marine".
+func (r *IPRule) Scope() string {
+	who := "Everyone"
+	if r.Role != nil {
+		role := r.Role.String()
+		who = strings.ToUpper(role[:1]) + role[1:] + "s"
+	}
+	if r.Company != nil {
+		who += " at " + r.Company.Name
+	} else if r.CompanyID != nil {
+		who += fmt.Sprintf(" at company #%d", *r.CompanyID)
+	}
+	return who
+}
+
+// CheckIPRules decides whether the user may sign in from the address. When
+// a deny rule refuses it, that rule is returned as well.
+func CheckIPRules(rules []IPRule, user *User, ipAddress string) (allowed bool, refusedBy *IPRule) {
+	var allowRules []*IPRule
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.AppliesTo(user) {
+			continue
+		}
+		switch rule.Action {
+		case IPRuleDeny:
+			if rule.Matches(ipAddress) {
+				return false, rule
+			}
+		case IPRuleAllow:
+			allowRules = append(allowRules, rule)
+		}
+	}
+
+	if len(allowRules) == 0 {
+		return true, nil
+	}
+	for _, rule := range allowRules {
+		if rule.Matches(ipAddress) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
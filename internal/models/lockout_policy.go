@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// LockoutPolicy configures how accounts are locked after consecutive failed
+// sign-ins.
+type LockoutPolicy struct {
+	// Threshold is the number of failures in a row that locks the account.
+	Threshold int
+	// BaseDuration is how long the first lock lasts. Every further failure
+	// doubles it.
+	BaseDuration time.Duration
+	// MaxDuration caps how long an account stays locked.
+	MaxDuration time.Duration
+}
+
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		Threshold:    5,
+		BaseDuration: time.Minute,
+		MaxDuration:  24 * time.Hour,
+	}
+}
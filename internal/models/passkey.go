@@ -0,0 +1,59 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Passkey is a WebAuthn credential registered by a user for passwordless
+// sign-in.
+type Passkey struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"not null;index" json:"user_id"`
+	Name   string `gorm:"not null;size:100" json:"name"`
+	// CredentialID is the base64url credential ID chosen by the authenticator.
+	CredentialID string `gorm:"uniqueIndex;not null;size:1400" json:"-"`
+	// PublicKey is the credential's COSE_Key.
+	PublicKey  []byte     `gorm:"not null" json:"-"`
+	SignCount  uint32     `gorm:"not null;default:0" json:"-"`
+	AAGUID     string     `gorm:"size:36" json:"aaguid"`
+	Transports string     `gorm:"size:100" json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (p *Passkey) TransportList() []string {
+	if p.Transports == "" {
+		return nil
+	}
+	return strings.Split(p.Transports, ",")
+}
+
+const (
+	PasskeyChallengeRegistration = "registration"
+	PasskeyChallengeLogin        = "login"
+)
+
+// PasskeyChallenge is an outstanding WebAuthn challenge. Registration
+// challenges belong to the signed-in user; sign-in challenges to nobody yet.
+type PasskeyChallenge struct {
+	ID              uint      `gorm:"primaryKey"`
+	ChallengeDigest string    `gorm:"uniqueIndex;not null;size:64"`
+	Purpose         string    `gorm:"not null;size:20"`
+	UserID          *uint     `gorm:"index"`
+	ExpiresAt       time.Time `gorm:"not null;index"`
+	CreatedAt       time.Time
+}
+
+func (c *PasskeyChallenge) IsExpired() bool {
+	return time.Now().After(c.ExpiresAt)
+}
+
+// PasskeyUserHandle is the WebAuthn user handle for a user: their ID as a
+// decimal string, which carries no personal information.
+func PasskeyUserHandle(userID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(userID), 10))
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var ErrPasswordReused = errors.New("password was used recently, please choose a different one")
+
+// PasswordPolicy is the single set of rules every new password is checked
+// against, whether it is chosen by the user, set by an admin or generated.
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// BannedWords may not appear anywhere in the password, ignoring case,
+	// spaces and punctuation. The user's email is always banned as well.
+	BannedWords []string
+	// CompanyNames returns the names of the current companies, which are
+	// banned the same way; see companyWords. It may be nil.
+	CompanyNames func() []string
+	// HistorySize is how many previous passwords, including the current
+	// one, may not be reused.
+	HistorySize int
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     8,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		HistorySize:   5,
+	}
+}
+
+var (
+	passwordPolicyMu sync.RWMutex
+	passwordPolicy   = DefaultPasswordPolicy()
+)
+
+// SetPasswordPolicy replaces the policy used by SetPassword and
+// ValidatePassword.
+func SetPasswordPolicy(policy PasswordPolicy) {
+	passwordPolicyMu.Lock()
+	defer passwordPolicyMu.Unlock()
+	passwordPolicy = policy
+}
+
+func GetPasswordPolicy() PasswordPolicy {
+	passwordPolicyMu.RLock()
+	defer passwordPolicyMu.RUnlock()
+	return passwordPolicy
+}
+
+// Validate checks a candidate password. The user is optional; when given,
+// their email address is added to the banned words.
+func (p PasswordPolicy) Validate(password string, user *User) error {
+	if len(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		return fmt.Errorf("password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		return fmt.Errorf("password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		return fmt.Errorf("password must contain a number")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return fmt.Errorf("password must contain a symbol")
+	}
+
+	banned := p.BannedWords
+	if p.CompanyNames != nil {
+		banned = append(banned[:len(banned):len(banned)], companyWords(p.CompanyNames())...)
+	}
+
+	normalized := normalizePasswordWord(password)
+	for _, word := range banned {
+		if w := normalizePasswordWord(word); w != "" && strings.Contains(normalized, w) {
+			return fmt.Errorf("password must not contain %q", word)
+		}
+	}
+
+	if user != nil && user.Email != "" {
+		email := normalizeEmail(user.Email)
+		local := email
+		if at := strings.Index(email, "@"); at >= 0 {
+			local = email[:at]
+		}
+		// Very short local parts such as "bd" would ban too many passwords.
+		for _, word := range []string{email, local} {
+			if w := normalizePasswordWord(word); len(w) >= 4 && strings.Contains(normalized, w) {
+				return fmt.Errorf("password must not contain your email address")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Description summarises the policy for form help text.
+func (p PasswordPolicy) Description() string {
+	var classes []string
+	if p.RequireUpper {
+		classes = append(classes, "an uppercase letter")
+	}
+	if p.RequireLower {
+		classes = append(classes, "a lowercase letter")
+	}
+	if p.RequireDigit {
+		classes = append(classes, "a number")
+	}
+	if p.RequireSymbol {
+		classes = append(classes, "a symbol")
+	}
+
+	description := fmt.Sprintf("At least %d characters", p.MinLength)
+	switch len(classes) {
+	case 0:
+	case 1:
+		description += " including " + classes[0]
+	default:
+		description += " including " + strings.Join(classes[:len(classes)-1], ", ") + " and " + classes[len(classes)-1]
+	}
+	return description + ". Company names, your email and recent passwords are not allowed."
+}
+
+// companyWords turns company names into banned words. Each pair of
+// neighbouring words is banned on its own, so "Al Safwan Marine" also rules
+// out "AlSafwan2024"; single words shorter than four letters are too common
+// to ban.
+func companyWords(names []string) []string {
+	var words []string
+	for _, name := range names {
+		fields := strings.Fields(name)
+		if len(fields) < 2 {
+			if len(normalizePasswordWord(name)) >= 4 {
+				words = append(words, strings.TrimSpace(name))
+			}
+			continue
+		}
+		for i := 0; i+1 < len(fields); i++ {
+			words = append(words, fields[i]+" "+fields[i+1])
+		}
+	}
+	return words
+}
+
+func normalizePasswordWord(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// PasswordHistory keeps the digests of previous passwords so they cannot be
+// reused.
+type PasswordHistory struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	UserID         uint      `gorm:"not null;index" json:"user_id"`
+	PasswordDigest string    `gorm:"not null" json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
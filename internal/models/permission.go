@@ -0,0 +1,145 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	PermUsersView           = "users.view"
+	PermUsersCreate         = "users.create"
+	PermUsersUpdate         = "users.update"
+	PermUsersDelete         = "users.delete"
+	PermUsersDisable        = "users.disable"
+	PermUsersResetPassword  = "users.reset_password"
+	PermUsersUnlock         = "users.unlock"
+	PermUsersRevokeSessions = "users.revoke_sessions"
+	PermUsersImpersonate    = "users.impersonate"
+	// PermUsersManagePrivileged extends the other user permissions from
+	// salespeople to managers and admins, and allows assigning any role.
+	PermUsersManagePrivileged = "users.manage_privileged"
+	PermActivitiesView        = "activities.view"
+	PermRolesManage           = "roles.manage"
+	PermRateLimitsManage      = "rate_limits.manage"
+	PermSecurityAlertsView    = "security_alerts.view"
+	PermIPRulesManage         = "ip_rules.manage"
+	PermCompaniesManage       = "companies.manage"
+	// PermUsersAllCompanies extends the other user permissions from the
+	// user's own company to every company.
+	PermUsersAllCompanies = "users.all_companies"
+)
+
+// Permissions lists every permission a role can be granted, with the
+// description shown on the roles page.
+var Permissions = []struct {
+	Name        string
+	Description string
+}{
+	{PermUsersView, "List and view users"},
+	{PermUsersCreate, "Create users"},
+	{PermUsersUpdate, "Edit users"},
+	{PermUsersDelete, "Delete users"},
+	{PermUsersDisable, "Enable and disable users"},
+	{PermUsersResetPassword, "Reset users' passwords"},
+	{PermUsersUnlock, "Unlock accounts locked after failed sign-ins"},
+	{PermUsersRevokeSessions, "Sign users out of all sessions"},
+	{PermUsersImpersonate, "Log in as another user to see what they see"},
+	{PermUsersManagePrivileged, "Apply the above to managers and admins, and assign any role"},
+	{PermUsersAllCompanies, "Apply the above to users of every company, not only their own"},
+	{PermActivitiesView, "View everyone's activity"},
+	{PermRolesManage, "Change the permissions of each role"},
+	{PermRateLimitsManage, "View and clear rate limit throttles"},
+	{PermSecurityAlertsView, "See every user's unconfirmed sign-in alerts on the dashboard"},
+	{PermIPRulesManage, "Choose the networks each role and company may sign in from"},
+	{PermCompaniesManage, "Add and edit companies, their logos, email domains and default roles"},
+}
+
+// Roles lists the roles in order of decreasing privilege.
+var Roles = []UserRole{RoleAdmin, RoleManager, RoleSalesperson}
+
+func IsValidPermission(name string) bool {
+	for _, p := range Permissions {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermission grants one permission to every user with the role.
+type RolePermission struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Role       UserRole  `gorm:"not null;uniqueIndex:idx_role_permissions_role_permission" json:"role"`
+	Permission string    `gorm:"not null;size:50;uniqueIndex:idx_role_permissions_role_permission" json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// KnownPermission records that a permission's default grants have been
+// stored, so permissions added in later releases reach existing databases
+// without undoing changes made on the roles page.
+type KnownPermission struct {
+	Name      string    `gorm:"primaryKey;size:50" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PermissionsRevision is a single row counting the changes made to
+// role_permissions, so that every instance sharing the database can tell
+// whether its copy of the permission sets is current.
+type PermissionsRevision struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Revision uint64 `gorm:"not null;default:0" json:"revision"`
+}
+
+// DefaultRolePermissions are the permission sets matching what each role
+// could do before permissions were configurable. They are stored on first
+// start and used until the stored sets are loaded.
+func DefaultRolePermissions() map[UserRole][]string {
+	admin := make([]string, 0, len(Permissions))
+	for _, p := range Permissions {
+		admin = append(admin, p.Name)
+	}
+
+	return map[UserRole][]string{
+		RoleAdmin: admin,
+		RoleManager: {
+			PermUsersView,
+			PermUsersCreate,
+			PermUsersUpdate,
+			PermUsersDelete,
+			PermUsersDisable,
+			PermUsersResetPassword,
+			PermActivitiesView,
+		},
+		RoleSalesperson: {},
+	}
+}
+
+var (
+	rolePermissionsMu sync.RWMutex
+	rolePermissions   = permissionSets(DefaultRolePermissions())
+)
+
+// SetRolePermissions replaces the permission sets checked by
+// User.HasPermission.
+func SetRolePermissions(sets map[UserRole][]string) {
+	rolePermissionsMu.Lock()
+	defer rolePermissionsMu.Unlock()
+	rolePermissions = permissionSets(sets)
+}
+
+func RoleHasPermission(role UserRole, permission string) bool {
+	rolePermissionsMu.RLock()
+	defer rolePermissionsMu.RUnlock()
+	return rolePermissions[role][permission]
+}
+
+func permissionSets(sets map[UserRole][]string) map[UserRole]map[string]bool {
+	result := make(map[UserRole]map[string]bool, len(sets))
+	for role, permissions := range sets {
+		result[role] = make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			result[role][permission] = true
+		}
+	}
+	return result
+}
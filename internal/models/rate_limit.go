@@ -0,0 +1,74 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Parts a rate limit key can be built from.
+const (
+	RateLimitKeyIP    = "ip"
+	RateLimitKeyEmail = "email"
+	// RateLimitKeyUser is the signed-in user, or the personal access token
+	// an API request was authenticated with. Anonymous requests, including
+	// ones whose token has not been accepted, fall back to the IP.
+	RateLimitKeyUser = "user"
+)
+
+// RateLimitPolicy allows Limit requests per Period for every distinct
+// combination of the KeyBy parts.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int64
+	Period time.Duration
+	KeyBy  []string
+}
+
+// DefaultRateLimitPolicies are used for any policy that is not configured.
+func DefaultRateLimitPolicies() []RateLimitPolicy {
+	return []RateLimitPolicy{
+		{Name: "login", Limit: 10, Period: 3 * time.Minute, KeyBy: []string{RateLimitKeyIP, RateLimitKeyEmail}},
+		{Name: "login_ip", Limit: 50, Period: 3 * time.Minute, KeyBy: []string{RateLimitKeyIP}},
+		{Name: "sign_in", Limit: 20, Period: 3 * time.Minute, KeyBy: []string{RateLimitKeyIP}},
+		{Name: "password_reset", Limit: 10, Period: 3 * time.Minute, KeyBy: []string{RateLimitKeyIP, RateLimitKeyEmail}},
+		{Name: "authenticated", Limit: 300, Period: time.Minute, KeyBy: []string{RateLimitKeyUser}},
+	}
+}
+
+func IsValidRateLimitKeyPart(part string) bool {
+	switch part {
+	case RateLimitKeyIP, RateLimitKeyEmail, RateLimitKeyUser:
+		return true
+	}
+	return false
+}
+
+// RateLimitCounter counts the requests made under one key in the current
+// window. Counters live in the database so limits survive restarts and are
+// shared by every instance.
+type RateLimitCounter struct {
+	Key       string    `gorm:"primaryKey;size:255" json:"key"`
+	Count     int64     `gorm:"not null" json:"count"`
+	Limit     int64     `gorm:"column:request_limit;not null" json:"limit"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsThrottled reports whether further requests are refused until the
+// window ends.
+func (c *RateLimitCounter) IsThrottled() bool {
+	return c.Count > c.Limit
+}
+
+// Policy returns the name of the policy the key belongs to.
+func (c *RateLimitCounter) Policy() string {
+	policy, _, _ := strings.Cut(c.Key, ":")
+	return policy
+}
+
+// Subject returns the key without its policy, e.g. "ip=10.0.0.1 email=a@b.c".
+func (c *RateLimitCounter) Subject() string {
+	_, subject, _ := strings.Cut(c.Key, ":")
+	return subject
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	// RememberTokenLifetime caps how long "remember me" keeps a device
+	// signed in, however often it is used.
+	RememberTokenLifetime = 30 * 24 * time.Hour
+	// RememberTokenIdleTimeout forgets devices that have not been used for
+	// a while.
+	RememberTokenIdleTimeout = 14 * 24 * time.Hour
+)
+
+// RememberToken is one "remember me" series. The series ID stays fixed for
+// the life of the login while the token is replaced every time it is used,
+// so a token that turns up again after rotation means the cookie was copied.
+type RememberToken struct {
+	ID                  uint      `gorm:"primaryKey" json:"id"`
+	UserID              uint      `gorm:"not null;index" json:"user_id"`
+	Series              string    `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	TokenDigest         string    `gorm:"not null;size:64" json:"-"`
+	// PreviousTokenDigest is accepted for a short while after rotation so
+	// parallel requests from the same browser are not mistaken for theft.
+	PreviousTokenDigest *string    `gorm:"size:64" json:"-"`
+	RotatedAt           *time.Time `json:"rotated_at"`
+	IPAddress           string    `gorm:"size:45" json:"ip_address"`
+	UserAgent           string    `gorm:"size:500" json:"user_agent"`
+	LastUsedAt          time.Time `json:"last_used_at"`
+	ExpiresAt           time.Time `json:"expires_at"`
+	CreatedAt           time.Time `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (r *RememberToken) IsExpired() bool {
+	now := time.Now()
+	return now.After(r.ExpiresAt) || now.After(r.LastUsedAt.Add(RememberTokenIdleTimeout))
+}
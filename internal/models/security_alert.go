@@ -0,0 +1,84 @@
+package models
+
+import "time"
+
+// Kinds of security alert raised when a user signs in.
+const (
+	// SecurityAlertNewDevice is a sign-in from an IP address or a device
+	// the account has not signed in from before.
+	SecurityAlertNewDevice = "new_device"
+	// SecurityAlertDistantLogin is a sign-in from a different network than
+	// another sign-in a few minutes earlier.
+	SecurityAlertDistantLogin = "distant_login"
+)
+
+// Security alert states. An alert stays open until the user confirms the
+// sign-in or revokes its session.
+const (
+	SecurityAlertOpen      = "open"
+	SecurityAlertConfirmed = "confirmed"
+	SecurityAlertRevoked   = "revoked"
+)
+
+// SecurityAlertPolicy configures when sign-ins raise alerts.
+type SecurityAlertPolicy struct {
+	// DistantLoginWindow is how close together two sign-ins from different
+	// networks must be to count as suspicious.
+	DistantLoginWindow time.Duration
+	// HistoryWindow is how far back sign-ins are looked at to decide
+	// whether an address or device is known.
+	HistoryWindow time.Duration
+	// EmailUser sends each alert to the account's email address.
+	EmailUser bool
+}
+
+func DefaultSecurityAlertPolicy() SecurityAlertPolicy {
+	return SecurityAlertPolicy{
+		DistantLoginWindow: 10 * time.Minute,
+		HistoryWindow:      180 * 24 * time.Hour,
+		EmailUser:          false,
+	}
+}
+
+// SecurityAlert records a sign-in that did not look like the account's
+// usual ones.
+type SecurityAlert struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	UserID    uint   `gorm:"not null;index" json:"user_id"`
+	SessionID *uint  `gorm:"index" json:"session_id"`
+	Type      string `gorm:"not null;size:30" json:"type"`
+	Status    string `gorm:"not null;size:20;index;default:open" json:"status"`
+	IPAddress string `gorm:"size:45" json:"ip_address"`
+	UserAgent string `gorm:"size:500" json:"user_agent"`
+	// PreviousIPAddress is the address of the earlier sign-in a distant
+	// login was compared with.
+	PreviousIPAddress string     `gorm:"size:45" json:"previous_ip_address,omitempty"`
+	ResolvedAt        *time.Time `json:"resolved_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (a *SecurityAlert) IsOpen() bool {
+	return a.Status == SecurityAlertOpen
+}
+
+// IsForSession reports whether the alerted sign-in opened the session.
+func (a *SecurityAlert) IsForSession(sessionID uint) bool {
+	return a.SessionID != nil && *a.SessionID == sessionID
+}
+
+// Device describes the browser and platform that signed in.
+func (a *SecurityAlert) Device() string {
+	return DescribeUserAgent(a.UserAgent)
+}
+
+// Description explains the alert in a sentence for pages and emails.
+func (a *SecurityAlert) Description() string {
+	switch a.Type {
+	case SecurityAlertDistantLogin:
+		return "Signed in from " + a.IPAddress + " minutes after a sign-in from " + a.PreviousIPAddress
+	default:
+		return "Signed in from a new device or location: " + a.Device() + " at " + a.IPAddress
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// TwoFactorChallenge is the pending state between a successful password check
+// and the TOTP step of a login. No session exists until it is completed.
+type TwoFactorChallenge struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	TokenDigest string  `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	Remember  bool      `gorm:"default:false" json:"remember"`
+	Attempts  int       `gorm:"default:0" json:"attempts"`
+	IPAddress string    `gorm:"size:45" json:"ip_address"`
+	UserAgent string    `gorm:"size:500" json:"user_agent"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (t *TwoFactorChallenge) IsExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
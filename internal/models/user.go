@@ -2,6 +2,8 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -29,34 +31,86 @@ func (r UserRole) String() string {
 	}
 }
 
+// ParseRole converts a role name as produced by UserRole.String.
+func ParseRole(name string) (UserRole, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "admin":
+		return RoleAdmin, true
+	case "manager":
+		return RoleManager, true
+	case "salesperson":
+		return RoleSalesperson, true
+	default:
+		return 0, false
+	}
+}
+
+// Rank orders roles by privilege, higher being more privileged, so that
+// comparisons do not depend on the order of the constants. Unknown roles
+// rank lowest.
+func (r UserRole) Rank() int {
+	switch r {
+	case RoleAdmin:
+		return 3
+	case RoleManager:
+		return 2
+	case RoleSalesperson:
+		return 1
+	default:
+		return 0
+	}
+}
+
 type User struct {
 	ID                     uint           `gorm:"primaryKey" json:"id"`
 	Email                  string         `gorm:"uniqueIndex;not null" json:"email"`
 	Name                   string         `gorm:"not null;size:100" json:"name"`
 	PasswordDigest         string         `gorm:"not null" json:"-"`
 	Role                   UserRole       `gorm:"not null;default:2" json:"role"`
-	Company                *string        `gorm:"size:100" json:"company"`
+	CompanyID              *uint          `gorm:"index" json:"company_id"`
 	Enabled                bool           `gorm:"default:true" json:"enabled"`
 	LastSignInAt           *time.Time     `json:"last_sign_in_at"`
 	CurrentSignInAt        *time.Time     `json:"current_sign_in_at"`
 	SignInCount            int            `gorm:"default:0" json:"sign_in_count"`
 	PasswordResetAt        *time.Time     `json:"password_reset_at"`
 	PasswordExpiresAt      *time.Time     `json:"password_expires_at"`
+	MustChangePassword     bool           `gorm:"default:false" json:"must_change_password"`
 	ManagedCustomersCount  int            `gorm:"default:0" json:"managed_customers_count"`
+	TOTPSecret             *string        `gorm:"size:128" json:"-"`
+	TOTPEnabled            bool           `gorm:"default:false" json:"totp_enabled"`
+	TOTPEnabledAt          *time.Time     `json:"totp_enabled_at"`
+	TOTPLastStep           int64          `gorm:"default:0" json:"-"`
+	FailedLoginAttempts    int            `gorm:"default:0" json:"failed_login_attempts"`
+	LockedUntil            *time.Time     `json:"locked_until"`
 	CreatedAt              time.Time      `json:"created_at"`
 	UpdatedAt              time.Time      `json:"updated_at"`
+	// DeletedAt archives the user instead of removing the row, so their
+	// activities keep pointing at it. PurgedAt is set once the retention
+	// window has passed and the row has been reduced to a tombstone.
+	DeletedAt              gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	DeletedByID            *uint          `json:"deleted_by_id,omitempty"`
+	PurgedAt               *time.Time     `json:"purged_at,omitempty"`
 	
+	Company                *Company       `json:"company,omitempty"`
 	Sessions               []Session      `gorm:"foreignKey:UserID"`
 	Activities             []UserActivity `gorm:"foreignKey:UserID"`
 	PasswordResetEvents    []PasswordResetEvent `gorm:"foreignKey:UserID"`
+	
+	// createdAsAdmin carries RoleAdmin through Create; see AfterCreate.
+	createdAsAdmin         bool
 }
 
 func (u *User) SetPassword(password string) error {
+	if err := GetPasswordPolicy().Validate(password, u); err != nil {
+		return err
+	}
+	
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 	u.PasswordDigest = string(hashedPassword)
+	u.MustChangePassword = false
 	now := time.Now()
 	u.PasswordResetAt = &now
 	expiry := now.Add(30 * 24 * time.Hour)
@@ -64,6 +118,53 @@ func (u *User) SetPassword(password string) error {
 	return nil
 }
 
+// SetRandomPassword gives the user a password nobody knows, for accounts
+// created through single sign-on. It never expires, so the user is not sent
+// to the change password page; they can still pick one through a reset.
+func (u *User) SetRandomPassword() error {
+	secret, err := GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordDigest = string(hashedPassword)
+	u.MustChangePassword = false
+	u.PasswordExpiresAt = nil
+	return nil
+}
+
+// DefaultDeletedUserRetention is how long a deleted user can be restored.
+const DefaultDeletedUserRetention = 30 * 24 * time.Hour
+
+// IsArchived reports whether the user has been deleted but not yet purged.
+func (u *User) IsArchived() bool {
+	return u.DeletedAt.Valid && u.PurgedAt == nil
+}
+
+// PurgeAt is when an archived user's personal details will be erased.
+func (u *User) PurgeAt(retention time.Duration) time.Time {
+	return u.DeletedAt.Time.Add(retention)
+}
+
+// Anonymize replaces the user's personal details and credentials with a
+// tombstone that still identifies the row by ID.
+func (u *User) Anonymize() {
+	now := time.Now()
+	u.Name = fmt.Sprintf("Deleted user #%d", u.ID)
+	u.Email = fmt.Sprintf("deleted-%d@deleted.invalid", u.ID)
+	u.PasswordDigest = ""
+	u.CompanyID = nil
+	u.Company = nil
+	u.Enabled = false
+	u.TOTPSecret = nil
+	u.TOTPEnabled = false
+	u.TOTPEnabledAt = nil
+	u.PurgedAt = &now
+}
+
 func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordDigest), []byte(password))
 	return err == nil
@@ -76,6 +177,33 @@ func (u *User) IsPasswordExpired() bool {
 	return time.Now().After(*u.PasswordExpiresAt)
 }
 
+// NeedsPasswordChange reports whether the user may only change their
+// password until they pick a new one.
+func (u *User) NeedsPasswordChange() bool {
+	return u.MustChangePassword || u.IsPasswordExpired()
+}
+
+func (u *User) IsLocked() bool {
+	if u.LockedUntil == nil {
+		return false
+	}
+	return time.Now().Before(*u.LockedUntil)
+}
+
+// CompanyName returns the name of the user's company, which must have been
+// loaded, or "" if they have none.
+func (u *User) CompanyName() string {
+	if u.Company == nil {
+		return ""
+	}
+	return u.Company.Name
+}
+
+// InCompany reports whether the user belongs to the company.
+func (u *User) InCompany(companyID uint) bool {
+	return u.CompanyID != nil && *u.CompanyID == companyID
+}
+
 func (u *User) ShouldResetForInactivity() bool {
 	if u.LastSignInAt == nil {
 		return false
@@ -91,15 +219,21 @@ func (u *User) UpdateSignInInfo() {
 	u.SignInCount++
 }
 
+// HasPermission reports whether the user's role grants the permission.
+func (u *User) HasPermission(permission string) bool {
+	return RoleHasPermission(u.Role, permission)
+}
+
+// CanManageUser reports whether the target is within reach of the user's
+// user management permissions. Without PermUsersManagePrivileged only
+// salespeople are, and without PermUsersAllCompanies only users of the
+// user's own company.
 func (u *User) CanManageUser(targetUser *User) bool {
-	switch u.Role {
-	case RoleAdmin:
-		return true
-	case RoleManager:
-		return targetUser.Role == RoleSalesperson
-	default:
+	if !u.HasPermission(PermUsersView) || !u.CanAssignCompany(targetUser.CompanyID) {
 		return false
 	}
+	
+	return targetUser.Role == RoleSalesperson || u.HasPermission(PermUsersManagePrivileged)
 }
 
 func (u *User) CanDisableUser(targetUser *User) bool {
@@ -111,26 +245,117 @@ func (u *User) CanDisableUser(targetUser *User) bool {
 		return false
 	}
 	
-	return u.CanManageUser(targetUser)
+	return u.HasPermission(PermUsersDisable) && u.CanManageUser(targetUser)
+}
+
+// CanAssignRole reports whether the user may create users with, or move
+// users into, the role.
+func (u *User) CanAssignRole(role UserRole) bool {
+	return role == RoleSalesperson || u.HasPermission(PermUsersManagePrivileged)
+}
+
+// CanAssignCompany reports whether the user may create users in, or move
+// users into, the company. Nil stands for no company.
+func (u *User) CanAssignCompany(companyID *uint) bool {
+	return u.HasPermission(PermUsersAllCompanies) || sameCompany(u.CompanyID, companyID)
+}
+
+// CompanyScope returns the part of the directory the user's lists and
+// statistics cover. Users with PermUsersAllCompanies see every company
+// unless they have chosen one to work in as their context; everyone else
+// sees their own company, or only users without one if they have none.
+func (u *User) CompanyScope(context *uint) CompanyScope {
+	if u.HasPermission(PermUsersAllCompanies) {
+		return CompanyScope{All: context == nil, CompanyID: context}
+	}
+	return CompanyScope{CompanyID: u.CompanyID}
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	u.Email = normalizeEmail(u.Email)
+	u.createdAsAdmin = u.Role == RoleAdmin
 	return nil
 }
 
+// AfterCreate gives a user created as an admin their role back. GORM
+// inserts the column default, salesperson, in place of a zero field, and
+// RoleAdmin is zero.
+func (u *User) AfterCreate(tx *gorm.DB) error {
+	if !u.createdAsAdmin {
+		return nil
+	}
+	u.createdAsAdmin = false
+	u.Role = RoleAdmin
+	return tx.Model(u).UpdateColumn("role", RoleAdmin).Error
+}
+
 func (u *User) BeforeUpdate(tx *gorm.DB) error {
 	u.Email = normalizeEmail(u.Email)
 	return nil
 }
 
+// AfterSave signs a disabled user out of every session, whichever code path
+// disabled them.
+func (u *User) AfterSave(tx *gorm.DB) error {
+	if u.ID == 0 || u.Enabled {
+		return nil
+	}
+	// Partial updates may leave Enabled unset, so check the stored value.
+	disabled := tx.Model(&User{}).Select("id").Where("id = ? AND enabled = ?", u.ID, false)
+	if err := tx.Where("user_id IN (?)", disabled).Delete(&Session{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id IN (?)", disabled).Delete(&RememberToken{}).Error
+}
+
+// AfterDelete ends the deleted user's sessions and remembered devices,
+// drops their security alerts and revokes an open invitation. It runs when
+// a user is archived as well; their activities and invitation stay for the
+// audit trail.
+func (u *User) AfterDelete(tx *gorm.DB) error {
+	if u.ID == 0 {
+		return nil
+	}
+	if err := tx.Where("user_id = ?", u.ID).Delete(&Session{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", u.ID).Delete(&SecurityAlert{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Invitation{}).
+		Where("user_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", u.ID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "token_digest": nil}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", u.ID).Delete(&RememberToken{}).Error
+}
+
+const (
+	// SessionIdleTimeout ends a session that has not been used for a while.
+	SessionIdleTimeout = 30 * time.Minute
+	// SessionAbsoluteTimeout ends a session however active it is.
+	// Remembered devices get a fresh session from their remember token.
+	SessionAbsoluteTimeout = 12 * time.Hour
+)
+
 type Session struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	UserID    uint      `gorm:"not null;index" json:"user_id"`
-	Token     string    `gorm:"uniqueIndex;not null" json:"-"`
+	TokenDigest string  `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	// RememberTokenID links sessions opened through "remember me" to their
+	// series so revoking one revokes the other.
+	RememberTokenID *uint   `gorm:"index" json:"remember_token_id"`
+	// ImpersonatorID is set on sessions an admin opened with "Log in as".
+	ImpersonatorID *uint    `gorm:"index" json:"impersonator_id"`
+	// CompanyContextID is the company a user who may see every company
+	// has chosen to work in, or nil while they see all of them.
+	CompanyContextID *uint  `json:"company_context_id"`
+	// CSRFToken is the synchronizer token forms in this session must submit.
+	CSRFToken string        `gorm:"size:64" json:"-"`
 	IPAddress string    `gorm:"size:45" json:"ip_address"`
 	UserAgent string    `gorm:"size:500" json:"user_agent"`
 	ExpiresAt time.Time `json:"expires_at"`
+	AbsoluteExpiresAt *time.Time `json:"absolute_expires_at"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	
@@ -138,11 +363,86 @@ type Session struct {
 }
 
 func (s *Session) IsExpired() bool {
-	return time.Now().After(s.ExpiresAt)
+	now := time.Now()
+	if s.AbsoluteExpiresAt != nil && now.After(*s.AbsoluteExpiresAt) {
+		return true
+	}
+	return now.After(s.ExpiresAt)
 }
 
+// Extend pushes the idle timeout back, never past the absolute timeout.
 func (s *Session) Extend() {
-	s.ExpiresAt = time.Now().Add(30 * time.Minute)
+	expiresAt := time.Now().Add(SessionIdleTimeout)
+	if s.AbsoluteExpiresAt == nil {
+		absolute := s.CreatedAt.Add(SessionAbsoluteTimeout)
+		s.AbsoluteExpiresAt = &absolute
+	}
+	if expiresAt.After(*s.AbsoluteExpiresAt) {
+		expiresAt = *s.AbsoluteExpiresAt
+	}
+	s.ExpiresAt = expiresAt
+}
+
+// IsRemembered reports whether the session came from "remember me".
+func (s *Session) IsRemembered() bool {
+	return s.RememberTokenID != nil
+}
+
+// LastSeenAt is when the session was last used; it is touched on every
+// authenticated request.
+func (s *Session) LastSeenAt() time.Time {
+	return s.UpdatedAt
+}
+
+// Device gives a short description such as "Chrome on Windows" from the
+// session's user agent.
+func (s *Session) Device() string {
+	return DescribeUserAgent(s.UserAgent)
+}
+
+// DescribeUserAgent names the browser and platform of a user agent, e.g.
+// "Chrome on Windows". Browser updates do not change the description.
+func DescribeUserAgent(ua string) string {
+	browser := ""
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(ua, "curl/"):
+		browser = "curl"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
 }
 
 type UserActivity struct {
@@ -166,6 +466,7 @@ const (
 	ResetTypeManual             ResetType = "manual"
 	ResetTypeAutomaticExpiry    ResetType = "automatic_expiry"
 	ResetTypeAutomaticInactivity ResetType = "automatic_inactivity"
+	ResetTypeSelfService        ResetType = "self_service"
 )
 
 type PasswordResetEvent struct {
@@ -177,17 +478,39 @@ type PasswordResetEvent struct {
 	UserAgent  string    `gorm:"size:500" json:"user_agent"`
 	Success    bool      `gorm:"default:false" json:"success"`
 	ResetType  ResetType `gorm:"not null" json:"reset_type"`
-	Token      *string   `gorm:"uniqueIndex;size:100" json:"-"`
+	TokenDigest *string  `gorm:"uniqueIndex;size:64" json:"-"`
 	ExpiresAt  *time.Time `json:"expires_at"`
+	// A manual reset's temporary password is shown to the admin once
+	// through a reveal link. RevealSecret holds it sealed with the link's
+	// token, which is not stored, and is cleared when viewed or expired.
+	RevealDigest    *string    `gorm:"uniqueIndex;size:64" json:"-"`
+	RevealSecret    *string    `gorm:"size:255" json:"-"`
+	RevealExpiresAt *time.Time `json:"reveal_expires_at"`
+	SecretViewedAt  *time.Time `json:"secret_viewed_at"`
 	CreatedAt  time.Time `json:"created_at"`
 	
 	User       User      `gorm:"foreignKey:UserID"`
 	Admin      *User     `gorm:"foreignKey:AdminID"`
 }
 
+// PasswordRevealTTL is how long the reveal link for a temporary password
+// works if it is not opened.
+const PasswordRevealTTL = 10 * time.Minute
+
 func (p *PasswordResetEvent) IsExpired() bool {
 	if p.ExpiresAt == nil {
 		return false
 	}
 	return time.Now().After(*p.ExpiresAt)
+}
+
+// SecretViewed reports whether the admin opened the temporary password.
+func (p *PasswordResetEvent) SecretViewed() bool {
+	return p.SecretViewedAt != nil
+}
+
+// HasRevealLink reports whether the event had a temporary password to
+// reveal, viewed or not.
+func (p *PasswordResetEvent) HasRevealLink() bool {
+	return p.RevealExpiresAt != nil
 }
\ No newline at end of file
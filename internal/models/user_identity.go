@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Provider    string     `gorm:"not null;size:50;uniqueIndex:idx_user_identities_provider_subject" json:"provider"`
+	Subject     string     `gorm:"not null;size:255;uniqueIndex:idx_user_identities_provider_subject" json:"subject"`
+	Email       string     `gorm:"size:255" json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// SSOLoginState remembers an SSO sign-in between the redirect to the
+// provider and its callback. The state value itself is only kept as a digest.
+// LinkUserID is set when a signed-in user is linking the provider to their
+// account rather than signing in.
+type SSOLoginState struct {
+	ID           uint      `gorm:"primaryKey"`
+	StateDigest  string    `gorm:"uniqueIndex;not null;size:64"`
+	Provider     string    `gorm:"not null;size:50"`
+	Nonce        string    `gorm:"not null;size:64"`
+	CodeVerifier string    `gorm:"not null;size:128"`
+	LinkUserID   *uint     `gorm:"index"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+	CreatedAt    time.Time
+}
+
+func (s *SSOLoginState) IsExpired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
@@ -1,13 +1,17 @@
 package models
 
 import (
+	"strings"
 	"testing"
 	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func TestUserSetPassword(t *testing.T) {
 	user := &User{}
-	password := "testpassword123"
+	password := "Secur3!Passw0rd"
 	
 	err := user.SetPassword(password)
 	if err != nil {
@@ -29,7 +33,7 @@ func TestUserSetPassword(t *testing.T) {
 
 func TestUserCheckPassword(t *testing.T) {
 	user := &User{}
-	password := "testpassword123"
+	password := "Secur3!Passw0rd"
 	
 	user.SetPassword(password)
 	
@@ -88,6 +92,31 @@ func TestUserUpdateSignInInfo(t *testing.T) {
 	}
 }
 
+func TestUserCreateKeepsRole(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	
+	for _, role := range []UserRole{RoleAdmin, RoleManager, RoleSalesperson} {
+		user := &User{Email: role.String() + "@example.com", Name: role.String(), PasswordDigest: "x", Role: role, Enabled: true}
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("Failed to create %s: %v", role, err)
+		}
+		
+		var stored User
+		if err := db.First(&stored, user.ID).Error; err != nil {
+			t.Fatalf("Failed to load %s: %v", role, err)
+		}
+		if user.Role != role || stored.Role != role {
+			t.Errorf("Created %s, got %s in memory and %s stored", role, user.Role, stored.Role)
+		}
+	}
+}
+
 func TestUserCanManageUser(t *testing.T) {
 	admin := &User{Role: RoleAdmin}
 	manager := &User{Role: RoleManager}
@@ -114,6 +143,55 @@ func TestUserCanManageUser(t *testing.T) {
 	}
 }
 
+func TestUserCompanyScope(t *testing.T) {
+	acme, other := uint(1), uint(2)
+	admin := &User{ID: 1, Role: RoleAdmin}
+	manager := &User{ID: 2, Role: RoleManager, CompanyID: &acme}
+	colleague := &User{ID: 3, Role: RoleSalesperson, CompanyID: &acme}
+	outsider := &User{ID: 4, Role: RoleSalesperson, CompanyID: &other}
+	unassigned := &User{ID: 5, Role: RoleSalesperson}
+	
+	if !manager.CanManageUser(colleague) || manager.CanManageUser(outsider) || manager.CanManageUser(unassigned) {
+		t.Error("Managers should only manage users of their own company")
+	}
+	if !admin.CanManageUser(outsider) || !admin.CanManageUser(unassigned) {
+		t.Error("Admins should manage users of every company")
+	}
+	if !manager.CanAssignCompany(&acme) || manager.CanAssignCompany(&other) || manager.CanAssignCompany(nil) {
+		t.Error("Managers should only assign their own company")
+	}
+	if !(&User{Role: RoleManager}).CanAssignCompany(nil) {
+		t.Error("Managers without a company should keep users without one")
+	}
+	
+	scope := manager.CompanyScope(&other)
+	if scope.All || !scope.Includes(colleague) || scope.Includes(outsider) {
+		t.Errorf("A manager's scope should be their company whatever the context, got %+v", scope)
+	}
+	if condition, args := scope.Condition("company_id"); condition != "company_id = ?" || len(args) != 1 || args[0] != acme {
+		t.Errorf("Unexpected condition %q %v", condition, args)
+	}
+	
+	scope = admin.CompanyScope(nil)
+	if !scope.All || !scope.Includes(outsider) || !scope.Includes(unassigned) {
+		t.Errorf("An admin without a context should see every company, got %+v", scope)
+	}
+	if condition, _ := scope.Condition("company_id"); condition != "" {
+		t.Errorf("Every company should need no condition, got %q", condition)
+	}
+	if scope = admin.CompanyScope(&other); scope.All || !scope.Includes(outsider) || scope.Includes(colleague) {
+		t.Errorf("An admin's context should narrow their scope, got %+v", scope)
+	}
+	
+	scope = (&User{Role: RoleManager}).CompanyScope(nil)
+	if scope.Includes(colleague) || !scope.Includes(unassigned) {
+		t.Errorf("Managers without a company should only see users without one, got %+v", scope)
+	}
+	if condition, args := scope.Condition("company_id"); condition != "company_id IS NULL" || len(args) != 0 {
+		t.Errorf("Unexpected condition %q %v", condition, args)
+	}
+}
+
 func TestUserCanDisableUser(t *testing.T) {
 	admin := &User{ID: 1, Role: RoleAdmin}
 	manager := &User{ID: 2, Role: RoleManager}
@@ -132,6 +210,39 @@ func TestUserCanDisableUser(t *testing.T) {
 	}
 }
 
+func TestUserHasPermission(t *testing.T) {
+	defer SetRolePermissions(DefaultRolePermissions())
+	
+	manager := &User{ID: 2, Role: RoleManager}
+	salesperson := &User{ID: 3, Role: RoleSalesperson}
+	
+	if !manager.HasPermission(PermUsersDelete) || manager.HasPermission(PermRolesManage) {
+		t.Error("Managers should start with the permissions they had before roles were configurable")
+	}
+	
+	// A manager who may reset passwords but not delete users.
+	SetRolePermissions(map[UserRole][]string{
+		RoleManager: {PermUsersView, PermUsersResetPassword},
+	})
+	
+	if manager.HasPermission(PermUsersDelete) {
+		t.Error("Manager should not be able to delete users once the permission is removed")
+	}
+	if !manager.HasPermission(PermUsersResetPassword) || !manager.CanManageUser(salesperson) {
+		t.Error("Manager should still be able to reset salespeople's passwords")
+	}
+	if manager.CanDisableUser(salesperson) {
+		t.Error("Manager should not be able to disable users without users.disable")
+	}
+	if manager.CanAssignRole(RoleManager) || !manager.CanAssignRole(RoleSalesperson) {
+		t.Error("Only users.manage_privileged should allow assigning roles other than salesperson")
+	}
+	
+	if (&User{Role: RoleAdmin}).HasPermission(PermUsersView) {
+		t.Error("Roles missing from the permission sets should have no permissions")
+	}
+}
+
 func TestGenerateStrongPassword(t *testing.T) {
 	password, err := GenerateStrongPassword()
 	if err != nil {
@@ -149,9 +260,11 @@ func TestValidatePassword(t *testing.T) {
 		valid    bool
 	}{
 		{"", false},
-		{"12345", false},
-		{"123456", true},
-		{"longenoughpassword", true},
+		{"Ab1!xyz", false},
+		{"Ab1!xyzw", true},
+		{"longenoughpassword", false},
+		{"LongEnoughPassword1", false},
+		{"Sh1p$Ahoy", true},
 	}
 	
 	for _, test := range tests {
@@ -165,6 +278,53 @@ func TestValidatePassword(t *testing.T) {
 	}
 }
 
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	policy.CompanyNames = func() []string {
+		return []string{"Al Safwan Marine", "Louis Safety", "Data Grid Labs", "ASM", "Seaways"}
+	}
+	user := &User{Email: "captain.jones@alsafwanmarine.com"}
+	
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"Sh1p$Ahoy", true},
+		{"SHIP$AHOY1", false},
+		{"ship$ahoy1", false},
+		{"Ship$Ahoy", false},
+		{"Ship1Ahoy", false},
+		{"AlSafwan#2024", false},
+		{"al-safwan#2024X", false},
+		{"Louis.Safety1", false},
+		{"Grid-Labs#2024", false},
+		{"Seaways#2024", false},
+		{"Asm!Harbour9", true},
+		{"Captain.Jones1", false},
+		{"captainjones@alsafwanmarine.com1A", false},
+	}
+	
+	for _, test := range tests {
+		err := policy.Validate(test.password, user)
+		if test.valid && err != nil {
+			t.Errorf("Password %q should be valid, got %v", test.password, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Password %q should be invalid", test.password)
+		}
+	}
+	
+	short := &User{Email: "bd@alsafwanmarine.com"}
+	if err := policy.Validate("Bd!Harbour9", short); err != nil {
+		t.Errorf("Short email local parts should not be banned, got %v", err)
+	}
+	
+	relaxed := PasswordPolicy{MinLength: 4}
+	if err := relaxed.Validate("abcd", nil); err != nil {
+		t.Errorf("Relaxed policy should accept %q, got %v", "abcd", err)
+	}
+}
+
 func TestValidateName(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -188,27 +348,89 @@ func TestValidateName(t *testing.T) {
 	}
 }
 
-func TestValidateCompany(t *testing.T) {
+func TestValidateEmail(t *testing.T) {
 	tests := []struct {
-		company *string
-		valid   bool
+		email string
+		valid bool
 	}{
-		{nil, true},
-		{stringPtr("Al Safwan Marine"), true},
-		{stringPtr("Louis Safety"), true},
-		{stringPtr("Data Grid Labs"), true},
-		{stringPtr("Invalid Company"), false},
+		{"", false},
+		{"jane", false},
+		{"jane@example.com", true},
+		{"jane.doe+sales@example.co.uk", true},
+		{"Jane <jane@example.com>", false},
+		{"jane@example.com, john@example.com", false},
+		{longString(250) + "@x.io", false},
 	}
 	
 	for _, test := range tests {
-		err := ValidateCompany(test.company)
+		err := ValidateEmail(test.email)
 		if test.valid && err != nil {
-			t.Errorf("Company %v should be valid", test.company)
+			t.Errorf("Email %q should be valid", test.email)
 		}
 		if !test.valid && err == nil {
-			t.Errorf("Company %v should be invalid", test.company)
+			t.Errorf("Email %q should be invalid", test.email)
+		}
+	}
+}
+
+func TestNormalizeEmailDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+		valid  bool
+	}{
+		{"", "", true},
+		{" @AlSafwanMarine.com ", "alsafwanmarine.com", true},
+		{"mail.louis-safety.co.uk", "mail.louis-safety.co.uk", true},
+		{"localhost", "", false},
+		{"example..com", "", false},
+		{".example.com", "", false},
+		{"exa mple.com", "", false},
+		{"user@example.com", "", false},
+	}
+	
+	for _, test := range tests {
+		got, ok := NormalizeEmailDomain(test.domain)
+		if got != test.want || ok != test.valid {
+			t.Errorf("NormalizeEmailDomain(%q) = %q, %v; want %q, %v", test.domain, got, ok, test.want, test.valid)
+		}
+	}
+}
+
+func TestCompanyLookup(t *testing.T) {
+	companies := []Company{
+		{ID: 1, Name: "Al Safwan Marine", EmailDomain: "alsafwanmarine.com"},
+		{ID: 2, Name: "Louis Safety"},
+	}
+	
+	if company := FindCompany(companies, " louis safety "); company == nil || company.ID != 2 {
+		t.Errorf("Expected names to match ignoring case and spaces, got %v", company)
+	}
+	if company := FindCompany(companies, "Louis"); company != nil {
+		t.Errorf("Expected no partial matches, got %v", company)
+	}
+	
+	if company := CompanyForEmail(companies, "Sam@AlSafwanMarine.com"); company == nil || company.ID != 1 {
+		t.Errorf("Expected the email domain to match, got %v", company)
+	}
+	for _, email := range []string{"sam@mail.alsafwanmarine.com", "alsafwanmarine.com@example.com", "sam@example.com"} {
+		if company := CompanyForEmail(companies, email); company != nil {
+			t.Errorf("Expected %s to match no company, got %v", email, company)
 		}
 	}
+	
+	if companies[0].LogoURL() != "" || companies[0].HasLogo() {
+		t.Error("A company without a logo should have no logo URL")
+	}
+	updated := time.Unix(1700000000, 0)
+	companies[0].LogoUpdatedAt = &updated
+	if companies[0].LogoURL() != "/companies/1/logo?v=1700000000" {
+		t.Errorf("Unexpected logo URL %q", companies[0].LogoURL())
+	}
+	
+	if IsValidDefaultRole(RoleAdmin) || !IsValidDefaultRole(RoleManager) || !IsValidDefaultRole(RoleSalesperson) {
+		t.Error("Only managers and salespeople should be valid default roles")
+	}
 }
 
 func longString(n int) string {
@@ -219,6 +441,102 @@ func longString(n int) string {
 	return string(result)
 }
 
-func stringPtr(s string) *string {
-	return &s
-}
\ No newline at end of file
+func TestCheckIPRules(t *testing.T) {
+	admin := RoleAdmin
+	sales := RoleSalesperson
+	asm, louis := uint(1), uint(2)
+	rules := []IPRule{
+		{ID: 1, Action: IPRuleAllow, CIDR: "10.20.0.0/16", Role: &admin},
+		{ID: 2, Action: IPRuleAllow, CIDR: "192.0.2.0/24", Role: &admin, CompanyID: &asm},
+		{ID: 3, Action: IPRuleDeny, CIDR: "10.20.99.0/24"},
+		{ID: 4, Action: IPRuleDeny, CIDR: "198.51.100.0/24", Role: &sales},
+	}
+	
+	asmAdmin := &User{Role: RoleAdmin, CompanyID: &asm}
+	otherAdmin := &User{Role: RoleAdmin, CompanyID: &louis}
+	salesperson := &User{Role: RoleSalesperson}
+	
+	tests := []struct {
+		name      string
+		user      *User
+		ip        string
+		allowed   bool
+		refusedBy uint
+	}{
+		{"admin in the office", otherAdmin, "10.20.1.5", true, 0},
+		{"admin at home", otherAdmin, "203.0.113.9", false, 0},
+		{"admin on another company's VPN", otherAdmin, "192.0.2.10", false, 0},
+		{"admin on their company's VPN", asmAdmin, "192.0.2.10", true, 0},
+		{"deny wins over allow", otherAdmin, "10.20.99.4", false, 3},
+		{"salesperson travelling", salesperson, "203.0.113.9", true, 0},
+		{"salesperson on a denied network", salesperson, "198.51.100.20", false, 4},
+		{"unparseable address", otherAdmin, "not-an-ip", false, 0},
+	}
+	
+	for _, test := range tests {
+		allowed, refusedBy := CheckIPRules(rules, test.user, test.ip)
+		if allowed != test.allowed {
+			t.Errorf("%s: expected allowed=%v", test.name, test.allowed)
+		}
+		if (refusedBy == nil && test.refusedBy != 0) || (refusedBy != nil && refusedBy.ID != test.refusedBy) {
+			t.Errorf("%s: expected rule %d to refuse, got %+v", test.name, test.refusedBy, refusedBy)
+		}
+	}
+	
+	if allowed, _ := CheckIPRules(nil, salesperson, "203.0.113.9"); !allowed {
+		t.Error("Without rules everyone should be allowed")
+	}
+}
+
+func TestParseCIDR(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", true},
+		{" 10.1.2.3/16 ", "10.1.0.0/16", true},
+		{"203.0.113.7", "203.0.113.7/32", true},
+		{"2001:db8::1", "2001:db8::1/128", true},
+		{"2001:db8::/32", "2001:db8::/32", true},
+		{"10.0.0.0/33", "", false},
+		{"office", "", false},
+	}
+	
+	for _, test := range tests {
+		got, ok := ParseCIDR(test.input)
+		if ok != test.ok || got != test.want {
+			t.Errorf("ParseCIDR(%q) = %q, %v; want %q, %v", test.input, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestUserArchive(t *testing.T) {
+	companyID := uint(1)
+	user := &User{ID: 7, Name: "Test User", Email: "test@example.com", CompanyID: &companyID, Company: &Company{ID: companyID, Name: "Al Safwan Marine"}, Enabled: true}
+	user.SetPassword("Secur3!Passw0rd")
+	
+	if user.IsArchived() {
+		t.Error("A new user should not be archived")
+	}
+	
+	deletedAt := time.Now().Add(-time.Hour)
+	user.DeletedAt = gorm.DeletedAt{Time: deletedAt, Valid: true}
+	if !user.IsArchived() {
+		t.Error("A deleted user should be archived")
+	}
+	if !user.PurgeAt(DefaultDeletedUserRetention).Equal(deletedAt.Add(DefaultDeletedUserRetention)) {
+		t.Error("The user should be purged once the retention window has passed")
+	}
+	
+	user.Anonymize()
+	if user.IsArchived() || user.PurgedAt == nil {
+		t.Error("An anonymized user should be purged, not archived")
+	}
+	if strings.Contains(user.Name, "Test") || strings.Contains(user.Email, "test@") || user.CompanyID != nil || user.Company != nil || user.Enabled {
+		t.Errorf("Expected personal details to be removed, got %+v", user)
+	}
+	if user.CheckPassword("Secur3!Passw0rd") {
+		t.Error("An anonymized user should have no usable password")
+	}
+}
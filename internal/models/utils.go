@@ -1,10 +1,17 @@
 package models
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
+	"net/mail"
 	"strings"
 )
 
@@ -20,6 +27,67 @@ func GenerateSecureToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// HashToken returns the SHA-256 digest that is stored in place of a secret
+// token, so a copy of the database cannot be used to replay it.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeriveKey returns a key for one purpose from the app key, so that keys
+// used for signing and for encryption are never the same.
+func DeriveKey(appKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, appKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// ErrSealedSecret is returned when a sealed secret is malformed or was not
+// sealed with the given key.
+var ErrSealedSecret = errors.New("the secret could not be decrypted")
+
+// SealSecret encrypts secret with a 32-byte key using AES-GCM and returns
+// it base64-encoded with its nonce.
+func SealSecret(key []byte, secret string) (string, error) {
+	aead, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// OpenSecret decrypts a value returned by SealSecret.
+func OpenSecret(key []byte, sealed string) (string, error) {
+	aead, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", ErrSealedSecret
+	}
+	secret, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrSealedSecret
+	}
+	return string(secret), nil
+}
+
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrSealedSecret
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 func GenerateStrongPassword() (string, error) {
 	const (
 		upperChars   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -52,8 +120,13 @@ func GenerateStrongPassword() (string, error) {
 		return "", err
 	}
 	
+	length := 8
+	if minLength := GetPasswordPolicy().MinLength; minLength > length {
+		length = minLength
+	}
+	
 	allChars := upperChars + lowerChars + numberChars + specialChars
-	for i := 0; i < 4; i++ {
+	for i := 4; i < length; i++ {
 		if err := appendRandomChar(allChars); err != nil {
 			return "", err
 		}
@@ -72,10 +145,7 @@ func GenerateStrongPassword() (string, error) {
 }
 
 func ValidatePassword(password string) error {
-	if len(password) < 6 {
-		return fmt.Errorf("password must be at least 6 characters long")
-	}
-	return nil
+	return GetPasswordPolicy().Validate(password, nil)
 }
 
 func ValidateName(name string) error {
@@ -85,22 +155,12 @@ func ValidateName(name string) error {
 	return nil
 }
 
-func ValidateCompany(company *string) error {
-	if company == nil {
-		return nil
+// ValidateEmail checks that email is a bare address such as
+// "name@example.com", without a display name or angle brackets.
+func ValidateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > 254 {
+		return fmt.Errorf("email must be a valid address")
 	}
-	
-	validCompanies := []string{
-		"Al Safwan Marine",
-		"Louis Safety",
-		"Data Grid Labs",
-	}
-	
-	for _, valid := range validCompanies {
-		if *company == valid {
-			return nil
-		}
-	}
-	
-	return fmt.Errorf("invalid company name")
-}
\ No newline at end of file
+	return nil
+}
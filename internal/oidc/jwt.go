@@ -0,0 +1,198 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+func parseJWT(raw string) (*jwtHeader, map[string]interface{}, []byte, []byte, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, nil, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: malformed header", ErrInvalidIDToken)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: malformed header", ErrInvalidIDToken)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: malformed payload", ErrInvalidIDToken)
+	}
+	var claims map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: malformed payload", ErrInvalidIDToken)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: malformed signature", ErrInvalidIDToken)
+	}
+
+	return &header, claims, []byte(parts[0] + "." + parts[1]), signature, nil
+}
+
+// hashes maps the supported JWS algorithms to their digest. Symmetric and
+// "none" algorithms are deliberately absent.
+var hashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+func verifySignature(alg string, key interface{}, signingInput, signature []byte) error {
+	hash, ok := hashes[alg]
+	if !ok {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidIDToken, alg)
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: key does not match algorithm %q", ErrInvalidIDToken, alg)
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type publicKey struct {
+	id        string
+	algorithm string
+	key       interface{}
+}
+
+type keySet struct {
+	keys []publicKey
+}
+
+func (s *jsonWebKeySet) parse() (*keySet, error) {
+	set := &keySet{}
+	for _, jwk := range s.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		switch jwk.KeyType {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err1 != nil || err2 != nil || len(e) > 4 {
+				continue
+			}
+			key = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Curve {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(pub.X, pub.Y) {
+				continue
+			}
+			key = pub
+		default:
+			continue
+		}
+
+		set.keys = append(set.keys, publicKey{id: jwk.KeyID, algorithm: jwk.Algorithm, key: key})
+	}
+
+	if len(set.keys) == 0 {
+		return nil, fmt.Errorf("oidc: provider published no usable signing keys")
+	}
+	return set, nil
+}
+
+// find returns the key with the given ID. A token without a kid is accepted
+// only when the provider publishes a single key.
+func (s *keySet) find(kid, alg string) interface{} {
+	if kid == "" {
+		if len(s.keys) == 1 {
+			return s.keys[0].key
+		}
+		return nil
+	}
+	for _, k := range s.keys {
+		if k.id == kid && (k.algorithm == "" || k.algorithm == alg) {
+			return k.key
+		}
+	}
+	return nil
+}
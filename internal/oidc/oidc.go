@@ -0,0 +1,382 @@
+// Package oidc is a small OpenID Connect relying party: discovery, the
+// authorization code flow with PKCE, and ID token verification.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+	ErrNonceMismatch  = errors.New("oidc: ID token nonce does not match")
+)
+
+// clockSkew is the leeway allowed when checking token timestamps.
+const clockSkew = 2 * time.Minute
+
+// Config describes one OpenID provider and this application's registration
+// with it.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Client talks to a single provider. Discovery metadata and signing keys
+// are fetched on first use and cached.
+type Client struct {
+	config     Config
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	metadata  *providerMetadata
+	keys      *keySet
+	keysFetch time.Time
+}
+
+type providerMetadata struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+func NewClient(config Config) *Client {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Client{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// IDToken holds the verified claims of an ID token.
+type IDToken struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified *bool
+	Name          string
+	Claims        map[string]interface{}
+}
+
+// NewCodeVerifier returns a random PKCE code verifier.
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// NewState returns a random value suitable for the state and nonce
+// parameters.
+func NewState() (string, error) {
+	return randomString(32)
+}
+
+// CodeChallenge derives the S256 PKCE challenge for a verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL builds the URL the browser is sent to for signing in.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.config.ClientID},
+		"redirect_uri":          {c.config.RedirectURL},
+		"scope":                 {strings.Join(c.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token.
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*IDToken, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	useBasicAuth := c.config.ClientSecret != "" && !onlySupportsPost(metadata.TokenEndpointAuthMethods)
+	if !useBasicAuth {
+		form.Set("client_id", c.config.ClientID)
+		if c.config.ClientSecret != "" {
+			form.Set("client_secret", c.config.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasicAuth {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var tokenError struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		json.Unmarshal(body, &tokenError)
+		return nil, fmt.Errorf("oidc: token endpoint returned %d: %s %s", resp.StatusCode, tokenError.Error, tokenError.ErrorDescription)
+	}
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("oidc: decoding token response: %w", err)
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token response has no id_token")
+	}
+
+	return c.Verify(ctx, tokenResponse.IDToken, nonce)
+}
+
+func onlySupportsPost(methods []string) bool {
+	if len(methods) == 0 {
+		return false
+	}
+	for _, method := range methods {
+		if method == "client_secret_basic" {
+			return false
+		}
+	}
+	for _, method := range methods {
+		if method == "client_secret_post" {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the ID token's signature, issuer, audience, lifetime and
+// nonce, and returns its claims.
+func (c *Client) Verify(ctx context.Context, rawIDToken, nonce string) (*IDToken, error) {
+	header, claims, signingInput, signature, err := parseJWT(rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := c.signingKey(ctx, header.KeyID, header.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Algorithm, key, signingInput, signature); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); iss != c.config.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match", ErrInvalidIDToken, iss)
+	}
+	if !hasAudience(claims, c.config.ClientID) {
+		return nil, fmt.Errorf("%w: audience does not include this client", ErrInvalidIDToken)
+	}
+
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok || now.After(exp.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	}
+	if iat, ok := numericDate(claims["iat"]); ok && iat.After(now.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: token was issued in the future", ErrInvalidIDToken)
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	token := &IDToken{
+		Issuer:  c.config.Issuer,
+		Subject: subject,
+		Claims:  claims,
+	}
+	token.Email, _ = claims["email"].(string)
+	token.Name, _ = claims["name"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		token.EmailVerified = &verified
+	case string:
+		// Some providers send the flag as a string.
+		v := verified == "true"
+		token.EmailVerified = &v
+	}
+	return token, nil
+}
+
+func hasAudience(claims map[string]interface{}, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		found := false
+		for _, a := range aud {
+			if s, _ := a.(string); s == clientID {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+		// With several audiences the token must have been issued to us.
+		if len(aud) > 1 {
+			azp, _ := claims["azp"].(string)
+			return azp == clientID
+		}
+		return true
+	}
+	return false
+}
+
+func numericDate(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+	return time.Time{}, false
+}
+
+func (c *Client) discover(ctx context.Context) (*providerMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+
+	var metadata providerMetadata
+	wellKnown := strings.TrimSuffix(c.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(ctx, wellKnown, &metadata); err != nil {
+		return nil, fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+	if metadata.Issuer != c.config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", metadata.Issuer, c.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery document for %q is incomplete", c.config.Issuer)
+	}
+
+	c.metadata = &metadata
+	return c.metadata, nil
+}
+
+// signingKey finds the key for kid, refetching the key set once if it is
+// unknown so that key rotation at the provider is picked up.
+func (c *Client) signingKey(ctx context.Context, kid, alg string) (interface{}, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keys != nil {
+		if key := c.keys.find(kid, alg); key != nil {
+			return key, nil
+		}
+		if time.Since(c.keysFetch) < time.Minute {
+			return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+		}
+	}
+
+	var set jsonWebKeySet
+	if err := c.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc: fetching signing keys failed: %w", err)
+	}
+	keys, err := set.parse()
+	if err != nil {
+		return nil, err
+	}
+	c.keys = keys
+	c.keysFetch = time.Now()
+
+	if key := c.keys.find(kid, alg); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(target)
+}
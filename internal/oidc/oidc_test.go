@@ -0,0 +1,164 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://app.test/login/sso/test/callback"
+
+func newTestClient(t *testing.T) (*Client, *oidctest.Issuer) {
+	issuer := oidctest.NewIssuer("todo-app", "s3cret")
+	t.Cleanup(issuer.Close)
+
+	client := NewClient(Config{
+		Issuer:       issuer.URL,
+		ClientID:     "todo-app",
+		ClientSecret: "s3cret",
+		RedirectURL:  redirectURL,
+	})
+	return client, issuer
+}
+
+// authorize follows the authorization URL and returns the code and state
+// the provider redirected back with.
+func authorize(t *testing.T, client *Client, state, nonce, verifier string) (string, string) {
+	authURL, err := client.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorization request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), redirectURL) {
+		t.Fatalf("unexpected redirect %q", resp.Header.Get("Location"))
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	client, issuer := newTestClient(t)
+	issuer.SetClaims(map[string]interface{}{
+		"sub":            "user-1",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+		"groups":         []string{"managers"},
+	})
+
+	verifier, _ := NewCodeVerifier()
+	code, state := authorize(t, client, "state-1", "nonce-1", verifier)
+	if state != "state-1" {
+		t.Errorf("state = %q, want state-1", state)
+	}
+
+	token, err := client.Exchange(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if token.Subject != "user-1" || token.Email != "jane@example.com" || token.Name != "Jane Doe" {
+		t.Errorf("unexpected token %+v", token)
+	}
+	if token.EmailVerified == nil || !*token.EmailVerified {
+		t.Error("email_verified should be true")
+	}
+	if groups, _ := token.Claims["groups"].([]interface{}); len(groups) != 1 {
+		t.Errorf("groups claim = %v", token.Claims["groups"])
+	}
+
+	// Codes are single use.
+	if _, err := client.Exchange(context.Background(), code, verifier, "nonce-1"); err == nil {
+		t.Error("Exchange should fail when a code is redeemed twice")
+	}
+}
+
+func TestExchangeRejectsWrongVerifierAndNonce(t *testing.T) {
+	client, issuer := newTestClient(t)
+	issuer.SetClaims(map[string]interface{}{"sub": "user-1"})
+
+	verifier, _ := NewCodeVerifier()
+	code, _ := authorize(t, client, "state", "nonce", verifier)
+	if _, err := client.Exchange(context.Background(), code, verifier+"x", "nonce"); err == nil {
+		t.Error("Exchange should fail with the wrong PKCE verifier")
+	}
+
+	code, _ = authorize(t, client, "state", "nonce", verifier)
+	if _, err := client.Exchange(context.Background(), code, verifier, "other-nonce"); !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("Expected ErrNonceMismatch, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	client, issuer := newTestClient(t)
+	now := time.Now()
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   issuer.URL,
+			"aud":   "todo-app",
+			"sub":   "user-1",
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Minute).Unix(),
+			"nonce": "n",
+		}
+	}
+
+	if _, err := client.Verify(context.Background(), issuer.SignToken(valid()), "n"); err != nil {
+		t.Fatalf("Verify rejected a valid token: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(map[string]interface{})
+	}{
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "other-app" }},
+		{"several audiences without azp", func(c map[string]interface{}) { c["aud"] = []string{"todo-app", "other-app"} }},
+		{"expired", func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() }},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = now.Add(time.Hour).Unix() }},
+		{"missing subject", func(c map[string]interface{}) { delete(c, "sub") }},
+	}
+	for _, test := range tests {
+		claims := valid()
+		test.modify(claims)
+		if _, err := client.Verify(context.Background(), issuer.SignToken(claims), "n"); err == nil {
+			t.Errorf("Verify accepted a token with %s", test.name)
+		}
+	}
+
+	// A tampered payload no longer matches the signature.
+	parts := strings.Split(issuer.SignToken(valid()), ".")
+	forged := strings.Split(issuer.SignToken(map[string]interface{}{"sub": "admin"}), ".")
+	if _, err := client.Verify(context.Background(), parts[0]+"."+forged[1]+"."+parts[2], "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("Expected ErrInvalidIDToken for a tampered token, got %v", err)
+	}
+
+	// Unsigned tokens are never accepted.
+	unsigned := "eyJhbGciOiJub25lIn0." + parts[1] + "."
+	if _, err := client.Verify(context.Background(), unsigned, "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("Expected ErrInvalidIDToken for alg none, got %v", err)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	if got := CodeChallenge(verifier); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("CodeChallenge = %s", got)
+	}
+}
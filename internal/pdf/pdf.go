@@ -0,0 +1,316 @@
+// Package pdf streams printable table reports as PDF 1.4. Pages are A4
+// landscape, text is set in the standard Helvetica fonts (so nothing is
+// embedded) and characters outside Windows-1252 are replaced with "?".
+// Each page is written as soon as it is full, so long reports are not held
+// in memory.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrClosed is returned when writing to a finished document.
+var ErrClosed = errors.New("pdf: document is closed")
+
+// Page layout in points (1/72 inch).
+const (
+	pageWidth    = 842.0
+	pageHeight   = 595.0
+	margin       = 36.0
+	fontSize     = 8.0
+	rowHeight    = 14.0
+	titleSize    = 14.0
+	cellPadding  = 3.0
+	footerOffset = 20.0
+)
+
+// Column is one column of a table. Widths are relative: each column gets
+// its share of the printable width.
+type Column struct {
+	Heading string
+	Width   float64
+}
+
+// Table describes the report. Subtitle is printed under the title on the
+// first page, e.g. to record the filters used.
+type Table struct {
+	Title    string
+	Subtitle string
+	Columns  []Column
+}
+
+// Object numbers fixed by the writer; pages start after them.
+const (
+	catalogObject = 1
+	pagesObject   = 2
+	fontObject    = 3
+	boldObject    = 4
+	infoObject    = 5
+	firstFree     = 6
+)
+
+// TableWriter writes a Table one row at a time.
+type TableWriter struct {
+	out     *countingWriter
+	table   Table
+	widths  []float64
+	offsets map[int]int64
+	next    int
+	pages   []int
+
+	page      bytes.Buffer
+	y         float64
+	rowOnPage int
+	closed    bool
+}
+
+// NewTableWriter starts a document and its first page.
+func NewTableWriter(w io.Writer, table Table) (*TableWriter, error) {
+	if len(table.Columns) == 0 {
+		return nil, errors.New("pdf: a table needs at least one column")
+	}
+
+	total := 0.0
+	for _, column := range table.Columns {
+		total += column.Width
+	}
+	widths := make([]float64, len(table.Columns))
+	for i, column := range table.Columns {
+		widths[i] = column.Width / total * (pageWidth - 2*margin)
+	}
+
+	tw := &TableWriter{
+		out:     &countingWriter{w: bufio.NewWriter(w)},
+		table:   table,
+		widths:  widths,
+		offsets: map[int]int64{},
+		next:    firstFree,
+	}
+	tw.out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	tw.writeObject(catalogObject, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObject))
+	tw.writeObject(fontObject, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	tw.writeObject(boldObject, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	tw.writeObject(infoObject, fmt.Sprintf("<< /Title %s /Producer (ASM Tracker) >>", pdfString(table.Title)))
+	tw.startPage()
+	return tw, tw.out.err
+}
+
+// WriteRow adds a row, starting a new page when the current one is full.
+// Values that do not fit their column are shortened with "...".
+func (tw *TableWriter) WriteRow(values ...string) error {
+	if tw.closed {
+		return ErrClosed
+	}
+	if tw.y-rowHeight < margin+footerOffset {
+		tw.finishPage()
+		tw.startPage()
+	}
+
+	if tw.rowOnPage%2 == 1 {
+		fmt.Fprintf(&tw.page, "0.95 g %.2f %.2f %.2f %.2f re f 0 g\n", margin, tw.y-rowHeight, pageWidth-2*margin, rowHeight)
+	}
+	tw.writeCells(values, "F1")
+	tw.rowOnPage++
+	return tw.out.err
+}
+
+// Close writes the last page and the document trailer. It does not close
+// the underlying writer.
+func (tw *TableWriter) Close() error {
+	if tw.closed {
+		return ErrClosed
+	}
+	tw.finishPage()
+	tw.closed = true
+
+	kids := make([]string, len(tw.pages))
+	for i, page := range tw.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", page)
+	}
+	tw.writeObject(pagesObject, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(tw.pages)))
+
+	xref := tw.out.n
+	fmt.Fprintf(tw.out, "xref\n0 %d\n0000000000 65535 f \n", tw.next)
+	for object := 1; object < tw.next; object++ {
+		fmt.Fprintf(tw.out, "%010d 00000 n \n", tw.offsets[object])
+	}
+	fmt.Fprintf(tw.out, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", tw.next, catalogObject, infoObject, xref)
+	if tw.out.err != nil {
+		return tw.out.err
+	}
+	return tw.out.w.Flush()
+}
+
+func (tw *TableWriter) startPage() {
+	tw.page.Reset()
+	tw.rowOnPage = 0
+	tw.y = pageHeight - margin
+
+	if len(tw.pages) == 0 {
+		tw.text(margin, tw.y-titleSize, "F2", titleSize, tw.table.Title)
+		tw.y -= titleSize + 6
+		if tw.table.Subtitle != "" {
+			tw.text(margin, tw.y-fontSize-2, "F1", fontSize, tw.table.Subtitle)
+			tw.y -= fontSize + 6
+		}
+		tw.y -= 6
+	}
+
+	headings := make([]string, len(tw.table.Columns))
+	for i, column := range tw.table.Columns {
+		headings[i] = column.Heading
+	}
+	tw.writeCells(headings, "F2")
+	fmt.Fprintf(&tw.page, "0.5 w %.2f %.2f m %.2f %.2f l S\n", margin, tw.y, pageWidth-margin, tw.y)
+}
+
+func (tw *TableWriter) finishPage() {
+	tw.text(margin, margin, "F1", fontSize, fmt.Sprintf("%s - page %d", tw.table.Title, len(tw.pages)+1))
+
+	content := tw.next
+	page := tw.next + 1
+	tw.next += 2
+	tw.writeObject(content, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", tw.page.Len(), tw.page.String()))
+	tw.writeObject(page, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %g %g] /Contents %d 0 R /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> >>",
+		pagesObject, pageWidth, pageHeight, content, fontObject, boldObject))
+	tw.pages = append(tw.pages, page)
+}
+
+func (tw *TableWriter) writeCells(values []string, font string) {
+	x := margin
+	for i, width := range tw.widths {
+		value := ""
+		if i < len(values) {
+			value = fit(values[i], width-2*cellPadding, fontSize)
+		}
+		tw.text(x+cellPadding, tw.y-rowHeight+4, font, fontSize, value)
+		x += width
+	}
+	tw.y -= rowHeight
+}
+
+func (tw *TableWriter) text(x, y float64, font string, size float64, s string) {
+	if s == "" {
+		return
+	}
+	fmt.Fprintf(&tw.page, "BT /%s %g Tf %.2f %.2f Td %s Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+func (tw *TableWriter) writeObject(number int, body string) {
+	tw.offsets[number] = tw.out.n
+	fmt.Fprintf(tw.out, "%d 0 obj\n%s\nendobj\n", number, body)
+}
+
+// fit shortens s with "..." until it is at most width points wide.
+func fit(s string, width, size float64) string {
+	if textWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		shortened := strings.TrimRight(string(runes), " ") + "..."
+		if textWidth(shortened, size) <= width {
+			return shortened
+		}
+	}
+	return ""
+}
+
+func textWidth(s string, size float64) float64 {
+	units := 0
+	for _, r := range s {
+		if r >= 32 && r < 32+rune(len(helveticaWidths)) {
+			units += helveticaWidths[r-32]
+		} else {
+			units += 556
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// pdfString encodes s as a PDF literal string in Windows-1252.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		c, ok := winAnsi(r)
+		if !ok {
+			c = '?'
+		}
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			if c < 32 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// winAnsi maps r to its Windows-1252 byte.
+func winAnsi(r rune) (byte, bool) {
+	switch {
+	case r < 0x80, r >= 0xa0 && r <= 0xff:
+		return byte(r), true
+	}
+	for i, special := range winAnsiSpecials {
+		if special == r {
+			return byte(0x80 + i), true
+		}
+	}
+	return 0, false
+}
+
+// winAnsiSpecials are the characters at 0x80-0x9f in Windows-1252.
+var winAnsiSpecials = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// helveticaWidths are the Helvetica glyph widths, in thousandths of the
+// font size, of the printable ASCII characters from space to tilde.
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// countingWriter tracks the output offset for the cross-reference table
+// and keeps the first write error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func (c *countingWriter) WriteString(s string) {
+	c.Write([]byte(s))
+}
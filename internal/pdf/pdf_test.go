@@ -0,0 +1,96 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTableWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewTableWriter(&buf, Table{
+		Title:    "Users",
+		Subtitle: "Role: salesperson",
+		Columns:  []Column{{"Name", 2}, {"Email", 3}, {"Sign-ins", 1}},
+	})
+	if err != nil {
+		t.Fatalf("NewTableWriter failed: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := writer.WriteRow(fmt.Sprintf("User (%d)", i), fmt.Sprintf("user%d@example.com", i), strconv.Itoa(i)); err != nil {
+			t.Fatalf("WriteRow failed: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := writer.WriteRow("late"); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+
+	doc := buf.String()
+	if !strings.HasPrefix(doc, "%PDF-1.4\n") || !strings.HasSuffix(doc, "%%EOF\n") {
+		t.Fatal("Expected a PDF header and trailer")
+	}
+	if !strings.Contains(doc, `(User \(42\)) Tj`) {
+		t.Error("Parentheses in text should be escaped")
+	}
+
+	pages := regexp.MustCompile(`/Count (\d+)`).FindStringSubmatch(doc)
+	if pages == nil || pages[1] == "1" {
+		t.Errorf("Expected 100 rows to span several pages, got %v", pages)
+	}
+
+	// Every cross-reference entry points at its object.
+	start, err := strconv.Atoi(regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(doc)[1])
+	if err != nil || !strings.HasPrefix(doc[start:], "xref\n") {
+		t.Fatalf("startxref does not point at the xref table")
+	}
+	lines := strings.Split(doc[start:], "\n")
+	count, _ := strconv.Atoi(strings.Fields(lines[1])[1])
+	for object := 1; object < count; object++ {
+		offset, _ := strconv.Atoi(strings.Fields(lines[2+object])[0])
+		if want := fmt.Sprintf("%d 0 obj\n", object); !strings.HasPrefix(doc[offset:], want) {
+			t.Errorf("Object %d: offset %d does not start %q", object, offset, want)
+		}
+	}
+
+	// Stream lengths match their content.
+	for _, match := range regexp.MustCompile(`(?s)<< /Length (\d+) >>\nstream\n(.*?)endstream`).FindAllStringSubmatch(doc, -1) {
+		if length, _ := strconv.Atoi(match[1]); length != len(match[2]) {
+			t.Errorf("Stream length %d, content is %d bytes", length, len(match[2]))
+		}
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := map[string]string{
+		"plain":     "(plain)",
+		`a\b(c)`:    `(a\\b\(c\))`,
+		"café – ok": "(caf\xe9 \x96 ok)",
+		"日本":        "(??)",
+		"tab\there": "(tab here)",
+	}
+	for input, want := range tests {
+		if got := pdfString(input); got != want {
+			t.Errorf("pdfString(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	if got := fit("Short", 100, fontSize); got != "Short" {
+		t.Errorf("Text that fits should be kept, got %q", got)
+	}
+	long := strings.Repeat("W", 50)
+	got := fit(long, 60, fontSize)
+	if !strings.HasSuffix(got, "...") || textWidth(got, fontSize) > 60 {
+		t.Errorf("Expected text shortened to 60pt, got %q (%.1fpt)", got, textWidth(got, fontSize))
+	}
+	if got := fit(long, 1, fontSize); got != "" {
+		t.Errorf("Nothing should fit in 1pt, got %q", got)
+	}
+}
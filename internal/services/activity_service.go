@@ -73,6 +73,89 @@ func (s *ActivityService) LogPasswordChange(user *models.User, ipAddress, userAg
 	return s.LogActivity(&user.ID, "password_change", ipAddress, userAgent, metadata)
 }
 
+func (s *ActivityService) LogAccountLocked(user *models.User, lockedUntil time.Time, ipAddress, userAgent string) error {
+	metadata := map[string]interface{}{
+		"user_id":         user.ID,
+		"user_name":       user.Name,
+		"failed_attempts": user.FailedLoginAttempts,
+		"locked_until":    lockedUntil,
+	}
+	return s.LogActivity(&user.ID, "account_locked", ipAddress, userAgent, metadata)
+}
+
+func (s *ActivityService) LogTwoFactorEvent(user *models.User, activityType, stage, ipAddress, userAgent string) error {
+	metadata := map[string]interface{}{
+		"user_id":   user.ID,
+		"user_name": user.Name,
+		"stage":     stage,
+	}
+	return s.LogActivity(&user.ID, activityType, ipAddress, userAgent, metadata)
+}
+
+// LogSessionsRevoked records sessions being ended on purpose, by the user
+// themselves or by an admin.
+func (s *ActivityService) LogSessionsRevoked(performingUser *models.User, targetUser *models.User, count int64, scope, ipAddress, userAgent string) error {
+	metadata := map[string]interface{}{
+		"performing_user_id":   performingUser.ID,
+		"performing_user_name": performingUser.Name,
+		"target_user_id":       targetUser.ID,
+		"target_user_name":     targetUser.Name,
+		"sessions":             count,
+		"scope":                scope,
+	}
+	return s.LogActivity(&performingUser.ID, "sessions_revoked", ipAddress, userAgent, metadata)
+}
+
+func (s *ActivityService) LogAPITokenEvent(user *models.User, apiToken *models.APIToken, activityType, ipAddress, userAgent string) error {
+	metadata := map[string]interface{}{
+		"user_id":        user.ID,
+		"api_token_id":   apiToken.ID,
+		"api_token_name": apiToken.Name,
+		"scopes":         apiToken.Scopes,
+	}
+	return s.LogActivity(&user.ID, activityType, ipAddress, userAgent, metadata)
+}
+
+// LogAPIRequest records a request that was authenticated with a personal
+// access token.
+func (s *ActivityService) LogAPIRequest(user *models.User, apiToken *models.APIToken, method, path string, status int, ipAddress, userAgent string) error {
+	metadata := map[string]interface{}{
+		"api_token_id":   apiToken.ID,
+		"api_token_name": apiToken.Name,
+		"method":         method,
+		"path":           path,
+		"status":         status,
+	}
+	return s.LogActivity(&user.ID, "api_request", ipAddress, userAgent, metadata)
+}
+
+// LogImpersonation records an admin starting or ending a session as another
+// user. The activity belongs to the admin.
+func (s *ActivityService) LogImpersonation(impersonator, target *models.User, activityType, ipAddress, userAgent string) error {
+	metadata := map[string]interface{}{
+		"impersonator_id":   impersonator.ID,
+		"impersonator_name": impersonator.Name,
+		"user_id":           target.ID,
+		"user_name":         target.Name,
+	}
+	return s.LogActivity(&impersonator.ID, activityType, ipAddress, userAgent, metadata)
+}
+
+// LogImpersonatedRequest records a request an admin made while logged in as
+// another user.
+func (s *ActivityService) LogImpersonatedRequest(user, impersonator *models.User, method, path string, status int, ipAddress, userAgent string) error {
+	metadata := map[string]interface{}{
+		"impersonator_id":   impersonator.ID,
+		"impersonator_name": impersonator.Name,
+		"user_id":           user.ID,
+		"user_name":         user.Name,
+		"method":            method,
+		"path":              path,
+		"status":            status,
+	}
+	return s.LogActivity(&impersonator.ID, "impersonated_request", ipAddress, userAgent, metadata)
+}
+
 func (s *ActivityService) LogPageView(user *models.User, page, ipAddress, userAgent string) error {
 	metadata := map[string]interface{}{
 		"page":      page,
@@ -105,9 +188,41 @@ func (s *ActivityService) GetUserActivities(userID uint, limit int) ([]models.Us
 	return activities, err
 }
 
+// CountUserActivities returns how many activities are recorded for the user.
+func (s *ActivityService) CountUserActivities(userID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.UserActivity{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
 func (s *ActivityService) GetAllActivities(limit int) ([]models.UserActivity, error) {
 	var activities []models.UserActivity
-	query := s.db.Preload("User").Order("performed_at DESC")
+	query := s.db.Preload("User", withArchivedUsers).Order("performed_at DESC")
+	
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	
+	err := query.Find(&activities).Error
+	return activities, err
+}
+
+// InCompanyScope reports whether the user, deleted or not, is within the
+// scope.
+func (s *ActivityService) InCompanyScope(userID uint, scope models.CompanyScope) (bool, error) {
+	var count int64
+	err := scopeToCompany(s.db.Table("users").Where("id = ?", userID), "company_id", scope).Count(&count).Error
+	return count > 0, err
+}
+
+// GetCompanyActivities returns the latest activities of the users in the
+// scope, including deleted ones.
+func (s *ActivityService) GetCompanyActivities(scope models.CompanyScope, limit int) ([]models.UserActivity, error) {
+	var activities []models.UserActivity
+	query := s.db.Preload("User", withArchivedUsers).Order("performed_at DESC")
+	if !scope.All {
+		query = query.Where("user_id IN (?)", scopeToCompany(s.db.Table("users").Select("id"), "company_id", scope))
+	}
 	
 	if limit > 0 {
 		query = query.Limit(limit)
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidAPIToken      = errors.New("API token is invalid or has expired")
+	ErrAPITokenNameRequired = errors.New("token name is required")
+	ErrAPITokenScopeInvalid = errors.New("choose at least one valid scope")
+)
+
+// apiTokenTouchInterval limits how often last-used details are written, so a
+// busy script does not turn every request into a database write.
+const apiTokenTouchInterval = time.Minute
+
+type APITokenService struct {
+	db              *gorm.DB
+	activityService *ActivityService
+}
+
+func NewAPITokenService(db *gorm.DB, activityService *ActivityService) *APITokenService {
+	return &APITokenService{
+		db:              db,
+		activityService: activityService,
+	}
+}
+
+// CreateToken issues a personal access token for the user. The returned
+// token is not stored anywhere and cannot be shown again.
+func (s *APITokenService) CreateToken(user *models.User, name string, scopes []string, expiresAt *time.Time, ipAddress, userAgent string) (*models.APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, "", ErrAPITokenNameRequired
+	}
+
+	seen := make(map[string]bool)
+	var granted []string
+	for _, scope := range scopes {
+		if !models.IsValidAPITokenScope(scope) {
+			return nil, "", ErrAPITokenScopeInvalid
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			granted = append(granted, scope)
+		}
+	}
+	if len(granted) == 0 {
+		return nil, "", ErrAPITokenScopeInvalid
+	}
+
+	secret, err := models.GenerateSecureToken()
+	if err != nil {
+		return nil, "", err
+	}
+	token := models.APITokenPrefix + secret
+
+	apiToken := &models.APIToken{
+		UserID:      user.ID,
+		Name:        name,
+		Prefix:      token[:len(models.APITokenPrefix)+8],
+		TokenDigest: models.HashToken(token),
+		Scopes:      strings.Join(granted, ","),
+		ExpiresAt:   expiresAt,
+	}
+	if err := s.db.Create(apiToken).Error; err != nil {
+		return nil, "", err
+	}
+
+	s.activityService.LogAPITokenEvent(user, apiToken, "api_token_created", ipAddress, userAgent)
+
+	return apiToken, token, nil
+}
+
+func (s *APITokenService) GetUserTokens(userID uint) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeToken deletes one of the user's tokens. It returns
+// gorm.ErrRecordNotFound if the token does not belong to the user.
+func (s *APITokenService) RevokeToken(user *models.User, tokenID uint, ipAddress, userAgent string) error {
+	var apiToken models.APIToken
+	if err := s.db.Where("id = ? AND user_id = ?", tokenID, user.ID).First(&apiToken).Error; err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(&apiToken).Error; err != nil {
+		return err
+	}
+
+	s.activityService.LogAPITokenEvent(user, &apiToken, "api_token_revoked", ipAddress, userAgent)
+	return nil
+}
+
+// Authenticate resolves a bearer token to its owner and records when and
+// from where it was last used.
+func (s *APITokenService) Authenticate(token, ipAddress string) (*models.User, *models.APIToken, error) {
+	if !IsAPIToken(token) {
+		return nil, nil, ErrInvalidAPIToken
+	}
+
+	var apiToken models.APIToken
+	if err := s.db.Preload("User").Where("token_digest = ?", models.HashToken(token)).First(&apiToken).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, ErrInvalidAPIToken
+		}
+		return nil, nil, err
+	}
+
+	if apiToken.IsExpired() || !apiToken.User.Enabled {
+		return nil, nil, ErrInvalidAPIToken
+	}
+
+	now := time.Now()
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= apiTokenTouchInterval || apiToken.LastUsedIP != ipAddress {
+		s.db.Model(&models.APIToken{}).Where("id = ?", apiToken.ID).UpdateColumns(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ipAddress,
+		})
+		apiToken.LastUsedAt = &now
+		apiToken.LastUsedIP = ipAddress
+	}
+
+	user := apiToken.User
+	return &user, &apiToken, nil
+}
+
+// IsAPIToken reports whether a bearer token is a personal access token
+// rather than a session token.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, models.APITokenPrefix)
+}
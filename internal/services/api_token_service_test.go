@@ -0,0 +1,91 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestAPITokenService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	apiTokenService := NewAPITokenService(db, activityService)
+	
+	user := &models.User{Email: "test@example.com", Name: "Test User", Role: models.RoleManager, Enabled: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	if _, _, err := apiTokenService.CreateToken(user, "export", []string{"users:admin"}, nil, "127.0.0.1", "test-agent"); err != ErrAPITokenScopeInvalid {
+		t.Errorf("Expected ErrAPITokenScopeInvalid for an unknown scope, got %v", err)
+	}
+	if _, _, err := apiTokenService.CreateToken(user, " ", []string{models.ScopeUsersRead}, nil, "127.0.0.1", "test-agent"); err != ErrAPITokenNameRequired {
+		t.Errorf("Expected ErrAPITokenNameRequired for a blank name, got %v", err)
+	}
+	
+	apiToken, token, err := apiTokenService.CreateToken(user, "export", []string{models.ScopeUsersRead}, nil, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	
+	if !IsAPIToken(token) || !strings.HasPrefix(token, apiToken.Prefix) {
+		t.Errorf("Token %q should start with the API token prefix and its display prefix %q", token, apiToken.Prefix)
+	}
+	
+	var stored models.APIToken
+	db.First(&stored, apiToken.ID)
+	if stored.TokenDigest != models.HashToken(token) {
+		t.Error("Only the token digest should be stored")
+	}
+	
+	authUser, authToken, err := apiTokenService.Authenticate(token, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if authUser.ID != user.ID {
+		t.Errorf("Expected token to belong to user %d, got %d", user.ID, authUser.ID)
+	}
+	if !authToken.HasScope(models.ScopeUsersRead) || authToken.HasScope(models.ScopeUsersWrite) {
+		t.Errorf("Unexpected scopes %q", authToken.Scopes)
+	}
+	
+	db.First(&stored, apiToken.ID)
+	if stored.LastUsedAt == nil || stored.LastUsedIP != "10.0.0.1" {
+		t.Error("Authenticate should record when and where the token was last used")
+	}
+	
+	if _, _, err := apiTokenService.Authenticate(token+"0", "10.0.0.1"); err != ErrInvalidAPIToken {
+		t.Errorf("Expected ErrInvalidAPIToken for a wrong token, got %v", err)
+	}
+	
+	// Expired tokens are rejected.
+	expiresAt := time.Now().Add(-time.Minute)
+	_, expiredToken, _ := apiTokenService.CreateToken(user, "old", []string{models.ScopeUsersRead}, &expiresAt, "127.0.0.1", "test-agent")
+	if _, _, err := apiTokenService.Authenticate(expiredToken, "10.0.0.1"); err != ErrInvalidAPIToken {
+		t.Errorf("Expected ErrInvalidAPIToken for an expired token, got %v", err)
+	}
+	
+	// Tokens stop working while their owner is disabled.
+	db.Model(user).Update("enabled", false)
+	if _, _, err := apiTokenService.Authenticate(token, "10.0.0.1"); err != ErrInvalidAPIToken {
+		t.Errorf("Expected ErrInvalidAPIToken for a disabled user, got %v", err)
+	}
+	db.Model(user).Update("enabled", true)
+	
+	other := &models.User{Email: "other@example.com", Name: "Other User", Role: models.RoleSalesperson, Enabled: true}
+	db.Create(other)
+	if err := apiTokenService.RevokeToken(other, apiToken.ID, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("Revoking another user's token should fail with ErrRecordNotFound, got %v", err)
+	}
+	
+	if err := apiTokenService.RevokeToken(user, apiToken.ID, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	if _, _, err := apiTokenService.Authenticate(token, "10.0.0.1"); err != ErrInvalidAPIToken {
+		t.Errorf("Expected ErrInvalidAPIToken for a revoked token, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"alsafwanmarine.com/todo-app/internal/models"
 	"gorm.io/gorm"
@@ -12,31 +13,65 @@ type AuthService struct {
 	db *gorm.DB
 	sessionService *SessionService
 	activityService *ActivityService
+	twoFactorService *TwoFactorService
+	lockoutService *LockoutService
+	passwordPolicyService *PasswordPolicyService
+	securityAlertService *SecurityAlertService
+	ipRuleService *IPRuleService
 }
 
-func NewAuthService(db *gorm.DB, sessionService *SessionService, activityService *ActivityService) *AuthService {
+func NewAuthService(db *gorm.DB, sessionService *SessionService, activityService *ActivityService, twoFactorService *TwoFactorService, lockoutService *LockoutService, passwordPolicyService *PasswordPolicyService, securityAlertService *SecurityAlertService, ipRuleService *IPRuleService) *AuthService {
 	return &AuthService{
 		db:             db,
 		sessionService: sessionService,
 		activityService: activityService,
+		twoFactorService: twoFactorService,
+		lockoutService: lockoutService,
+		passwordPolicyService: passwordPolicyService,
+		securityAlertService: securityAlertService,
+		ipRuleService: ipRuleService,
 	}
 }
 
 type LoginCredentials struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+	Remember bool   `json:"remember"`
 }
 
 type LoginResult struct {
 	User    *models.User    `json:"user"`
 	Session *models.Session `json:"session"`
 	Token   string         `json:"token"`
+	Remember bool          `json:"remember"`
+	// RememberToken is the new "remember me" cookie value, if one was
+	// issued or rotated.
+	RememberToken string   `json:"-"`
+	
+	// TwoFactorRequired is set when the password was correct but the user
+	// still has to pass the TOTP step. No session exists yet; the caller
+	// completes the login with ChallengeToken.
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+	
+	// PasswordChangeRequired means the session is restricted to changing
+	// the password, either because it expired or an admin issued a
+	// temporary one.
+	PasswordChangeRequired bool `json:"password_change_required"`
 }
 
 var (
 	ErrInvalidCredentials = errors.New("invalid email or password")
 	ErrUserDisabled      = errors.New("user account is disabled")
 	ErrRateLimited       = errors.New("too many login attempts")
+	ErrTwoFactorChallengeInvalid = errors.New("two-factor challenge is invalid or has expired")
+	ErrPermissionDenied  = errors.New("insufficient permissions")
+	ErrImpersonating     = errors.New("not allowed while logged in as another user")
+)
+
+const (
+	twoFactorChallengeTTL = 5 * time.Minute
+	maxTwoFactorAttempts  = 5
 )
 
 func normalizeEmail(email string) string {
@@ -58,34 +93,225 @@ func (s *AuthService) Login(credentials LoginCredentials, ipAddress, userAgent s
 		return nil, ErrInvalidCredentials
 	}
 	
+	if err := s.lockoutService.CheckLocked(&user); err != nil {
+		s.activityService.LogFailedLogin(&user.ID, credentials.Email, ipAddress, userAgent)
+		return nil, err
+	}
+	
 	if !user.CheckPassword(credentials.Password) {
 		s.activityService.LogFailedLogin(&user.ID, credentials.Email, ipAddress, userAgent)
+		if err := s.lockoutService.RegisterFailure(&user, ipAddress, userAgent); err != nil {
+			return nil, err
+		}
+		if err := s.lockoutService.CheckLocked(&user); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidCredentials
 	}
 	
-	if user.IsPasswordExpired() {
-		return nil, errors.New("password has expired")
+	if user.TOTPEnabled {
+		return s.createTwoFactorChallenge(&user, credentials.Remember, ipAddress, userAgent)
+	}
+	
+	return s.completeLogin(&user, credentials.Remember, ipAddress, userAgent)
+}
+
+// LoginWithIdentity signs in a user whose identity was already proven by an
+// external provider. Password lockouts do not apply, but the TOTP step does.
+func (s *AuthService) LoginWithIdentity(user *models.User, ipAddress, userAgent string) (*LoginResult, error) {
+	if !user.Enabled {
+		s.activityService.LogFailedLogin(&user.ID, user.Email, ipAddress, userAgent)
+		return nil, ErrUserDisabled
+	}
+	
+	if user.TOTPEnabled {
+		return s.createTwoFactorChallenge(user, false, ipAddress, userAgent)
+	}
+	
+	return s.completeLogin(user, false, ipAddress, userAgent)
+}
+
+// LoginWithPasskey signs in a user who proved possession of a registered
+// passkey with user verification, which already counts as two factors.
+func (s *AuthService) LoginWithPasskey(user *models.User, ipAddress, userAgent string) (*LoginResult, error) {
+	if !user.Enabled {
+		s.activityService.LogFailedLogin(&user.ID, user.Email, ipAddress, userAgent)
+		return nil, ErrUserDisabled
+	}
+	
+	return s.completeLogin(user, false, ipAddress, userAgent)
+}
+
+// CompleteTwoFactorLogin finishes a login that was paused for the TOTP step.
+// The challenge is discarded after too many wrong codes, sending the user
+// back to the password step.
+func (s *AuthService) CompleteTwoFactorLogin(challengeToken, code, ipAddress, userAgent string) (*LoginResult, error) {
+	var challenge models.TwoFactorChallenge
+	if err := s.db.Where("token_digest = ?", models.HashToken(challengeToken)).First(&challenge).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrTwoFactorChallengeInvalid
+		}
+		return nil, err
+	}
+	
+	if challenge.IsExpired() {
+		s.db.Delete(&challenge)
+		return nil, ErrTwoFactorChallengeInvalid
+	}
+	
+	var user models.User
+	if err := s.db.First(&user, challenge.UserID).Error; err != nil {
+		return nil, err
+	}
+	
+	if !user.Enabled {
+		s.db.Delete(&challenge)
+		return nil, ErrInvalidCredentials
+	}
+	
+	if err := s.lockoutService.CheckLocked(&user); err != nil {
+		s.db.Delete(&challenge)
+		return nil, err
+	}
+	
+	if !s.twoFactorService.VerifyCode(&user, code) {
+		s.activityService.LogTwoFactorEvent(&user, "two_factor_failed", "login", ipAddress, userAgent)
+		if err := s.lockoutService.RegisterFailure(&user, ipAddress, userAgent); err != nil {
+			return nil, err
+		}
+		if err := s.lockoutService.CheckLocked(&user); err != nil {
+			s.db.Delete(&challenge)
+			return nil, err
+		}
+		
+		challenge.Attempts++
+		if challenge.Attempts >= maxTwoFactorAttempts {
+			s.db.Delete(&challenge)
+			return nil, ErrTwoFactorChallengeInvalid
+		}
+		s.db.Save(&challenge)
+		return nil, ErrInvalidTwoFactorCode
+	}
+	
+	s.db.Delete(&challenge)
+	s.activityService.LogTwoFactorEvent(&user, "two_factor_verify", "login", ipAddress, userAgent)
+	
+	return s.completeLogin(&user, challenge.Remember, ipAddress, userAgent)
+}
+
+func (s *AuthService) createTwoFactorChallenge(user *models.User, remember bool, ipAddress, userAgent string) (*LoginResult, error) {
+	// Refuse the network before asking for a code; completeLogin checks
+	// again in case the second step comes from elsewhere.
+	if err := s.ipRuleService.CheckAccess(user, ipAddress, userAgent, "password"); err != nil {
+		return nil, err
+	}
+	
+	token, err := models.GenerateSecureToken()
+	if err != nil {
+		return nil, err
+	}
+	
+	challenge := &models.TwoFactorChallenge{
+		UserID:      user.ID,
+		TokenDigest: models.HashToken(token),
+		Remember:    remember,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		ExpiresAt: time.Now().Add(twoFactorChallengeTTL),
+	}
+	
+	if err := s.db.Create(challenge).Error; err != nil {
+		return nil, err
+	}
+	
+	return &LoginResult{
+		User:              user,
+		Remember:          remember,
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+	}, nil
+}
+
+func (s *AuthService) completeLogin(user *models.User, remember bool, ipAddress, userAgent string) (*LoginResult, error) {
+	if err := s.ipRuleService.CheckAccess(user, ipAddress, userAgent, "login"); err != nil {
+		return nil, err
 	}
 	
+	if err := s.lockoutService.Reset(user); err != nil {
+		return nil, err
+	}
 	user.UpdateSignInInfo()
-	if err := s.db.Save(&user).Error; err != nil {
+	if err := s.db.Save(user).Error; err != nil {
 		return nil, err
 	}
 	
-	session, token, err := s.sessionService.CreateSession(&user, ipAddress, userAgent)
+	var (
+		session       *models.Session
+		token         string
+		rememberToken string
+		err           error
+	)
+	if remember {
+		session, token, rememberToken, err = s.sessionService.CreateRememberedSession(user, ipAddress, userAgent)
+	} else {
+		session, token, err = s.sessionService.CreateSession(user, ipAddress, userAgent)
+	}
 	if err != nil {
 		return nil, err
 	}
 	
-	s.activityService.LogLogin(&user, ipAddress, userAgent)
+	s.securityAlertService.CheckLogin(user, session, ipAddress, userAgent)
+	s.activityService.LogLogin(user, ipAddress, userAgent)
 	
 	return &LoginResult{
-		User:    &user,
-		Session: session,
-		Token:   token,
+		User:     user,
+		Session:  session,
+		Token:    token,
+		Remember: remember,
+		RememberToken: rememberToken,
+		PasswordChangeRequired: user.NeedsPasswordChange(),
 	}, nil
 }
 
+// ResumeSession signs a remembered device back in once its session has
+// timed out. A replayed remember token revokes the series and is logged as
+// a possible cookie theft.
+func (s *AuthService) ResumeSession(rememberCookie, ipAddress, userAgent string) (*LoginResult, error) {
+	session, token, rememberToken, err := s.sessionService.ResumeRememberedSession(rememberCookie, ipAddress, userAgent)
+	if err != nil {
+		var reused *RememberTokenReusedError
+		if errors.As(err, &reused) {
+			s.activityService.LogActivity(&reused.UserID, "remember_token_reused", ipAddress, userAgent, map[string]interface{}{
+				"user_id": reused.UserID,
+			})
+		}
+		return nil, err
+	}
+	
+	var user models.User
+	if err := s.db.First(&user, session.UserID).Error; err != nil {
+		return nil, err
+	}
+	
+	if !user.Enabled {
+		s.sessionService.DestroySession(token)
+		return nil, ErrUserDisabled
+	}
+	
+	return &LoginResult{
+		User:          &user,
+		Session:       session,
+		Token:         token,
+		Remember:      true,
+		RememberToken: rememberToken,
+		PasswordChangeRequired: user.NeedsPasswordChange(),
+	}, nil
+}
+
+func (s *AuthService) CleanupExpiredChallenges() error {
+	return s.db.Where("expires_at < ?", time.Now()).Delete(&models.TwoFactorChallenge{}).Error
+}
+
 func (s *AuthService) Logout(sessionToken string, ipAddress, userAgent string) error {
 	session, err := s.sessionService.GetSessionByToken(sessionToken)
 	if err != nil {
@@ -111,29 +337,43 @@ func (s *AuthService) Logout(sessionToken string, ipAddress, userAgent string) e
 }
 
 func (s *AuthService) GetCurrentUser(sessionToken string) (*models.User, error) {
+	user, _, err := s.GetCurrentSession(sessionToken)
+	return user, err
+}
+
+// GetCurrentSession is GetCurrentUser for callers that also need the
+// session, for example to tell whether it is impersonated.
+func (s *AuthService) GetCurrentSession(sessionToken string) (*models.User, *models.Session, error) {
 	session, err := s.sessionService.GetSessionByToken(sessionToken)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	
 	if session == nil || session.IsExpired() {
-		return nil, errors.New("invalid or expired session")
+		return nil, nil, errors.New("invalid or expired session")
 	}
 	
 	var user models.User
-	if err := s.db.First(&user, session.UserID).Error; err != nil {
-		return nil, err
+	if err := s.db.Preload("Company").First(&user, session.UserID).Error; err != nil {
+		return nil, nil, err
 	}
 	
 	if !user.Enabled {
 		s.sessionService.DestroySession(sessionToken)
-		return nil, errors.New("user account is disabled")
+		return nil, nil, errors.New("user account is disabled")
+	}
+	
+	// Sessions from before CSRF tokens were bound to them get one now.
+	if session.CSRFToken == "" {
+		if session.CSRFToken, err = models.GenerateSecureToken(); err != nil {
+			return nil, nil, err
+		}
 	}
 	
 	session.Extend()
 	s.db.Save(session)
 	
-	return &user, nil
+	return &user, session, nil
 }
 
 func (s *AuthService) IsAuthenticated(sessionToken string) bool {
@@ -145,33 +385,30 @@ func (s *AuthService) RequireAuth(sessionToken string) (*models.User, error) {
 	return s.GetCurrentUser(sessionToken)
 }
 
-func (s *AuthService) RequireRole(sessionToken string, requiredRole models.UserRole) (*models.User, error) {
+func (s *AuthService) RequirePermission(sessionToken, permission string) (*models.User, error) {
 	user, err := s.RequireAuth(sessionToken)
 	if err != nil {
 		return nil, err
 	}
 	
-	if user.Role != requiredRole {
-		return nil, errors.New("insufficient permissions")
+	if !user.HasPermission(permission) {
+		return nil, ErrPermissionDenied
 	}
 	
 	return user, nil
 }
 
-func (s *AuthService) RequireRoleOrHigher(sessionToken string, minRole models.UserRole) (*models.User, error) {
-	user, err := s.RequireAuth(sessionToken)
+// ChangePassword updates the user's own password and signs them out of
+// every session except the one identified by sessionToken.
+func (s *AuthService) ChangePassword(userID uint, currentPassword, newPassword, sessionToken string) error {
+	session, err := s.sessionService.GetSessionByToken(sessionToken)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	
-	if int(user.Role) > int(minRole) {
-		return nil, errors.New("insufficient permissions")
+	if session != nil && session.ImpersonatorID != nil {
+		return ErrImpersonating
 	}
 	
-	return user, nil
-}
-
-func (s *AuthService) ChangePassword(userID uint, currentPassword, newPassword string) error {
 	var user models.User
 	if err := s.db.First(&user, userID).Error; err != nil {
 		return err
@@ -181,15 +418,11 @@ func (s *AuthService) ChangePassword(userID uint, currentPassword, newPassword s
 		return errors.New("current password is incorrect")
 	}
 	
-	if err := models.ValidatePassword(newPassword); err != nil {
-		return err
-	}
-	
-	if err := user.SetPassword(newPassword); err != nil {
+	if err := s.passwordPolicyService.UpdatePassword(&user, newPassword); err != nil {
 		return err
 	}
 	
-	if err := s.db.Save(&user).Error; err != nil {
+	if _, err := s.sessionService.DestroyOtherUserSessions(user.ID, sessionToken); err != nil {
 		return err
 	}
 	
@@ -1,15 +1,21 @@
 package services
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"alsafwanmarine.com/todo-app/internal/mailer"
 	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/totp"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// testAppKey stands in for the app key loaded by config.LoadAppKey.
+var testAppKey = []byte("test-app-key-0123456789abcdefghij")
+
 func setupTestDB(t *testing.T) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
@@ -19,10 +25,27 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	}
 	
 	err = db.AutoMigrate(
+		&models.Company{},
+		&models.CompanyLogo{},
 		&models.User{},
 		&models.Session{},
 		&models.UserActivity{},
 		&models.PasswordResetEvent{},
+		&models.TwoFactorChallenge{},
+		&models.PasswordHistory{},
+		&models.RememberToken{},
+		&models.APIToken{},
+		&models.UserIdentity{},
+		&models.SSOLoginState{},
+		&models.Passkey{},
+		&models.PasskeyChallenge{},
+		&models.RolePermission{},
+		&models.KnownPermission{},
+		&models.PermissionsRevision{},
+		&models.RateLimitCounter{},
+		&models.SecurityAlert{},
+		&models.Invitation{},
+		&models.IPRule{},
 	)
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
@@ -36,7 +59,10 @@ func TestAuthServiceLogin(t *testing.T) {
 	
 	sessionService := NewSessionService(db)
 	activityService := NewActivityService(db)
-	authService := NewAuthService(db, sessionService, activityService)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
 	
 	user := &models.User{
 		Email:   "test@example.com",
@@ -44,7 +70,7 @@ func TestAuthServiceLogin(t *testing.T) {
 		Role:    models.RoleSalesperson,
 		Enabled: true,
 	}
-	user.SetPassword("testpassword123")
+	user.SetPassword("Secur3!Passw0rd")
 	
 	if err := db.Create(user).Error; err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
@@ -52,7 +78,7 @@ func TestAuthServiceLogin(t *testing.T) {
 	
 	credentials := LoginCredentials{
 		Email:    "test@example.com",
-		Password: "testpassword123",
+		Password: "Secur3!Passw0rd",
 	}
 	
 	result, err := authService.Login(credentials, "127.0.0.1", "test-agent")
@@ -78,7 +104,10 @@ func TestAuthServiceLoginInvalidCredentials(t *testing.T) {
 	
 	sessionService := NewSessionService(db)
 	activityService := NewActivityService(db)
-	authService := NewAuthService(db, sessionService, activityService)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
 	
 	credentials := LoginCredentials{
 		Email:    "nonexistent@example.com",
@@ -96,7 +125,10 @@ func TestAuthServiceLoginDisabledUser(t *testing.T) {
 	
 	sessionService := NewSessionService(db)
 	activityService := NewActivityService(db)
-	authService := NewAuthService(db, sessionService, activityService)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
 	
 	user := &models.User{
 		Email:   "disabled@example.com",
@@ -104,7 +136,7 @@ func TestAuthServiceLoginDisabledUser(t *testing.T) {
 		Role:    models.RoleSalesperson,
 		Enabled: true, // Create as enabled first
 	}
-	user.SetPassword("testpassword123")
+	user.SetPassword("Secur3!Passw0rd")
 	
 	if err := db.Create(user).Error; err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
@@ -118,7 +150,7 @@ func TestAuthServiceLoginDisabledUser(t *testing.T) {
 	
 	credentials := LoginCredentials{
 		Email:    "disabled@example.com",
-		Password: "testpassword123",
+		Password: "Secur3!Passw0rd",
 	}
 	
 	result, err := authService.Login(credentials, "127.0.0.1", "test-agent")
@@ -135,7 +167,10 @@ func TestAuthServiceGetCurrentUser(t *testing.T) {
 	
 	sessionService := NewSessionService(db)
 	activityService := NewActivityService(db)
-	authService := NewAuthService(db, sessionService, activityService)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
 	
 	user := &models.User{
 		Email:   "test@example.com",
@@ -153,6 +188,10 @@ func TestAuthServiceGetCurrentUser(t *testing.T) {
 		t.Fatalf("Failed to create session: %v", err)
 	}
 	
+	if session.TokenDigest == token || session.TokenDigest != models.HashToken(token) {
+		t.Error("Session should store the token's digest, not the token")
+	}
+	
 	currentUser, err := authService.GetCurrentUser(token)
 	if err != nil {
 		t.Fatalf("GetCurrentUser failed: %v", err)
@@ -168,6 +207,22 @@ func TestAuthServiceGetCurrentUser(t *testing.T) {
 	if updatedSession.ExpiresAt.Before(time.Now().Add(25 * time.Minute)) {
 		t.Error("Session should have been extended")
 	}
+	
+	other, _, _ := sessionService.CreateSession(user, "127.0.0.1", "test-agent")
+	if session.CSRFToken == "" || session.CSRFToken == other.CSRFToken {
+		t.Error("Each session should get its own CSRF token")
+	}
+	
+	// Sessions created before CSRF tokens existed get one on their next use.
+	db.Model(&models.Session{}).Where("id = ?", session.ID).Update("csrf_token", "")
+	_, current, err := authService.GetCurrentSession(token)
+	if err != nil {
+		t.Fatalf("GetCurrentSession failed: %v", err)
+	}
+	db.First(&updatedSession, session.ID)
+	if current.CSRFToken == "" || updatedSession.CSRFToken != current.CSRFToken {
+		t.Error("A missing CSRF token should be generated and stored")
+	}
 }
 
 func TestAuthServiceLogout(t *testing.T) {
@@ -175,7 +230,10 @@ func TestAuthServiceLogout(t *testing.T) {
 	
 	sessionService := NewSessionService(db)
 	activityService := NewActivityService(db)
-	authService := NewAuthService(db, sessionService, activityService)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
 	
 	user := &models.User{
 		Email:   "test@example.com",
@@ -209,7 +267,10 @@ func TestAuthServiceChangePassword(t *testing.T) {
 	
 	sessionService := NewSessionService(db)
 	activityService := NewActivityService(db)
-	authService := NewAuthService(db, sessionService, activityService)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
 	
 	user := &models.User{
 		Email:   "test@example.com",
@@ -217,25 +278,404 @@ func TestAuthServiceChangePassword(t *testing.T) {
 		Role:    models.RoleSalesperson,
 		Enabled: true,
 	}
-	user.SetPassword("oldpassword123")
+	user.SetPassword("Old!Passw0rd1")
 	
 	if err := db.Create(user).Error; err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
 	
-	err := authService.ChangePassword(user.ID, "oldpassword123", "newpassword123")
+	_, currentToken, _ := sessionService.CreateSession(user, "127.0.0.1", "test-agent")
+	otherSession, _, _ := sessionService.CreateSession(user, "10.0.0.1", "other-agent")
+	
+	err := authService.ChangePassword(user.ID, "Old!Passw0rd1", "New!Passw0rd1", currentToken)
 	if err != nil {
 		t.Fatalf("ChangePassword failed: %v", err)
 	}
 	
+	if session, _ := sessionService.GetSessionByToken(currentToken); session == nil {
+		t.Error("The session that changed the password should be kept")
+	}
+	if err := db.First(&models.Session{}, otherSession.ID).Error; err == nil {
+		t.Error("Other sessions should be revoked after a password change")
+	}
+	
 	var updatedUser models.User
 	db.First(&updatedUser, user.ID)
 	
-	if !updatedUser.CheckPassword("newpassword123") {
+	if !updatedUser.CheckPassword("New!Passw0rd1") {
 		t.Error("New password should be valid")
 	}
 	
-	if updatedUser.CheckPassword("oldpassword123") {
+	if updatedUser.CheckPassword("Old!Passw0rd1") {
 		t.Error("Old password should no longer be valid")
 	}
-}
\ No newline at end of file
+}
+
+func TestAuthServiceLoginWithTwoFactor(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	
+	user := &models.User{
+		Email:       "test@example.com",
+		Name:        "Test User",
+		Role:        models.RoleManager,
+		Enabled:     true,
+		TOTPSecret:  &secret,
+		TOTPEnabled: true,
+	}
+	user.SetPassword("Secur3!Passw0rd")
+	
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	// The secret was stored in plaintext, as older versions did; encrypting
+	// it must keep the user's authenticator working.
+	if err := twoFactorService.EncryptStoredSecrets(); err != nil {
+		t.Fatalf("EncryptStoredSecrets failed: %v", err)
+	}
+	var stored models.User
+	db.First(&stored, user.ID)
+	if stored.TOTPSecret == nil || *stored.TOTPSecret == secret {
+		t.Error("The TOTP secret should be encrypted at rest")
+	}
+	
+	credentials := LoginCredentials{
+		Email:    "test@example.com",
+		Password: "Secur3!Passw0rd",
+		Remember: true,
+	}
+	
+	result, err := authService.Login(credentials, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	
+	if !result.TwoFactorRequired || result.ChallengeToken == "" {
+		t.Fatal("Login should require the two-factor step")
+	}
+	
+	if result.Token != "" || result.Session != nil {
+		t.Error("No session should be created before the two-factor step")
+	}
+	
+	_, err = authService.CompleteTwoFactorLogin(result.ChallengeToken, "000000", "127.0.0.1", "test-agent")
+	if err != ErrInvalidTwoFactorCode {
+		t.Errorf("Expected ErrInvalidTwoFactorCode, got %v", err)
+	}
+	
+	code, _ := totp.Code(secret, time.Now())
+	completed, err := authService.CompleteTwoFactorLogin(result.ChallengeToken, code, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CompleteTwoFactorLogin failed: %v", err)
+	}
+	
+	if completed.Token == "" || completed.Session == nil {
+		t.Error("Session should be created after the two-factor step")
+	}
+	
+	if !completed.Remember {
+		t.Error("Remember flag should carry over from the password step")
+	}
+	
+	_, err = authService.CompleteTwoFactorLogin(result.ChallengeToken, code, "127.0.0.1", "test-agent")
+	if err != ErrTwoFactorChallengeInvalid {
+		t.Errorf("Challenge should not be reusable, got %v", err)
+	}
+	
+	var failures int64
+	db.Model(&models.UserActivity{}).Where("activity_type = ?", "two_factor_failed").Count(&failures)
+	if failures != 1 {
+		t.Errorf("Expected 1 two_factor_failed activity, got %d", failures)
+	}
+}
+
+func TestAuthServiceLoginLockout(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	
+	user := &models.User{
+		Email:   "test@example.com",
+		Name:    "Test User",
+		Role:    models.RoleSalesperson,
+		Enabled: true,
+	}
+	user.SetPassword("Secur3!Passw0rd")
+	
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	wrong := LoginCredentials{Email: "test@example.com", Password: "wrongpassword"}
+	for i := 1; i < 5; i++ {
+		if _, err := authService.Login(wrong, "127.0.0.1", "test-agent"); err != ErrInvalidCredentials {
+			t.Fatalf("Attempt %d: expected ErrInvalidCredentials, got %v", i, err)
+		}
+	}
+	
+	_, err := authService.Login(wrong, "127.0.0.1", "test-agent")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Fifth failure should lock the account, got %v", err)
+	}
+	
+	remaining, locked := LockoutRemaining(err)
+	if !locked || remaining <= 0 || remaining > time.Minute {
+		t.Errorf("Expected a lockout of up to a minute, got %v", remaining)
+	}
+	
+	correct := LoginCredentials{Email: "test@example.com", Password: "Secur3!Passw0rd"}
+	if _, err := authService.Login(correct, "127.0.0.1", "test-agent"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Correct password should be rejected while locked, got %v", err)
+	}
+	
+	var lockedUser models.User
+	db.First(&lockedUser, user.ID)
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	if err := db.Create(admin).Error; err != nil {
+		t.Fatalf("Failed to create admin: %v", err)
+	}
+	
+	if err := lockoutService.Unlock(admin, &lockedUser, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	
+	if _, err := authService.Login(correct, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Login after unlock failed: %v", err)
+	}
+	
+	var updatedUser models.User
+	db.First(&updatedUser, user.ID)
+	
+	if updatedUser.FailedLoginAttempts != 0 || updatedUser.LockedUntil != nil {
+		t.Error("Successful login should clear the failure count")
+	}
+	
+	var lockEvents int64
+	db.Model(&models.UserActivity{}).Where("activity_type = ?", "account_locked").Count(&lockEvents)
+	if lockEvents != 1 {
+		t.Errorf("Expected 1 account_locked activity, got %d", lockEvents)
+	}
+}
+
+func TestAuthServiceChangePasswordHistory(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	
+	policy := models.DefaultPasswordPolicy()
+	policy.HistorySize = 3
+	models.SetPasswordPolicy(policy)
+	defer models.SetPasswordPolicy(models.DefaultPasswordPolicy())
+	
+	user := &models.User{
+		Email:   "test@example.com",
+		Name:    "Test User",
+		Role:    models.RoleSalesperson,
+		Enabled: true,
+	}
+	user.SetPassword("First!Passw0rd")
+	
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	if err := authService.ChangePassword(user.ID, "First!Passw0rd", "First!Passw0rd", ""); err != models.ErrPasswordReused {
+		t.Errorf("Reusing the current password should fail, got %v", err)
+	}
+	
+	if err := authService.ChangePassword(user.ID, "First!Passw0rd", "weak", ""); err == nil {
+		t.Error("Passwords that break the policy should be rejected")
+	}
+	
+	passwords := []string{"First!Passw0rd", "Second!Passw0rd", "Third!Passw0rd", "Fourth!Passw0rd"}
+	for i := 1; i < len(passwords); i++ {
+		if err := authService.ChangePassword(user.ID, passwords[i-1], passwords[i], ""); err != nil {
+			t.Fatalf("ChangePassword to %q failed: %v", passwords[i], err)
+		}
+	}
+	
+	if err := authService.ChangePassword(user.ID, "Fourth!Passw0rd", "Second!Passw0rd", ""); err != models.ErrPasswordReused {
+		t.Errorf("A password within the history should be rejected, got %v", err)
+	}
+	
+	if err := authService.ChangePassword(user.ID, "Fourth!Passw0rd", "First!Passw0rd", ""); err != nil {
+		t.Errorf("A password older than the history should be accepted, got %v", err)
+	}
+	
+	var historyCount int64
+	db.Model(&models.PasswordHistory{}).Where("user_id = ?", user.ID).Count(&historyCount)
+	if historyCount != 2 {
+		t.Errorf("Expected 2 history entries, got %d", historyCount)
+	}
+}
+
+func TestAuthServiceLoginPasswordChangeRequired(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	passwordResetService := NewPasswordResetService(db, sessionService, activityService, passwordPolicyService, &recordingMailer{}, "http://localhost")
+	
+	user := &models.User{
+		Email:   "test@example.com",
+		Name:    "Test User",
+		Role:    models.RoleSalesperson,
+		Enabled: true,
+	}
+	user.SetPassword("Secur3!Passw0rd")
+	expired := time.Now().Add(-time.Hour)
+	user.PasswordExpiresAt = &expired
+	
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	if err := db.Create(admin).Error; err != nil {
+		t.Fatalf("Failed to create admin: %v", err)
+	}
+	
+	credentials := LoginCredentials{Email: "test@example.com", Password: "Secur3!Passw0rd"}
+	result, err := authService.Login(credentials, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Login with an expired password should succeed, got %v", err)
+	}
+	
+	if !result.PasswordChangeRequired {
+		t.Error("Login with an expired password should require a password change")
+	}
+	
+	_, token, err := passwordResetService.ManualResetWithReveal(user.ID, admin.ID, "test", "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("ManualResetWithReveal failed: %v", err)
+	}
+	temporary, _, err := passwordResetService.RevealPassword(admin, token, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("RevealPassword failed: %v", err)
+	}
+	
+	credentials.Password = temporary
+	result, err = authService.Login(credentials, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Login with the temporary password failed: %v", err)
+	}
+	
+	if !result.PasswordChangeRequired {
+		t.Error("Login with a temporary password should require a password change")
+	}
+	
+	if err := authService.ChangePassword(user.ID, temporary, "Brand!N3wPass", ""); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	
+	var updatedUser models.User
+	db.First(&updatedUser, user.ID)
+	
+	if updatedUser.NeedsPasswordChange() {
+		t.Error("Changing the password should lift the restriction")
+	}
+}
+
+type recordingMailer struct {
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestAuthServiceRememberMe(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	
+	user := &models.User{
+		Email:   "test@example.com",
+		Name:    "Test User",
+		Role:    models.RoleSalesperson,
+		Enabled: true,
+	}
+	user.SetPassword("Secur3!Passw0rd")
+	
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	credentials := LoginCredentials{Email: "test@example.com", Password: "Secur3!Passw0rd", Remember: true}
+	result, err := authService.Login(credentials, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if result.RememberToken == "" || !result.Session.IsRemembered() {
+		t.Fatal("Remembered login should issue a remember token bound to the session")
+	}
+	
+	// The session times out; the remember token brings the user back and
+	// is rotated.
+	db.Model(&models.Session{}).Where("id = ?", result.Session.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, err := authService.GetCurrentUser(result.Token); err == nil {
+		t.Fatal("Expired session should not authenticate")
+	}
+	
+	resumed, err := authService.ResumeSession(result.RememberToken, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("ResumeSession failed: %v", err)
+	}
+	if resumed.User.ID != user.ID || resumed.Token == "" {
+		t.Fatal("ResumeSession should start a session for the user")
+	}
+	if resumed.RememberToken == "" || resumed.RememberToken == result.RememberToken {
+		t.Fatal("ResumeSession should rotate the remember token")
+	}
+	
+	// A parallel request with the old cookie is tolerated for a moment.
+	if _, err := authService.ResumeSession(result.RememberToken, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Old token should be accepted during the grace period, got %v", err)
+	}
+	
+	// After that, replaying the old cookie looks like theft and revokes
+	// the whole series.
+	db.Model(&models.RememberToken{}).Where("user_id = ?", user.ID).Update("rotated_at", time.Now().Add(-time.Hour))
+	if _, err := authService.ResumeSession(result.RememberToken, "10.0.0.1", "attacker"); !errors.Is(err, ErrRememberTokenReused) {
+		t.Fatalf("Expected ErrRememberTokenReused, got %v", err)
+	}
+	if _, err := authService.ResumeSession(resumed.RememberToken, "127.0.0.1", "test-agent"); err != ErrRememberTokenInvalid {
+		t.Errorf("Series should be revoked after reuse, got %v", err)
+	}
+	if _, err := authService.GetCurrentUser(resumed.Token); err == nil {
+		t.Error("Sessions from a revoked series should be ended")
+	}
+}
@@ -115,8 +115,8 @@ func (css *CachedStatsService) fetchStatsFromDB() (DashboardStats, error) {
 		dest  *int64
 		args  []interface{}
 	}{
-		{"SELECT COUNT(*) FROM users", &stats.TotalUsers, nil},
-		{"SELECT COUNT(*) FROM users WHERE enabled = ?", &stats.ActiveUsers, []interface{}{true}},
+		{"SELECT COUNT(*) FROM users WHERE deleted_at IS NULL", &stats.TotalUsers, nil},
+		{"SELECT COUNT(*) FROM users WHERE enabled = ? AND deleted_at IS NULL", &stats.ActiveUsers, []interface{}{true}},
 		{"SELECT COUNT(*) FROM user_activities WHERE activity_type = ? AND performed_at >= ?", &stats.SessionsToday, []interface{}{"login", today}},
 		{"SELECT COUNT(*) FROM user_activities WHERE activity_type = ? AND performed_at >= ?", &stats.FailedLoginsToday, []interface{}{"failed_login", today}},
 	}
@@ -175,7 +175,7 @@ func (css *CachedStatsService) fetchUsersFromDB(page int, limit int, filters map
 	
 	// Get users with pagination and only necessary fields
 	if err := query.
-		Select("id, name, email, role, company, enabled, created_at, last_sign_in_at").
+		Select("id, name, email, role, company_id, enabled, created_at, last_sign_in_at").
 		Order("created_at DESC").
 		Limit(limit).
 		Offset(offset).
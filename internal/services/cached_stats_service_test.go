@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/cache"
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+func TestCachedStatsServiceSkipsArchivedUsers(t *testing.T) {
+	db := setupTestDB(t)
+	
+	appCache := cache.New(time.Minute)
+	defer appCache.Close()
+	statsService := NewCachedStatsService(db, appCache)
+	archiveService := NewUserArchiveService(db, NewActivityService(db), models.DefaultDeletedUserRetention)
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	user := &models.User{Email: "user@example.com", Name: "Test User", Role: models.RoleSalesperson, Enabled: true}
+	for _, u := range []*models.User{admin, user} {
+		u.SetPassword("Secur3!Passw0rd")
+		db.Create(u)
+	}
+	
+	if err := archiveService.Archive(admin, user, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	
+	stats, err := statsService.GetDashboardStats()
+	if err != nil {
+		t.Fatalf("GetDashboardStats failed: %v", err)
+	}
+	if stats.TotalUsers != 1 || stats.ActiveUsers != 1 {
+		t.Errorf("Expected the archived user to be left out, got %d users and %d active", stats.TotalUsers, stats.ActiveUsers)
+	}
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCompanyNameInvalid   = errors.New("company name is required and must be at most 100 characters")
+	ErrCompanyNameTaken     = errors.New("another company already has that name")
+	ErrCompanyDomainInvalid = errors.New("enter an email domain such as example.com")
+	ErrCompanyDomainTaken   = errors.New("another company already uses that email domain")
+	ErrCompanyRoleInvalid   = errors.New("the default role must be manager or salesperson")
+	ErrCompanyInUse         = errors.New("the company still has users or IP rules")
+	ErrCompanyLogoInvalid   = fmt.Errorf("the logo must be a PNG, JPEG, GIF or WebP image of at most %d KB", models.MaxCompanyLogoSize>>10)
+)
+
+// CompanyService manages the companies users belong to and their settings.
+type CompanyService struct {
+	db              *gorm.DB
+	activityService *ActivityService
+}
+
+func NewCompanyService(db *gorm.DB, activityService *ActivityService) *CompanyService {
+	return &CompanyService{
+		db:              db,
+		activityService: activityService,
+	}
+}
+
+// GetCompanies returns every company by name.
+func (s *CompanyService) GetCompanies() ([]models.Company, error) {
+	var companies []models.Company
+	err := s.db.Order("name").Find(&companies).Error
+	return companies, err
+}
+
+// Names returns the name of every company, for the password policy to
+// ban. Errors are logged and leave the list empty.
+func (s *CompanyService) Names() []string {
+	var names []string
+	if err := s.db.Model(&models.Company{}).Pluck("name", &names).Error; err != nil {
+		log.Printf("Failed to load company names for the password policy: %v", err)
+	}
+	return names
+}
+
+func (s *CompanyService) GetCompany(companyID uint) (*models.Company, error) {
+	var company models.Company
+	if err := s.db.First(&company, companyID).Error; err != nil {
+		return nil, err
+	}
+	return &company, nil
+}
+
+// FindByName returns the company with the name, ignoring case. It returns
+// gorm.ErrRecordNotFound if there is none.
+func (s *CompanyService) FindByName(name string) (*models.Company, error) {
+	companies, err := s.GetCompanies()
+	if err != nil {
+		return nil, err
+	}
+	company := models.FindCompany(companies, name)
+	if company == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return company, nil
+}
+
+// ForEmail returns the company whose email domain the address is at, or
+// nil if there is none.
+func (s *CompanyService) ForEmail(email string) (*models.Company, error) {
+	companies, err := s.GetCompanies()
+	if err != nil {
+		return nil, err
+	}
+	return models.CompanyForEmail(companies, email), nil
+}
+
+// CountUsers returns how many users, not counting deleted ones, each
+// company has.
+func (s *CompanyService) CountUsers() (map[uint]int64, error) {
+	var rows []struct {
+		CompanyID uint
+		Count     int64
+	}
+	err := s.db.Model(&models.User{}).
+		Select("company_id, COUNT(*) AS count").
+		Where("company_id IS NOT NULL").
+		Group("company_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CompanyID] = row.Count
+	}
+	return counts, nil
+}
+
+// CreateCompany validates and stores a new company.
+func (s *CompanyService) CreateCompany(performingUser *models.User, company *models.Company, ipAddress, userAgent string) error {
+	if !performingUser.HasPermission(models.PermCompaniesManage) {
+		return ErrPermissionDenied
+	}
+	company.ID = 0
+	if err := s.validate(company); err != nil {
+		return err
+	}
+	if err := s.db.Create(company).Error; err != nil {
+		return err
+	}
+
+	s.logCompany(performingUser, company, "company_created", ipAddress, userAgent)
+	return nil
+}
+
+// UpdateCompany saves the name, email domain and default role of an
+// existing company.
+func (s *CompanyService) UpdateCompany(performingUser *models.User, company *models.Company, ipAddress, userAgent string) error {
+	if !performingUser.HasPermission(models.PermCompaniesManage) {
+		return ErrPermissionDenied
+	}
+	if err := s.validate(company); err != nil {
+		return err
+	}
+
+	result := s.db.Model(&models.Company{}).Where("id = ?", company.ID).Updates(map[string]interface{}{
+		"name":         company.Name,
+		"email_domain": company.EmailDomain,
+		"default_role": company.DefaultRole,
+		"updated_at":   time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	s.logCompany(performingUser, company, "company_updated", ipAddress, userAgent)
+	return nil
+}
+
+// DeleteCompany removes a company and its logo. Companies that users,
+// including deleted ones still within their retention window, or IP rules
+// refer to cannot be deleted.
+func (s *CompanyService) DeleteCompany(performingUser *models.User, companyID uint, ipAddress, userAgent string) error {
+	if !performingUser.HasPermission(models.PermCompaniesManage) {
+		return ErrPermissionDenied
+	}
+	company, err := s.GetCompany(companyID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var users, rules int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("company_id = ?", companyID).Count(&users).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.IPRule{}).Where("company_id = ?", companyID).Count(&rules).Error; err != nil {
+			return err
+		}
+		if users > 0 || rules > 0 {
+			return ErrCompanyInUse
+		}
+
+		if err := tx.Where("company_id = ?", companyID).Delete(&models.CompanyLogo{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Session{}).Where("company_context_id = ?", companyID).Update("company_context_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Company{}, companyID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logCompany(performingUser, company, "company_deleted", ipAddress, userAgent)
+	return nil
+}
+
+// SetLogo replaces the company's logo with the image in data.
+func (s *CompanyService) SetLogo(performingUser *models.User, companyID uint, data []byte, ipAddress, userAgent string) error {
+	if !performingUser.HasPermission(models.PermCompaniesManage) {
+		return ErrPermissionDenied
+	}
+	if len(data) == 0 || len(data) > models.MaxCompanyLogoSize {
+		return ErrCompanyLogoInvalid
+	}
+	contentType := http.DetectContentType(data)
+	valid := false
+	for _, logoType := range models.CompanyLogoTypes {
+		if contentType == logoType {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrCompanyLogoInvalid
+	}
+
+	company, err := s.GetCompany(companyID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&models.CompanyLogo{CompanyID: companyID, ContentType: contentType, Data: data, UpdatedAt: now}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Company{}).Where("id = ?", companyID).Update("logo_updated_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logCompany(performingUser, company, "company_logo_updated", ipAddress, userAgent)
+	return nil
+}
+
+// RemoveLogo deletes the company's logo, if it has one.
+func (s *CompanyService) RemoveLogo(performingUser *models.User, companyID uint, ipAddress, userAgent string) error {
+	if !performingUser.HasPermission(models.PermCompaniesManage) {
+		return ErrPermissionDenied
+	}
+	company, err := s.GetCompany(companyID)
+	if err != nil {
+		return err
+	}
+	if !company.HasLogo() {
+		return nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("company_id = ?", companyID).Delete(&models.CompanyLogo{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Company{}).Where("id = ?", companyID).Update("logo_updated_at", nil).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logCompany(performingUser, company, "company_logo_removed", ipAddress, userAgent)
+	return nil
+}
+
+// GetLogo returns the company's logo. It returns gorm.ErrRecordNotFound if
+// the company has none.
+func (s *CompanyService) GetLogo(companyID uint) (*models.CompanyLogo, error) {
+	var logo models.CompanyLogo
+	if err := s.db.Where("company_id = ?", companyID).First(&logo).Error; err != nil {
+		return nil, err
+	}
+	return &logo, nil
+}
+
+// SetContext makes the company the one the session's user works in, or
+// lets them see every company again when companyID is nil. Only users who
+// may see every company have a context; everyone else is held to their
+// own company.
+func (s *CompanyService) SetContext(performingUser *models.User, session *models.Session, companyID *uint) error {
+	if !performingUser.HasPermission(models.PermUsersAllCompanies) {
+		return ErrPermissionDenied
+	}
+	if companyID != nil {
+		if _, err := s.GetCompany(*companyID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.db.Model(&models.Session{}).Where("id = ?", session.ID).Update("company_context_id", companyID).Error; err != nil {
+		return err
+	}
+	session.CompanyContextID = companyID
+	return nil
+}
+
+func (s *CompanyService) validate(company *models.Company) error {
+	company.Name = strings.TrimSpace(company.Name)
+	if company.Name == "" || len(company.Name) > 100 {
+		return ErrCompanyNameInvalid
+	}
+	domain, ok := models.NormalizeEmailDomain(company.EmailDomain)
+	if !ok {
+		return ErrCompanyDomainInvalid
+	}
+	company.EmailDomain = domain
+	if !models.IsValidDefaultRole(company.DefaultRole) {
+		return ErrCompanyRoleInvalid
+	}
+
+	companies, err := s.GetCompanies()
+	if err != nil {
+		return err
+	}
+	for _, other := range companies {
+		if other.ID == company.ID {
+			continue
+		}
+		if strings.EqualFold(other.Name, company.Name) {
+			return ErrCompanyNameTaken
+		}
+		if domain != "" && other.EmailDomain == domain {
+			return ErrCompanyDomainTaken
+		}
+	}
+	return nil
+}
+
+func (s *CompanyService) logCompany(performingUser *models.User, company *models.Company, activityType, ipAddress, userAgent string) {
+	s.activityService.LogActivity(&performingUser.ID, activityType, ipAddress, userAgent, map[string]interface{}{
+		"performed_by": performingUser.ID,
+		"company_id":   company.ID,
+		"company_name": company.Name,
+	})
+}
+
+// scopeToCompany narrows query to the rows whose company, held in column, is
+// within the scope.
+func scopeToCompany(query *gorm.DB, column string, scope models.CompanyScope) *gorm.DB {
+	if condition, args := scope.Condition(column); condition != "" {
+		return query.Where(condition, args...)
+	}
+	return query
+}
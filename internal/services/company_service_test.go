@@ -0,0 +1,232 @@
+package services
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestCompanyService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	companyService := NewCompanyService(db, activityService)
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	manager := &models.User{Email: "manager@example.com", Name: "Manager", Role: models.RoleManager, Enabled: true}
+	db.Create(admin)
+	db.Create(manager)
+	
+	if err := companyService.CreateCompany(manager, &models.Company{Name: "Acme", DefaultRole: models.RoleSalesperson}, "127.0.0.1", "test-agent"); err != ErrPermissionDenied {
+		t.Errorf("Managers should not add companies, got %v", err)
+	}
+	
+	for _, tc := range []struct {
+		company models.Company
+		want    error
+	}{
+		{models.Company{Name: "  ", DefaultRole: models.RoleSalesperson}, ErrCompanyNameInvalid},
+		{models.Company{Name: "Acme", EmailDomain: "not a domain", DefaultRole: models.RoleSalesperson}, ErrCompanyDomainInvalid},
+		{models.Company{Name: "Acme", DefaultRole: models.RoleAdmin}, ErrCompanyRoleInvalid},
+	} {
+		if err := companyService.CreateCompany(admin, &tc.company, "127.0.0.1", "test-agent"); err != tc.want {
+			t.Errorf("CreateCompany(%+v) = %v, want %v", tc.company, err, tc.want)
+		}
+	}
+	
+	acme := &models.Company{Name: " Acme ", EmailDomain: "@Acme.COM", DefaultRole: models.RoleManager}
+	if err := companyService.CreateCompany(admin, acme, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("CreateCompany failed: %v", err)
+	}
+	if acme.Name != "Acme" || acme.EmailDomain != "acme.com" {
+		t.Errorf("Expected the name and domain to be normalised, got %q and %q", acme.Name, acme.EmailDomain)
+	}
+	if err := companyService.CreateCompany(admin, &models.Company{Name: "ACME", DefaultRole: models.RoleSalesperson}, "127.0.0.1", "test-agent"); err != ErrCompanyNameTaken {
+		t.Errorf("Company names should be unique ignoring case, got %v", err)
+	}
+	other := &models.Company{Name: "Other", EmailDomain: "acme.com", DefaultRole: models.RoleSalesperson}
+	if err := companyService.CreateCompany(admin, other, "127.0.0.1", "test-agent"); err != ErrCompanyDomainTaken {
+		t.Errorf("Email domains should be unique, got %v", err)
+	}
+	other.EmailDomain = "other.org"
+	if err := companyService.CreateCompany(admin, other, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("CreateCompany failed: %v", err)
+	}
+	
+	if company, _ := companyService.ForEmail("jo@ACME.com"); company == nil || company.ID != acme.ID {
+		t.Errorf("Expected jo@ACME.com to belong to Acme, got %+v", company)
+	}
+	if company, _ := companyService.ForEmail("jo@example.com"); company != nil {
+		t.Errorf("Expected no company for an unknown domain, got %+v", company)
+	}
+	
+	acme.Name = "Acme Shipping"
+	acme.DefaultRole = models.RoleSalesperson
+	if err := companyService.UpdateCompany(admin, acme, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("UpdateCompany failed: %v", err)
+	}
+	if company, err := companyService.FindByName("acme shipping"); err != nil || company.ID != acme.ID || company.DefaultRole != models.RoleSalesperson {
+		t.Errorf("Expected the company to be renamed, got %+v, %v", company, err)
+	}
+	if err := companyService.UpdateCompany(admin, &models.Company{ID: 999, Name: "Ghost", DefaultRole: models.RoleSalesperson}, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("Updating a missing company should fail, got %v", err)
+	}
+	
+	// Logos must be images of an allowed type.
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+	if err := companyService.SetLogo(admin, acme.ID, []byte("<svg onload=alert(1)></svg>"), "127.0.0.1", "test-agent"); err != ErrCompanyLogoInvalid {
+		t.Errorf("SVG logos should be refused, got %v", err)
+	}
+	if err := companyService.SetLogo(admin, acme.ID, png, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("SetLogo failed: %v", err)
+	}
+	logo, err := companyService.GetLogo(acme.ID)
+	if err != nil || logo.ContentType != "image/png" || !bytes.Equal(logo.Data, png) {
+		t.Fatalf("Expected the PNG logo to be stored, got %+v, %v", logo, err)
+	}
+	company, _ := companyService.GetCompany(acme.ID)
+	if !company.HasLogo() || !strings.HasPrefix(company.LogoURL(), "/companies/"+strconv.Itoa(int(acme.ID))+"/logo?v=") {
+		t.Errorf("Expected a versioned logo URL, got %q", company.LogoURL())
+	}
+	if err := companyService.RemoveLogo(admin, acme.ID, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("RemoveLogo failed: %v", err)
+	}
+	if _, err := companyService.GetLogo(acme.ID); err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected the logo to be removed, got %v", err)
+	}
+	if company, _ := companyService.GetCompany(acme.ID); company.HasLogo() {
+		t.Error("A company without a logo should not have a logo URL")
+	}
+	
+	// Companies with users cannot be deleted.
+	db.Model(manager).Update("company_id", acme.ID)
+	counts, _ := companyService.CountUsers()
+	if counts[acme.ID] != 1 || counts[other.ID] != 0 {
+		t.Errorf("Expected Acme to have one user, got %v", counts)
+	}
+	if err := companyService.DeleteCompany(admin, acme.ID, "127.0.0.1", "test-agent"); err != ErrCompanyInUse {
+		t.Errorf("Companies with users should not be deleted, got %v", err)
+	}
+	if err := companyService.DeleteCompany(manager, other.ID, "127.0.0.1", "test-agent"); err != ErrPermissionDenied {
+		t.Errorf("Managers should not delete companies, got %v", err)
+	}
+	if err := companyService.DeleteCompany(admin, other.ID, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("DeleteCompany failed: %v", err)
+	}
+	if companies, _ := companyService.GetCompanies(); len(companies) != 1 || companies[0].ID != acme.ID {
+		t.Errorf("Expected only Acme to remain, got %+v", companies)
+	}
+	
+	var logged int64
+	db.Model(&models.UserActivity{}).Where("activity_type LIKE ?", "company_%").Count(&logged)
+	if logged != 6 {
+		t.Errorf("Expected 6 company changes to be logged, got %d", logged)
+	}
+}
+
+func TestCompanyScope(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	companyService := NewCompanyService(db, activityService)
+	invitationService := NewInvitationService(db, activityService, NewPasswordPolicyService(db), &recordingMailer{}, "http://localhost", testAppKey)
+	
+	acme := &models.Company{Name: "Acme", DefaultRole: models.RoleSalesperson}
+	other := &models.Company{Name: "Other", DefaultRole: models.RoleSalesperson}
+	db.Create(acme)
+	db.Create(other)
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	manager := &models.User{Email: "manager@example.com", Name: "Manager", Role: models.RoleManager, CompanyID: &acme.ID, Enabled: true}
+	colleague := &models.User{Email: "colleague@example.com", Name: "Colleague", Role: models.RoleSalesperson, CompanyID: &acme.ID, Enabled: true}
+	outsider := &models.User{Email: "outsider@example.com", Name: "Outsider", Role: models.RoleSalesperson, CompanyID: &other.ID, Enabled: true}
+	for _, u := range []*models.User{admin, manager, colleague, outsider} {
+		db.Create(u)
+		activityService.LogActivity(&u.ID, "login", "127.0.0.1", "test-agent", nil)
+	}
+	
+	// Managers only see their own company's activity.
+	activities, err := activityService.GetCompanyActivities(manager.CompanyScope(nil), 10)
+	if err != nil || len(activities) != 2 {
+		t.Errorf("Expected the 2 Acme users' activity, got %d (%v)", len(activities), err)
+	}
+	if activities, _ = activityService.GetCompanyActivities(admin.CompanyScope(nil), 10); len(activities) != 4 {
+		t.Errorf("Expected every company's activity, got %d", len(activities))
+	}
+	if activities, _ = activityService.GetCompanyActivities(admin.CompanyScope(&other.ID), 10); len(activities) != 1 {
+		t.Errorf("Expected only Other's activity in its context, got %d", len(activities))
+	}
+	if inScope, _ := activityService.InCompanyScope(outsider.ID, manager.CompanyScope(nil)); inScope {
+		t.Error("Users of other companies should be out of a manager's scope")
+	}
+	if inScope, _ := activityService.InCompanyScope(colleague.ID, manager.CompanyScope(nil)); !inScope {
+		t.Error("Users of the manager's company should be in scope")
+	}
+	
+	// Managers invite users into their own company only.
+	if _, err := invitationService.Invite(manager, &models.User{Email: "new@example.com", Name: "New", Role: models.RoleSalesperson, CompanyID: &other.ID}, "127.0.0.1", "test-agent"); err != ErrPermissionDenied {
+		t.Errorf("Managers should not invite users into other companies, got %v", err)
+	}
+	if _, err := invitationService.Invite(manager, &models.User{Email: "new@example.com", Name: "New", Role: models.RoleSalesperson, CompanyID: &acme.ID}, "127.0.0.1", "test-agent"); err != nil {
+		t.Errorf("Invite failed: %v", err)
+	}
+	
+	// Only users who see every company choose a context, and it is
+	// cleared when its company goes.
+	session := &models.Session{UserID: admin.ID, TokenDigest: "admin-session", ExpiresAt: time.Now().Add(time.Hour)}
+	db.Create(session)
+	if err := companyService.SetContext(manager, session, &other.ID); err != ErrPermissionDenied {
+		t.Errorf("Managers should not switch company, got %v", err)
+	}
+	missing := uint(9999)
+	if err := companyService.SetContext(admin, session, &missing); err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected gorm.ErrRecordNotFound for a missing company, got %v", err)
+	}
+	if err := companyService.SetContext(admin, session, &other.ID); err != nil {
+		t.Fatalf("SetContext failed: %v", err)
+	}
+	var saved models.Session
+	db.First(&saved, session.ID)
+	if saved.CompanyContextID == nil || *saved.CompanyContextID != other.ID {
+		t.Errorf("Expected the context to be saved, got %v", saved.CompanyContextID)
+	}
+	
+	db.Model(outsider).Update("company_id", nil)
+	if err := companyService.DeleteCompany(admin, other.ID, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("DeleteCompany failed: %v", err)
+	}
+	saved = models.Session{}
+	db.First(&saved, session.ID)
+	if saved.CompanyContextID != nil {
+		t.Errorf("Expected the context to be cleared with its company, got %v", *saved.CompanyContextID)
+	}
+}
+
+func TestCompanyNamesInPasswordPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	companyService := NewCompanyService(db, NewActivityService(db))
+	
+	policy := models.DefaultPasswordPolicy()
+	policy.CompanyNames = companyService.Names
+	models.SetPasswordPolicy(policy)
+	defer models.SetPasswordPolicy(models.DefaultPasswordPolicy())
+	
+	if err := models.ValidatePassword("BlueHarbour#24"); err != nil {
+		t.Fatalf("Password should be valid without companies, got %v", err)
+	}
+	
+	company := &models.Company{Name: "Blue Harbour Shipping", DefaultRole: models.RoleSalesperson}
+	db.Create(company)
+	if err := models.ValidatePassword("BlueHarbour#24"); err == nil {
+		t.Error("Passwords containing a company's name should be rejected")
+	}
+	
+	db.Model(company).Update("name", "Red Dock")
+	if err := models.ValidatePassword("BlueHarbour#24"); err != nil {
+		t.Errorf("Renamed companies should no longer be banned, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrImpersonateSelf      = errors.New("you cannot log in as yourself")
+	ErrNotImpersonating     = errors.New("this session is not impersonating anyone")
+	ErrAlreadyImpersonating = errors.New("return to your own account before logging in as someone else")
+)
+
+// ImpersonationService lets admins open a session as another user to see
+// what they see. The session remembers who opened it so every request can
+// be attributed to the admin.
+type ImpersonationService struct {
+	db              *gorm.DB
+	sessionService  *SessionService
+	activityService *ActivityService
+}
+
+func NewImpersonationService(db *gorm.DB, sessionService *SessionService, activityService *ActivityService) *ImpersonationService {
+	return &ImpersonationService{
+		db:              db,
+		sessionService:  sessionService,
+		activityService: activityService,
+	}
+}
+
+// Start opens a session as target on behalf of impersonator. The
+// impersonator's own session, identified by sessionToken, is left untouched
+// so they can return to it.
+func (s *ImpersonationService) Start(impersonator *models.User, sessionToken string, target *models.User, ipAddress, userAgent string) (*LoginResult, error) {
+	current, err := s.sessionService.GetSessionByToken(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ImpersonatorID != nil {
+		return nil, ErrAlreadyImpersonating
+	}
+	if impersonator.ID == target.ID {
+		return nil, ErrImpersonateSelf
+	}
+	if !impersonator.HasPermission(models.PermUsersImpersonate) || !impersonator.CanManageUser(target) {
+		return nil, ErrPermissionDenied
+	}
+	// Impersonating a peer or a superior would hand over privileges the
+	// impersonator does not have, such as another admin's.
+	if target.Role.Rank() >= impersonator.Role.Rank() {
+		return nil, ErrPermissionDenied
+	}
+	if !target.Enabled {
+		return nil, ErrUserDisabled
+	}
+
+	session, token, err := s.sessionService.CreateImpersonationSession(target, impersonator, ipAddress, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	s.activityService.LogImpersonation(impersonator, target, "impersonation_started", ipAddress, userAgent)
+
+	return &LoginResult{
+		User:    target,
+		Session: session,
+		Token:   token,
+	}, nil
+}
+
+// Stop ends an impersonated session and returns the admin who opened it.
+func (s *ImpersonationService) Stop(sessionToken, ipAddress, userAgent string) (*models.User, error) {
+	session, err := s.sessionService.GetSessionByToken(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.ImpersonatorID == nil {
+		return nil, ErrNotImpersonating
+	}
+
+	var impersonator, target models.User
+	if err := s.db.First(&impersonator, *session.ImpersonatorID).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.First(&target, session.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.sessionService.DestroySession(sessionToken); err != nil {
+		return nil, err
+	}
+
+	s.activityService.LogImpersonation(&impersonator, &target, "impersonation_stopped", ipAddress, userAgent)
+
+	return &impersonator, nil
+}
+
+// GetImpersonator returns the admin behind an impersonated session, or nil
+// for an ordinary one. The session is ended if the admin has since been
+// disabled or lost the permission to impersonate.
+func (s *ImpersonationService) GetImpersonator(session *models.Session) (*models.User, error) {
+	if session == nil || session.ImpersonatorID == nil {
+		return nil, nil
+	}
+
+	var impersonator models.User
+	if err := s.db.First(&impersonator, *session.ImpersonatorID).Error; err != nil {
+		return nil, err
+	}
+
+	if !impersonator.Enabled || !impersonator.HasPermission(models.PermUsersImpersonate) {
+		s.db.Delete(session)
+		return nil, ErrPermissionDenied
+	}
+
+	return &impersonator, nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"testing"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+func TestImpersonationService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	impersonationService := NewImpersonationService(db, sessionService, activityService)
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin User", Role: models.RoleAdmin, Enabled: true}
+	manager := &models.User{Email: "manager@example.com", Name: "Manager User", Role: models.RoleManager, Enabled: true}
+	sales := &models.User{Email: "sales@example.com", Name: "Sales User", Role: models.RoleSalesperson, Enabled: true}
+	sales.SetPassword("Original@Pass123")
+	db.Create(admin)
+	db.Create(manager)
+	db.Create(sales)
+	
+	_, adminToken, _ := sessionService.CreateSession(admin, "127.0.0.1", "test-agent")
+	_, managerToken, _ := sessionService.CreateSession(manager, "127.0.0.1", "test-agent")
+	
+	if _, err := impersonationService.Start(manager, managerToken, sales, "127.0.0.1", "test-agent"); err != ErrPermissionDenied {
+		t.Errorf("Managers should not be able to impersonate by default, got %v", err)
+	}
+	if _, err := impersonationService.Start(admin, adminToken, admin, "127.0.0.1", "test-agent"); err != ErrImpersonateSelf {
+		t.Errorf("Expected ErrImpersonateSelf, got %v", err)
+	}
+	otherAdmin := &models.User{Email: "admin2@example.com", Name: "Other Admin", Role: models.RoleAdmin, Enabled: true}
+	db.Create(otherAdmin)
+	if _, err := impersonationService.Start(admin, adminToken, otherAdmin, "127.0.0.1", "test-agent"); err != ErrPermissionDenied {
+		t.Errorf("Admins should not be able to impersonate other admins, got %v", err)
+	}
+	
+	result, err := impersonationService.Start(admin, adminToken, sales, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	
+	user, session, err := authService.GetCurrentSession(result.Token)
+	if err != nil || user.ID != sales.ID {
+		t.Fatalf("The impersonated session should belong to the user, got %v", err)
+	}
+	impersonator, err := impersonationService.GetImpersonator(session)
+	if err != nil || impersonator == nil || impersonator.ID != admin.ID {
+		t.Fatalf("The session should be flagged with the admin, got %v", err)
+	}
+	
+	if _, err := impersonationService.Start(admin, result.Token, manager, "127.0.0.1", "test-agent"); err != ErrAlreadyImpersonating {
+		t.Errorf("Expected ErrAlreadyImpersonating, got %v", err)
+	}
+	
+	if err := authService.ChangePassword(sales.ID, "Original@Pass123", "Changed@Pass456", result.Token); err != ErrImpersonating {
+		t.Errorf("Password changes should be blocked while impersonating, got %v", err)
+	}
+	
+	stoppedBy, err := impersonationService.Stop(result.Token, "127.0.0.1", "test-agent")
+	if err != nil || stoppedBy.ID != admin.ID {
+		t.Fatalf("Stop should return the admin, got %v", err)
+	}
+	if authService.IsAuthenticated(result.Token) {
+		t.Error("The impersonated session should end on Stop")
+	}
+	if !authService.IsAuthenticated(adminToken) {
+		t.Error("The admin's own session should survive impersonation")
+	}
+	if _, err := impersonationService.Stop(adminToken, "127.0.0.1", "test-agent"); err != ErrNotImpersonating {
+		t.Errorf("Expected ErrNotImpersonating, got %v", err)
+	}
+	
+	for _, activityType := range []string{"impersonation_started", "impersonation_stopped"} {
+		var activity models.UserActivity
+		if err := db.Where("activity_type = ? AND user_id = ?", activityType, admin.ID).First(&activity).Error; err != nil {
+			t.Errorf("Expected %s to be logged for the admin", activityType)
+		}
+	}
+	
+	// Disabling the admin ends sessions they opened as someone else.
+	result, _ = impersonationService.Start(admin, adminToken, sales, "127.0.0.1", "test-agent")
+	_, session, _ = authService.GetCurrentSession(result.Token)
+	db.Model(&models.User{}).Where("id = ?", admin.ID).Update("enabled", false)
+	if _, err := impersonationService.GetImpersonator(session); err == nil {
+		t.Error("Expected an error once the admin is disabled")
+	}
+	if authService.IsAuthenticated(result.Token) {
+		t.Error("The impersonated session should end once the admin is disabled")
+	}
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/mailer"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+	ErrInvitationNotSent = errors.New("the invitation email could not be sent")
+)
+
+// InvitationService onboards users by email: an admin enters the account
+// details and the user chooses their own password through a single-use
+// link signed with the app key.
+type InvitationService struct {
+	db                    *gorm.DB
+	activityService       *ActivityService
+	passwordPolicyService *PasswordPolicyService
+	mailer                mailer.Mailer
+	baseURL               string
+	signingKey            []byte
+}
+
+func NewInvitationService(db *gorm.DB, activityService *ActivityService, passwordPolicyService *PasswordPolicyService, mailer mailer.Mailer, baseURL string, appKey []byte) *InvitationService {
+	return &InvitationService{
+		db:                    db,
+		activityService:       activityService,
+		passwordPolicyService: passwordPolicyService,
+		mailer:                mailer,
+		baseURL:               baseURL,
+		signingKey:            models.DeriveKey(appKey, "invitation-links"),
+	}
+}
+
+// Invite creates user as a disabled account with a password nobody knows
+// and emails them a link to accept. The caller fills in the name, email,
+// role and company. If only the email fails, the invitation is kept and
+// ErrInvitationNotSent is returned so it can be resent.
+func (s *InvitationService) Invite(performingUser *models.User, user *models.User, ipAddress, userAgent string) (*models.Invitation, error) {
+	if !performingUser.HasPermission(models.PermUsersCreate) || !performingUser.CanAssignRole(user.Role) || !performingUser.CanAssignCompany(user.CompanyID) {
+		return nil, ErrPermissionDenied
+	}
+
+	var invitation *models.Invitation
+	var token string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		invitation, token, err = s.createInvitation(tx, performingUser, user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.activityService.LogUserCRUD(performingUser, user, "invite", ipAddress, userAgent)
+
+	if err := s.sendInvitation(user, performingUser, token); err != nil {
+		return invitation, ErrInvitationNotSent
+	}
+	return invitation, nil
+}
+
+// Resend emails a fresh link for a pending or expired invitation. The
+// previous link stops working. It returns gorm.ErrRecordNotFound if the
+// invitation does not exist or was already accepted.
+func (s *InvitationService) Resend(performingUser *models.User, invitationID uint, ipAddress, userAgent string) (*models.Invitation, error) {
+	invitation, err := s.findOpenInvitation(performingUser, invitationID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(models.InvitationTTL)
+	token, digest, err := s.newInvitationToken(invitation.UserID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	invitation.TokenDigest = &digest
+	invitation.SentAt = now
+	invitation.ExpiresAt = expiresAt
+	err = s.db.Model(&models.Invitation{}).Where("id = ?", invitation.ID).Updates(map[string]interface{}{
+		"token_digest": digest,
+		"sent_at":      invitation.SentAt,
+		"expires_at":   invitation.ExpiresAt,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	s.activityService.LogUserCRUD(performingUser, &invitation.User, "resend_invitation", ipAddress, userAgent)
+
+	if err := s.sendInvitation(&invitation.User, performingUser, token); err != nil {
+		return invitation, ErrInvitationNotSent
+	}
+	return invitation, nil
+}
+
+// Revoke withdraws an invitation: the link stops working and the account
+// it created is deleted like any other user, so it can be restored for the
+// retention window and the audit trail keeps pointing at it. It returns
+// gorm.ErrRecordNotFound if the invitation does not exist or was already
+// accepted or revoked.
+func (s *InvitationService) Revoke(performingUser *models.User, invitationID uint, ipAddress, userAgent string) (*models.Invitation, error) {
+	invitation, err := s.findOpenInvitation(performingUser, invitationID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Invitation{}).Where("id = ?", invitation.ID).Updates(map[string]interface{}{
+			"revoked_at":   now,
+			"token_digest": nil,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", invitation.UserID).Update("deleted_by_id", performingUser.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&invitation.User).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	invitation.RevokedAt = &now
+	invitation.TokenDigest = nil
+
+	s.activityService.LogUserCRUD(performingUser, &invitation.User, "revoke_invitation", ipAddress, userAgent)
+	return invitation, nil
+}
+
+// createInvitation stores user as a disabled account with a password
+// nobody knows, together with its invitation, and returns the link token.
+// Sending the email is left to the caller.
+func (s *InvitationService) createInvitation(tx *gorm.DB, performingUser *models.User, user *models.User) (*models.Invitation, string, error) {
+	user.Email = normalizeEmail(user.Email)
+	user.Enabled = false
+	if err := user.SetRandomPassword(); err != nil {
+		return nil, "", err
+	}
+
+	if err := tx.Create(user).Error; err != nil {
+		return nil, "", err
+	}
+	// Enabled defaults to true, so GORM leaves a false value out of the
+	// insert.
+	if err := tx.Model(user).Update("enabled", false).Error; err != nil {
+		return nil, "", err
+	}
+
+	// The token names the user, so it can only be made once they exist.
+	now := time.Now()
+	expiresAt := now.Add(models.InvitationTTL)
+	token, digest, err := s.newInvitationToken(user.ID, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	invitation := &models.Invitation{
+		UserID:      user.ID,
+		InvitedByID: &performingUser.ID,
+		TokenDigest: &digest,
+		SentAt:      now,
+		ExpiresAt:   expiresAt,
+	}
+	if err := tx.Create(invitation).Error; err != nil {
+		return nil, "", err
+	}
+	invitation.User = *user
+	return invitation, token, nil
+}
+
+func (s *InvitationService) findOpenInvitation(performingUser *models.User, invitationID uint) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := s.db.Preload("User").Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitationID).First(&invitation).Error; err != nil {
+		return nil, err
+	}
+	if !performingUser.HasPermission(models.PermUsersCreate) || !performingUser.CanManageUser(&invitation.User) {
+		return nil, ErrPermissionDenied
+	}
+	return &invitation, nil
+}
+
+// GetOpenInvitations lists invitations that have been neither accepted nor
+// revoked, including expired ones, newest first.
+func (s *InvitationService) GetOpenInvitations() ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	err := s.db.Preload("User").Preload("InvitedBy").
+		Where("accepted_at IS NULL AND revoked_at IS NULL").
+		Order("created_at DESC").
+		Find(&invitations).Error
+	return invitations, err
+}
+
+// HasOpenInvitation reports whether the user was invited and has not
+// accepted yet, even if the link has expired. Such accounts stay disabled
+// until the user chooses a password.
+func (s *InvitationService) HasOpenInvitation(userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Invitation{}).
+		Where("user_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// FindPendingInvitation returns the unaccepted, unexpired invitation for
+// token along with its user. Tokens that were not signed with the app key
+// are refused without a lookup.
+func (s *InvitationService) FindPendingInvitation(token string) (*models.Invitation, error) {
+	userID, ok := s.verifyInvitationToken(token)
+	if !ok {
+		return nil, ErrInvalidInvitation
+	}
+
+	var invitation models.Invitation
+	if err := s.db.Preload("User").
+		Where("token_digest = ? AND user_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", models.HashToken(token), userID, time.Now()).
+		First(&invitation).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// Accept sets the invited user's password and enables their account.
+func (s *InvitationService) Accept(token, password, ipAddress, userAgent string) (*models.User, error) {
+	invitation, err := s.FindPendingInvitation(token)
+	if err != nil {
+		return nil, err
+	}
+
+	user := invitation.User
+
+	// Check the password before using up the token so a rejected password
+	// can be corrected with the same link.
+	if err := models.GetPasswordPolicy().Validate(password, &user); err != nil {
+		return nil, err
+	}
+
+	// Claim the token first so it cannot be used twice.
+	result := s.db.Model(&models.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL", invitation.ID).
+		Updates(map[string]interface{}{
+			"accepted_at":  time.Now(),
+			"token_digest": nil,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidInvitation
+	}
+
+	user.Enabled = true
+	if err := s.passwordPolicyService.UpdatePassword(&user, password); err != nil {
+		return nil, err
+	}
+
+	s.activityService.LogActivity(&user.ID, "invitation_accepted", ipAddress, userAgent, map[string]interface{}{
+		"user_id":       user.ID,
+		"user_name":     user.Name,
+		"invitation_id": invitation.ID,
+	})
+
+	return &user, nil
+}
+
+func (s *InvitationService) sendInvitation(user, invitedBy *models.User, token string) error {
+	link := fmt.Sprintf("%s/invite/%s", s.baseURL, token)
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "You have been invited to ASM Tracker",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"%s has created an ASM Tracker account for you.\n"+
+			"Open the link below to choose your password and activate it:\n\n%s\n\n"+
+			"The link expires in %d days and can only be used once.\n",
+			user.Name, invitedBy.Name, link, int(models.InvitationTTL/(24*time.Hour))),
+	})
+}
+
+// newInvitationToken returns a link token for the user that expires at
+// expiresAt, along with the digest to store. The token is
+// "<user ID>.<expiry>.<nonce>.<signature>"; the nonce makes every link
+// unique so a resent link replaces the old one.
+func (s *InvitationService) newInvitationToken(userID uint, expiresAt time.Time) (token, digest string, err error) {
+	nonce, err := models.GenerateSecureToken()
+	if err != nil {
+		return "", "", err
+	}
+	payload := fmt.Sprintf("%d.%d.%s", userID, expiresAt.Unix(), nonce)
+	token = payload + "." + s.sign(payload)
+	return token, models.HashToken(token), nil
+}
+
+// verifyInvitationToken checks the token's signature and expiry and returns
+// the user it was issued for.
+func (s *InvitationService) verifyInvitationToken(token string) (uint, bool) {
+	dot := strings.LastIndex(token, ".")
+	if dot < 0 {
+		return 0, false
+	}
+	payload, signature := token[:dot], token[dot+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return 0, false
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	userID, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() >= expiresAt {
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+func (s *InvitationService) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/mailer"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestInvitationService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	mail := &recordingMailer{}
+	invitationService := NewInvitationService(db, activityService, NewPasswordPolicyService(db), mail, "http://localhost", testAppKey)
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	manager := &models.User{Email: "manager@example.com", Name: "Manager", Role: models.RoleManager, Enabled: true}
+	admin.SetPassword("Secur3!Passw0rd")
+	manager.SetPassword("Secur3!Passw0rd")
+	db.Create(admin)
+	db.Create(manager)
+	
+	linkToken := func(msg mailer.Message) string {
+		start := strings.Index(msg.Body, "http://localhost/invite/")
+		if start < 0 {
+			t.Fatalf("Email does not contain an invite link: %q", msg.Body)
+		}
+		return strings.Fields(msg.Body[start+len("http://localhost/invite/"):])[0]
+	}
+	
+	if _, err := invitationService.Invite(manager, &models.User{Email: "boss@example.com", Name: "Boss", Role: models.RoleAdmin}, "127.0.0.1", "test-agent"); err != ErrPermissionDenied {
+		t.Errorf("Managers should not invite admins, got %v", err)
+	}
+	
+	invited := &models.User{Email: " New@Example.com", Name: "New User", Role: models.RoleSalesperson}
+	invitation, err := invitationService.Invite(admin, invited, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	var stored models.User
+	db.First(&stored, invited.ID)
+	if stored.Enabled || stored.Email != "new@example.com" {
+		t.Errorf("Expected a disabled account for new@example.com, got %+v", stored)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To != "new@example.com" {
+		t.Fatalf("Expected the invitation to be emailed, got %+v", mail.sent)
+	}
+	firstToken := linkToken(mail.sent[0])
+	if invitation.TokenDigest == nil || *invitation.TokenDigest == firstToken {
+		t.Error("The invitation should store the token's digest, not the token")
+	}
+	if pending, err := invitationService.HasOpenInvitation(invited.ID); err != nil || !pending {
+		t.Errorf("Expected the invited user to have an open invitation, got %v, %v", pending, err)
+	}
+	
+	// Links are signed with the app key.
+	if _, err := invitationService.FindPendingInvitation(firstToken[:len(firstToken)-1] + "x"); err != ErrInvalidInvitation {
+		t.Errorf("A tampered link should be refused, got %v", err)
+	}
+	otherKey := NewInvitationService(db, activityService, NewPasswordPolicyService(db), mail, "http://localhost", []byte("another-app-key-0123456789abcdefg"))
+	if _, err := otherKey.FindPendingInvitation(firstToken); err != ErrInvalidInvitation {
+		t.Errorf("A link signed with another key should be refused, got %v", err)
+	}
+	if _, err := invitationService.FindPendingInvitation(firstToken); err != nil {
+		t.Errorf("FindPendingInvitation failed: %v", err)
+	}
+	
+	// Resending replaces the link.
+	if _, err := invitationService.Resend(admin, invitation.ID, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Resend failed: %v", err)
+	}
+	token := linkToken(mail.sent[1])
+	if _, err := invitationService.FindPendingInvitation(firstToken); err != ErrInvalidInvitation {
+		t.Errorf("The previous link should stop working, got %v", err)
+	}
+	
+	open, _ := invitationService.GetOpenInvitations()
+	if len(open) != 1 || open[0].User.ID != invited.ID || open[0].InvitedBy == nil || open[0].InvitedBy.ID != admin.ID {
+		t.Errorf("Expected the invitation to be listed, got %+v", open)
+	}
+	
+	if _, err := invitationService.Accept(token, "weak", "127.0.0.1", "test-agent"); err == nil {
+		t.Error("Accepting with a weak password should fail")
+	}
+	user, err := invitationService.Accept(token, "New!Passw0rd1", "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	db.First(&stored, user.ID)
+	if !stored.Enabled || !stored.CheckPassword("New!Passw0rd1") {
+		t.Error("Accepting should enable the account with the chosen password")
+	}
+	if _, err := invitationService.Accept(token, "Other!Passw0rd2", "127.0.0.1", "test-agent"); err != ErrInvalidInvitation {
+		t.Errorf("An invitation should only be accepted once, got %v", err)
+	}
+	if pending, _ := invitationService.HasOpenInvitation(invited.ID); pending {
+		t.Error("An accepted invitation should no longer be open")
+	}
+	if _, err := invitationService.Resend(admin, invitation.ID, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("An accepted invitation should not be resent, got %v", err)
+	}
+	
+	// Expired invitations cannot be accepted but can be resent.
+	expiring, err := invitationService.Invite(manager, &models.User{Email: "late@example.com", Name: "Late User", Role: models.RoleSalesperson}, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	db.Model(&models.Invitation{}).Where("id = ?", expiring.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, err := invitationService.Accept(linkToken(mail.sent[2]), "New!Passw0rd1", "127.0.0.1", "test-agent"); err != ErrInvalidInvitation {
+		t.Errorf("An expired invitation should be refused, got %v", err)
+	}
+	resent, err := invitationService.Resend(manager, expiring.ID, "127.0.0.1", "test-agent")
+	if err != nil || !resent.IsPending() {
+		t.Fatalf("Resending should renew an expired invitation: %v", err)
+	}
+	
+	// Revoking deletes the unused account softly, keeping the invitation.
+	if _, err := invitationService.Revoke(admin, expiring.ID, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	var count int64
+	db.Model(&models.User{}).Where("email = ?", "late@example.com").Count(&count)
+	if count != 0 {
+		t.Error("Revoking should delete the invited account")
+	}
+	var revokedUser models.User
+	if err := db.Unscoped().Where("email = ?", "late@example.com").First(&revokedUser).Error; err != nil || revokedUser.DeletedByID == nil || *revokedUser.DeletedByID != admin.ID {
+		t.Errorf("Revoking should archive the account like a deletion, got %+v, %v", revokedUser, err)
+	}
+	var revoked models.Invitation
+	if err := db.First(&revoked, expiring.ID).Error; err != nil || revoked.RevokedAt == nil || revoked.TokenDigest != nil {
+		t.Errorf("Revoking should keep the invitation as revoked, got %+v, %v", revoked, err)
+	}
+	if open, _ := invitationService.GetOpenInvitations(); len(open) != 0 {
+		t.Errorf("A revoked invitation should not be listed, got %d", len(open))
+	}
+	if _, err := invitationService.Revoke(admin, expiring.ID, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("An invitation should only be revoked once, got %v", err)
+	}
+	if _, err := invitationService.Accept(linkToken(mail.sent[3]), "New!Passw0rd1", "127.0.0.1", "test-agent"); err != ErrInvalidInvitation {
+		t.Errorf("A revoked invitation should be refused, got %v", err)
+	}
+	
+	for _, activityType := range []string{"user_crud", "invitation_accepted"} {
+		var activity models.UserActivity
+		if err := db.Where("activity_type = ?", activityType).First(&activity).Error; err != nil {
+			t.Errorf("Expected %s to be logged", activityType)
+		}
+	}
+}
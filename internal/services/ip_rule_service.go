@@ -0,0 +1,189 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIPNotAllowed   = errors.New("sign-in is not allowed from this network")
+	ErrIPRuleInvalid  = errors.New("invalid IP rule")
+	ErrIPRuleLocksOut = errors.New("the change would block your own access")
+)
+
+// IPRuleService decides which networks users may sign in from, based on
+// the allow and deny rules admins manage.
+type IPRuleService struct {
+	db              *gorm.DB
+	sessionService  *SessionService
+	activityService *ActivityService
+	// breakGlassEmail names one admin the rules never apply to, so a bad
+	// rule cannot lock everyone out.
+	breakGlassEmail string
+}
+
+func NewIPRuleService(db *gorm.DB, sessionService *SessionService, activityService *ActivityService, breakGlassEmail string) *IPRuleService {
+	return &IPRuleService{
+		db:              db,
+		sessionService:  sessionService,
+		activityService: activityService,
+		breakGlassEmail: normalizeEmail(breakGlassEmail),
+	}
+}
+
+func (s *IPRuleService) BreakGlassEmail() string {
+	return s.breakGlassEmail
+}
+
+func (s *IPRuleService) isBreakGlass(user *models.User) bool {
+	return s.breakGlassEmail != "" && user.Role == models.RoleAdmin && normalizeEmail(user.Email) == s.breakGlassEmail
+}
+
+func (s *IPRuleService) GetRules() ([]models.IPRule, error) {
+	var rules []models.IPRule
+	err := s.db.Preload("Company").Order("id").Find(&rules).Error
+	return rules, err
+}
+
+// CheckAccess returns ErrIPNotAllowed if the user may not use the app from
+// the address, and logs the refusal. stage says what was attempted, e.g.
+// "login" or "request".
+func (s *IPRuleService) CheckAccess(user *models.User, ipAddress, userAgent, stage string) error {
+	rules, err := s.GetRules()
+	if err != nil {
+		return err
+	}
+
+	allowed, refusedBy := models.CheckIPRules(rules, user, ipAddress)
+	if allowed {
+		return nil
+	}
+
+	metadata := map[string]interface{}{
+		"user_id": user.ID,
+		"stage":   stage,
+	}
+	if refusedBy != nil {
+		metadata["ip_rule_id"] = refusedBy.ID
+	}
+
+	if s.isBreakGlass(user) {
+		// Only sign-ins are logged; the override applies to every request
+		// that follows.
+		if stage == "login" {
+			s.activityService.LogActivity(&user.ID, "ip_rule_break_glass", ipAddress, userAgent, metadata)
+		}
+		return nil
+	}
+
+	s.activityService.LogActivity(&user.ID, "ip_rule_denied", ipAddress, userAgent, metadata)
+	return ErrIPNotAllowed
+}
+
+// CheckSession re-checks a signed-in session against the rules, for the
+// user and for the admin behind an impersonated session. A refused session
+// is ended together with its remembered device.
+func (s *IPRuleService) CheckSession(user, impersonator *models.User, session *models.Session, ipAddress, userAgent string) error {
+	err := s.CheckAccess(user, ipAddress, userAgent, "request")
+	if err == nil && impersonator != nil {
+		err = s.CheckAccess(impersonator, ipAddress, userAgent, "request")
+	}
+	if err == ErrIPNotAllowed {
+		s.sessionService.RevokeUserSession(session.UserID, session.ID)
+	}
+	return err
+}
+
+// CreateRule validates and stores a rule. It refuses rules that would stop
+// the admin creating them from reaching the app from where they are.
+func (s *IPRuleService) CreateRule(performingUser *models.User, rule *models.IPRule, ipAddress, userAgent string) error {
+	cidr, ok := models.ParseCIDR(rule.CIDR)
+	if !ok || (rule.Action != models.IPRuleAllow && rule.Action != models.IPRuleDeny) {
+		return ErrIPRuleInvalid
+	}
+	if rule.Role != nil && rule.Role.String() == "unknown" {
+		return ErrIPRuleInvalid
+	}
+	rule.CIDR = cidr
+	if rule.CompanyID != nil {
+		var company models.Company
+		if err := s.db.First(&company, *rule.CompanyID).Error; err != nil {
+			return ErrIPRuleInvalid
+		}
+		rule.Company = &company
+	}
+	rule.Description = strings.TrimSpace(rule.Description)
+	rule.CreatedByID = &performingUser.ID
+
+	rules, err := s.GetRules()
+	if err != nil {
+		return err
+	}
+	if err := s.checkNotLockedOut(performingUser, append(rules, *rule), ipAddress); err != nil {
+		return err
+	}
+
+	if err := s.db.Omit("Company").Create(rule).Error; err != nil {
+		return err
+	}
+
+	s.activityService.LogActivity(&performingUser.ID, "ip_rule_created", ipAddress, userAgent, map[string]interface{}{
+		"performed_by": performingUser.ID,
+		"ip_rule_id":   rule.ID,
+		"action":       rule.Action,
+		"cidr":         rule.CIDR,
+		"scope":        rule.Scope(),
+	})
+	return nil
+}
+
+// DeleteRule removes a rule. It returns gorm.ErrRecordNotFound if there is
+// no such rule.
+func (s *IPRuleService) DeleteRule(performingUser *models.User, ruleID uint, ipAddress, userAgent string) error {
+	rules, err := s.GetRules()
+	if err != nil {
+		return err
+	}
+
+	var deleted *models.IPRule
+	remaining := make([]models.IPRule, 0, len(rules))
+	for i := range rules {
+		if rules[i].ID == ruleID {
+			deleted = &rules[i]
+			continue
+		}
+		remaining = append(remaining, rules[i])
+	}
+	if deleted == nil {
+		return gorm.ErrRecordNotFound
+	}
+	if err := s.checkNotLockedOut(performingUser, remaining, ipAddress); err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(&models.IPRule{}, ruleID).Error; err != nil {
+		return err
+	}
+
+	s.activityService.LogActivity(&performingUser.ID, "ip_rule_deleted", ipAddress, userAgent, map[string]interface{}{
+		"performed_by": performingUser.ID,
+		"ip_rule_id":   deleted.ID,
+		"action":       deleted.Action,
+		"cidr":         deleted.CIDR,
+		"scope":        deleted.Scope(),
+	})
+	return nil
+}
+
+func (s *IPRuleService) checkNotLockedOut(user *models.User, rules []models.IPRule, ipAddress string) error {
+	if s.isBreakGlass(user) {
+		return nil
+	}
+	if allowed, _ := models.CheckIPRules(rules, user, ipAddress); !allowed {
+		return ErrIPRuleLocksOut
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"testing"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestIPRuleService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	ipRuleService := NewIPRuleService(db, sessionService, activityService, "Rescue@Example.com")
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), ipRuleService)
+	
+	company := &models.Company{Name: "Al Safwan Marine", DefaultRole: models.RoleSalesperson}
+	db.Create(company)
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	rescue := &models.User{Email: "rescue@example.com", Name: "Rescue", Role: models.RoleAdmin, Enabled: true}
+	sales := &models.User{Email: "sales@example.com", Name: "Sales", Role: models.RoleSalesperson, Enabled: true, CompanyID: &company.ID}
+	for _, user := range []*models.User{admin, rescue, sales} {
+		user.SetPassword("Secur3!Passw0rd")
+		db.Create(user)
+	}
+	login := func(email, ip string) (*LoginResult, error) {
+		return authService.Login(LoginCredentials{Email: email, Password: "Secur3!Passw0rd"}, ip, "test-agent")
+	}
+	
+	// Without rules everyone signs in from anywhere.
+	if _, err := login("sales@example.com", "203.0.113.5"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	
+	adminRole := models.RoleAdmin
+	if err := ipRuleService.CreateRule(admin, &models.IPRule{Action: models.IPRuleAllow, CIDR: "10.0.0.0/8", Role: &adminRole}, "203.0.113.5", "test-agent"); err != ErrIPRuleLocksOut {
+		t.Errorf("A rule blocking the admin's own address should be refused, got %v", err)
+	}
+	if err := ipRuleService.CreateRule(admin, &models.IPRule{Action: models.IPRuleAllow, CIDR: "office"}, "10.0.0.1", "test-agent"); err != ErrIPRuleInvalid {
+		t.Errorf("Expected an invalid network to be refused, got %v", err)
+	}
+	unknown := company.ID + 1
+	if err := ipRuleService.CreateRule(admin, &models.IPRule{Action: models.IPRuleDeny, CIDR: "10.0.0.0/8", CompanyID: &unknown}, "10.0.0.1", "test-agent"); err != ErrIPRuleInvalid {
+		t.Errorf("Expected an unknown company to be refused, got %v", err)
+	}
+	
+	allow := &models.IPRule{Action: models.IPRuleAllow, CIDR: "10.0.0.1", Role: &adminRole, Description: " Office "}
+	if err := ipRuleService.CreateRule(admin, allow, "10.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("CreateRule failed: %v", err)
+	}
+	if allow.CIDR != "10.0.0.1/32" || allow.Description != "Office" {
+		t.Errorf("Expected the rule to be normalised, got %+v", allow)
+	}
+	deny := &models.IPRule{Action: models.IPRuleDeny, CIDR: "203.0.113.0/24", CompanyID: &company.ID}
+	if err := ipRuleService.CreateRule(admin, deny, "10.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("CreateRule failed: %v", err)
+	}
+	if deny.Scope() != "Everyone at Al Safwan Marine" {
+		t.Errorf("Expected the rule to name its company, got %q", deny.Scope())
+	}
+	
+	if _, err := login("admin@example.com", "198.51.100.7"); err != ErrIPNotAllowed {
+		t.Errorf("Admins should only sign in from allowed networks, got %v", err)
+	}
+	var denied models.UserActivity
+	if err := db.Where("activity_type = ? AND user_id = ?", "ip_rule_denied", admin.ID).First(&denied).Error; err != nil {
+		t.Error("Expected the refused sign-in to be logged")
+	}
+	if _, err := login("admin@example.com", "10.0.0.1"); err != nil {
+		t.Errorf("Login from the allowed network failed: %v", err)
+	}
+	if _, err := login("sales@example.com", "203.0.113.5"); err != ErrIPNotAllowed {
+		t.Errorf("The company's deny rule should apply, got %v", err)
+	}
+	if _, err := login("sales@example.com", "198.51.100.7"); err != nil {
+		t.Errorf("Rules for admins should not affect salespeople: %v", err)
+	}
+	
+	// The break-glass admin is never refused, but the override is logged.
+	if _, err := login("rescue@example.com", "198.51.100.7"); err != nil {
+		t.Errorf("The break-glass admin should be allowed: %v", err)
+	}
+	var override models.UserActivity
+	if err := db.Where("activity_type = ? AND user_id = ?", "ip_rule_break_glass", rescue.ID).First(&override).Error; err != nil {
+		t.Error("Expected the break-glass sign-in to be logged")
+	}
+	
+	// Sessions are re-checked on each request.
+	result, err := login("admin@example.com", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if err := ipRuleService.CheckSession(admin, nil, result.Session, "10.0.0.1", "test-agent"); err != nil {
+		t.Errorf("The session should be allowed from the office: %v", err)
+	}
+	if err := ipRuleService.CheckSession(sales, admin, result.Session, "198.51.100.7", "test-agent"); err != ErrIPNotAllowed {
+		t.Errorf("The impersonating admin's rules should apply, got %v", err)
+	}
+	if authService.IsAuthenticated(result.Token) {
+		t.Error("A refused session should be revoked")
+	}
+	
+	if err := ipRuleService.DeleteRule(admin, allow.ID, "198.51.100.7", "test-agent"); err != nil {
+		t.Fatalf("DeleteRule failed: %v", err)
+	}
+	if err := ipRuleService.DeleteRule(admin, allow.ID, "198.51.100.7", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected a deleted rule to be missing, got %v", err)
+	}
+	if _, err := login("admin@example.com", "198.51.100.7"); err != nil {
+		t.Errorf("Login should succeed once the allow rule is gone: %v", err)
+	}
+	for _, activityType := range []string{"ip_rule_created", "ip_rule_deleted"} {
+		var activity models.UserActivity
+		if err := db.Where("activity_type = ? AND user_id = ?", activityType, admin.ID).First(&activity).Error; err != nil {
+			t.Errorf("Expected %s to be logged", activityType)
+		}
+	}
+}
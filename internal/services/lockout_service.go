@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+// AccountLockedError is returned by Login while an account is locked out.
+// It unwraps to ErrRateLimited.
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+func (e *AccountLockedError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// LockoutRemaining reports how long the account behind err stays locked.
+func LockoutRemaining(err error) (time.Duration, bool) {
+	var lockErr *AccountLockedError
+	if !errors.As(err, &lockErr) {
+		return 0, false
+	}
+	return time.Until(lockErr.Until), true
+}
+
+type LockoutService struct {
+	db *gorm.DB
+	activityService *ActivityService
+	policy          models.LockoutPolicy
+}
+
+func NewLockoutService(db *gorm.DB, activityService *ActivityService, policy models.LockoutPolicy) *LockoutService {
+	return &LockoutService{
+		db:             db,
+		activityService: activityService,
+		policy:          policy,
+	}
+}
+
+// LockoutDuration returns how long an account is locked after the given
+// number of consecutive failures. The first lock lasts the policy's base
+// duration and every further failure doubles it, up to its maximum.
+func (s *LockoutService) LockoutDuration(failures int) time.Duration {
+	if failures < s.policy.Threshold {
+		return 0
+	}
+
+	duration := s.policy.BaseDuration
+	for i := s.policy.Threshold; i < failures && duration < s.policy.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > s.policy.MaxDuration {
+		return s.policy.MaxDuration
+	}
+	return duration
+}
+
+// CheckLocked returns an AccountLockedError if the user is currently locked.
+func (s *LockoutService) CheckLocked(user *models.User) error {
+	if user.IsLocked() {
+		return &AccountLockedError{Until: *user.LockedUntil}
+	}
+	return nil
+}
+
+// RegisterFailure counts a failed sign-in and locks the account once the
+// threshold is reached.
+func (s *LockoutService) RegisterFailure(user *models.User, ipAddress, userAgent string) error {
+	err := s.db.Model(&models.User{}).Where("id = ?", user.ID).
+		UpdateColumn("failed_login_attempts", gorm.Expr("failed_login_attempts + 1")).Error
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Model(&models.User{}).Select("failed_login_attempts").
+		Where("id = ?", user.ID).Scan(&user.FailedLoginAttempts).Error; err != nil {
+		return err
+	}
+
+	duration := s.LockoutDuration(user.FailedLoginAttempts)
+	if duration == 0 {
+		return nil
+	}
+
+	lockedUntil := time.Now().Add(duration)
+	user.LockedUntil = &lockedUntil
+	if err := s.db.Model(&models.User{}).Where("id = ?", user.ID).
+		UpdateColumn("locked_until", lockedUntil).Error; err != nil {
+		return err
+	}
+
+	s.activityService.LogAccountLocked(user, lockedUntil, ipAddress, userAgent)
+
+	return nil
+}
+
+// Reset clears the failure count after a successful sign-in.
+func (s *LockoutService) Reset(user *models.User) error {
+	if user.FailedLoginAttempts == 0 && user.LockedUntil == nil {
+		return nil
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+	return s.db.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
+}
+
+// Unlock lets an admin clear a lockout before it expires.
+func (s *LockoutService) Unlock(admin, user *models.User, ipAddress, userAgent string) error {
+	if err := s.Reset(user); err != nil {
+		return err
+	}
+
+	s.activityService.LogUserCRUD(admin, user, "unlock", ipAddress, userAgent)
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	lockoutService := NewLockoutService(nil, nil, models.DefaultLockoutPolicy())
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{15, 1024 * time.Minute},
+		{16, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+	
+	for _, test := range tests {
+		if got := lockoutService.LockoutDuration(test.failures); got != test.expected {
+			t.Errorf("LockoutDuration(%d) = %v, want %v", test.failures, got, test.expected)
+		}
+	}
+	
+	lockoutService = NewLockoutService(nil, nil, models.LockoutPolicy{Threshold: 3, BaseDuration: 10 * time.Minute, MaxDuration: time.Hour})
+	for failures, expected := range map[int]time.Duration{2: 0, 3: 10 * time.Minute, 4: 20 * time.Minute, 6: time.Hour} {
+		if got := lockoutService.LockoutDuration(failures); got != expected {
+			t.Errorf("LockoutDuration(%d) with a custom policy = %v, want %v", failures, got, expected)
+		}
+	}
+}
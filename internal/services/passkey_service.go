@@ -0,0 +1,276 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/webauthn"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPasskeyChallengeInvalid = errors.New("passkey request is invalid or has expired")
+	ErrPasskeyNotFound         = errors.New("passkey is not registered")
+	ErrPasskeyExists           = errors.New("passkey is already registered")
+	ErrPasskeyNameInvalid      = errors.New("passkey name must be at most 100 characters")
+)
+
+type PasskeyService struct {
+	db              *gorm.DB
+	authService     *AuthService
+	activityService *ActivityService
+	relyingParty    *webauthn.RelyingParty
+}
+
+func NewPasskeyService(db *gorm.DB, authService *AuthService, activityService *ActivityService, config webauthn.Config) *PasskeyService {
+	return &PasskeyService{
+		db:              db,
+		authService:     authService,
+		activityService: activityService,
+		relyingParty:    webauthn.New(config),
+	}
+}
+
+// BeginRegistration issues the options for adding a passkey to the user's
+// account. Passkeys the user already has are excluded.
+func (s *PasskeyService) BeginRegistration(user *models.User) (*webauthn.CreationOptions, error) {
+	challenge, err := s.createChallenge(models.PasskeyChallengeRegistration, &user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := s.GetUserPasskeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	exclude := make([]webauthn.CredentialDescriptor, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		exclude = append(exclude, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         passkey.CredentialID,
+			Transports: passkey.TransportList(),
+		})
+	}
+
+	return s.relyingParty.CreationOptions(challenge, models.PasskeyUserHandle(user.ID), user.Email, user.Name, exclude), nil
+}
+
+// FinishRegistration verifies the authenticator's response and stores the
+// new passkey under the given name.
+func (s *PasskeyService) FinishRegistration(user *models.User, name string, response *webauthn.AttestationResponse, ipAddress, userAgent string) (*models.Passkey, error) {
+	name = strings.TrimSpace(name)
+	if len(name) > 100 {
+		return nil, ErrPasskeyNameInvalid
+	}
+	if name == "" {
+		name = "Passkey added " + time.Now().Format("Jan 2, 2006")
+	}
+
+	challenge, err := s.consumeChallenge(response.Response.ClientDataJSON, models.PasskeyChallengeRegistration, &user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.relyingParty.VerifyRegistration(response, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID := webauthn.Encoding.EncodeToString(credential.ID)
+	var existing int64
+	s.db.Model(&models.Passkey{}).Where("credential_id = ?", credentialID).Count(&existing)
+	if existing > 0 {
+		return nil, ErrPasskeyExists
+	}
+
+	passkey := &models.Passkey{
+		UserID:       user.ID,
+		Name:         name,
+		CredentialID: credentialID,
+		PublicKey:    credential.PublicKey,
+		SignCount:    credential.SignCount,
+		AAGUID:       formatAAGUID(credential.AAGUID),
+		Transports:   strings.Join(credential.Transports, ","),
+	}
+	if err := s.db.Create(passkey).Error; err != nil {
+		return nil, err
+	}
+
+	s.activityService.LogActivity(&user.ID, "passkey_registered", ipAddress, userAgent, map[string]interface{}{
+		"user_id":      user.ID,
+		"passkey_id":   passkey.ID,
+		"passkey_name": passkey.Name,
+	})
+
+	return passkey, nil
+}
+
+// BeginLogin issues the options for a passwordless sign-in.
+func (s *PasskeyService) BeginLogin() (*webauthn.RequestOptions, error) {
+	challenge, err := s.createChallenge(models.PasskeyChallengeLogin, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.relyingParty.RequestOptions(challenge), nil
+}
+
+// FinishLogin verifies a passkey assertion and starts a session. Passkeys
+// require user verification, so no TOTP step follows.
+func (s *PasskeyService) FinishLogin(response *webauthn.AssertionResponse, ipAddress, userAgent string) (*LoginResult, error) {
+	challenge, err := s.consumeChallenge(response.Response.ClientDataJSON, models.PasskeyChallengeLogin, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var passkey models.Passkey
+	if err := s.db.Preload("User").Where("credential_id = ?", response.ID).First(&passkey).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrPasskeyNotFound
+		}
+		return nil, err
+	}
+	if passkey.User.ID == 0 {
+		// The user has been deleted.
+		return nil, ErrPasskeyNotFound
+	}
+
+	if response.Response.UserHandle != "" {
+		userHandle, err := webauthn.Encoding.DecodeString(response.Response.UserHandle)
+		if err != nil || string(userHandle) != string(models.PasskeyUserHandle(passkey.UserID)) {
+			return nil, ErrPasskeyNotFound
+		}
+	}
+
+	signCount, err := s.relyingParty.VerifyAssertion(response, challenge, passkey.PublicKey, passkey.SignCount)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCount) {
+			s.activityService.LogActivity(&passkey.UserID, "passkey_clone_suspected", ipAddress, userAgent, map[string]interface{}{
+				"user_id":      passkey.UserID,
+				"passkey_id":   passkey.ID,
+				"passkey_name": passkey.Name,
+			})
+		} else {
+			s.activityService.LogFailedLogin(&passkey.UserID, passkey.User.Email, ipAddress, userAgent)
+		}
+		return nil, err
+	}
+
+	// Only advance the counter from the value that was checked, so two
+	// concurrent sign-ins with the same assertion cannot both succeed.
+	now := time.Now()
+	result := s.db.Model(&models.Passkey{}).
+		Where("id = ? AND sign_count = ?", passkey.ID, passkey.SignCount).
+		Updates(map[string]interface{}{"sign_count": signCount, "last_used_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, webauthn.ErrSignCount
+	}
+
+	s.activityService.LogActivity(&passkey.UserID, "passkey_login", ipAddress, userAgent, map[string]interface{}{
+		"user_id":      passkey.UserID,
+		"passkey_id":   passkey.ID,
+		"passkey_name": passkey.Name,
+	})
+
+	user := passkey.User
+	return s.authService.LoginWithPasskey(&user, ipAddress, userAgent)
+}
+
+func (s *PasskeyService) GetUserPasskeys(userID uint) ([]models.Passkey, error) {
+	var passkeys []models.Passkey
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&passkeys).Error
+	return passkeys, err
+}
+
+// DeletePasskey removes one of the user's passkeys. It returns
+// gorm.ErrRecordNotFound if the passkey does not belong to the user.
+func (s *PasskeyService) DeletePasskey(user *models.User, passkeyID uint, ipAddress, userAgent string) error {
+	var passkey models.Passkey
+	if err := s.db.Where("id = ? AND user_id = ?", passkeyID, user.ID).First(&passkey).Error; err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(&passkey).Error; err != nil {
+		return err
+	}
+
+	s.activityService.LogActivity(&user.ID, "passkey_removed", ipAddress, userAgent, map[string]interface{}{
+		"user_id":      user.ID,
+		"passkey_id":   passkey.ID,
+		"passkey_name": passkey.Name,
+	})
+	return nil
+}
+
+func (s *PasskeyService) CleanupExpiredChallenges() error {
+	return s.db.Where("expires_at < ?", time.Now()).Delete(&models.PasskeyChallenge{}).Error
+}
+
+func (s *PasskeyService) createChallenge(purpose string, userID *uint) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.PasskeyChallenge{
+		ChallengeDigest: models.HashToken(string(challenge)),
+		Purpose:         purpose,
+		UserID:          userID,
+		ExpiresAt:       time.Now().Add(webauthn.Timeout),
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// consumeChallenge finds the challenge a response was made for and deletes
+// it, so every challenge is used at most once.
+func (s *PasskeyService) consumeChallenge(clientDataJSON, purpose string, userID *uint) ([]byte, error) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var record models.PasskeyChallenge
+	if err := s.db.Where("challenge_digest = ? AND purpose = ?", models.HashToken(string(challenge)), purpose).First(&record).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrPasskeyChallengeInvalid
+		}
+		return nil, err
+	}
+
+	result := s.db.Where("id = ?", record.ID).Delete(&models.PasskeyChallenge{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || record.IsExpired() {
+		return nil, ErrPasskeyChallengeInvalid
+	}
+
+	if userID != nil && (record.UserID == nil || *record.UserID != *userID) {
+		return nil, ErrPasskeyChallengeInvalid
+	}
+
+	return challenge, nil
+}
+
+func formatAAGUID(aaguid []byte) string {
+	if len(aaguid) != 16 {
+		return ""
+	}
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	for i, c := range aaguid {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&0x0f])
+	}
+	return b.String()
+}
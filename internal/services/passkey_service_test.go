@@ -0,0 +1,130 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/webauthn"
+	"alsafwanmarine.com/todo-app/internal/webauthn/webauthntest"
+	"gorm.io/gorm"
+)
+
+func TestPasskeyService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, NewLockoutService(db, activityService, models.DefaultLockoutPolicy()), NewPasswordPolicyService(db), NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	passkeyService := NewPasskeyService(db, authService, activityService, webauthn.Config{
+		RPID:   "localhost",
+		RPName: "ASM Tracker",
+		Origin: "http://localhost:8001",
+	})
+	authenticator := webauthntest.New("localhost", "http://localhost:8001")
+	
+	user := &models.User{Email: "test@example.com", Name: "Test User", Role: models.RoleSalesperson, Enabled: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	options, err := passkeyService.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration failed: %v", err)
+	}
+	attestation, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Authenticator failed to create a passkey: %v", err)
+	}
+	passkey, err := passkeyService.FinishRegistration(user, "Laptop", attestation, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("FinishRegistration failed: %v", err)
+	}
+	if passkey.CredentialID != attestation.ID || passkey.Transports != "internal" {
+		t.Errorf("Unexpected passkey %+v", passkey)
+	}
+	
+	// Each registration challenge can only be used once.
+	if _, err := passkeyService.FinishRegistration(user, "Laptop", attestation, "127.0.0.1", "test-agent"); err != ErrPasskeyChallengeInvalid {
+		t.Errorf("Expected ErrPasskeyChallengeInvalid for a reused challenge, got %v", err)
+	}
+	
+	// A challenge issued to one user cannot register a passkey for another.
+	other := &models.User{Email: "other@example.com", Name: "Other User", Role: models.RoleSalesperson, Enabled: true}
+	db.Create(other)
+	options, _ = passkeyService.BeginRegistration(user)
+	if len(options.ExcludeCredentials) != 1 || options.ExcludeCredentials[0].ID != passkey.CredentialID {
+		t.Errorf("Registered passkeys should be excluded, got %+v", options.ExcludeCredentials)
+	}
+	attestation, _ = webauthntest.New("localhost", "http://localhost:8001").Create(options)
+	if _, err := passkeyService.FinishRegistration(other, "Stolen", attestation, "127.0.0.1", "test-agent"); err != ErrPasskeyChallengeInvalid {
+		t.Errorf("Expected ErrPasskeyChallengeInvalid for another user's challenge, got %v", err)
+	}
+	
+	// Passwordless sign-in creates a session.
+	requestOptions, err := passkeyService.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin failed: %v", err)
+	}
+	assertion, err := authenticator.Get(requestOptions)
+	if err != nil {
+		t.Fatalf("Authenticator failed to sign in: %v", err)
+	}
+	result, err := passkeyService.FinishLogin(assertion, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("FinishLogin failed: %v", err)
+	}
+	if result.Token == "" || result.User.ID != user.ID {
+		t.Fatalf("Expected a session for user %d, got %+v", user.ID, result)
+	}
+	if session, _ := sessionService.GetSessionByToken(result.Token); session == nil {
+		t.Error("FinishLogin should create a session")
+	}
+	
+	var stored models.Passkey
+	db.First(&stored, passkey.ID)
+	if stored.SignCount != 1 || stored.LastUsedAt == nil {
+		t.Errorf("Expected sign count 1 and a last used time, got %d and %v", stored.SignCount, stored.LastUsedAt)
+	}
+	
+	// The same assertion cannot be replayed.
+	if _, err := passkeyService.FinishLogin(assertion, "127.0.0.1", "test-agent"); err != ErrPasskeyChallengeInvalid {
+		t.Errorf("Expected ErrPasskeyChallengeInvalid for a replayed assertion, got %v", err)
+	}
+	
+	// A counter that goes backwards points to a cloned authenticator.
+	authenticator.SetSignCount(0)
+	requestOptions, _ = passkeyService.BeginLogin()
+	assertion, _ = authenticator.Get(requestOptions)
+	if _, err := passkeyService.FinishLogin(assertion, "127.0.0.1", "test-agent"); !errors.Is(err, webauthn.ErrSignCount) {
+		t.Errorf("Expected webauthn.ErrSignCount for a stale counter, got %v", err)
+	}
+	var cloneAlerts int64
+	db.Model(&models.UserActivity{}).Where("user_id = ? AND activity_type = ?", user.ID, "passkey_clone_suspected").Count(&cloneAlerts)
+	if cloneAlerts != 1 {
+		t.Errorf("Expected the suspected clone to be logged, got %d entries", cloneAlerts)
+	}
+	
+	// Disabled users cannot sign in with a passkey.
+	authenticator.SetSignCount(10)
+	db.Model(user).Update("enabled", false)
+	requestOptions, _ = passkeyService.BeginLogin()
+	assertion, _ = authenticator.Get(requestOptions)
+	if _, err := passkeyService.FinishLogin(assertion, "127.0.0.1", "test-agent"); err != ErrUserDisabled {
+		t.Errorf("Expected ErrUserDisabled, got %v", err)
+	}
+	db.Model(user).Update("enabled", true)
+	
+	if err := passkeyService.DeletePasskey(other, passkey.ID, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("Deleting another user's passkey should fail with ErrRecordNotFound, got %v", err)
+	}
+	if err := passkeyService.DeletePasskey(user, passkey.ID, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("DeletePasskey failed: %v", err)
+	}
+	requestOptions, _ = passkeyService.BeginLogin()
+	assertion, _ = authenticator.Get(requestOptions)
+	if _, err := passkeyService.FinishLogin(assertion, "127.0.0.1", "test-agent"); err != ErrPasskeyNotFound {
+		t.Errorf("Expected ErrPasskeyNotFound for a removed passkey, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+type PasswordPolicyService struct {
+	db *gorm.DB
+}
+
+func NewPasswordPolicyService(db *gorm.DB) *PasswordPolicyService {
+	return &PasswordPolicyService{db: db}
+}
+
+// CheckHistory returns models.ErrPasswordReused if the password matches the
+// user's current password or one of their recent ones.
+func (s *PasswordPolicyService) CheckHistory(user *models.User, password string) error {
+	historySize := models.GetPasswordPolicy().HistorySize
+	if historySize <= 0 {
+		return nil
+	}
+
+	if user.PasswordDigest != "" && user.CheckPassword(password) {
+		return models.ErrPasswordReused
+	}
+
+	if user.ID == 0 || historySize == 1 {
+		return nil
+	}
+
+	var history []models.PasswordHistory
+	if err := s.db.Where("user_id = ?", user.ID).
+		Order("created_at DESC, id DESC").
+		Limit(historySize - 1).
+		Find(&history).Error; err != nil {
+		return err
+	}
+
+	for _, entry := range history {
+		previous := models.User{PasswordDigest: entry.PasswordDigest}
+		if previous.CheckPassword(password) {
+			return models.ErrPasswordReused
+		}
+	}
+
+	return nil
+}
+
+// UpdatePassword applies the password policy and history check, then saves
+// the new password and moves the old one into the history.
+func (s *PasswordPolicyService) UpdatePassword(user *models.User, password string) error {
+	if err := models.GetPasswordPolicy().Validate(password, user); err != nil {
+		return err
+	}
+
+	if err := s.CheckHistory(user, password); err != nil {
+		return err
+	}
+
+	previousDigest := user.PasswordDigest
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+
+		historySize := models.GetPasswordPolicy().HistorySize
+		if previousDigest == "" || historySize <= 1 {
+			return nil
+		}
+
+		if err := tx.Create(&models.PasswordHistory{
+			UserID:         user.ID,
+			PasswordDigest: previousDigest,
+		}).Error; err != nil {
+			return err
+		}
+
+		// The current password counts towards the history, so only the
+		// previous HistorySize-1 digests need to be kept.
+		keep := tx.Model(&models.PasswordHistory{}).Select("id").
+			Where("user_id = ?", user.ID).
+			Order("created_at DESC, id DESC").
+			Limit(historySize - 1)
+		return tx.Where("user_id = ? AND id NOT IN (?)", user.ID, keep).
+			Delete(&models.PasswordHistory{}).Error
+	})
+}
@@ -1,32 +1,53 @@
 package services
 
 import (
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 
+	"alsafwanmarine.com/todo-app/internal/mailer"
 	"alsafwanmarine.com/todo-app/internal/models"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	ErrInvalidRevealLink = errors.New("the password has already been viewed or the link has expired")
+)
+
+const resetTokenTTL = 24 * time.Hour
+
 type PasswordResetService struct {
 	db *gorm.DB
+	sessionService *SessionService
 	activityService *ActivityService
+	passwordPolicyService *PasswordPolicyService
+	mailer mailer.Mailer
+	baseURL string
 }
 
-func NewPasswordResetService(db *gorm.DB, activityService *ActivityService) *PasswordResetService {
+func NewPasswordResetService(db *gorm.DB, sessionService *SessionService, activityService *ActivityService, passwordPolicyService *PasswordPolicyService, mailer mailer.Mailer, baseURL string) *PasswordResetService {
 	return &PasswordResetService{
 		db:             db,
+		sessionService: sessionService,
 		activityService: activityService,
+		passwordPolicyService: passwordPolicyService,
+		mailer:         mailer,
+		baseURL:        baseURL,
 	}
 }
 
-func (s *PasswordResetService) CreateResetEvent(userID uint, adminID *uint, reason string, resetType models.ResetType, ipAddress, userAgent string) (*models.PasswordResetEvent, error) {
+// CreateResetEvent records a pending reset and returns it together with the
+// token to send to the user. Only the token's digest is stored.
+func (s *PasswordResetService) CreateResetEvent(userID uint, adminID *uint, reason string, resetType models.ResetType, ipAddress, userAgent string) (*models.PasswordResetEvent, string, error) {
 	token, err := models.GenerateSecureToken()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	digest := models.HashToken(token)
 	
-	expiresAt := time.Now().Add(24 * time.Hour)
+	expiresAt := time.Now().Add(resetTokenTTL)
 	
 	resetEvent := &models.PasswordResetEvent{
 		UserID:    userID,
@@ -36,70 +57,272 @@ func (s *PasswordResetService) CreateResetEvent(userID uint, adminID *uint, reas
 		UserAgent: userAgent,
 		Success:   false,
 		ResetType: resetType,
-		Token:     &token,
+		TokenDigest: &digest,
 		ExpiresAt: &expiresAt,
 	}
 	
 	if err := s.db.Create(resetEvent).Error; err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
-	return resetEvent, nil
+	return resetEvent, token, nil
 }
 
-func (s *PasswordResetService) ResetPasswordWithToken(token, newPassword string) error {
-	var resetEvent models.PasswordResetEvent
-	if err := s.db.Where("token = ? AND expires_at > ?", token, time.Now()).First(&resetEvent).Error; err != nil {
+// RequestReset emails a reset link to the account with the given address.
+// Unknown and disabled accounts are silently ignored so callers can give
+// the same answer either way.
+func (s *PasswordResetService) RequestReset(email, ipAddress, userAgent string) error {
+	var user models.User
+	if err := s.db.Where("email = ? AND enabled = ?", normalizeEmail(email), true).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return errors.New("invalid or expired reset token")
+			return nil
 		}
 		return err
 	}
 	
-	var user models.User
-	if err := s.db.First(&user, resetEvent.UserID).Error; err != nil {
+	// Only the most recent link stays valid.
+	if err := s.db.Model(&models.PasswordResetEvent{}).
+		Where("user_id = ? AND success = ? AND token_digest IS NOT NULL", user.ID, false).
+		Update("token_digest", nil).Error; err != nil {
 		return err
 	}
 	
-	if err := models.ValidatePassword(newPassword); err != nil {
+	_, token, err := s.CreateResetEvent(user.ID, nil, "User requested password reset", models.ResetTypeSelfService, ipAddress, userAgent)
+	if err != nil {
+		return err
+	}
+	
+	link := fmt.Sprintf("%s/reset-password/%s", s.baseURL, token)
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your ASM Tracker password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"We received a request to reset the password for your ASM Tracker account.\n"+
+			"Open the link below to choose a new password:\n\n%s\n\n"+
+			"The link expires in 24 hours and can only be used once. If you did not ask for a reset, you can ignore this email.\n",
+			user.Name, link),
+	})
+}
+
+// FindValidResetEvent returns the unused, unexpired reset event for token
+// along with its user.
+func (s *PasswordResetService) FindValidResetEvent(token string) (*models.PasswordResetEvent, error) {
+	if token == "" {
+		return nil, ErrInvalidResetToken
+	}
+	
+	var resetEvent models.PasswordResetEvent
+	if err := s.db.Preload("User").
+		Where("token_digest = ? AND success = ? AND expires_at > ?", models.HashToken(token), false, time.Now()).
+		First(&resetEvent).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInvalidResetToken
+		}
+		return nil, err
+	}
+	
+	if !resetEvent.User.Enabled {
+		return nil, ErrInvalidResetToken
+	}
+	
+	return &resetEvent, nil
+}
+
+func (s *PasswordResetService) ResetPasswordWithToken(token, newPassword, ipAddress, userAgent string) error {
+	resetEvent, err := s.FindValidResetEvent(token)
+	if err != nil {
 		return err
 	}
 	
-	if err := user.SetPassword(newPassword); err != nil {
+	user := resetEvent.User
+	
+	// Check the password before using up the token so a rejected password
+	// can be corrected with the same link.
+	if err := models.GetPasswordPolicy().Validate(newPassword, &user); err != nil {
+		return err
+	}
+	if err := s.passwordPolicyService.CheckHistory(&user, newPassword); err != nil {
 		return err
 	}
 	
-	if err := s.db.Save(&user).Error; err != nil {
+	// Claim the token first so it cannot be used twice.
+	result := s.db.Model(&models.PasswordResetEvent{}).
+		Where("id = ? AND success = ?", resetEvent.ID, false).
+		Updates(map[string]interface{}{
+			"success":      true,
+			"token_digest": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidResetToken
+	}
+	
+	if err := s.passwordPolicyService.UpdatePassword(&user, newPassword); err != nil {
 		return err
 	}
 	
-	resetEvent.Success = true
-	if err := s.db.Save(&resetEvent).Error; err != nil {
+	if _, err := s.sessionService.DestroyUserSessions(user.ID); err != nil {
 		return err
 	}
 	
-	s.activityService.LogPasswordChange(&user, "", "")
+	s.activityService.LogPasswordChange(&user, ipAddress, userAgent)
 	
 	return nil
 }
 
-func (s *PasswordResetService) ManualReset(userID uint, adminID uint, reason, ipAddress, userAgent string) (string, error) {
+// ManualResetWithReveal gives the user a generated password they must
+// change at their next sign-in. The password is not returned; instead the
+// token lets the admin view it once with RevealPassword. The token stops
+// working after it is used or after models.PasswordRevealTTL.
+func (s *PasswordResetService) ManualResetWithReveal(userID uint, adminID uint, reason, ipAddress, userAgent string) (*models.PasswordResetEvent, string, error) {
+	resetEvent, newPassword, err := s.manualReset(userID, adminID, reason, ipAddress, userAgent)
+	if err != nil {
+		return nil, "", err
+	}
+	
+	token, err := models.GenerateSecureToken()
+	if err != nil {
+		return nil, "", err
+	}
+	sealed, err := sealRevealSecret(token, newPassword)
+	if err != nil {
+		return nil, "", err
+	}
+	digest := models.HashToken(token)
+	expiresAt := time.Now().Add(models.PasswordRevealTTL)
+	
+	resetEvent.RevealDigest = &digest
+	resetEvent.RevealSecret = &sealed
+	resetEvent.RevealExpiresAt = &expiresAt
+	if err := s.db.Model(&models.PasswordResetEvent{}).Where("id = ?", resetEvent.ID).Updates(map[string]interface{}{
+		"reveal_digest":     digest,
+		"reveal_secret":     sealed,
+		"reveal_expires_at": expiresAt,
+	}).Error; err != nil {
+		return nil, "", err
+	}
+	
+	return resetEvent, token, nil
+}
+
+// FindReveal returns the reset event whose temporary password the admin can
+// still view with token, along with its user.
+func (s *PasswordResetService) FindReveal(admin *models.User, token string) (*models.PasswordResetEvent, error) {
+	if token == "" {
+		return nil, ErrInvalidRevealLink
+	}
+	
+	var resetEvent models.PasswordResetEvent
+	if err := s.db.Preload("User").
+		Where("reveal_digest = ? AND admin_id = ? AND reveal_secret IS NOT NULL AND reveal_expires_at > ?", models.HashToken(token), admin.ID, time.Now()).
+		First(&resetEvent).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInvalidRevealLink
+		}
+		return nil, err
+	}
+	
+	return &resetEvent, nil
+}
+
+// RevealPassword returns the temporary password behind token once, and
+// records that it was viewed.
+func (s *PasswordResetService) RevealPassword(admin *models.User, token, ipAddress, userAgent string) (string, *models.PasswordResetEvent, error) {
+	resetEvent, err := s.FindReveal(admin, token)
+	if err != nil {
+		return "", nil, err
+	}
+	
+	password, err := openRevealSecret(token, *resetEvent.RevealSecret)
+	if err != nil {
+		return "", nil, ErrInvalidRevealLink
+	}
+	
+	// Claim the secret so it cannot be shown twice.
+	now := time.Now()
+	result := s.db.Model(&models.PasswordResetEvent{}).
+		Where("id = ? AND reveal_secret IS NOT NULL", resetEvent.ID).
+		Updates(map[string]interface{}{
+			"reveal_digest":    nil,
+			"reveal_secret":    nil,
+			"secret_viewed_at": now,
+		})
+	if result.Error != nil {
+		return "", nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", nil, ErrInvalidRevealLink
+	}
+	resetEvent.RevealDigest = nil
+	resetEvent.RevealSecret = nil
+	resetEvent.SecretViewedAt = &now
+	
+	s.activityService.LogActivity(&admin.ID, "password_reset_revealed", ipAddress, userAgent, map[string]interface{}{
+		"performing_user_id":      admin.ID,
+		"target_user_id":          resetEvent.UserID,
+		"password_reset_event_id": resetEvent.ID,
+	})
+	
+	return password, resetEvent, nil
+}
+
+// ClearExpiredReveals drops temporary passwords whose reveal link expired
+// without being opened.
+func (s *PasswordResetService) ClearExpiredReveals() error {
+	return s.db.Model(&models.PasswordResetEvent{}).
+		Where("reveal_secret IS NOT NULL AND reveal_expires_at <= ?", time.Now()).
+		Updates(map[string]interface{}{
+			"reveal_digest": nil,
+			"reveal_secret": nil,
+		}).Error
+}
+
+// sealRevealSecret encrypts secret with the reveal token, so the stored
+// value is useless without the token.
+func sealRevealSecret(token, secret string) (string, error) {
+	key, err := hex.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidRevealLink
+	}
+	return models.SealSecret(key, secret)
+}
+
+func openRevealSecret(token, sealed string) (string, error) {
+	key, err := hex.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidRevealLink
+	}
+	return models.OpenSecret(key, sealed)
+}
+
+// manualReset gives the user a generated password they must change at their
+// next sign-in, and records the reset.
+func (s *PasswordResetService) manualReset(userID uint, adminID uint, reason, ipAddress, userAgent string) (*models.PasswordResetEvent, string, error) {
 	var user models.User
 	if err := s.db.First(&user, userID).Error; err != nil {
-		return "", err
+		return nil, "", err
 	}
 	
 	newPassword, err := models.GenerateStrongPassword()
 	if err != nil {
-		return "", err
+		return nil, "", err
+	}
+	
+	if err := s.passwordPolicyService.UpdatePassword(&user, newPassword); err != nil {
+		return nil, "", err
 	}
 	
-	if err := user.SetPassword(newPassword); err != nil {
-		return "", err
+	if _, err := s.sessionService.DestroyUserSessions(user.ID); err != nil {
+		return nil, "", err
 	}
 	
-	if err := s.db.Save(&user).Error; err != nil {
-		return "", err
+	// The generated password is temporary; the user has to replace it
+	// before they can do anything else.
+	user.MustChangePassword = true
+	if err := s.db.Model(&user).Update("must_change_password", true).Error; err != nil {
+		return nil, "", err
 	}
 	
 	resetEvent := &models.PasswordResetEvent{
@@ -113,46 +336,14 @@ func (s *PasswordResetService) ManualReset(userID uint, adminID uint, reason, ip
 	}
 	
 	if err := s.db.Create(resetEvent).Error; err != nil {
-		return "", err
+		return nil, "", err
 	}
 	
 	var admin models.User
 	s.db.First(&admin, adminID)
 	s.activityService.LogUserCRUD(&admin, &user, "password_reset", ipAddress, userAgent)
 	
-	return newPassword, nil
-}
-
-func (s *PasswordResetService) AutoResetExpiredPasswords() error {
-	var users []models.User
-	if err := s.db.Where("password_expires_at IS NOT NULL AND password_expires_at < ?", time.Now()).Find(&users).Error; err != nil {
-		return err
-	}
-	
-	for _, user := range users {
-		newPassword, err := models.GenerateStrongPassword()
-		if err != nil {
-			continue
-		}
-		
-		if err := user.SetPassword(newPassword); err != nil {
-			continue
-		}
-		
-		if err := s.db.Save(&user).Error; err != nil {
-			continue
-		}
-		
-		resetEvent := &models.PasswordResetEvent{
-			UserID:    user.ID,
-			Reason:    "Password expired automatically",
-			Success:   true,
-			ResetType: models.ResetTypeAutomaticExpiry,
-		}
-		s.db.Create(resetEvent)
-	}
-	
-	return nil
+	return resetEvent, newPassword, nil
 }
 
 func (s *PasswordResetService) AutoResetInactiveUsers() error {
@@ -169,13 +360,10 @@ func (s *PasswordResetService) AutoResetInactiveUsers() error {
 			continue
 		}
 		
-		if err := user.SetPassword(newPassword); err != nil {
-			continue
-		}
-		
-		if err := s.db.Save(&user).Error; err != nil {
+		if err := s.passwordPolicyService.UpdatePassword(&user, newPassword); err != nil {
 			continue
 		}
+		s.sessionService.DestroyUserSessions(user.ID)
 		
 		resetEvent := &models.PasswordResetEvent{
 			UserID:    user.ID,
@@ -189,15 +377,25 @@ func (s *PasswordResetService) AutoResetInactiveUsers() error {
 	return nil
 }
 
-func (s *PasswordResetService) BulkResetPasswords(userIDs []uint, adminID uint, reason, ipAddress, userAgent string) (map[uint]string, error) {
-	results := make(map[uint]string)
+// PasswordReveal is what an admin gets back from a reset instead of the
+// temporary password: a token to view it once with RevealPassword.
+type PasswordReveal struct {
+	Token     string    `json:"reveal_token"`
+	ExpiresAt time.Time `json:"reveal_expires_at"`
+}
+
+// BulkResetPasswords resets each user's password with ManualResetWithReveal
+// and returns the reveal tokens by user ID. Users that could not be reset
+// are left out.
+func (s *PasswordResetService) BulkResetPasswords(userIDs []uint, adminID uint, reason, ipAddress, userAgent string) (map[uint]PasswordReveal, error) {
+	results := make(map[uint]PasswordReveal)
 	
 	for _, userID := range userIDs {
-		newPassword, err := s.ManualReset(userID, adminID, reason, ipAddress, userAgent)
+		resetEvent, token, err := s.ManualResetWithReveal(userID, adminID, reason, ipAddress, userAgent)
 		if err != nil {
 			continue
 		}
-		results[userID] = newPassword
+		results[userID] = PasswordReveal{Token: token, ExpiresAt: *resetEvent.RevealExpiresAt}
 	}
 	
 	return results, nil
@@ -214,7 +412,7 @@ func (s *PasswordResetService) GetResetEvents(userID uint) ([]models.PasswordRes
 
 func (s *PasswordResetService) GetAllResetEvents(limit int) ([]models.PasswordResetEvent, error) {
 	var events []models.PasswordResetEvent
-	query := s.db.Preload("User").Preload("Admin").Order("created_at DESC")
+	query := s.db.Preload("User", withArchivedUsers).Preload("Admin", withArchivedUsers).Order("created_at DESC")
 	
 	if limit > 0 {
 		query = query.Limit(limit)
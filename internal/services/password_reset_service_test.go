@@ -0,0 +1,159 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+func TestPasswordResetServiceRequestReset(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	passwordPolicyService := NewPasswordPolicyService(db)
+	mail := &recordingMailer{}
+	passwordResetService := NewPasswordResetService(db, NewSessionService(db), activityService, passwordPolicyService, mail, "http://localhost")
+	
+	user := &models.User{
+		Email:   "test@example.com",
+		Name:    "Test User",
+		Role:    models.RoleSalesperson,
+		Enabled: true,
+	}
+	user.SetPassword("Old!Passw0rd1")
+	
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	// Unknown accounts are not an error and send nothing.
+	if err := passwordResetService.RequestReset("nobody@example.com", "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("RequestReset for an unknown email should not fail, got %v", err)
+	}
+	if len(mail.sent) != 0 {
+		t.Fatalf("Expected no email for an unknown account, got %d", len(mail.sent))
+	}
+	
+	if err := passwordResetService.RequestReset(" Test@Example.com ", "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("RequestReset failed: %v", err)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To != "test@example.com" {
+		t.Fatalf("Expected one email to test@example.com, got %+v", mail.sent)
+	}
+	
+	body := mail.sent[0].Body
+	start := strings.Index(body, "http://localhost/reset-password/")
+	if start < 0 {
+		t.Fatalf("Email does not contain a reset link: %q", body)
+	}
+	token := strings.Fields(body[start+len("http://localhost/reset-password/"):])[0]
+	
+	resetEvent, err := passwordResetService.FindValidResetEvent(token)
+	if err != nil {
+		t.Fatalf("Token from the email should be valid, got %v", err)
+	}
+	if resetEvent.TokenDigest == nil || *resetEvent.TokenDigest == token {
+		t.Error("Reset event should store the token's digest, not the token")
+	}
+	
+	if err := passwordResetService.ResetPasswordWithToken(token, "weak", "127.0.0.1", "test-agent"); err == nil {
+		t.Error("Reset with a weak password should fail")
+	}
+	
+	if err := passwordResetService.ResetPasswordWithToken(token, "New!Passw0rd1", "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Reset with a valid token failed: %v", err)
+	}
+	
+	var updated models.User
+	db.First(&updated, user.ID)
+	if !updated.CheckPassword("New!Passw0rd1") {
+		t.Error("Password should have been changed")
+	}
+	
+	if err := passwordResetService.ResetPasswordWithToken(token, "Other!Passw0rd2", "127.0.0.1", "test-agent"); err != ErrInvalidResetToken {
+		t.Errorf("Reusing a token should fail with ErrInvalidResetToken, got %v", err)
+	}
+}
+
+func TestPasswordResetServiceReveal(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	passwordResetService := NewPasswordResetService(db, sessionService, activityService, NewPasswordPolicyService(db), &recordingMailer{}, "http://localhost")
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	other := &models.User{Email: "other@example.com", Name: "Other Admin", Role: models.RoleAdmin, Enabled: true}
+	user := &models.User{Email: "sales@example.com", Name: "Sales User", Role: models.RoleSalesperson, Enabled: true}
+	for _, u := range []*models.User{admin, other, user} {
+		u.SetPassword("Secur3!Passw0rd")
+		db.Create(u)
+	}
+	
+	resetEvent, token, err := passwordResetService.ManualResetWithReveal(user.ID, admin.ID, "Forgot password", "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("ManualResetWithReveal failed: %v", err)
+	}
+	
+	var stored models.PasswordResetEvent
+	db.First(&stored, resetEvent.ID)
+	if stored.RevealSecret == nil || stored.RevealDigest == nil || *stored.RevealDigest == token || stored.SecretViewed() {
+		t.Fatalf("Expected a sealed, unviewed secret, got %+v", stored)
+	}
+	var updated models.User
+	db.First(&updated, user.ID)
+	if !updated.MustChangePassword {
+		t.Error("The temporary password should have to be changed at the next sign-in")
+	}
+	if updated.CheckPassword(*stored.RevealSecret) {
+		t.Error("The stored secret should not be the password itself")
+	}
+	
+	if _, err := passwordResetService.FindReveal(other, token); err != ErrInvalidRevealLink {
+		t.Errorf("Only the admin who reset the password may view it, got %v", err)
+	}
+	
+	password, viewed, err := passwordResetService.RevealPassword(admin, token, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("RevealPassword failed: %v", err)
+	}
+	if !updated.CheckPassword(password) {
+		t.Error("The revealed password should be the user's new password")
+	}
+	if !viewed.SecretViewed() {
+		t.Error("The event should record that the secret was viewed")
+	}
+	
+	db.First(&stored, resetEvent.ID)
+	if stored.RevealSecret != nil || stored.SecretViewedAt == nil {
+		t.Errorf("The secret should be dropped once viewed, got %+v", stored)
+	}
+	if _, _, err := passwordResetService.RevealPassword(admin, token, "127.0.0.1", "test-agent"); err != ErrInvalidRevealLink {
+		t.Errorf("The password should only be shown once, got %v", err)
+	}
+	
+	// Unopened links expire.
+	expiring, token, err := passwordResetService.ManualResetWithReveal(user.ID, admin.ID, "Forgot again", "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("ManualResetWithReveal failed: %v", err)
+	}
+	db.Model(&models.PasswordResetEvent{}).Where("id = ?", expiring.ID).Update("reveal_expires_at", time.Now().Add(-time.Minute))
+	if _, _, err := passwordResetService.RevealPassword(admin, token, "127.0.0.1", "test-agent"); err != ErrInvalidRevealLink {
+		t.Errorf("An expired link should be refused, got %v", err)
+	}
+	if err := passwordResetService.ClearExpiredReveals(); err != nil {
+		t.Fatalf("ClearExpiredReveals failed: %v", err)
+	}
+	var expired models.PasswordResetEvent
+	db.First(&expired, expiring.ID)
+	if expired.RevealSecret != nil || expired.SecretViewed() || !expired.HasRevealLink() {
+		t.Errorf("An expired secret should be dropped without counting as viewed, got %+v", expired)
+	}
+	
+	var activity models.UserActivity
+	if err := db.Where("activity_type = ? AND user_id = ?", "password_reset_revealed", admin.ID).First(&activity).Error; err != nil {
+		t.Error("Expected the reveal to be logged")
+	}
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPermissionInvalid   = errors.New("unknown permission")
+	ErrRoleInvalid         = errors.New("unknown role")
+	ErrRolesManageRequired = errors.New("the admin role must keep the permission to manage roles")
+)
+
+// permissionsRevisionID is the primary key of the single
+// models.PermissionsRevision row.
+const permissionsRevisionID = 1
+
+// PermissionService keeps the role permission sets in the database and
+// publishes them to models.SetRolePermissions.
+type PermissionService struct {
+	db              *gorm.DB
+	activityService *ActivityService
+
+	reloadMu sync.Mutex
+	// revision is the models.PermissionsRevision the published sets were
+	// read at.
+	revision atomic.Uint64
+}
+
+func NewPermissionService(db *gorm.DB, activityService *ActivityService) *PermissionService {
+	return &PermissionService{
+		db:              db,
+		activityService: activityService,
+	}
+}
+
+// Load reads the permission sets and makes them current. Permissions it has
+// not seen before, which on the first start is all of them, are granted to
+// the roles that have them in models.DefaultRolePermissions.
+func (s *PermissionService) Load() error {
+	if err := s.db.FirstOrCreate(&models.PermissionsRevision{}, models.PermissionsRevision{ID: permissionsRevisionID}).Error; err != nil {
+		return err
+	}
+	if err := s.grantNewPermissions(); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// Reload reads the permission sets again and makes them current.
+func (s *PermissionService) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return s.reload()
+}
+
+// Refresh reloads the permission sets if they were changed since they were
+// last read, including by another instance sharing the database. It is
+// run before every request so a revoked permission stops working at once.
+func (s *PermissionService) Refresh() error {
+	revision, err := s.currentRevision()
+	if err != nil {
+		return err
+	}
+	if revision == s.revision.Load() {
+		return nil
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	// Another request may have reloaded while this one waited.
+	if revision == s.revision.Load() {
+		return nil
+	}
+	return s.reload()
+}
+
+func (s *PermissionService) reload() error {
+	// Read the revision first, so a change made while the sets are read
+	// leaves them marked as stale rather than current.
+	revision, err := s.currentRevision()
+	if err != nil {
+		return err
+	}
+	sets, err := s.GetRolePermissions()
+	if err != nil {
+		return err
+	}
+	models.SetRolePermissions(sets)
+	s.revision.Store(revision)
+	return nil
+}
+
+func (s *PermissionService) currentRevision() (uint64, error) {
+	var revision uint64
+	err := s.db.Model(&models.PermissionsRevision{}).Where("id = ?", permissionsRevisionID).Pluck("revision", &revision).Error
+	return revision, err
+}
+
+// bumpRevision marks the stored permission sets as changed, which makes
+// every instance reload them on its next request.
+func bumpRevision(tx *gorm.DB) error {
+	return tx.Model(&models.PermissionsRevision{}).Where("id = ?", permissionsRevisionID).UpdateColumn("revision", gorm.Expr("revision + 1")).Error
+}
+
+func (s *PermissionService) grantNewPermissions() error {
+	var recorded []string
+	if err := s.db.Model(&models.KnownPermission{}).Pluck("name", &recorded).Error; err != nil {
+		return err
+	}
+	known := recorded
+	// Databases from before permissions were tracked already hold the
+	// defaults of every permission that is in use.
+	if len(known) == 0 {
+		if err := s.db.Model(&models.RolePermission{}).Distinct().Pluck("permission", &known).Error; err != nil {
+			return err
+		}
+	}
+	isRecorded := make(map[string]bool, len(recorded))
+	for _, name := range recorded {
+		isRecorded[name] = true
+	}
+	isKnown := make(map[string]bool, len(known))
+	for _, name := range known {
+		isKnown[name] = true
+	}
+
+	defaults := models.DefaultRolePermissions()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		changed := false
+		for _, p := range models.Permissions {
+			if isRecorded[p.Name] {
+				continue
+			}
+			if !isKnown[p.Name] {
+				for _, role := range models.Roles {
+					for _, permission := range defaults[role] {
+						if permission != p.Name {
+							continue
+						}
+						if err := tx.Create(&models.RolePermission{Role: role, Permission: permission}).Error; err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err := tx.Create(&models.KnownPermission{Name: p.Name}).Error; err != nil {
+				return err
+			}
+			changed = true
+		}
+		if changed {
+			return bumpRevision(tx)
+		}
+		return nil
+	})
+}
+
+// GetRolePermissions returns the stored permission names of every role.
+func (s *PermissionService) GetRolePermissions() (map[models.UserRole][]string, error) {
+	var rows []models.RolePermission
+	if err := s.db.Order("role, permission").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	sets := make(map[models.UserRole][]string, len(models.Roles))
+	for _, role := range models.Roles {
+		sets[role] = []string{}
+	}
+	for _, row := range rows {
+		sets[row.Role] = append(sets[row.Role], row.Permission)
+	}
+	return sets, nil
+}
+
+// UpdateRolePermissions replaces the role's permission set and applies it
+// straight away.
+func (s *PermissionService) UpdateRolePermissions(performingUser *models.User, role models.UserRole, permissions []string, ipAddress, userAgent string) error {
+	if role.String() == "unknown" {
+		return ErrRoleInvalid
+	}
+
+	granted := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		if !models.IsValidPermission(permission) {
+			return ErrPermissionInvalid
+		}
+		granted[permission] = true
+	}
+
+	// Someone has to be able to undo a mistake.
+	if role == models.RoleAdmin && !granted[models.PermRolesManage] {
+		return ErrRolesManageRequired
+	}
+
+	current, err := s.GetRolePermissions()
+	if err != nil {
+		return err
+	}
+	var added, removed []string
+	for _, permission := range current[role] {
+		if !granted[permission] {
+			removed = append(removed, permission)
+		}
+		delete(granted, permission)
+	}
+	for _, p := range models.Permissions {
+		if granted[p.Name] {
+			added = append(added, p.Name)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if len(removed) > 0 {
+			if err := tx.Where("role = ? AND permission IN ?", role, removed).Delete(&models.RolePermission{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, permission := range added {
+			if err := tx.Create(&models.RolePermission{Role: role, Permission: permission}).Error; err != nil {
+				return err
+			}
+		}
+		return bumpRevision(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.Reload(); err != nil {
+		return err
+	}
+
+	s.activityService.LogActivity(&performingUser.ID, "role_permissions_updated", ipAddress, userAgent, map[string]interface{}{
+		"performed_by":        performingUser.ID,
+		"role":                role.String(),
+		"permissions_added":   added,
+		"permissions_removed": removed,
+	})
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"testing"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+func TestPermissionService(t *testing.T) {
+	db := setupTestDB(t)
+	defer models.SetRolePermissions(models.DefaultRolePermissions())
+	
+	activityService := NewActivityService(db)
+	permissionService := NewPermissionService(db, activityService)
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin User", Role: models.RoleAdmin, Enabled: true}
+	manager := &models.User{Email: "manager@example.com", Name: "Manager User", Role: models.RoleManager, Enabled: true}
+	db.Create(admin)
+	db.Create(manager)
+	
+	// The first load stores the default permission sets.
+	if err := permissionService.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	var count int64
+	db.Model(&models.RolePermission{}).Where("role = ?", models.RoleManager).Count(&count)
+	if int(count) != len(models.DefaultRolePermissions()[models.RoleManager]) {
+		t.Errorf("Expected the manager's default permissions to be stored, got %d rows", count)
+	}
+	
+	err := permissionService.UpdateRolePermissions(admin, models.RoleManager, []string{models.PermUsersView, models.PermUsersResetPassword}, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("UpdateRolePermissions failed: %v", err)
+	}
+	if manager.HasPermission(models.PermUsersDelete) || !manager.HasPermission(models.PermUsersResetPassword) {
+		t.Error("Changed permissions should apply immediately")
+	}
+	
+	// Permissions survive a restart.
+	models.SetRolePermissions(models.DefaultRolePermissions())
+	if err := permissionService.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if manager.HasPermission(models.PermUsersDelete) {
+		t.Error("Stored permissions should replace the defaults on load")
+	}
+	
+	// Changes made by another instance apply on the next refresh, and only
+	// then.
+	other := NewPermissionService(db, activityService)
+	current := models.DefaultRolePermissions()
+	current[models.RoleManager] = []string{models.PermUsersView, models.PermUsersResetPassword}
+	if err := other.UpdateRolePermissions(admin, models.RoleManager, []string{models.PermUsersView, models.PermUsersDelete}, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("UpdateRolePermissions failed: %v", err)
+	}
+	models.SetRolePermissions(current)
+	if err := permissionService.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if !manager.HasPermission(models.PermUsersDelete) || manager.HasPermission(models.PermUsersResetPassword) {
+		t.Error("Refresh should pick up permissions changed by another instance")
+	}
+	models.SetRolePermissions(current)
+	permissionService.Refresh()
+	if manager.HasPermission(models.PermUsersDelete) {
+		t.Error("Refresh should not reload unchanged permissions")
+	}
+	permissionService.Reload()
+	other.UpdateRolePermissions(admin, models.RoleManager, []string{models.PermUsersView, models.PermUsersResetPassword}, "127.0.0.1", "test-agent")
+	
+	var activity models.UserActivity
+	if err := db.Where("activity_type = ?", "role_permissions_updated").First(&activity).Error; err != nil {
+		t.Error("Permission changes should be logged")
+	}
+	
+	if err := permissionService.UpdateRolePermissions(admin, models.RoleManager, []string{"users.everything"}, "127.0.0.1", "test-agent"); err != ErrPermissionInvalid {
+		t.Errorf("Expected ErrPermissionInvalid, got %v", err)
+	}
+	if err := permissionService.UpdateRolePermissions(admin, models.RoleAdmin, []string{models.PermUsersView}, "127.0.0.1", "test-agent"); err != ErrRolesManageRequired {
+		t.Errorf("Expected ErrRolesManageRequired, got %v", err)
+	}
+	if !admin.HasPermission(models.PermRolesManage) {
+		t.Error("A refused update should leave the permissions unchanged")
+	}
+	
+	// A permission added in a later release gets its defaults, while
+	// earlier changes on the roles page are kept.
+	db.Where("name = ?", models.PermUsersImpersonate).Delete(&models.KnownPermission{})
+	db.Where("permission = ?", models.PermUsersImpersonate).Delete(&models.RolePermission{})
+	if err := permissionService.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !admin.HasPermission(models.PermUsersImpersonate) {
+		t.Error("A new permission should be granted to the roles that have it by default")
+	}
+	if manager.HasPermission(models.PermUsersDelete) {
+		t.Error("Loading new permissions should not restore removed ones")
+	}
+}
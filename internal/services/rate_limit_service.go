@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RateLimitService is a limiter.Store that keeps its counters in the
+// database, and lets admins see and clear them.
+type RateLimitService struct {
+	db              *gorm.DB
+	activityService *ActivityService
+}
+
+func NewRateLimitService(db *gorm.DB, activityService *ActivityService) *RateLimitService {
+	return &RateLimitService{
+		db:              db,
+		activityService: activityService,
+	}
+}
+
+var _ limiter.Store = (*RateLimitService)(nil)
+
+// Get counts one request against the key.
+func (s *RateLimitService) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.Increment(ctx, key, 1, rate)
+}
+
+// Increment counts count requests against the key, starting a new window
+// if the previous one has ended. The upsert keeps concurrent requests from
+// several instances from losing counts.
+func (s *RateLimitService) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	now := time.Now()
+	counter := models.RateLimitCounter{
+		Key:       key,
+		Count:     count,
+		Limit:     rate.Limit,
+		ExpiresAt: now.Add(rate.Period),
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"count":         gorm.Expr("CASE WHEN rate_limit_counters.expires_at <= ? THEN ? ELSE rate_limit_counters.count + ? END", now, count, count),
+				"expires_at":    gorm.Expr("CASE WHEN rate_limit_counters.expires_at <= ? THEN ? ELSE rate_limit_counters.expires_at END", now, counter.ExpiresAt),
+				"request_limit": rate.Limit,
+				"updated_at":    now,
+			}),
+		}).Create(&counter).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("key = ?", key).First(&counter).Error
+	})
+	if err != nil {
+		return limiter.Context{}, err
+	}
+
+	return common.GetContextFromState(now, rate, counter.ExpiresAt, counter.Count), nil
+}
+
+// Peek returns the key's state without counting a request.
+func (s *RateLimitService) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	now := time.Now()
+	var counter models.RateLimitCounter
+	err := s.db.WithContext(ctx).Where("key = ? AND expires_at > ?", key, now).First(&counter).Error
+	if err == gorm.ErrRecordNotFound {
+		return common.GetContextFromState(now, rate, now.Add(rate.Period), 0), nil
+	}
+	if err != nil {
+		return limiter.Context{}, err
+	}
+
+	return common.GetContextFromState(now, rate, counter.ExpiresAt, counter.Count), nil
+}
+
+// Reset forgets the key's requests.
+func (s *RateLimitService) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := s.db.WithContext(ctx).Where("key = ?", key).Delete(&models.RateLimitCounter{}).Error; err != nil {
+		return limiter.Context{}, err
+	}
+
+	now := time.Now()
+	return common.GetContextFromState(now, rate, now.Add(rate.Period), 0), nil
+}
+
+// GetActiveCounters lists the counters whose window has not ended yet,
+// throttled ones first.
+func (s *RateLimitService) GetActiveCounters() ([]models.RateLimitCounter, error) {
+	var counters []models.RateLimitCounter
+	err := s.db.Where("expires_at > ?", time.Now()).
+		Order("count > request_limit DESC, updated_at DESC").
+		Find(&counters).Error
+	return counters, err
+}
+
+// ClearCounter lifts the throttle on one key. It returns
+// gorm.ErrRecordNotFound if the key has no counter.
+func (s *RateLimitService) ClearCounter(performingUser *models.User, key, ipAddress, userAgent string) error {
+	result := s.db.Where("key = ?", key).Delete(&models.RateLimitCounter{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	s.activityService.LogActivity(&performingUser.ID, "rate_limit_cleared", ipAddress, userAgent, map[string]interface{}{
+		"performed_by": performingUser.ID,
+		"key":          key,
+	})
+	return nil
+}
+
+// ClearAllCounters lifts every throttle and returns how many counters were
+// removed.
+func (s *RateLimitService) ClearAllCounters(performingUser *models.User, ipAddress, userAgent string) (int64, error) {
+	result := s.db.Where("1 = 1").Delete(&models.RateLimitCounter{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	s.activityService.LogActivity(&performingUser.ID, "rate_limit_cleared", ipAddress, userAgent, map[string]interface{}{
+		"performed_by": performingUser.ID,
+		"counters":     result.RowsAffected,
+	})
+	return result.RowsAffected, nil
+}
+
+func (s *RateLimitService) CleanupExpiredCounters() error {
+	return s.db.Where("expires_at <= ?", time.Now()).Delete(&models.RateLimitCounter{}).Error
+}
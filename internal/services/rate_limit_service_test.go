@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"github.com/ulule/limiter/v3"
+	"gorm.io/gorm"
+)
+
+func TestRateLimitService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	rateLimitService := NewRateLimitService(db, activityService)
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin User", Role: models.RoleAdmin, Enabled: true}
+	db.Create(admin)
+	
+	ctx := context.Background()
+	rate := limiter.Rate{Period: time.Minute, Limit: 3}
+	key := "login:ip=10.0.0.1 email=sales@example.com"
+	
+	for i := 1; i <= 3; i++ {
+		result, err := rateLimitService.Get(ctx, key, rate)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if result.Reached || result.Remaining != int64(3-i) {
+			t.Errorf("Request %d: expected %d remaining, got %+v", i, 3-i, result)
+		}
+	}
+	
+	result, _ := rateLimitService.Get(ctx, key, rate)
+	if !result.Reached {
+		t.Error("The fourth request should be refused")
+	}
+	
+	// Other keys are counted separately.
+	if result, _ := rateLimitService.Get(ctx, "login:ip=10.0.0.1 email=admin@example.com", rate); result.Reached {
+		t.Error("A different email should not share the counter")
+	}
+	
+	if result, _ := rateLimitService.Peek(ctx, key, rate); !result.Reached {
+		t.Error("Peek should report the throttle")
+	}
+	var counter models.RateLimitCounter
+	db.Where("key = ?", key).First(&counter)
+	if counter.Count != 4 || !counter.IsThrottled() {
+		t.Errorf("Peek should not count a request, got %d", counter.Count)
+	}
+	if counter.Policy() != "login" || counter.Subject() != "ip=10.0.0.1 email=sales@example.com" {
+		t.Errorf("Unexpected policy %q and subject %q", counter.Policy(), counter.Subject())
+	}
+	
+	counters, err := rateLimitService.GetActiveCounters()
+	if err != nil || len(counters) != 2 || counters[0].Key != key {
+		t.Fatalf("Expected the throttled counter to be listed first, got %v", err)
+	}
+	
+	// A new window starts once the old one has ended.
+	db.Model(&models.RateLimitCounter{}).Where("key = ?", key).Update("expires_at", time.Now().Add(-time.Second))
+	result, _ = rateLimitService.Get(ctx, key, rate)
+	if result.Reached || result.Remaining != 2 {
+		t.Errorf("Expected the count to restart after the window, got %+v", result)
+	}
+	
+	if _, err := rateLimitService.Reset(ctx, key, rate); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if result, _ := rateLimitService.Peek(ctx, key, rate); result.Remaining != 3 {
+		t.Errorf("Expected a full allowance after Reset, got %+v", result)
+	}
+	
+	rateLimitService.Get(ctx, key, rate)
+	if err := rateLimitService.ClearCounter(admin, key, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("ClearCounter failed: %v", err)
+	}
+	if err := rateLimitService.ClearCounter(admin, key, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected gorm.ErrRecordNotFound for a cleared key, got %v", err)
+	}
+	
+	rateLimitService.Get(ctx, key, rate)
+	cleared, err := rateLimitService.ClearAllCounters(admin, "127.0.0.1", "test-agent")
+	if err != nil || cleared != 2 {
+		t.Errorf("Expected 2 counters cleared, got %d (%v)", cleared, err)
+	}
+	
+	var activities int64
+	db.Model(&models.UserActivity{}).Where("activity_type = ? AND user_id = ?", "rate_limit_cleared", admin.ID).Count(&activities)
+	if activities != 2 {
+		t.Errorf("Expected 2 rate_limit_cleared activities, got %d", activities)
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/mailer"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+// SecurityAlertService compares each sign-in with the account's earlier
+// "login" activities and raises an alert when it does not fit.
+type SecurityAlertService struct {
+	db              *gorm.DB
+	sessionService  *SessionService
+	activityService *ActivityService
+	mailer          mailer.Mailer
+	baseURL         string
+	policy          models.SecurityAlertPolicy
+}
+
+func NewSecurityAlertService(db *gorm.DB, sessionService *SessionService, activityService *ActivityService, mailer mailer.Mailer, baseURL string, policy models.SecurityAlertPolicy) *SecurityAlertService {
+	return &SecurityAlertService{
+		db:              db,
+		sessionService:  sessionService,
+		activityService: activityService,
+		mailer:          mailer,
+		baseURL:         baseURL,
+		policy:          policy,
+	}
+}
+
+// CheckLogin looks for something unusual about a sign-in that has just
+// opened session. It must run before the sign-in is logged, and returns nil
+// when there is nothing to report. An account's first sign-in has nothing
+// to compare with and never raises an alert.
+func (s *SecurityAlertService) CheckLogin(user *models.User, session *models.Session, ipAddress, userAgent string) (*models.SecurityAlert, error) {
+	var logins []models.UserActivity
+	err := s.db.Select("ip_address", "user_agent", "performed_at").
+		Where("user_id = ? AND activity_type = ?", user.ID, "login").
+		Order("performed_at DESC").
+		Limit(1).
+		Find(&logins).Error
+	if err != nil || len(logins) == 0 {
+		return nil, err
+	}
+
+	alert := &models.SecurityAlert{
+		UserID:    user.ID,
+		SessionID: &session.ID,
+		Status:    models.SecurityAlertOpen,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+	}
+
+	last := logins[0]
+	if time.Since(last.PerformedAt) <= s.policy.DistantLoginWindow && distantNetworks(last.IPAddress, ipAddress) {
+		alert.Type = models.SecurityAlertDistantLogin
+		alert.PreviousIPAddress = last.IPAddress
+	} else {
+		known, err := s.knownSignIn(user, ipAddress, userAgent)
+		if err != nil || known {
+			return nil, err
+		}
+		alert.Type = models.SecurityAlertNewDevice
+	}
+
+	if err := s.db.Create(alert).Error; err != nil {
+		return nil, err
+	}
+
+	s.activityService.LogActivity(&user.ID, "security_alert", ipAddress, userAgent, map[string]interface{}{
+		"user_id":           user.ID,
+		"security_alert_id": alert.ID,
+		"alert_type":        alert.Type,
+	})
+
+	if s.policy.EmailUser {
+		s.sendAlertEmail(user, alert)
+	}
+
+	return alert, nil
+}
+
+// knownSignIn reports whether both the address and the device have signed
+// in to the account within the policy's HistoryWindow.
+func (s *SecurityAlertService) knownSignIn(user *models.User, ipAddress, userAgent string) (bool, error) {
+	recent := func() *gorm.DB {
+		return s.db.Model(&models.UserActivity{}).
+			Where("user_id = ? AND activity_type = ? AND performed_at >= ?", user.ID, "login", time.Now().Add(-s.policy.HistoryWindow))
+	}
+
+	var knownIP []uint
+	if err := recent().Where("ip_address = ?", ipAddress).Limit(1).Pluck("id", &knownIP).Error; err != nil || len(knownIP) == 0 {
+		return false, err
+	}
+
+	// Only distinct user agents are loaded, as versions differ but few
+	// devices sign in to one account.
+	var userAgents []string
+	if err := recent().Distinct("user_agent").Pluck("user_agent", &userAgents).Error; err != nil {
+		return false, err
+	}
+	device := models.DescribeUserAgent(userAgent)
+	for _, agent := range userAgents {
+		if models.DescribeUserAgent(agent) == device {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *SecurityAlertService) sendAlertEmail(user *models.User, alert *models.SecurityAlert) error {
+	link := fmt.Sprintf("%s/profile/sessions", s.baseURL)
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "New sign-in to your ASM Tracker account",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"We noticed a sign-in to your ASM Tracker account that looks different from your usual ones:\n\n"+
+			"%s\nTime: %s\n\n"+
+			"If this was you, confirm it on the page below. If not, revoke the session there and change your password:\n\n%s\n",
+			user.Name, alert.Description(), alert.CreatedAt.Format("Jan 2, 2006 15:04 MST"), link),
+	})
+}
+
+// distantNetworks reports whether two addresses of the same family are in
+// different /16 (IPv4) or /48 (IPv6) networks. Without a location database
+// this is the best available sign that two sign-ins could not come from the
+// same place. A switch between IPv4 and IPv6 says nothing about distance,
+// since one device often has both, so it is never distant.
+func distantNetworks(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a != b
+	}
+
+	v4A, v4B := ipA.To4(), ipB.To4()
+	switch {
+	case v4A != nil && v4B != nil:
+		mask := net.CIDRMask(16, 32)
+		return !v4A.Mask(mask).Equal(v4B.Mask(mask))
+	case v4A == nil && v4B == nil:
+		mask := net.CIDRMask(48, 128)
+		return !ipA.Mask(mask).Equal(ipB.Mask(mask))
+	default:
+		return false
+	}
+}
+
+// GetOpenAlerts lists unresolved alerts for the users in the scope, newest
+// first.
+func (s *SecurityAlertService) GetOpenAlerts(scope models.CompanyScope, limit int) ([]models.SecurityAlert, error) {
+	var alerts []models.SecurityAlert
+	query := s.db.Preload("User").
+		Where("status = ?", models.SecurityAlertOpen).
+		Order("created_at DESC").
+		Limit(limit)
+	if !scope.All {
+		query = query.Where("user_id IN (?)", scopeToCompany(s.db.Table("users").Select("id"), "company_id", scope))
+	}
+	err := query.Find(&alerts).Error
+	return alerts, err
+}
+
+// GetUserOpenAlerts lists the user's unresolved alerts, newest first.
+func (s *SecurityAlertService) GetUserOpenAlerts(userID uint) ([]models.SecurityAlert, error) {
+	var alerts []models.SecurityAlert
+	err := s.db.Where("user_id = ? AND status = ?", userID, models.SecurityAlertOpen).
+		Order("created_at DESC").
+		Find(&alerts).Error
+	return alerts, err
+}
+
+// ConfirmAlert records that the user recognises the sign-in. It returns
+// gorm.ErrRecordNotFound if the alert is not one of the user's open alerts.
+func (s *SecurityAlertService) ConfirmAlert(user *models.User, alertID uint, ipAddress, userAgent string) error {
+	alert, err := s.resolveAlert(user, alertID, models.SecurityAlertConfirmed)
+	if err != nil {
+		return err
+	}
+
+	s.activityService.LogActivity(&user.ID, "security_alert_confirmed", ipAddress, userAgent, map[string]interface{}{
+		"user_id":           user.ID,
+		"security_alert_id": alert.ID,
+	})
+	return nil
+}
+
+// RevokeAlert ends the session the alerted sign-in opened, along with its
+// remembered device. It returns gorm.ErrRecordNotFound if the alert is not
+// one of the user's open alerts.
+func (s *SecurityAlertService) RevokeAlert(user *models.User, alertID uint, ipAddress, userAgent string) (*models.SecurityAlert, error) {
+	alert, err := s.resolveAlert(user, alertID, models.SecurityAlertRevoked)
+	if err != nil {
+		return nil, err
+	}
+
+	if alert.SessionID != nil {
+		err := s.sessionService.RevokeUserSession(user.ID, *alert.SessionID)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+	}
+
+	s.activityService.LogActivity(&user.ID, "security_alert_revoked", ipAddress, userAgent, map[string]interface{}{
+		"user_id":           user.ID,
+		"security_alert_id": alert.ID,
+		"session_id":        alert.SessionID,
+	})
+	return alert, nil
+}
+
+func (s *SecurityAlertService) resolveAlert(user *models.User, alertID uint, status string) (*models.SecurityAlert, error) {
+	var alert models.SecurityAlert
+	err := s.db.Where("id = ? AND user_id = ? AND status = ?", alertID, user.ID, models.SecurityAlertOpen).First(&alert).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.db.Model(&models.SecurityAlert{}).Where("id = ?", alert.ID).Updates(map[string]interface{}{
+		"status":      status,
+		"resolved_at": now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	alert.Status = status
+	alert.ResolvedAt = &now
+	return &alert, nil
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestSecurityAlertService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	mail := &recordingMailer{}
+	policy := models.DefaultSecurityAlertPolicy()
+	policy.EmailUser = true
+	securityAlertService := NewSecurityAlertService(db, sessionService, activityService, mail, "http://localhost", policy)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, securityAlertService, NewIPRuleService(db, sessionService, activityService, ""))
+	
+	user := &models.User{Email: "sales@example.com", Name: "Sales User", Role: models.RoleSalesperson, Enabled: true}
+	other := &models.User{Email: "other@example.com", Name: "Other User", Role: models.RoleSalesperson, Enabled: true}
+	user.SetPassword("Secur3!Passw0rd")
+	db.Create(user)
+	db.Create(other)
+	
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	const chromeUpdated = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	credentials := LoginCredentials{Email: "sales@example.com", Password: "Secur3!Passw0rd"}
+	
+	// The first sign-in has nothing to compare with.
+	first, err := authService.Login(credentials, "10.1.0.1", chrome)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	var count int64
+	db.Model(&models.SecurityAlert{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("The first sign-in should not raise an alert, got %d", count)
+	}
+	
+	// Another network minutes later looks like two places at once.
+	second, _ := authService.Login(credentials, "203.0.113.5", chrome)
+	var alert models.SecurityAlert
+	if err := db.Where("user_id = ?", user.ID).Order("id DESC").First(&alert).Error; err != nil {
+		t.Fatal("Expected an alert for a distant sign-in")
+	}
+	if alert.Type != models.SecurityAlertDistantLogin || alert.PreviousIPAddress != "10.1.0.1" || !alert.IsForSession(second.Session.ID) {
+		t.Errorf("Unexpected alert %+v", alert)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To != user.Email || !strings.Contains(mail.sent[0].Body, "/profile/sessions") {
+		t.Errorf("Expected the alert to be emailed to the user, got %+v", mail.sent)
+	}
+	
+	// Earlier sign-ins are no longer recent.
+	db.Model(&models.UserActivity{}).Where("activity_type = ?", "login").Update("performed_at", time.Now().Add(-time.Hour))
+	
+	cases := []struct {
+		name      string
+		ip        string
+		userAgent string
+		want      string
+	}{
+		{"known IP and device", "10.1.0.1", chrome, ""},
+		{"browser update", "10.1.0.1", chromeUpdated, ""},
+		{"new device", "10.1.0.1", firefox, models.SecurityAlertNewDevice},
+		{"new IP", "10.1.0.2", chrome, models.SecurityAlertNewDevice},
+	}
+	for _, tc := range cases {
+		got, err := securityAlertService.CheckLogin(user, first.Session, tc.ip, tc.userAgent)
+		if err != nil {
+			t.Fatalf("%s: CheckLogin failed: %v", tc.name, err)
+		}
+		if (got == nil && tc.want != "") || (got != nil && got.Type != tc.want) {
+			t.Errorf("%s: expected alert %q, got %+v", tc.name, tc.want, got)
+		}
+	}
+	
+	if distantNetworks("10.1.0.1", "10.1.200.7") || !distantNetworks("10.1.0.1", "10.2.0.1") {
+		t.Error("IPv4 addresses should be compared by /16")
+	}
+	if distantNetworks("2001:db8:1::1", "2001:db8:1:ff::1") || !distantNetworks("2001:db8:1::1", "2001:db8:2::1") {
+		t.Error("IPv6 addresses should be compared by /48")
+	}
+	if distantNetworks("10.1.0.1", "2001:db8:1::1") || distantNetworks("2001:db8:1::1", "203.0.113.5") {
+		t.Error("A switch between IPv4 and IPv6 should not count as distant")
+	}
+	
+	alerts, err := securityAlertService.GetUserOpenAlerts(user.ID)
+	if err != nil || len(alerts) != 3 {
+		t.Fatalf("Expected 3 open alerts, got %d (%v)", len(alerts), err)
+	}
+	open, _ := securityAlertService.GetOpenAlerts(models.CompanyScope{All: true}, 10)
+	if len(open) != 3 || open[0].User.ID != user.ID {
+		t.Errorf("Expected the open alerts with their users, got %d", len(open))
+	}
+	acme := &models.Company{Name: "Acme", DefaultRole: models.RoleSalesperson}
+	db.Create(acme)
+	if open, _ = securityAlertService.GetOpenAlerts(models.CompanyScope{CompanyID: &acme.ID}, 10); len(open) != 0 {
+		t.Errorf("Expected no open alerts in another company's scope, got %d", len(open))
+	}
+	
+	if err := securityAlertService.ConfirmAlert(other, alert.ID, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("Users should not resolve each other's alerts, got %v", err)
+	}
+	if err := securityAlertService.ConfirmAlert(user, alerts[0].ID, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("ConfirmAlert failed: %v", err)
+	}
+	if err := securityAlertService.ConfirmAlert(user, alerts[0].ID, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("A confirmed alert should not be confirmed again, got %v", err)
+	}
+	
+	revoked, err := securityAlertService.RevokeAlert(user, alert.ID, "127.0.0.1", "test-agent")
+	if err != nil || revoked.Status != models.SecurityAlertRevoked {
+		t.Fatalf("RevokeAlert failed: %v", err)
+	}
+	if authService.IsAuthenticated(second.Token) {
+		t.Error("Revoking the alert should end the session it opened")
+	}
+	if !authService.IsAuthenticated(first.Token) {
+		t.Error("Other sessions should survive")
+	}
+	
+	alerts, _ = securityAlertService.GetUserOpenAlerts(user.ID)
+	if len(alerts) != 1 {
+		t.Errorf("Expected 1 open alert left, got %d", len(alerts))
+	}
+	for _, activityType := range []string{"security_alert", "security_alert_confirmed", "security_alert_revoked"} {
+		var activity models.UserActivity
+		if err := db.Where("activity_type = ? AND user_id = ?", activityType, user.ID).First(&activity).Error; err != nil {
+			t.Errorf("Expected %s to be logged", activityType)
+		}
+	}
+}
+
+func TestSecurityAlertHistoryWindow(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	policy := models.DefaultSecurityAlertPolicy()
+	policy.HistoryWindow = 30 * 24 * time.Hour
+	securityAlertService := NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", policy)
+	
+	user := &models.User{Email: "sales@example.com", Name: "Sales User", Role: models.RoleSalesperson, Enabled: true}
+	db.Create(user)
+	session, _, err := sessionService.CreateSession(user, "10.1.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	login := &models.UserActivity{UserID: &user.ID, ActivityType: "login", IPAddress: "10.1.0.1", UserAgent: chrome, PerformedAt: time.Now().Add(-60 * 24 * time.Hour)}
+	db.Create(login)
+	
+	// Sign-ins older than the window no longer make a device known.
+	alert, err := securityAlertService.CheckLogin(user, session, "10.1.0.1", chrome)
+	if err != nil || alert == nil || alert.Type != models.SecurityAlertNewDevice {
+		t.Fatalf("Expected a new device alert after the window, got %+v, %v", alert, err)
+	}
+	
+	db.Model(login).Update("performed_at", time.Now().Add(-24*time.Hour))
+	if alert, err := securityAlertService.CheckLogin(user, session, "10.1.0.1", chrome); err != nil || alert != nil {
+		t.Errorf("Expected no alert for a recent device, got %+v, %v", alert, err)
+	}
+}
@@ -1,12 +1,37 @@
 package services
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"alsafwanmarine.com/todo-app/internal/models"
 	"gorm.io/gorm"
 )
 
+// rememberTokenGracePeriod is how long the previous remember token stays
+// usable after rotation, for requests that were already in flight.
+const rememberTokenGracePeriod = time.Minute
+
+var (
+	ErrRememberTokenInvalid = errors.New("remember token is invalid or has expired")
+	ErrRememberTokenReused  = errors.New("remember token was already used")
+)
+
+// RememberTokenReusedError is returned when a rotated remember token is
+// presented again. It unwraps to ErrRememberTokenReused.
+type RememberTokenReusedError struct {
+	UserID uint
+}
+
+func (e *RememberTokenReusedError) Error() string {
+	return ErrRememberTokenReused.Error()
+}
+
+func (e *RememberTokenReusedError) Unwrap() error {
+	return ErrRememberTokenReused
+}
+
 type SessionService struct {
 	db *gorm.DB
 }
@@ -16,52 +41,299 @@ func NewSessionService(db *gorm.DB) *SessionService {
 }
 
 func (s *SessionService) CreateSession(user *models.User, ipAddress, userAgent string) (*models.Session, string, error) {
+	return s.createSession(user.ID, nil, nil, ipAddress, userAgent)
+}
+
+// CreateImpersonationSession opens a session as user on behalf of the
+// impersonator. It is never remembered.
+func (s *SessionService) CreateImpersonationSession(user, impersonator *models.User, ipAddress, userAgent string) (*models.Session, string, error) {
+	return s.createSession(user.ID, nil, &impersonator.ID, ipAddress, userAgent)
+}
+
+func (s *SessionService) createSession(userID uint, rememberTokenID, impersonatorID *uint, ipAddress, userAgent string) (*models.Session, string, error) {
 	token, err := models.GenerateSecureToken()
 	if err != nil {
 		return nil, "", err
 	}
-	
+	csrfToken, err := models.GenerateSecureToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	absoluteExpiresAt := now.Add(models.SessionAbsoluteTimeout)
 	session := &models.Session{
-		UserID:    user.ID,
-		Token:     token,
+		UserID:      userID,
+		TokenDigest: models.HashToken(token),
+		RememberTokenID: rememberTokenID,
+		ImpersonatorID: impersonatorID,
+		CSRFToken: csrfToken,
 		IPAddress: ipAddress,
 		UserAgent: userAgent,
-		ExpiresAt: time.Now().Add(30 * time.Minute),
+		ExpiresAt: now.Add(models.SessionIdleTimeout),
+		AbsoluteExpiresAt: &absoluteExpiresAt,
 	}
-	
+
 	if err := s.db.Create(session).Error; err != nil {
 		return nil, "", err
 	}
-	
+
 	return session, token, nil
 }
 
+// CreateRememberedSession starts a session together with a new "remember
+// me" series. It returns the session token and the remember cookie value.
+func (s *SessionService) CreateRememberedSession(user *models.User, ipAddress, userAgent string) (*models.Session, string, string, error) {
+	series, err := models.GenerateSecureToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+	token, err := models.GenerateSecureToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	now := time.Now()
+	rememberToken := &models.RememberToken{
+		UserID:      user.ID,
+		Series:      series,
+		TokenDigest: models.HashToken(token),
+		IPAddress:   ipAddress,
+		UserAgent:   userAgent,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(models.RememberTokenLifetime),
+	}
+	if err := s.db.Create(rememberToken).Error; err != nil {
+		return nil, "", "", err
+	}
+
+	session, sessionToken, err := s.createSession(user.ID, &rememberToken.ID, nil, ipAddress, userAgent)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return session, sessionToken, series + ":" + token, nil
+}
+
+// ResumeRememberedSession exchanges a remember cookie for a new session.
+// The token is rotated, so the returned cookie value replaces the old one;
+// it is empty when a just-rotated token was accepted during the grace
+// period and the browser already holds the newer cookie.
+//
+// A token that was rotated away longer ago means the cookie has been copied.
+// The whole series and its sessions are revoked and a
+// RememberTokenReusedError is returned.
+func (s *SessionService) ResumeRememberedSession(cookie, ipAddress, userAgent string) (*models.Session, string, string, error) {
+	series, token, ok := strings.Cut(cookie, ":")
+	if !ok || series == "" || token == "" {
+		return nil, "", "", ErrRememberTokenInvalid
+	}
+
+	var rememberToken models.RememberToken
+	if err := s.db.Where("series = ?", series).First(&rememberToken).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, "", "", ErrRememberTokenInvalid
+		}
+		return nil, "", "", err
+	}
+
+	if rememberToken.IsExpired() {
+		s.revokeRememberTokens(s.db.Where("id = ?", rememberToken.ID))
+		return nil, "", "", ErrRememberTokenInvalid
+	}
+
+	digest := models.HashToken(token)
+	if digest != rememberToken.TokenDigest {
+		inGracePeriod := rememberToken.PreviousTokenDigest != nil &&
+			*rememberToken.PreviousTokenDigest == digest &&
+			rememberToken.RotatedAt != nil &&
+			time.Since(*rememberToken.RotatedAt) < rememberTokenGracePeriod
+		if !inGracePeriod {
+			s.revokeRememberTokens(s.db.Where("id = ?", rememberToken.ID))
+			return nil, "", "", &RememberTokenReusedError{UserID: rememberToken.UserID}
+		}
+
+		session, sessionToken, err := s.createSession(rememberToken.UserID, &rememberToken.ID, nil, ipAddress, userAgent)
+		return session, sessionToken, "", err
+	}
+
+	newToken, err := models.GenerateSecureToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	// Rotate only if nobody else did in the meantime; otherwise this
+	// request lost a race with another one carrying the same cookie.
+	now := time.Now()
+	result := s.db.Model(&models.RememberToken{}).
+		Where("id = ? AND token_digest = ?", rememberToken.ID, digest).
+		Updates(map[string]interface{}{
+			"token_digest":          models.HashToken(newToken),
+			"previous_token_digest": digest,
+			"rotated_at":            now,
+			"last_used_at":          now,
+		})
+	if result.Error != nil {
+		return nil, "", "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		session, sessionToken, err := s.createSession(rememberToken.UserID, &rememberToken.ID, nil, ipAddress, userAgent)
+		return session, sessionToken, "", err
+	}
+
+	// The series' earlier sessions are replaced by the new one.
+	if err := s.db.Where("remember_token_id = ?", rememberToken.ID).Delete(&models.Session{}).Error; err != nil {
+		return nil, "", "", err
+	}
+
+	session, sessionToken, err := s.createSession(rememberToken.UserID, &rememberToken.ID, nil, ipAddress, userAgent)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return session, sessionToken, series + ":" + newToken, nil
+}
+
+// ForgetRememberToken revokes the series behind a remember cookie, if any.
+func (s *SessionService) ForgetRememberToken(cookie string) error {
+	series, _, _ := strings.Cut(cookie, ":")
+	if series == "" {
+		return nil
+	}
+	return s.revokeRememberTokens(s.db.Where("series = ?", series))
+}
+
+// revokeRememberTokens deletes the matching remember tokens and every
+// session opened through them.
+func (s *SessionService) revokeRememberTokens(query *gorm.DB) error {
+	var ids []uint
+	if err := query.Model(&models.RememberToken{}).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := s.db.Where("remember_token_id IN ?", ids).Delete(&models.Session{}).Error; err != nil {
+		return err
+	}
+	return s.db.Where("id IN ?", ids).Delete(&models.RememberToken{}).Error
+}
+
 func (s *SessionService) GetSessionByToken(token string) (*models.Session, error) {
 	var session models.Session
-	if err := s.db.Where("token = ?", token).First(&session).Error; err != nil {
+	if err := s.db.Where("token_digest = ?", models.HashToken(token)).First(&session).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
 		}
 		return nil, err
 	}
-	
+
 	return &session, nil
 }
 
+// DestroySession ends the session and, for a remembered session, forgets
+// the device as well.
 func (s *SessionService) DestroySession(token string) error {
-	return s.db.Where("token = ?", token).Delete(&models.Session{}).Error
+	session, err := s.GetSessionByToken(token)
+	if err != nil || session == nil {
+		return err
+	}
+
+	if session.RememberTokenID != nil {
+		if err := s.revokeRememberTokens(s.db.Where("id = ?", *session.RememberTokenID)); err != nil {
+			return err
+		}
+	}
+
+	return s.db.Delete(session).Error
 }
 
-func (s *SessionService) DestroyUserSessions(userID uint) error {
-	return s.db.Where("user_id = ?", userID).Delete(&models.Session{}).Error
+// DestroyUserSessions signs the user out everywhere, including remembered
+// devices, and returns how many sessions were ended.
+func (s *SessionService) DestroyUserSessions(userID uint) (int64, error) {
+	result := s.db.Where("user_id = ?", userID).Delete(&models.Session{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	if err := s.db.Where("user_id = ?", userID).Delete(&models.RememberToken{}).Error; err != nil {
+		return result.RowsAffected, err
+	}
+
+	return result.RowsAffected, nil
+}
+
+// DestroyOtherUserSessions signs the user out everywhere except the session
+// identified by keepToken.
+func (s *SessionService) DestroyOtherUserSessions(userID uint, keepToken string) (int64, error) {
+	rememberTokens := s.db.Where("user_id = ?", userID)
+	if keep, err := s.GetSessionByToken(keepToken); err != nil {
+		return 0, err
+	} else if keep != nil && keep.RememberTokenID != nil {
+		rememberTokens = rememberTokens.Where("id <> ?", *keep.RememberTokenID)
+	}
+	if err := s.revokeRememberTokens(rememberTokens); err != nil {
+		return 0, err
+	}
+
+	result := s.db.Where("user_id = ? AND token_digest <> ?", userID, models.HashToken(keepToken)).
+		Delete(&models.Session{})
+	return result.RowsAffected, result.Error
+}
+
+// RevokeUserSession ends one of the user's sessions. It returns
+// gorm.ErrRecordNotFound if the session does not belong to the user.
+func (s *SessionService) RevokeUserSession(userID, sessionID uint) error {
+	var session models.Session
+	if err := s.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return err
+	}
+
+	if session.RememberTokenID != nil {
+		if err := s.revokeRememberTokens(s.db.Where("id = ?", *session.RememberTokenID)); err != nil {
+			return err
+		}
+	}
+
+	return s.db.Delete(&session).Error
+}
+
+// GetActiveUserSessions lists the user's sessions that can still be used,
+// most recently used first. A remembered session stays listed after it
+// times out because its device can sign straight back in.
+func (s *SessionService) GetActiveUserSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.db.Where("user_id = ?", userID).
+		Where("expires_at > ? OR remember_token_id IN (?)", time.Now(), s.validRememberTokenIDs()).
+		Order("updated_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (s *SessionService) validRememberTokenIDs() *gorm.DB {
+	now := time.Now()
+	return s.db.Model(&models.RememberToken{}).Select("id").
+		Where("expires_at > ? AND last_used_at > ?", now, now.Add(-models.RememberTokenIdleTimeout))
 }
 
 func (s *SessionService) CleanupExpiredSessions() error {
-	return s.db.Where("expires_at < ?", time.Now()).Delete(&models.Session{}).Error
+	now := time.Now()
+	if err := s.revokeRememberTokens(s.db.Where("expires_at < ? OR last_used_at < ?", now, now.Add(-models.RememberTokenIdleTimeout))); err != nil {
+		return err
+	}
+
+	return s.db.Where("expires_at < ?", now).
+		Where("remember_token_id IS NULL OR remember_token_id NOT IN (?)", s.validRememberTokenIDs()).
+		Delete(&models.Session{}).Error
 }
 
 func (s *SessionService) ExtendSession(token string) error {
-	return s.db.Model(&models.Session{}).
-		Where("token = ?", token).
-		Update("expires_at", time.Now().Add(30*time.Minute)).Error
-}
\ No newline at end of file
+	session, err := s.GetSessionByToken(token)
+	if err != nil || session == nil {
+		return err
+	}
+
+	session.Extend()
+	return s.db.Model(session).Update("expires_at", session.ExpiresAt).Error
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestSessionRevocation(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	
+	user := &models.User{Email: "test@example.com", Name: "Test User", Role: models.RoleSalesperson, Enabled: true}
+	other := &models.User{Email: "other@example.com", Name: "Other User", Role: models.RoleSalesperson, Enabled: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	if err := db.Create(other).Error; err != nil {
+		t.Fatalf("Failed to create other user: %v", err)
+	}
+	
+	first, _, _ := sessionService.CreateSession(user, "127.0.0.1", "test-agent")
+	sessionService.CreateSession(user, "127.0.0.1", "test-agent")
+	otherSession, _, _ := sessionService.CreateSession(other, "127.0.0.1", "test-agent")
+	
+	if err := sessionService.RevokeUserSession(user.ID, otherSession.ID); err != gorm.ErrRecordNotFound {
+		t.Errorf("Revoking another user's session should fail with ErrRecordNotFound, got %v", err)
+	}
+	
+	if err := sessionService.RevokeUserSession(user.ID, first.ID); err != nil {
+		t.Fatalf("RevokeUserSession failed: %v", err)
+	}
+	
+	sessions, _ := sessionService.GetActiveUserSessions(user.ID)
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 active session after revoking one, got %d", len(sessions))
+	}
+	
+	// Disabling the user ends their remaining sessions.
+	user.Enabled = false
+	if err := db.Save(user).Error; err != nil {
+		t.Fatalf("Failed to disable user: %v", err)
+	}
+	
+	sessions, _ = sessionService.GetActiveUserSessions(user.ID)
+	if len(sessions) != 0 {
+		t.Errorf("Expected no sessions for a disabled user, got %d", len(sessions))
+	}
+	
+	if err := db.First(&models.Session{}, otherSession.ID).Error; err != nil {
+		t.Error("Other users' sessions should be untouched")
+	}
+	
+	// Deleting a user ends their sessions as well.
+	if err := db.Delete(other).Error; err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if err := db.First(&models.Session{}, otherSession.ID).Error; err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected a deleted user's sessions to be removed, got %v", err)
+	}
+}
+
+func TestSessionAbsoluteTimeout(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	
+	user := &models.User{Email: "test@example.com", Name: "Test User", Role: models.RoleSalesperson, Enabled: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	session, _, err := sessionService.CreateSession(user, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	
+	absolute := time.Now().Add(10 * time.Minute)
+	session.AbsoluteExpiresAt = &absolute
+	session.Extend()
+	if session.ExpiresAt.After(absolute) {
+		t.Error("Extend should not go past the absolute timeout")
+	}
+	
+	past := time.Now().Add(-time.Minute)
+	session.AbsoluteExpiresAt = &past
+	session.ExpiresAt = time.Now().Add(time.Hour)
+	if !session.IsExpired() {
+		t.Error("Session past its absolute timeout should be expired")
+	}
+}
@@ -0,0 +1,463 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/oidc"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSSOProviderUnknown = errors.New("unknown SSO provider")
+	ErrSSOStateInvalid    = errors.New("SSO sign-in has expired or was not started from this browser")
+	ErrSSOAccountNotFound = errors.New("no account matches this SSO identity")
+	ErrSSOEmailUnverified = errors.New("the identity provider has not verified this email address")
+	ErrSSOCompanyMismatch = errors.New("the account belongs to a different company")
+	ErrSSOCompanyUnknown  = errors.New("the provider's company does not exist")
+	ErrSSOLinkRequired    = errors.New("the account has to link this provider from its profile first")
+	ErrSSOIdentityTaken   = errors.New("the SSO identity is already linked to another account")
+)
+
+// ssoStateTTL is how long a user has to finish signing in at the provider.
+const ssoStateTTL = 10 * time.Minute
+
+// SSOProvider is one company's OpenID Connect identity provider and how its
+// claims map onto local accounts.
+type SSOProvider struct {
+	// Key identifies the provider in URLs and linked identities.
+	Key  string
+	Name string
+	OIDC oidc.Config
+
+	// Company names the company of users provisioned through this
+	// provider. If CompanyClaim is set and names a known company, that wins
+	// instead. Without either, the email domain decides. Existing accounts
+	// are only linked by email when they belong to this company.
+	Company      string
+	CompanyClaim string
+
+	// RoleClaim names a string or list claim whose values are looked up in
+	// RoleMap. The most privileged match is used, else DefaultRole.
+	RoleClaim   string
+	RoleMap     map[string]models.UserRole
+	DefaultRole models.UserRole
+
+	// Provision creates accounts for unknown users on their first sign-in.
+	Provision bool
+}
+
+// MapRole picks the role for a new user from the provider's claims.
+func (p *SSOProvider) MapRole(claims map[string]interface{}) models.UserRole {
+	role := p.DefaultRole
+	for _, value := range claimValues(claims[p.RoleClaim]) {
+		if mapped, ok := p.RoleMap[value]; ok && mapped.Rank() > role.Rank() {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// MapCompany picks the company for a user from the provider's claims,
+// falling back to the provider's own company. It returns
+// ErrSSOCompanyUnknown if that does not exist.
+func (p *SSOProvider) MapCompany(claims map[string]interface{}, companies []models.Company) (*models.Company, error) {
+	if p.CompanyClaim != "" {
+		if value, ok := claims[p.CompanyClaim].(string); ok {
+			if company := models.FindCompany(companies, value); company != nil {
+				return company, nil
+			}
+		}
+	}
+	if p.Company == "" {
+		return nil, nil
+	}
+	company := models.FindCompany(companies, p.Company)
+	if company == nil {
+		return nil, ErrSSOCompanyUnknown
+	}
+	return company, nil
+}
+
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+type SSOService struct {
+	db              *gorm.DB
+	authService     *AuthService
+	activityService *ActivityService
+	providers       []SSOProvider
+	clients         map[string]*oidc.Client
+}
+
+func NewSSOService(db *gorm.DB, authService *AuthService, activityService *ActivityService, providers []SSOProvider) *SSOService {
+	clients := make(map[string]*oidc.Client, len(providers))
+	for _, provider := range providers {
+		clients[provider.Key] = oidc.NewClient(provider.OIDC)
+	}
+
+	return &SSOService{
+		db:              db,
+		authService:     authService,
+		activityService: activityService,
+		providers:       providers,
+		clients:         clients,
+	}
+}
+
+// Providers lists the configured providers in configuration order.
+func (s *SSOService) Providers() []SSOProvider {
+	return s.providers
+}
+
+func (s *SSOService) provider(key string) (*SSOProvider, *oidc.Client, error) {
+	for i := range s.providers {
+		if s.providers[i].Key == key {
+			return &s.providers[i], s.clients[key], nil
+		}
+	}
+	return nil, nil, ErrSSOProviderUnknown
+}
+
+// BeginLogin starts an authorization code flow with PKCE. It returns the URL
+// to send the browser to and the state value, which the caller must bind to
+// the browser so the callback can be matched to it.
+func (s *SSOService) BeginLogin(ctx context.Context, providerKey string) (string, string, error) {
+	return s.begin(ctx, providerKey, nil)
+}
+
+// BeginLink starts the same flow for a signed-in user who wants to sign in
+// with the provider from now on. Its state can only be completed by
+// CompleteLink for the same user.
+func (s *SSOService) BeginLink(ctx context.Context, providerKey string, user *models.User) (string, string, error) {
+	return s.begin(ctx, providerKey, &user.ID)
+}
+
+func (s *SSOService) begin(ctx context.Context, providerKey string, linkUserID *uint) (string, string, error) {
+	_, client, err := s.provider(providerKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := oidc.NewState()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.NewState()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := client.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	loginState := &models.SSOLoginState{
+		StateDigest:  models.HashToken(state),
+		Provider:     providerKey,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(ssoStateTTL),
+	}
+	if err := s.db.Create(loginState).Error; err != nil {
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+// redeemState looks up the state of a flow and deletes it, so that each
+// state can only be used once, even by concurrent callbacks.
+func (s *SSOService) redeemState(providerKey, state string) (*models.SSOLoginState, error) {
+	var loginState models.SSOLoginState
+	if err := s.db.Where("state_digest = ? AND provider = ?", models.HashToken(state), providerKey).First(&loginState).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrSSOStateInvalid
+		}
+		return nil, err
+	}
+
+	result := s.db.Where("id = ?", loginState.ID).Delete(&models.SSOLoginState{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || loginState.IsExpired() {
+		return nil, ErrSSOStateInvalid
+	}
+	return &loginState, nil
+}
+
+// CompleteLogin handles the provider's callback: it redeems the code,
+// matches the identity to a local account and signs the user in. Users with
+// two-factor authentication enabled still get the TOTP step.
+func (s *SSOService) CompleteLogin(ctx context.Context, providerKey, state, code, ipAddress, userAgent string) (*LoginResult, error) {
+	provider, client, err := s.provider(providerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	loginState, err := s.redeemState(providerKey, state)
+	if err != nil {
+		return nil, err
+	}
+	if loginState.LinkUserID != nil {
+		return nil, ErrSSOStateInvalid
+	}
+
+	idToken, err := client.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		s.logFailure(nil, provider, "", "", err, ipAddress, userAgent)
+		return nil, err
+	}
+
+	user, err := s.resolveUser(provider, idToken, ipAddress, userAgent)
+	if err != nil {
+		var userID *uint
+		if user != nil {
+			userID = &user.ID
+		}
+		s.logFailure(userID, provider, idToken.Subject, idToken.Email, err, ipAddress, userAgent)
+		return nil, err
+	}
+
+	s.activityService.LogActivity(&user.ID, "sso_login", ipAddress, userAgent, map[string]interface{}{
+		"user_id":  user.ID,
+		"provider": provider.Key,
+		"subject":  idToken.Subject,
+	})
+
+	return s.authService.LoginWithIdentity(user, ipAddress, userAgent)
+}
+
+// CompleteLink handles the provider's callback for a flow started by
+// BeginLink and links the identity to the signed-in user.
+func (s *SSOService) CompleteLink(ctx context.Context, providerKey, state, code string, user *models.User, ipAddress, userAgent string) error {
+	provider, client, err := s.provider(providerKey)
+	if err != nil {
+		return err
+	}
+
+	loginState, err := s.redeemState(providerKey, state)
+	if err != nil {
+		return err
+	}
+	if loginState.LinkUserID == nil || *loginState.LinkUserID != user.ID {
+		return ErrSSOStateInvalid
+	}
+
+	idToken, err := client.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		s.logFailure(&user.ID, provider, "", "", err, ipAddress, userAgent)
+		return err
+	}
+
+	var identity models.UserIdentity
+	err = s.db.Where("provider = ? AND subject = ?", provider.Key, idToken.Subject).First(&identity).Error
+	if err == nil && identity.UserID != user.ID {
+		s.logFailure(&user.ID, provider, idToken.Subject, idToken.Email, ErrSSOIdentityTaken, ipAddress, userAgent)
+		return ErrSSOIdentityTaken
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if err := s.linkIdentity(user, provider, idToken, time.Now()); err != nil {
+		return err
+	}
+	s.logLinked(user, provider, idToken, ipAddress, userAgent)
+	return nil
+}
+
+// resolveUser finds the account for an identity: first by a previous link,
+// then by email address, and finally by provisioning a new account. Only
+// accounts of the company the provider is bound to are linked by email;
+// anyone else has to link the provider from their profile.
+func (s *SSOService) resolveUser(provider *SSOProvider, idToken *oidc.IDToken, ipAddress, userAgent string) (*models.User, error) {
+	now := time.Now()
+
+	var identity models.UserIdentity
+	err := s.db.Preload("User").Where("provider = ? AND subject = ?", provider.Key, idToken.Subject).First(&identity).Error
+	if err == nil && identity.User.ID == 0 {
+		// The linked user has been deleted.
+		return nil, ErrSSOAccountNotFound
+	}
+	if err == nil {
+		// Update by ID so the preloaded user is not saved back as an association.
+		s.db.Model(&models.UserIdentity{}).Where("id = ?", identity.ID).Update("last_login_at", now)
+		return &identity.User, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	email := normalizeEmail(idToken.Email)
+	if email == "" {
+		return nil, ErrSSOAccountNotFound
+	}
+	if idToken.EmailVerified == nil || !*idToken.EmailVerified {
+		return nil, ErrSSOEmailUnverified
+	}
+
+	var companies []models.Company
+	if err := s.db.Find(&companies).Error; err != nil {
+		return nil, err
+	}
+	company, err := provider.MapCompany(idToken.Claims, companies)
+	if err != nil {
+		return nil, err
+	}
+	if company == nil {
+		company = models.CompanyForEmail(companies, email)
+	}
+
+	var user models.User
+	err = s.db.Where("email = ?", email).First(&user).Error
+	switch {
+	case err == nil:
+		// Never link across companies: another company's provider must not
+		// be able to sign in to this account by asserting its address.
+		if company != nil && user.CompanyID != nil && *user.CompanyID != company.ID {
+			return &user, ErrSSOCompanyMismatch
+		}
+		bound := models.FindCompany(companies, provider.Company)
+		if bound == nil || user.CompanyID == nil || *user.CompanyID != bound.ID {
+			return &user, ErrSSOLinkRequired
+		}
+		if err := s.linkIdentity(&user, provider, idToken, now); err != nil {
+			return nil, err
+		}
+		s.logLinked(&user, provider, idToken, ipAddress, userAgent)
+		return &user, nil
+	case err != gorm.ErrRecordNotFound:
+		return nil, err
+	case !provider.Provision:
+		return nil, ErrSSOAccountNotFound
+	}
+
+	user = models.User{
+		Email:   email,
+		Name:    ssoDisplayName(idToken),
+		Role:    provider.MapRole(idToken.Claims),
+		Company: company,
+		Enabled: true,
+	}
+	if company != nil {
+		user.CompanyID = &company.ID
+	}
+	if err := user.SetRandomPassword(); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Company").Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserIdentity{
+			UserID:      user.ID,
+			Provider:    provider.Key,
+			Subject:     idToken.Subject,
+			Email:       email,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.activityService.LogActivity(&user.ID, "sso_user_provisioned", ipAddress, userAgent, map[string]interface{}{
+		"user_id":   user.ID,
+		"user_role": user.Role.String(),
+		"company":   user.CompanyName(),
+		"provider":  provider.Key,
+		"subject":   idToken.Subject,
+	})
+	return &user, nil
+}
+
+func (s *SSOService) linkIdentity(user *models.User, provider *SSOProvider, idToken *oidc.IDToken, now time.Time) error {
+	// A user has at most one identity per provider; a new subject replaces
+	// the old link, e.g. after the account was recreated at the provider.
+	if err := s.db.Where("user_id = ? AND provider = ?", user.ID, provider.Key).Delete(&models.UserIdentity{}).Error; err != nil {
+		return err
+	}
+	return s.db.Create(&models.UserIdentity{
+		UserID:      user.ID,
+		Provider:    provider.Key,
+		Subject:     idToken.Subject,
+		Email:       normalizeEmail(idToken.Email),
+		LastLoginAt: &now,
+	}).Error
+}
+
+func (s *SSOService) logLinked(user *models.User, provider *SSOProvider, idToken *oidc.IDToken, ipAddress, userAgent string) {
+	s.activityService.LogActivity(&user.ID, "sso_account_linked", ipAddress, userAgent, map[string]interface{}{
+		"user_id":  user.ID,
+		"provider": provider.Key,
+		"subject":  idToken.Subject,
+	})
+}
+
+func (s *SSOService) logFailure(userID *uint, provider *SSOProvider, subject, email string, err error, ipAddress, userAgent string) {
+	s.activityService.LogActivity(userID, "sso_login_failed", ipAddress, userAgent, map[string]interface{}{
+		"provider": provider.Key,
+		"subject":  subject,
+		"email":    email,
+		"reason":   err.Error(),
+	})
+}
+
+// GetUserIdentities lists the providers linked to the user's account.
+func (s *SSOService) GetUserIdentities(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := s.db.Where("user_id = ?", userID).Order("provider").Find(&identities).Error
+	return identities, err
+}
+
+func (s *SSOService) CleanupExpiredStates() error {
+	return s.db.Where("expires_at < ?", time.Now()).Delete(&models.SSOLoginState{}).Error
+}
+
+// ssoDisplayName picks a name that passes models.ValidateName, falling back
+// to the email address when the provider sent no usable name.
+func ssoDisplayName(idToken *oidc.IDToken) string {
+	name := strings.TrimSpace(idToken.Name)
+	if name == "" {
+		given, _ := idToken.Claims["given_name"].(string)
+		family, _ := idToken.Claims["family_name"].(string)
+		name = strings.TrimSpace(given + " " + family)
+	}
+	if models.ValidateName(name) == nil {
+		return name
+	}
+
+	local, _, _ := strings.Cut(idToken.Email, "@")
+	if models.ValidateName(local) == nil {
+		return local
+	}
+	if len(idToken.Email) > 100 {
+		return idToken.Email[:100]
+	}
+	return idToken.Email
+}
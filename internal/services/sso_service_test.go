@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/oidc"
+	"alsafwanmarine.com/todo-app/internal/oidc/oidctest"
+)
+
+// ssoSignIn runs a full sign-in against the mock issuer, following the
+// provider's redirect the way a browser would.
+func ssoSignIn(t *testing.T, ssoService *SSOService, providerKey string) (*LoginResult, error) {
+	authURL, state, err := ssoService.BeginLogin(context.Background(), providerKey)
+	if err != nil {
+		t.Fatalf("BeginLogin failed: %v", err)
+	}
+	code := ssoAuthorize(t, authURL, state)
+	return ssoService.CompleteLogin(context.Background(), providerKey, state, code, "127.0.0.1", "test-agent")
+}
+
+// ssoLink links the provider to a signed-in user the same way.
+func ssoLink(t *testing.T, ssoService *SSOService, providerKey string, user *models.User) error {
+	authURL, state, err := ssoService.BeginLink(context.Background(), providerKey, user)
+	if err != nil {
+		t.Fatalf("BeginLink failed: %v", err)
+	}
+	code := ssoAuthorize(t, authURL, state)
+	return ssoService.CompleteLink(context.Background(), providerKey, state, code, user, "127.0.0.1", "test-agent")
+}
+
+// ssoAuthorize follows the redirect to the provider and returns the code
+// it sends back.
+func ssoAuthorize(t *testing.T, authURL, state string) string {
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatalf("Authorization request failed: %v", err)
+	}
+	resp.Body.Close()
+	
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	if callback.Query().Get("state") != state {
+		t.Fatalf("Provider returned state %q, want %q", callback.Query().Get("state"), state)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestSSOServiceLogin(t *testing.T) {
+	db := setupTestDB(t)
+	
+	issuer := oidctest.NewIssuer("todo-app", "s3cret")
+	defer issuer.Close()
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	asm := &models.Company{Name: "Al Safwan Marine", DefaultRole: models.RoleSalesperson}
+	louisSafety := &models.Company{Name: "Louis Safety", DefaultRole: models.RoleSalesperson}
+	db.Create(asm)
+	db.Create(louisSafety)
+	ssoService := NewSSOService(db, authService, activityService, []SSOProvider{{
+		Key:  "asm",
+		Name: "Al Safwan Marine",
+		OIDC: oidc.Config{
+			Issuer:       issuer.URL,
+			ClientID:     "todo-app",
+			ClientSecret: "s3cret",
+			RedirectURL:  "http://localhost/login/sso/asm/callback",
+		},
+		Company:     "Al Safwan Marine",
+		RoleClaim:   "groups",
+		RoleMap:     map[string]models.UserRole{"asm-managers": models.RoleManager, "asm-admins": models.RoleAdmin},
+		DefaultRole: models.RoleSalesperson,
+		Provision:   true,
+	}})
+	
+	// An unknown user is provisioned with the mapped role and company.
+	issuer.SetClaims(map[string]interface{}{
+		"sub":            "sub-jane",
+		"email":          "Jane@AlSafwanMarine.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+		"groups":         []string{"staff", "asm-managers"},
+	})
+	result, err := ssoSignIn(t, ssoService, "asm")
+	if err != nil {
+		t.Fatalf("SSO login failed: %v", err)
+	}
+	if result.Token == "" || result.User.Email != "jane@alsafwanmarine.com" {
+		t.Fatalf("Expected a session for jane@alsafwanmarine.com, got %+v", result)
+	}
+	if result.User.Role != models.RoleManager || result.User.CompanyName() != "Al Safwan Marine" {
+		t.Errorf("Provisioned user has role %v and company %q", result.User.Role, result.User.CompanyName())
+	}
+	if result.PasswordChangeRequired {
+		t.Error("Provisioned users should not be sent to the change password page")
+	}
+	
+	// The second sign-in finds the linked identity even if the email changed.
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-jane", "email": "jane.doe@alsafwanmarine.com"})
+	result, err = ssoSignIn(t, ssoService, "asm")
+	if err != nil {
+		t.Fatalf("Second SSO login failed: %v", err)
+	}
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 1 || result.User.Email != "jane@alsafwanmarine.com" {
+		t.Errorf("Expected the linked account to be reused, have %d users", count)
+	}
+	
+	// An existing account is linked by email, keeping its role.
+	existing := &models.User{Email: "sam@alsafwanmarine.com", Name: "Sam", Role: models.RoleSalesperson, CompanyID: &asm.ID, Enabled: true}
+	existing.SetPassword("Secur3!Passw0rd")
+	db.Create(existing)
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-sam", "email": "sam@alsafwanmarine.com", "email_verified": true, "groups": "asm-admins"})
+	result, err = ssoSignIn(t, ssoService, "asm")
+	if err != nil {
+		t.Fatalf("SSO login for an existing account failed: %v", err)
+	}
+	if result.User.ID != existing.ID || result.User.Role != models.RoleSalesperson {
+		t.Errorf("Expected to sign in as the existing salesperson, got user %d with role %v", result.User.ID, result.User.Role)
+	}
+	identities, _ := ssoService.GetUserIdentities(existing.ID)
+	if len(identities) != 1 || identities[0].Subject != "sub-sam" {
+		t.Errorf("Expected the account to be linked to sub-sam, got %+v", identities)
+	}
+	
+	// Accounts of another company are never linked.
+	louis := &models.User{Email: "lou@louissafety.com", Name: "Lou", Role: models.RoleSalesperson, CompanyID: &louisSafety.ID, Enabled: true}
+	louis.SetPassword("Secur3!Passw0rd")
+	db.Create(louis)
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-lou", "email": "lou@louissafety.com", "email_verified": true})
+	if _, err := ssoSignIn(t, ssoService, "asm"); err != ErrSSOCompanyMismatch {
+		t.Errorf("Expected ErrSSOCompanyMismatch, got %v", err)
+	}
+	
+	// Unverified addresses are neither linked nor provisioned, and a
+	// missing email_verified claim does not count as verified.
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-eve", "email": "sam@alsafwanmarine.com", "email_verified": false})
+	if _, err := ssoSignIn(t, ssoService, "asm"); err != ErrSSOEmailUnverified {
+		t.Errorf("Expected ErrSSOEmailUnverified, got %v", err)
+	}
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-eve", "email": "sam@alsafwanmarine.com"})
+	if _, err := ssoSignIn(t, ssoService, "asm"); err != ErrSSOEmailUnverified {
+		t.Errorf("Expected ErrSSOEmailUnverified without the claim, got %v", err)
+	}
+	
+	// Accounts without a company have to link the provider themselves.
+	admin := &models.User{Email: "it@alsafwanmarine.com", Name: "IT", Role: models.RoleAdmin, Enabled: true}
+	admin.SetPassword("Secur3!Passw0rd")
+	db.Create(admin)
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-it", "email": "it@alsafwanmarine.com", "email_verified": true})
+	if _, err := ssoSignIn(t, ssoService, "asm"); err != ErrSSOLinkRequired {
+		t.Errorf("Expected ErrSSOLinkRequired for an account without a company, got %v", err)
+	}
+	if err := ssoLink(t, ssoService, "asm", admin); err != nil {
+		t.Fatalf("Linking the provider failed: %v", err)
+	}
+	result, err = ssoSignIn(t, ssoService, "asm")
+	if err != nil || result.User.ID != admin.ID {
+		t.Errorf("Expected to sign in as the linked admin, got %+v, %v", result, err)
+	}
+	
+	// An identity linked to one account cannot be linked to another, and
+	// link states cannot be used to sign in.
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-sam"})
+	if err := ssoLink(t, ssoService, "asm", admin); err != ErrSSOIdentityTaken {
+		t.Errorf("Expected ErrSSOIdentityTaken, got %v", err)
+	}
+	_, state, _ := ssoService.BeginLink(context.Background(), "asm", admin)
+	if _, err := ssoService.CompleteLogin(context.Background(), "asm", state, "bad-code", "127.0.0.1", "test-agent"); err != ErrSSOStateInvalid {
+		t.Errorf("Expected ErrSSOStateInvalid for a link state, got %v", err)
+	}
+	_, state, _ = ssoService.BeginLink(context.Background(), "asm", admin)
+	if err := ssoService.CompleteLink(context.Background(), "asm", state, "bad-code", existing, "127.0.0.1", "test-agent"); err != ErrSSOStateInvalid {
+		t.Errorf("Expected ErrSSOStateInvalid for another user's link state, got %v", err)
+	}
+	
+	// Users with TOTP still have to pass the second factor.
+	db.Model(existing).Update("totp_enabled", true)
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-sam"})
+	result, err = ssoSignIn(t, ssoService, "asm")
+	if err != nil {
+		t.Fatalf("SSO login with two-factor failed: %v", err)
+	}
+	if !result.TwoFactorRequired || result.Token != "" {
+		t.Error("Expected a two-factor challenge instead of a session")
+	}
+	
+	// Disabled users cannot sign in through their link.
+	db.Model(existing).Updates(map[string]interface{}{"enabled": false, "totp_enabled": false})
+	if _, err := ssoSignIn(t, ssoService, "asm"); err != ErrUserDisabled {
+		t.Errorf("Expected ErrUserDisabled, got %v", err)
+	}
+	
+	// A state can only be used once.
+	_, state, _ = ssoService.BeginLogin(context.Background(), "asm")
+	ssoService.CompleteLogin(context.Background(), "asm", state, "bad-code", "127.0.0.1", "test-agent")
+	if _, err := ssoService.CompleteLogin(context.Background(), "asm", state, "bad-code", "127.0.0.1", "test-agent"); err != ErrSSOStateInvalid {
+		t.Errorf("Expected ErrSSOStateInvalid for a reused state, got %v", err)
+	}
+	
+	if _, _, err := ssoService.BeginLogin(context.Background(), "nope"); err != ErrSSOProviderUnknown {
+		t.Errorf("Expected ErrSSOProviderUnknown, got %v", err)
+	}
+}
+
+func TestSSOServiceWithoutProvisioning(t *testing.T) {
+	db := setupTestDB(t)
+	
+	issuer := oidctest.NewIssuer("todo-app", "")
+	defer issuer.Close()
+	
+	activityService := NewActivityService(db)
+	authService := NewAuthService(db, NewSessionService(db), activityService, NewTwoFactorService(db, activityService, testAppKey), NewLockoutService(db, activityService, models.DefaultLockoutPolicy()), NewPasswordPolicyService(db), NewSecurityAlertService(db, NewSessionService(db), activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, NewSessionService(db), activityService, ""))
+	ssoService := NewSSOService(db, authService, activityService, []SSOProvider{{
+		Key:  "dgl",
+		OIDC: oidc.Config{Issuer: issuer.URL, ClientID: "todo-app", RedirectURL: "http://localhost/login/sso/dgl/callback"},
+	}})
+	
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-new", "email": "new@datagridlabs.com", "email_verified": true})
+	if _, err := ssoSignIn(t, ssoService, "dgl"); err != ErrSSOAccountNotFound {
+		t.Errorf("Expected ErrSSOAccountNotFound, got %v", err)
+	}
+	
+	// A provider that is not bound to a company never links by email.
+	dgl := &models.Company{Name: "Data Grid Labs", DefaultRole: models.RoleSalesperson}
+	db.Create(dgl)
+	existing := &models.User{Email: "new@datagridlabs.com", Name: "New", Role: models.RoleSalesperson, CompanyID: &dgl.ID, Enabled: true}
+	existing.SetPassword("Secur3!Passw0rd")
+	db.Create(existing)
+	if _, err := ssoSignIn(t, ssoService, "dgl"); err != ErrSSOLinkRequired {
+		t.Errorf("Expected ErrSSOLinkRequired, got %v", err)
+	}
+}
+
+func TestSSOProviderMapRole(t *testing.T) {
+	provider := SSOProvider{
+		RoleClaim:   "roles",
+		RoleMap:     map[string]models.UserRole{"admins": models.RoleAdmin, "managers": models.RoleManager, "legacy": models.UserRole(-1)},
+		DefaultRole: models.RoleSalesperson,
+	}
+	
+	tests := []struct {
+		claim interface{}
+		want  models.UserRole
+	}{
+		{nil, models.RoleSalesperson},
+		{"managers", models.RoleManager},
+		{[]interface{}{"managers", "admins"}, models.RoleAdmin},
+		{[]interface{}{"others"}, models.RoleSalesperson},
+		{[]interface{}{"legacy", "managers"}, models.RoleManager},
+	}
+	for _, test := range tests {
+		if got := provider.MapRole(map[string]interface{}{"roles": test.claim}); got != test.want {
+			t.Errorf("MapRole(%v) = %v, want %v", test.claim, got, test.want)
+		}
+	}
+}
+
+func TestSSOProviderMapCompany(t *testing.T) {
+	companies := []models.Company{{ID: 1, Name: "Al Safwan Marine"}, {ID: 2, Name: "Louis Safety"}}
+	provider := SSOProvider{Company: "al safwan marine", CompanyClaim: "org"}
+	
+	tests := []struct {
+		claim interface{}
+		want  uint
+	}{
+		{nil, 1},
+		{"Louis Safety ", 2},
+		{"Unknown Ltd", 1},
+	}
+	for _, test := range tests {
+		company, err := provider.MapCompany(map[string]interface{}{"org": test.claim}, companies)
+		if err != nil || company == nil || company.ID != test.want {
+			t.Errorf("MapCompany(%v) = %+v, %v; want company %d", test.claim, company, err, test.want)
+		}
+	}
+	
+	provider.Company = "Data Grid Labs"
+	if _, err := provider.MapCompany(nil, companies); err != ErrSSOCompanyUnknown {
+		t.Errorf("Expected ErrSSOCompanyUnknown for a missing company, got %v", err)
+	}
+	provider.Company = ""
+	if company, err := provider.MapCompany(nil, companies); company != nil || err != nil {
+		t.Errorf("Expected no company without one configured, got %+v, %v", company, err)
+	}
+}
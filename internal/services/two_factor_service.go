@@ -0,0 +1,268 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/totp"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+const twoFactorIssuer = "ASM Tracker"
+
+// sealedSecretPrefix marks TOTP secrets that are encrypted with the app key,
+// telling them apart from plaintext secrets stored by older versions.
+const sealedSecretPrefix = "sealed:"
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor authentication has not been set up")
+	ErrTwoFactorRequiredByRole = errors.New("two-factor authentication is required for your role")
+	ErrInvalidTwoFactorCode    = errors.New("invalid authentication code")
+)
+
+type TwoFactorService struct {
+	db              *gorm.DB
+	activityService *ActivityService
+	requiredRoles   map[models.UserRole]bool
+	secretKey       []byte
+}
+
+func NewTwoFactorService(db *gorm.DB, activityService *ActivityService, appKey []byte) *TwoFactorService {
+	return &TwoFactorService{
+		db:              db,
+		activityService: activityService,
+		secretKey:       models.DeriveKey(appKey, "totp-secrets"),
+		requiredRoles: map[models.UserRole]bool{
+			models.RoleAdmin:   true,
+			models.RoleManager: true,
+		},
+	}
+}
+
+// SetRequiredRoles replaces the roles that must enroll before using the app.
+func (s *TwoFactorService) SetRequiredRoles(roles ...models.UserRole) {
+	s.requiredRoles = make(map[models.UserRole]bool, len(roles))
+	for _, role := range roles {
+		s.requiredRoles[role] = true
+	}
+}
+
+// IsRequired reports whether the user's role mandates two-factor login.
+func (s *TwoFactorService) IsRequired(user *models.User) bool {
+	return s.requiredRoles[user.Role]
+}
+
+// NeedsEnrollment reports whether the user must set up two-factor
+// authentication before continuing.
+func (s *TwoFactorService) NeedsEnrollment(user *models.User) bool {
+	return s.IsRequired(user) && !user.TOTPEnabled
+}
+
+type TwoFactorEnrollment struct {
+	Secret string
+	URI    string
+	QRCode template.HTML
+}
+
+// BeginEnrollment returns the pending secret for the user, creating one on the
+// first call so reloading the setup page keeps showing the same QR code.
+func (s *TwoFactorService) BeginEnrollment(user *models.User) (*TwoFactorEnrollment, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	if user.TOTPSecret == nil {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := s.sealSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.Model(user).Update("totp_secret", sealed).Error; err != nil {
+			return nil, err
+		}
+		user.TOTPSecret = &sealed
+	}
+
+	secret, err := s.openSecret(user)
+	if err != nil {
+		return nil, err
+	}
+	uri := totp.ProvisioningURI(twoFactorIssuer, user.Email, secret)
+	code, err := qrCodeSVG(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: secret,
+		URI:    uri,
+		QRCode: code,
+	}, nil
+}
+
+// ConfirmEnrollment enables two-factor login once the user proves their
+// authenticator produces valid codes for the pending secret.
+func (s *TwoFactorService) ConfirmEnrollment(user *models.User, code, ipAddress, userAgent string) error {
+	if user.TOTPEnabled {
+		return ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == nil {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	if !s.VerifyCode(user, code) {
+		s.activityService.LogTwoFactorEvent(user, "two_factor_failed", "enroll", ipAddress, userAgent)
+		return ErrInvalidTwoFactorCode
+	}
+
+	now := time.Now()
+	user.TOTPEnabled = true
+	user.TOTPEnabledAt = &now
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"totp_enabled":    true,
+		"totp_enabled_at": now,
+	}).Error; err != nil {
+		return err
+	}
+
+	s.activityService.LogTwoFactorEvent(user, "two_factor_enroll", "enroll", ipAddress, userAgent)
+
+	return nil
+}
+
+// Disable turns off two-factor login after re-checking the password and a
+// current code. Users whose role requires it cannot opt out.
+func (s *TwoFactorService) Disable(user *models.User, password, code, ipAddress, userAgent string) error {
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnrolled
+	}
+	if s.IsRequired(user) {
+		return ErrTwoFactorRequiredByRole
+	}
+	if !user.CheckPassword(password) {
+		return errors.New("current password is incorrect")
+	}
+	if !s.VerifyCode(user, code) {
+		s.activityService.LogTwoFactorEvent(user, "two_factor_failed", "disable", ipAddress, userAgent)
+		return ErrInvalidTwoFactorCode
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPEnabledAt = nil
+	user.TOTPSecret = nil
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"totp_enabled":    false,
+		"totp_enabled_at": nil,
+		"totp_secret":     nil,
+	}).Error; err != nil {
+		return err
+	}
+
+	s.activityService.LogTwoFactorEvent(user, "two_factor_disable", "disable", ipAddress, userAgent)
+
+	return nil
+}
+
+// VerifyCode checks a code against the user's secret and records the matched
+// time step, so a code cannot be used twice.
+func (s *TwoFactorService) VerifyCode(user *models.User, code string) bool {
+	secret, err := s.openSecret(user)
+	if err != nil {
+		return false
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false
+	}
+
+	result := s.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+
+	user.TOTPLastStep = step
+	return true
+}
+
+// EncryptStoredSecrets encrypts TOTP secrets that older versions stored in
+// plaintext. It is safe to run on every start.
+func (s *TwoFactorService) EncryptStoredSecrets() error {
+	var users []models.User
+	if err := s.db.Unscoped().
+		Where("totp_secret IS NOT NULL AND totp_secret NOT LIKE ?", sealedSecretPrefix+"%").
+		Find(&users).Error; err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		sealed, err := s.sealSecret(*user.TOTPSecret)
+		if err != nil {
+			return err
+		}
+		if err := s.db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Update("totp_secret", sealed).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealSecret encrypts a TOTP secret with the app key for storage.
+func (s *TwoFactorService) sealSecret(secret string) (string, error) {
+	sealed, err := models.SealSecret(s.secretKey, secret)
+	if err != nil {
+		return "", err
+	}
+	return sealedSecretPrefix + sealed, nil
+}
+
+// openSecret returns the user's TOTP secret in plaintext.
+func (s *TwoFactorService) openSecret(user *models.User) (string, error) {
+	if user.TOTPSecret == nil {
+		return "", ErrTwoFactorNotEnrolled
+	}
+	sealed, ok := strings.CutPrefix(*user.TOTPSecret, sealedSecretPrefix)
+	if !ok {
+		return "", models.ErrSealedSecret
+	}
+	return models.OpenSecret(s.secretKey, sealed)
+}
+
+// qrCodeSVG renders content as an inline SVG QR code. Inline markup keeps
+// it within the content security policy, which does not allow data: images.
+func qrCodeSVG(content string) (template.HTML, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	// The bitmap includes the quiet zone around the symbol.
+	bitmap := code.Bitmap()
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	// The SVG is built from the encoder's modules only, so it is safe to
+	// mark as HTML.
+	return template.HTML(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+			`<rect width="100%%" height="100%%" fill="#ffffff"/><path d="%s" fill="#000000"/></svg>`,
+		len(bitmap), len(bitmap), path.String(),
+	)), nil
+}
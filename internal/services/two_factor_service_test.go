@@ -0,0 +1,81 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/totp"
+)
+
+func TestTwoFactorServiceEnrollment(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	
+	user := &models.User{
+		Email:   "test@example.com",
+		Name:    "Test User",
+		Role:    models.RoleAdmin,
+		Enabled: true,
+	}
+	user.SetPassword("Secur3!Passw0rd")
+	
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	
+	if !twoFactorService.NeedsEnrollment(user) {
+		t.Error("Admin without two-factor should need enrollment")
+	}
+	
+	enrollment, err := twoFactorService.BeginEnrollment(user)
+	if err != nil {
+		t.Fatalf("BeginEnrollment failed: %v", err)
+	}
+	
+	if !strings.HasPrefix(string(enrollment.QRCode), "<svg") || enrollment.Secret == "" {
+		t.Error("Enrollment should include a secret and QR code")
+	}
+	
+	again, _ := twoFactorService.BeginEnrollment(user)
+	if again.Secret != enrollment.Secret {
+		t.Error("Pending secret should be reused until enrollment completes")
+	}
+	
+	code, _ := totp.Code(enrollment.Secret, time.Now())
+	if err := twoFactorService.ConfirmEnrollment(user, code, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("ConfirmEnrollment failed: %v", err)
+	}
+	
+	var updatedUser models.User
+	db.First(&updatedUser, user.ID)
+	
+	if !updatedUser.TOTPEnabled {
+		t.Error("Two-factor should be enabled after confirmation")
+	}
+	
+	if updatedUser.TOTPSecret == nil || strings.Contains(*updatedUser.TOTPSecret, enrollment.Secret) {
+		t.Error("The secret should be stored encrypted")
+	}
+	otherKey := NewTwoFactorService(db, activityService, []byte("another-app-key-0123456789abcdef"))
+	if next, _ := totp.Code(enrollment.Secret, time.Now().Add(30*time.Second)); otherKey.VerifyCode(&updatedUser, next) {
+		t.Error("A secret encrypted with another app key should not verify")
+	}
+	
+	if twoFactorService.VerifyCode(&updatedUser, code) {
+		t.Error("A code should not be accepted twice")
+	}
+	
+	if err := twoFactorService.Disable(&updatedUser, "Secur3!Passw0rd", code, "", ""); err != ErrTwoFactorRequiredByRole {
+		t.Errorf("Admins should not be able to disable two-factor, got %v", err)
+	}
+	
+	var enrollments int64
+	db.Model(&models.UserActivity{}).Where("activity_type = ?", "two_factor_enroll").Count(&enrollments)
+	if enrollments != 1 {
+		t.Errorf("Expected 1 two_factor_enroll activity, got %d", enrollments)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDeleteSelf          = errors.New("you cannot delete your own account")
+	ErrRestoreWindowPassed = errors.New("the user was deleted too long ago to be restored")
+)
+
+// UserArchiveService deletes users softly. A deleted user is archived and
+// can be restored for the retention window; after that their personal
+// details are purged and the row stays behind as a tombstone so the audit
+// trail keeps pointing at it.
+type UserArchiveService struct {
+	db              *gorm.DB
+	activityService *ActivityService
+	retention       time.Duration
+}
+
+func NewUserArchiveService(db *gorm.DB, activityService *ActivityService, retention time.Duration) *UserArchiveService {
+	return &UserArchiveService{
+		db:              db,
+		activityService: activityService,
+		retention:       retention,
+	}
+}
+
+func (s *UserArchiveService) Retention() time.Duration {
+	return s.retention
+}
+
+// withArchivedUsers is a preload scope for audit views, which keep showing
+// records of deleted and purged users.
+func withArchivedUsers(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// Archive deletes the user. They are signed out everywhere and no longer
+// listed or able to sign in, but can be restored.
+func (s *UserArchiveService) Archive(performingUser, user *models.User, ipAddress, userAgent string) error {
+	if performingUser.ID == user.ID {
+		return ErrDeleteSelf
+	}
+	if !performingUser.HasPermission(models.PermUsersDelete) || !performingUser.CanManageUser(user) {
+		return ErrPermissionDenied
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("deleted_by_id", performingUser.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.activityService.LogUserCRUD(performingUser, user, "delete", ipAddress, userAgent)
+	return nil
+}
+
+// GetArchivedUsers lists deleted users that can still be restored, most
+// recently deleted first.
+func (s *UserArchiveService) GetArchivedUsers() ([]models.User, error) {
+	var users []models.User
+	err := s.db.Unscoped().
+		Preload("Company").
+		Where("deleted_at IS NOT NULL AND purged_at IS NULL").
+		Order("deleted_at DESC").
+		Find(&users).Error
+	return users, err
+}
+
+// Restore brings back an archived user. Their sessions are gone, so they
+// sign in again as usual. It returns gorm.ErrRecordNotFound if the user is
+// not archived.
+func (s *UserArchiveService) Restore(performingUser *models.User, userID uint, ipAddress, userAgent string) (*models.User, error) {
+	var user models.User
+	if err := s.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL AND purged_at IS NULL", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+	if !performingUser.HasPermission(models.PermUsersDelete) || !performingUser.CanManageUser(&user) {
+		return nil, ErrPermissionDenied
+	}
+	if time.Now().After(user.PurgeAt(s.retention)) {
+		return nil, ErrRestoreWindowPassed
+	}
+
+	if err := s.db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"deleted_at":    nil,
+		"deleted_by_id": nil,
+	}).Error; err != nil {
+		return nil, err
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	user.DeletedByID = nil
+
+	s.activityService.LogUserCRUD(performingUser, &user, "restore", ipAddress, userAgent)
+	return &user, nil
+}
+
+// PurgeExpired turns users archived longer than the retention window into
+// tombstones: their personal details are replaced and their credentials
+// removed, while activities and password reset events stay linked to the
+// row. It returns how many users were purged.
+func (s *UserArchiveService) PurgeExpired() (int, error) {
+	var users []models.User
+	if err := s.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at <= ? AND purged_at IS NULL", time.Now().Add(-s.retention)).
+		Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range users {
+		if err := s.purge(&users[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (s *UserArchiveService) purge(user *models.User) error {
+	user.Anonymize()
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"name":            user.Name,
+			"email":           user.Email,
+			"password_digest": user.PasswordDigest,
+			"company_id":      nil,
+			"enabled":         false,
+			"totp_secret":     nil,
+			"totp_enabled":    false,
+			"totp_enabled_at": nil,
+			"purged_at":       user.PurgedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		for _, credential := range []interface{}{
+			&models.PasswordHistory{},
+			&models.APIToken{},
+			&models.Passkey{},
+			&models.UserIdentity{},
+			&models.RememberToken{},
+			&models.TwoFactorChallenge{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(credential).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.activityService.LogActivity(&user.ID, "user_purged", "", "", map[string]interface{}{
+		"user_id": user.ID,
+	})
+	return nil
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestUserArchiveService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	sessionService := NewSessionService(db)
+	activityService := NewActivityService(db)
+	twoFactorService := NewTwoFactorService(db, activityService, testAppKey)
+	lockoutService := NewLockoutService(db, activityService, models.DefaultLockoutPolicy())
+	passwordPolicyService := NewPasswordPolicyService(db)
+	authService := NewAuthService(db, sessionService, activityService, twoFactorService, lockoutService, passwordPolicyService, NewSecurityAlertService(db, sessionService, activityService, &recordingMailer{}, "http://localhost", models.DefaultSecurityAlertPolicy()), NewIPRuleService(db, sessionService, activityService, ""))
+	apiTokenService := NewAPITokenService(db, activityService)
+	archiveService := NewUserArchiveService(db, activityService, models.DefaultDeletedUserRetention)
+	
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	manager := &models.User{Email: "manager@example.com", Name: "Manager", Role: models.RoleManager, Enabled: true}
+	user := &models.User{Email: "user@example.com", Name: "Test User", Role: models.RoleSalesperson, Enabled: true}
+	for _, u := range []*models.User{admin, manager, user} {
+		u.SetPassword("Secur3!Passw0rd")
+		db.Create(u)
+	}
+	
+	result, err := authService.Login(LoginCredentials{Email: "user@example.com", Password: "Secur3!Passw0rd"}, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	_, apiToken, err := apiTokenService.CreateToken(user, "Script", []string{models.ScopeUsersRead}, nil, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	
+	if err := archiveService.Archive(admin, admin, "127.0.0.1", "test-agent"); err != ErrDeleteSelf {
+		t.Errorf("Admins should not delete themselves, got %v", err)
+	}
+	if err := archiveService.Archive(manager, admin, "127.0.0.1", "test-agent"); err != ErrPermissionDenied {
+		t.Errorf("Managers should not delete admins, got %v", err)
+	}
+	if err := archiveService.Archive(admin, user, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	
+	// Archived users drop out of normal queries and cannot sign in.
+	var count int64
+	db.Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Error("An archived user should be hidden from normal queries")
+	}
+	if session, _ := sessionService.GetSessionByToken(result.Token); session != nil {
+		t.Error("Archiving should end the user's sessions")
+	}
+	if _, err := authService.Login(LoginCredentials{Email: "user@example.com", Password: "Secur3!Passw0rd"}, "127.0.0.1", "test-agent"); err != ErrInvalidCredentials {
+		t.Errorf("An archived user should not sign in, got %v", err)
+	}
+	if _, _, err := apiTokenService.Authenticate(apiToken, "127.0.0.1"); err != ErrInvalidAPIToken {
+		t.Errorf("An archived user's API tokens should stop working, got %v", err)
+	}
+	
+	archived, _ := archiveService.GetArchivedUsers()
+	if len(archived) != 1 || archived[0].ID != user.ID || archived[0].DeletedByID == nil || *archived[0].DeletedByID != admin.ID {
+		t.Fatalf("Expected the archived user to be listed, got %+v", archived)
+	}
+	
+	restored, err := archiveService.Restore(admin, user.ID, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restored.IsArchived() {
+		t.Error("A restored user should no longer be archived")
+	}
+	if _, err := authService.Login(LoginCredentials{Email: "user@example.com", Password: "Secur3!Passw0rd"}, "127.0.0.1", "test-agent"); err != nil {
+		t.Errorf("A restored user should sign in again, got %v", err)
+	}
+	if _, err := archiveService.Restore(admin, user.ID, "127.0.0.1", "test-agent"); err != gorm.ErrRecordNotFound {
+		t.Errorf("Restoring a user that is not archived should fail, got %v", err)
+	}
+	
+	// Past the retention window the user can no longer be restored and is
+	// purged down to a tombstone.
+	if err := archiveService.Archive(admin, user, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Update("deleted_at", time.Now().Add(-models.DefaultDeletedUserRetention-time.Hour))
+	if _, err := archiveService.Restore(admin, user.ID, "127.0.0.1", "test-agent"); err != ErrRestoreWindowPassed {
+		t.Errorf("Restoring after the retention window should fail, got %v", err)
+	}
+	
+	purged, err := archiveService.PurgeExpired()
+	if err != nil {
+		t.Fatalf("PurgeExpired failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected one user to be purged, got %d", purged)
+	}
+	
+	var tombstone models.User
+	db.Unscoped().First(&tombstone, user.ID)
+	if tombstone.PurgedAt == nil || strings.Contains(tombstone.Email, "user@example.com") || tombstone.Name == "Test User" || tombstone.Enabled {
+		t.Errorf("Expected the user's details to be anonymized, got %+v", tombstone)
+	}
+	db.Model(&models.APIToken{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Error("Purging should remove the user's credentials")
+	}
+	db.Model(&models.UserActivity{}).Where("user_id = ?", user.ID).Count(&count)
+	if count == 0 {
+		t.Error("The user's activities should stay linked to the tombstone")
+	}
+	
+	archived, _ = archiveService.GetArchivedUsers()
+	if len(archived) != 0 {
+		t.Errorf("Purged users should not be listed as restorable, got %+v", archived)
+	}
+	if purged, _ := archiveService.PurgeExpired(); purged != 0 {
+		t.Errorf("A user should only be purged once, got %d", purged)
+	}
+	
+	// The email is free again once the old account is purged.
+	reused := &models.User{Email: "user@example.com", Name: "New User", Role: models.RoleSalesperson, Enabled: true}
+	reused.SetPassword("Secur3!Passw0rd")
+	if err := db.Create(reused).Error; err != nil {
+		t.Errorf("The purged user's email should be reusable, got %v", err)
+	}
+}
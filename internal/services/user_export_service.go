@@ -0,0 +1,307 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/pdf"
+	"alsafwanmarine.com/todo-app/internal/xlsx"
+	"gorm.io/gorm"
+)
+
+var ErrExportFormat = errors.New("export format must be csv, xlsx or pdf")
+
+// ExportFormats maps each export format to its content type.
+var ExportFormats = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"pdf":  "application/pdf",
+}
+
+// UserFilter holds the search, role, status and company filters of the
+// users list. Company is a company ID, or "none" for users without one.
+// CompanyContext is the company the viewer has chosen to work in, if they
+// may see every company.
+type UserFilter struct {
+	Search         string
+	Role           string
+	Status         string
+	Company        string
+	CompanyContext *uint
+}
+
+// Apply narrows query, which selects from users, to the users viewer may
+// see that match the filter. Without the privileged permission only
+// salespeople are included, and only the viewer's company scope is.
+func (f UserFilter) Apply(query *gorm.DB, viewer *models.User) *gorm.DB {
+	if !viewer.HasPermission(models.PermUsersManagePrivileged) {
+		query = query.Where("role = ?", models.RoleSalesperson)
+	}
+	query = scopeToCompany(query, "company_id", viewer.CompanyScope(f.CompanyContext))
+
+	if f.Search != "" {
+		searchParam := "%" + f.Search + "%"
+		query = query.Where("name LIKE ? OR email LIKE ?", searchParam, searchParam)
+	}
+
+	if f.Role != "" {
+		if role, err := strconv.Atoi(f.Role); err == nil {
+			query = query.Where("role = ?", role)
+		}
+	}
+
+	if f.Status == "enabled" {
+		query = query.Where("enabled = ?", true)
+	} else if f.Status == "disabled" {
+		query = query.Where("enabled = ?", false)
+	}
+
+	if f.Company == "none" {
+		query = query.Where("company_id IS NULL")
+	} else if f.Company != "" {
+		if companyID, err := strconv.ParseUint(f.Company, 10, 64); err == nil {
+			query = query.Where("company_id = ?", companyID)
+		}
+	}
+	return query
+}
+
+// Describe summarises the filter for export headers, e.g. "Search: sam;
+// Role: manager". companyNames maps company IDs to names.
+func (f UserFilter) Describe(companyNames map[uint]string) string {
+	var parts []string
+	if f.Search != "" {
+		parts = append(parts, "Search: "+f.Search)
+	}
+	if role, err := strconv.Atoi(f.Role); err == nil {
+		parts = append(parts, "Role: "+models.UserRole(role).String())
+	}
+	if f.Status == "enabled" || f.Status == "disabled" {
+		parts = append(parts, "Status: "+f.Status)
+	}
+	if f.Company == "none" {
+		parts = append(parts, "Company: none")
+	} else if companyID, err := strconv.ParseUint(f.Company, 10, 64); err == nil {
+		if name, ok := companyNames[uint(companyID)]; ok {
+			parts = append(parts, "Company: "+name)
+		}
+	}
+	if len(parts) == 0 {
+		return "All users"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// exportColumns are the columns of every export format.
+var exportColumns = []pdf.Column{
+	{Heading: "Name", Width: 3},
+	{Heading: "Email", Width: 4},
+	{Heading: "Role", Width: 1.6},
+	{Heading: "Company", Width: 2.4},
+	{Heading: "Enabled", Width: 1.2},
+	{Heading: "Last Sign-In", Width: 2},
+	{Heading: "Sign-Ins", Width: 1.2},
+	{Heading: "Password Expires", Width: 2},
+}
+
+const exportTimeFormat = "2006-01-02 15:04"
+
+// UserExportService writes the user directory as CSV, XLSX or PDF for
+// reporting.
+type UserExportService struct {
+	db              *gorm.DB
+	activityService *ActivityService
+}
+
+func NewUserExportService(db *gorm.DB, activityService *ActivityService) *UserExportService {
+	return &UserExportService{
+		db:              db,
+		activityService: activityService,
+	}
+}
+
+// Export streams the users matching filter to w in format, newest first,
+// and logs the export. It returns how many users were written.
+func (s *UserExportService) Export(performingUser *models.User, filter UserFilter, format string, w io.Writer, ipAddress, userAgent string) (int, error) {
+	if !performingUser.HasPermission(models.PermUsersView) {
+		return 0, ErrPermissionDenied
+	}
+	if _, ok := ExportFormats[format]; !ok {
+		return 0, ErrExportFormat
+	}
+
+	var companies []models.Company
+	if err := s.db.Find(&companies).Error; err != nil {
+		return 0, err
+	}
+	companyNames := make(map[uint]string, len(companies))
+	for _, company := range companies {
+		companyNames[company.ID] = company.Name
+	}
+	// Record the company the export was limited to.
+	if scope := performingUser.CompanyScope(filter.CompanyContext); !scope.All && filter.Company == "" {
+		filter.Company = "none"
+		if scope.CompanyID != nil {
+			filter.Company = strconv.FormatUint(uint64(*scope.CompanyID), 10)
+		}
+	}
+
+	rows, err := filter.Apply(s.db.Model(&models.User{}), performingUser).
+		Select("id, name, email, role, company_id, enabled, last_sign_in_at, sign_in_count, password_expires_at, created_at").
+		Order("created_at DESC").
+		Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	writer, err := newExportWriter(format, w, filter.Describe(companyNames))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for rows.Next() {
+		var user models.User
+		if err := s.db.ScanRows(rows, &user); err != nil {
+			return count, err
+		}
+		if user.CompanyID != nil {
+			user.Company = &models.Company{ID: *user.CompanyID, Name: companyNames[*user.CompanyID]}
+		}
+		if err := writer.writeUser(&user); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	rows.Close()
+	if err := writer.close(); err != nil {
+		return count, err
+	}
+
+	s.activityService.LogActivity(&performingUser.ID, "users_exported", ipAddress, userAgent, map[string]interface{}{
+		"format":  format,
+		"count":   count,
+		"search":  filter.Search,
+		"role":    filter.Role,
+		"status":  filter.Status,
+		"company": filter.Company,
+	})
+	return count, nil
+}
+
+type exportWriter interface {
+	writeUser(user *models.User) error
+	close() error
+}
+
+func newExportWriter(format string, w io.Writer, description string) (exportWriter, error) {
+	headings := make([]string, len(exportColumns))
+	for i, column := range exportColumns {
+		headings[i] = column.Heading
+	}
+
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		return &csvExportWriter{writer: writer}, writer.Write(headings)
+	case "xlsx":
+		writer, err := xlsx.NewWriter(w, "Users")
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxExportWriter{writer: writer}, writer.WriteHeader(headings...)
+	case "pdf":
+		writer, err := pdf.NewTableWriter(w, pdf.Table{
+			Title:    "User Directory",
+			Subtitle: fmt.Sprintf("%s. Exported %s.", description, time.Now().Format(exportTimeFormat)),
+			Columns:  exportColumns,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &pdfExportWriter{writer: writer}, nil
+	}
+	return nil, ErrExportFormat
+}
+
+// exportValues returns the user's cells as text, in exportColumns order.
+func exportValues(user *models.User) []string {
+	return []string{
+		user.Name,
+		user.Email,
+		user.Role.String(),
+		user.CompanyName(),
+		strconv.FormatBool(user.Enabled),
+		exportTime(user.LastSignInAt),
+		strconv.Itoa(user.SignInCount),
+		exportTime(user.PasswordExpiresAt),
+	}
+}
+
+func exportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(exportTimeFormat)
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func (e *csvExportWriter) writeUser(user *models.User) error {
+	values := exportValues(user)
+	// Keep spreadsheet apps from running cells that look like formulas.
+	for i, value := range values {
+		if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+			values[i] = "'" + value
+		}
+	}
+	return e.writer.Write(values)
+}
+
+func (e *csvExportWriter) close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type xlsxExportWriter struct {
+	writer *xlsx.Writer
+}
+
+func (e *xlsxExportWriter) writeUser(user *models.User) error {
+	values := exportValues(user)
+	return e.writer.WriteRow(values[0], values[1], values[2], values[3], user.Enabled, values[5], user.SignInCount, values[7])
+}
+
+func (e *xlsxExportWriter) close() error {
+	return e.writer.Close()
+}
+
+type pdfExportWriter struct {
+	writer *pdf.TableWriter
+}
+
+func (e *pdfExportWriter) writeUser(user *models.User) error {
+	values := exportValues(user)
+	if user.Enabled {
+		values[4] = "Yes"
+	} else {
+		values[4] = "No"
+	}
+	return e.writer.WriteRow(values...)
+}
+
+func (e *pdfExportWriter) close() error {
+	return e.writer.Close()
+}
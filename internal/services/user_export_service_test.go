@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/xlsx"
+)
+
+func TestUserExportService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	exportService := NewUserExportService(db, activityService)
+	
+	company := &models.Company{Name: "Data Grid Labs", DefaultRole: models.RoleSalesperson}
+	db.Create(company)
+	signedIn := time.Date(2026, 3, 4, 9, 30, 0, 0, time.UTC)
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	manager := &models.User{Email: "manager@example.com", Name: "Manager", Role: models.RoleManager, CompanyID: &company.ID, Enabled: true}
+	sam := &models.User{Email: "sam@example.com", Name: "=Sam Sales", Role: models.RoleSalesperson, CompanyID: &company.ID, Enabled: true, LastSignInAt: &signedIn, SignInCount: 7}
+	off := &models.User{Email: "off@example.com", Name: "Off Sales", Role: models.RoleSalesperson, Enabled: true}
+	for _, u := range []*models.User{admin, manager, sam, off} {
+		u.SetPassword("Secur3!Passw0rd")
+		db.Create(u)
+	}
+	db.Model(off).Update("enabled", false)
+	
+	var buf bytes.Buffer
+	if _, err := exportService.Export(admin, UserFilter{}, "docx", &buf, "127.0.0.1", "test-agent"); err != ErrExportFormat {
+		t.Errorf("Expected ErrExportFormat, got %v", err)
+	}
+	
+	count, err := exportService.Export(admin, UserFilter{}, "csv", &buf, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CSV export failed: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Export is not valid CSV: %v", err)
+	}
+	if count != 4 || len(records) != 5 || records[0][0] != "Name" || records[0][5] != "Last Sign-In" {
+		t.Fatalf("Unexpected CSV export (%d users): %q", count, records)
+	}
+	var samRecord []string
+	for _, record := range records {
+		if record[1] == sam.Email {
+			samRecord = record
+		}
+	}
+	if samRecord == nil || samRecord[0] != "'=Sam Sales" || samRecord[3] != company.Name || samRecord[5] != "2026-03-04 09:30" || samRecord[6] != "7" {
+		t.Errorf("Unexpected CSV row: %q", samRecord)
+	}
+	
+	// Managers only see salespeople, and filters are honored.
+	buf.Reset()
+	count, err = exportService.Export(manager, UserFilter{Status: "enabled"}, "xlsx", &buf, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("XLSX export failed: %v", err)
+	}
+	rows, err := xlsx.ReadRows(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Export is not a readable workbook: %v", err)
+	}
+	if count != 1 || len(rows) != 2 || rows[1][1] != sam.Email || rows[1][0] != "=Sam Sales" || rows[1][4] != "TRUE" || rows[1][6] != "7" {
+		t.Errorf("Unexpected XLSX export (%d users): %q", count, rows)
+	}
+	
+	buf.Reset()
+	count, err = exportService.Export(admin, UserFilter{Search: "off"}, "pdf", &buf, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("PDF export failed: %v", err)
+	}
+	if count != 1 || !strings.HasPrefix(buf.String(), "%PDF-") || !strings.Contains(buf.String(), "(off@example.com)") || !strings.Contains(buf.String(), "(Search: off. Exported") {
+		t.Errorf("Unexpected PDF export of %d users", count)
+	}
+	
+	// The company filter takes an ID, or "none" for users without one.
+	buf.Reset()
+	count, err = exportService.Export(admin, UserFilter{Company: strconv.Itoa(int(company.ID))}, "pdf", &buf, "127.0.0.1", "test-agent")
+	if err != nil || count != 2 || !strings.Contains(buf.String(), "(=Sam Sales)") || !strings.Contains(buf.String(), "(Company: Data Grid Labs. Exported") {
+		t.Errorf("Unexpected PDF export of %d users for the company: %v", count, err)
+	}
+	buf.Reset()
+	if count, _ = exportService.Export(admin, UserFilter{Company: "none"}, "csv", &buf, "127.0.0.1", "test-agent"); count != 2 {
+		t.Errorf("Expected the 2 users without a company, got %d", count)
+	}
+	
+	// Managers only export their own company, whatever they filter on;
+	// admins narrow theirs with a company context.
+	buf.Reset()
+	if count, _ = exportService.Export(manager, UserFilter{Company: "none"}, "csv", &buf, "127.0.0.1", "test-agent"); count != 0 {
+		t.Errorf("Managers should not export users of other companies, got %d", count)
+	}
+	buf.Reset()
+	count, err = exportService.Export(admin, UserFilter{CompanyContext: &company.ID}, "pdf", &buf, "127.0.0.1", "test-agent")
+	if err != nil || count != 2 || !strings.Contains(buf.String(), "(Company: Data Grid Labs. Exported") {
+		t.Errorf("Unexpected PDF export of %d users in the company context: %v", count, err)
+	}
+	
+	var logged int64
+	db.Model(&models.UserActivity{}).Where("activity_type = ?", "users_exported").Count(&logged)
+	if logged != 7 {
+		t.Errorf("Expected every successful export to be logged, got %d", logged)
+	}
+	
+	var activity models.UserActivity
+	db.Where("activity_type = ? AND user_id = ?", "users_exported", manager.ID).First(&activity)
+	if !strings.Contains(activity.Metadata.String, `"format":"xlsx"`) || !strings.Contains(activity.Metadata.String, `"status":"enabled"`) {
+		t.Errorf("Expected the format and filters to be logged, got %s", activity.Metadata.String)
+	}
+}
@@ -0,0 +1,416 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/xlsx"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxImportFileSize bounds uploaded import files.
+	MaxImportFileSize = 2 << 20
+	// MaxImportRows bounds how many users one file may hold.
+	MaxImportRows = 1000
+)
+
+var (
+	ErrImportFileType     = errors.New("upload a .csv or .xlsx file")
+	ErrImportFileTooLarge = fmt.Errorf("the file is larger than %d MB", MaxImportFileSize>>20)
+	ErrImportUnreadable   = errors.New("the file could not be read")
+	ErrImportNoHeader     = errors.New("the first row must name the columns, including name and email")
+	ErrImportEmpty        = errors.New("the file has no users to import")
+	ErrImportTooManyRows  = fmt.Errorf("the file has more than %d users", MaxImportRows)
+	ErrImportHasErrors    = errors.New("some rows have errors; fix them and upload the file again")
+)
+
+// ImportAction is what importing a row does.
+type ImportAction string
+
+const (
+	ImportCreate ImportAction = "create"
+	ImportUpdate ImportAction = "update"
+	ImportSkip   ImportAction = "skip"
+	ImportError  ImportAction = "error"
+)
+
+// ImportRow is one user as read from an import file. Blank role and
+// company cells leave an existing user's values as they are.
+type ImportRow struct {
+	Line    int    `json:"line"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Role    string `json:"role"`
+	Company string `json:"company"`
+}
+
+// ImportResult is the planned outcome for one row.
+type ImportResult struct {
+	Line    int
+	Name    string
+	Email   string
+	Role    models.UserRole
+	Company *models.Company
+	Action  ImportAction
+	// Changes describes an update field by field, e.g. "Role: salesperson
+	// to manager".
+	Changes []string
+	Errors  []string
+
+	user *models.User
+}
+
+// ImportPlan is the dry run of an import, or the outcome once applied.
+type ImportPlan struct {
+	Results []ImportResult
+	Created int
+	Updated int
+	Skipped int
+	Failed  int
+	// InvitationsNotSent counts created users whose invitation email
+	// failed; they can be resent from the users list.
+	InvitationsNotSent int
+}
+
+func (p *ImportPlan) HasErrors() bool {
+	return p.Failed > 0
+}
+
+// UserImportService creates and updates users in bulk from CSV or XLSX
+// files. New users are invited by email like users added one at a time.
+type UserImportService struct {
+	db                *gorm.DB
+	activityService   *ActivityService
+	invitationService *InvitationService
+}
+
+func NewUserImportService(db *gorm.DB, activityService *ActivityService, invitationService *InvitationService) *UserImportService {
+	return &UserImportService{
+		db:                db,
+		activityService:   activityService,
+		invitationService: invitationService,
+	}
+}
+
+// ParseFile reads the rows of a CSV or XLSX file, chosen by its extension.
+// The first row names the columns: name and email are required, role and
+// company are optional, and other columns are ignored.
+func (s *UserImportService) ParseFile(filename string, data []byte) ([]ImportRow, error) {
+	if len(data) > MaxImportFileSize {
+		return nil, ErrImportFileTooLarge
+	}
+
+	var records [][]string
+	var lines []int
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		reader.FieldsPerRecord = -1
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, ErrImportUnreadable
+			}
+			line, _ := reader.FieldPos(0)
+			records = append(records, record)
+			lines = append(lines, line)
+		}
+	case ".xlsx":
+		rows, err := xlsx.ReadRows(data)
+		if err != nil {
+			return nil, ErrImportUnreadable
+		}
+		for i, row := range rows {
+			records = append(records, row)
+			lines = append(lines, i+1)
+		}
+	default:
+		return nil, ErrImportFileType
+	}
+
+	if len(records) == 0 {
+		return nil, ErrImportNoHeader
+	}
+	columns := map[string]int{}
+	for i, heading := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(heading))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, ErrImportNoHeader
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, ErrImportNoHeader
+	}
+	cell := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []ImportRow
+	for i, record := range records[1:] {
+		row := ImportRow{
+			Line:    lines[i+1],
+			Name:    cell(record, "name"),
+			Email:   cell(record, "email"),
+			Role:    cell(record, "role"),
+			Company: cell(record, "company"),
+		}
+		if row.Name == "" && row.Email == "" && row.Role == "" && row.Company == "" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrImportEmpty
+	}
+	if len(rows) > MaxImportRows {
+		return nil, ErrImportTooManyRows
+	}
+	return rows, nil
+}
+
+// Preview works out what importing rows would do without changing
+// anything.
+func (s *UserImportService) Preview(performingUser *models.User, rows []ImportRow) (*ImportPlan, error) {
+	if !performingUser.HasPermission(models.PermUsersCreate) {
+		return nil, ErrPermissionDenied
+	}
+	return s.plan(s.db, performingUser, rows)
+}
+
+// Apply imports rows in one transaction. Nothing is changed and
+// ErrImportHasErrors is returned with the plan if any row has an error.
+// New users are emailed their invitation once the import is saved.
+func (s *UserImportService) Apply(performingUser *models.User, rows []ImportRow, ipAddress, userAgent string) (*ImportPlan, error) {
+	if !performingUser.HasPermission(models.PermUsersCreate) {
+		return nil, ErrPermissionDenied
+	}
+
+	var plan *ImportPlan
+	tokens := map[int]string{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if plan, err = s.plan(tx, performingUser, rows); err != nil {
+			return err
+		}
+		if plan.HasErrors() {
+			return ErrImportHasErrors
+		}
+
+		for i := range plan.Results {
+			result := &plan.Results[i]
+			switch result.Action {
+			case ImportCreate:
+				user := &models.User{
+					Name:      result.Name,
+					Email:     result.Email,
+					Role:      result.Role,
+					CompanyID: companyID(result.Company),
+				}
+				if _, tokens[i], err = s.invitationService.createInvitation(tx, performingUser, user); err != nil {
+					return err
+				}
+				result.user = user
+			case ImportUpdate:
+				result.user.Name = result.Name
+				result.user.Role = result.Role
+				result.user.CompanyID = companyID(result.Company)
+				result.user.Company = result.Company
+				if err := tx.Model(result.user).Omit("Company").Updates(map[string]interface{}{
+					"name":       result.Name,
+					"role":       result.Role,
+					"company_id": result.user.CompanyID,
+				}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if err == ErrImportHasErrors {
+			return plan, err
+		}
+		return nil, err
+	}
+
+	for i := range plan.Results {
+		result := &plan.Results[i]
+		switch result.Action {
+		case ImportCreate:
+			s.activityService.LogUserCRUD(performingUser, result.user, "import", ipAddress, userAgent)
+			if err := s.invitationService.sendInvitation(result.user, performingUser, tokens[i]); err != nil {
+				plan.InvitationsNotSent++
+			}
+		case ImportUpdate:
+			s.activityService.LogUserCRUD(performingUser, result.user, "update", ipAddress, userAgent)
+		}
+	}
+	return plan, nil
+}
+
+func (s *UserImportService) plan(tx *gorm.DB, performingUser *models.User, rows []ImportRow) (*ImportPlan, error) {
+	plan := &ImportPlan{}
+	seen := map[string]int{}
+
+	var companies []models.Company
+	if err := tx.Find(&companies).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		result := ImportResult{
+			Line:  row.Line,
+			Name:  row.Name,
+			Email: normalizeEmail(row.Email),
+			Role:  models.RoleSalesperson,
+		}
+		if row.Company != "" {
+			if result.Company = models.FindCompany(companies, row.Company); result.Company == nil {
+				result.Errors = append(result.Errors, "Company: no company is named "+row.Company)
+			} else if !performingUser.CanAssignCompany(&result.Company.ID) {
+				result.Errors = append(result.Errors, "Company: you can only import users into your own company")
+			}
+		}
+
+		if err := models.ValidateName(result.Name); err != nil {
+			result.Errors = append(result.Errors, "Name: "+err.Error())
+		}
+		if err := models.ValidateEmail(result.Email); err != nil {
+			result.Errors = append(result.Errors, "Email: "+err.Error())
+		} else if line, ok := seen[result.Email]; ok {
+			result.Errors = append(result.Errors, fmt.Sprintf("Email: also on line %d", line))
+		} else {
+			seen[result.Email] = row.Line
+		}
+		if row.Role != "" {
+			role, ok := models.ParseRole(row.Role)
+			if !ok {
+				result.Errors = append(result.Errors, "Role: must be admin, manager or salesperson")
+			} else if !performingUser.CanAssignRole(role) {
+				result.Errors = append(result.Errors, "Role: you can only import salespeople")
+			} else {
+				result.Role = role
+			}
+		}
+
+		if len(result.Errors) == 0 {
+			if err := s.planExisting(tx, performingUser, row, &result, companies); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(result.Errors) > 0 {
+			result.Action = ImportError
+		}
+		switch result.Action {
+		case ImportCreate:
+			plan.Created++
+		case ImportUpdate:
+			plan.Updated++
+		case ImportSkip:
+			plan.Skipped++
+		case ImportError:
+			plan.Failed++
+		}
+		plan.Results = append(plan.Results, result)
+	}
+	return plan, nil
+}
+
+// planExisting decides between creating the row's user and updating or
+// skipping the one that already has its email. New users without a company
+// join the one their email domain belongs to, or the importer's own if the
+// importer is limited to it, and without a role get that company's default
+// role if the importer may assign it.
+func (s *UserImportService) planExisting(tx *gorm.DB, performingUser *models.User, row ImportRow, result *ImportResult, companies []models.Company) error {
+	var existing models.User
+	err := tx.Unscoped().Where("email = ?", result.Email).First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		result.Action = ImportCreate
+		if result.Company == nil {
+			if performingUser.HasPermission(models.PermUsersAllCompanies) {
+				result.Company = models.CompanyForEmail(companies, result.Email)
+			} else {
+				result.Company = models.CompanyByID(companies, performingUser.CompanyID)
+			}
+		}
+		if row.Role == "" && result.Company != nil && performingUser.CanAssignRole(result.Company.DefaultRole) {
+			result.Role = result.Company.DefaultRole
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing.DeletedAt.Valid {
+		result.Errors = append(result.Errors, "Email: belongs to a deleted user; restore them from Deleted Users instead")
+		return nil
+	}
+	if !performingUser.HasPermission(models.PermUsersUpdate) || !performingUser.CanManageUser(&existing) {
+		result.Errors = append(result.Errors, "Email: belongs to a user you cannot edit")
+		return nil
+	}
+
+	existing.Company = models.CompanyByID(companies, existing.CompanyID)
+
+	// Blank cells keep the user's current values.
+	if row.Role == "" {
+		result.Role = existing.Role
+	}
+	if row.Company == "" {
+		result.Company = existing.Company
+	}
+
+	if existing.ID == performingUser.ID && existing.Role != result.Role {
+		result.Errors = append(result.Errors, "Role: you cannot change your own role")
+		return nil
+	}
+
+	if existing.Name != result.Name {
+		result.Changes = append(result.Changes, fmt.Sprintf("Name: %s to %s", existing.Name, result.Name))
+	}
+	if existing.Role != result.Role {
+		result.Changes = append(result.Changes, fmt.Sprintf("Role: %s to %s", existing.Role, result.Role))
+	}
+	if companyName(existing.Company) != companyName(result.Company) {
+		result.Changes = append(result.Changes, fmt.Sprintf("Company: %s to %s", companyName(existing.Company), companyName(result.Company)))
+	}
+
+	result.user = &existing
+	if len(result.Changes) > 0 {
+		result.Action = ImportUpdate
+	} else {
+		result.Action = ImportSkip
+	}
+	return nil
+}
+
+func companyName(company *models.Company) string {
+	if company == nil {
+		return "none"
+	}
+	return company.Name
+}
+
+func companyID(company *models.Company) *uint {
+	if company == nil {
+		return nil
+	}
+	return &company.ID
+}
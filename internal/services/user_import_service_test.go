@@ -0,0 +1,153 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"alsafwanmarine.com/todo-app/internal/models"
+)
+
+func TestUserImportService(t *testing.T) {
+	db := setupTestDB(t)
+	
+	activityService := NewActivityService(db)
+	mail := &recordingMailer{}
+	invitationService := NewInvitationService(db, activityService, NewPasswordPolicyService(db), mail, "http://localhost", testAppKey)
+	importService := NewUserImportService(db, activityService, invitationService)
+	
+	company := &models.Company{Name: "Louis Safety", DefaultRole: models.RoleSalesperson}
+	asm := &models.Company{Name: "Al Safwan Marine", DefaultRole: models.RoleSalesperson}
+	dataGrid := &models.Company{Name: "Data Grid Labs", EmailDomain: "datagrid.example", DefaultRole: models.RoleManager}
+	for _, c := range []*models.Company{company, asm, dataGrid} {
+		db.Create(c)
+	}
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, Enabled: true}
+	manager := &models.User{Email: "manager@example.com", Name: "Manager", Role: models.RoleManager, CompanyID: &asm.ID, Enabled: true}
+	existing := &models.User{Email: "sam@example.com", Name: "Sam Sales", Role: models.RoleSalesperson, CompanyID: &company.ID, Enabled: true}
+	unchanged := &models.User{Email: "una@example.com", Name: "Una Same", Role: models.RoleSalesperson, Enabled: true}
+	for _, u := range []*models.User{admin, manager, existing, unchanged} {
+		u.SetPassword("Secur3!Passw0rd")
+		db.Create(u)
+	}
+	
+	if _, err := importService.ParseFile("users.txt", []byte("name,email\n")); err != ErrImportFileType {
+		t.Errorf("Expected ErrImportFileType, got %v", err)
+	}
+	if _, err := importService.ParseFile("users.csv", []byte("full name,mail\nJane,jane@example.com\n")); err != ErrImportNoHeader {
+		t.Errorf("Expected ErrImportNoHeader, got %v", err)
+	}
+	if _, err := importService.ParseFile("users.csv", []byte("name,email\n,\n")); err != ErrImportEmpty {
+		t.Errorf("Expected ErrImportEmpty, got %v", err)
+	}
+	
+	csvData := "\xef\xbb\xbfEmail,Name,Role,Company,Notes\n" +
+		"New@Example.com , New Person,salesperson,Al Safwan Marine,first\n" +
+		"sam@example.com,Sam Sales,manager,,\n" +
+		"una@example.com,Una Same,,,\n" +
+		"\n" +
+		"bad-email,X,chief,Acme\n" +
+		"new@example.com,Twice Listed,,\n"
+	rows, err := importService.ParseFile("users.csv", []byte(csvData))
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if len(rows) != 5 || rows[0].Line != 2 || rows[0].Email != "New@Example.com" || rows[0].Company != "Al Safwan Marine" || rows[3].Line != 6 {
+		t.Fatalf("Unexpected rows: %+v", rows)
+	}
+	
+	plan, err := importService.Preview(admin, rows)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	want := []ImportAction{ImportCreate, ImportUpdate, ImportSkip, ImportError, ImportError}
+	for i, result := range plan.Results {
+		if result.Action != want[i] {
+			t.Errorf("Row %d: expected %s, got %s (%v)", result.Line, want[i], result.Action, result.Errors)
+		}
+	}
+	if len(plan.Results[1].Changes) != 1 || !strings.HasPrefix(plan.Results[1].Changes[0], "Role:") {
+		t.Errorf("Blank company should be kept and only the role changed, got %v", plan.Results[1].Changes)
+	}
+	if len(plan.Results[3].Errors) != 4 {
+		t.Errorf("Expected every invalid field to be reported, got %v", plan.Results[3].Errors)
+	}
+	if plan.Created != 1 || plan.Updated != 1 || plan.Skipped != 1 || plan.Failed != 2 {
+		t.Errorf("Unexpected counts: %+v", plan)
+	}
+	
+	// Nothing is saved while any row has an error.
+	if _, err := importService.Apply(admin, rows, "127.0.0.1", "test-agent"); err != ErrImportHasErrors {
+		t.Fatalf("Expected ErrImportHasErrors, got %v", err)
+	}
+	var count int64
+	db.Model(&models.User{}).Where("email = ?", "new@example.com").Count(&count)
+	if count != 0 || len(mail.sent) != 0 {
+		t.Error("An import with errors should not change anything")
+	}
+	
+	// Managers can only import salespeople.
+	plan, _ = importService.Preview(manager, rows[:2])
+	if plan.Results[0].Action != ImportCreate || plan.Results[1].Action != ImportError {
+		t.Errorf("Managers should not promote users, got %+v", plan.Results)
+	}
+	
+	plan, err = importService.Apply(admin, rows[:3], "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if plan.Created != 1 || plan.Updated != 1 || plan.Skipped != 1 || plan.InvitationsNotSent != 0 {
+		t.Errorf("Unexpected counts: %+v", plan)
+	}
+	
+	var created models.User
+	if err := db.Where("email = ?", "new@example.com").First(&created).Error; err != nil {
+		t.Fatalf("The new user should be created: %v", err)
+	}
+	if created.Enabled || !created.InCompany(asm.ID) {
+		t.Errorf("Expected a disabled, invited account, got %+v", created)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To != "new@example.com" {
+		t.Errorf("Expected the new user to be invited, got %+v", mail.sent)
+	}
+	var updated models.User
+	db.First(&updated, existing.ID)
+	if updated.Role != models.RoleManager || !updated.InCompany(company.ID) {
+		t.Errorf("Expected only the role to change, got %+v", updated)
+	}
+	
+	db.Model(&models.UserActivity{}).Where("activity_type = ? AND metadata LIKE ?", "user_crud", `%"action":"import"%`).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected the created user to be logged, got %d", count)
+	}
+	
+	// Importing the same file again changes nothing.
+	plan, _ = importService.Preview(admin, rows[:3])
+	if plan.Skipped != 3 {
+		t.Errorf("A repeated import should skip every row, got %+v", plan)
+	}
+	
+	// New users without a company or role follow their email domain, as
+	// far as the importer may assign the company's default role.
+	rows = []ImportRow{{Line: 2, Name: "Dana", Email: "dana@datagrid.example"}}
+	plan, _ = importService.Preview(admin, rows)
+	if result := plan.Results[0]; result.Company == nil || result.Company.ID != dataGrid.ID || result.Role != models.RoleManager {
+		t.Errorf("Expected Dana to join Data Grid Labs as a manager, got %+v", result)
+	}
+	plan, _ = importService.Preview(manager, rows)
+	if result := plan.Results[0]; result.Action != ImportCreate || result.Role != models.RoleSalesperson {
+		t.Errorf("A manager's import should fall back to salesperson, got %+v", result)
+	}
+	if result := plan.Results[0]; result.Company == nil || result.Company.ID != asm.ID {
+		t.Errorf("A manager's new users should join the manager's company, got %+v", result.Company)
+	}
+	
+	// Managers are held to their own company.
+	rows = []ImportRow{
+		{Line: 2, Name: "Lou", Email: "lou@example.com", Company: "Louis Safety"},
+		{Line: 3, Name: "Sam Sales", Email: "sam@example.com", Role: "salesperson"},
+	}
+	plan, _ = importService.Preview(manager, rows)
+	if plan.Results[0].Action != ImportError || plan.Results[1].Action != ImportError {
+		t.Errorf("Managers should not import into other companies, got %+v", plan.Results)
+	}
+}
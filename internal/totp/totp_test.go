@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+var rfcKey = []byte("12345678901234567890")
+
+func TestHOTPVectors(t *testing.T) {
+	expected := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for counter, want := range expected {
+		if got := hotp(rfcKey, uint64(counter), 6); got != want {
+			t.Errorf("hotp(counter=%d) = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+	}
+
+	for _, test := range tests {
+		step := Step(time.Unix(test.unix, 0))
+		if got := hotp(rfcKey, uint64(step), 8); got != test.want {
+			t.Errorf("totp(%d) = %s, want %s", test.unix, got, test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(rfcKey)
+	now := time.Unix(1234567890, 0)
+
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatalf("Code failed: %v", err)
+	}
+
+	step, ok := Validate(secret, code, now)
+	if !ok {
+		t.Fatal("Current code should validate")
+	}
+	if step != Step(now) {
+		t.Errorf("Validate returned step %d, want %d", step, Step(now))
+	}
+
+	if _, ok := Validate(secret, code, now.Add(Period)); !ok {
+		t.Error("Code from the previous step should be accepted within skew")
+	}
+
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Error("Code outside the skew window should be rejected")
+	}
+
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("Short code should be rejected")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret failed: %v", err)
+	}
+
+	if len(secret) != 32 {
+		t.Errorf("Secret should be 32 base32 characters, got %d", len(secret))
+	}
+
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Errorf("Generated secret should be usable: %v", err)
+	}
+}
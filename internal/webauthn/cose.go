@@ -0,0 +1,91 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers offered to authenticators, in order of
+// preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// parsePublicKey decodes a COSE_Key as found in attested credential data.
+func parsePublicKey(coseKey []byte) (int64, interface{}, error) {
+	item, _, err := decodeCBOR(coseKey)
+	if err != nil {
+		return 0, nil, err
+	}
+	key, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, ErrUnsupportedKey
+	}
+
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, ErrUnsupportedKey
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return 0, nil, ErrUnsupportedKey
+		}
+		return alg, pub, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, ErrUnsupportedKey
+		}
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, ErrUnsupportedKey
+		}
+		return alg, ed25519.PublicKey(x), nil
+	}
+
+	return 0, nil, ErrUnsupportedKey
+}
+
+func verifySignature(coseKey, signed, signature []byte) error {
+	alg, key, err := parsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(signed)
+	valid := false
+	switch alg {
+	case AlgES256:
+		valid = ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), digest[:], signature)
+	case AlgRS256:
+		valid = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	case AlgEdDSA:
+		valid = ed25519.Verify(key.(ed25519.PublicKey), signed, signature)
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
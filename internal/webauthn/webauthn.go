@@ -0,0 +1,380 @@
+// Package webauthn implements the relying party side of WebAuthn passkey
+// registration and sign-in. Attestation statements are not verified: the
+// application asks for "none" attestation and trusts any authenticator.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidResponse  = errors.New("webauthn: invalid authenticator response")
+	ErrChallenge        = errors.New("webauthn: challenge does not match")
+	ErrOrigin           = errors.New("webauthn: origin does not match")
+	ErrRPID             = errors.New("webauthn: relying party ID does not match")
+	ErrUserNotVerified  = errors.New("webauthn: user was not verified by the authenticator")
+	ErrInvalidSignature = errors.New("webauthn: invalid signature")
+	// ErrSignCount means the authenticator's counter went backwards, which
+	// suggests the credential has been cloned.
+	ErrSignCount = errors.New("webauthn: signature counter did not increase")
+)
+
+// Timeout is how long the browser waits for the user, and how long a
+// challenge stays valid.
+const Timeout = 5 * time.Minute
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// Encoding is used for every binary value exchanged with the browser.
+var Encoding = base64.RawURLEncoding
+
+type Config struct {
+	// RPID is the domain passkeys are bound to, e.g. "tracker.example.com".
+	RPID   string
+	RPName string
+	// Origin is the exact origin pages are served from, e.g.
+	// "https://tracker.example.com".
+	Origin string
+}
+
+type RelyingParty struct {
+	config Config
+}
+
+func New(config Config) *RelyingParty {
+	return &RelyingParty{config: config}
+}
+
+// NewChallenge returns 32 random bytes for a registration or sign-in.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions,
+// with binary values base64url encoded.
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey        string `json:"residentKey"`
+		RequireResidentKey bool   `json:"requireResidentKey"`
+		UserVerification   string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+	Timeout     int64  `json:"timeout"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions. It
+// lists no credentials, so the browser offers the user's discoverable
+// passkeys.
+type RequestOptions struct {
+	Challenge        string `json:"challenge"`
+	RPID             string `json:"rpId"`
+	UserVerification string `json:"userVerification"`
+	Timeout          int64  `json:"timeout"`
+}
+
+// CreationOptions builds the options for registering a discoverable,
+// user-verified passkey. Credentials in exclude are already registered.
+func (rp *RelyingParty) CreationOptions(challenge, userHandle []byte, name, displayName string, exclude []CredentialDescriptor) *CreationOptions {
+	options := &CreationOptions{
+		Challenge: Encoding.EncodeToString(challenge),
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		ExcludeCredentials: exclude,
+		Attestation:        "none",
+		Timeout:            Timeout.Milliseconds(),
+	}
+	if options.ExcludeCredentials == nil {
+		options.ExcludeCredentials = []CredentialDescriptor{}
+	}
+	options.RP.ID = rp.config.RPID
+	options.RP.Name = rp.config.RPName
+	options.User.ID = Encoding.EncodeToString(userHandle)
+	options.User.Name = name
+	options.User.DisplayName = displayName
+	options.AuthenticatorSelection.ResidentKey = "required"
+	options.AuthenticatorSelection.RequireResidentKey = true
+	options.AuthenticatorSelection.UserVerification = "required"
+	return options
+}
+
+func (rp *RelyingParty) RequestOptions(challenge []byte) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        Encoding.EncodeToString(challenge),
+		RPID:             rp.config.RPID,
+		UserVerification: "required",
+		Timeout:          Timeout.Milliseconds(),
+	}
+}
+
+// AttestationResponse is a PublicKeyCredential returned by
+// navigator.credentials.create, as posted by the browser.
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is a PublicKeyCredential returned by
+// navigator.credentials.get, as posted by the browser.
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is a newly registered passkey.
+type Credential struct {
+	ID         []byte
+	PublicKey  []byte // COSE_Key
+	SignCount  uint32
+	AAGUID     []byte
+	Transports []string
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// Challenge extracts the challenge from a response's clientDataJSON so the
+// caller can look up what it issued. The value is not yet verified.
+func Challenge(clientDataJSON string) ([]byte, error) {
+	raw, err := Encoding.DecodeString(clientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	challenge, err := Encoding.DecodeString(data.Challenge)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	return challenge, nil
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON, ceremony string, challenge []byte) ([]byte, error) {
+	raw, err := Encoding.DecodeString(clientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	if data.Type != ceremony {
+		return nil, ErrInvalidResponse
+	}
+	got, err := Encoding.DecodeString(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return nil, ErrChallenge
+	}
+	if data.Origin != rp.config.Origin {
+		return nil, ErrOrigin
+	}
+	return raw, nil
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// Only present when flagAttestedData is set.
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidResponse
+	}
+	auth := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if auth.flags&flagAttestedData == 0 {
+		return auth, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidResponse
+	}
+	auth.aaguid = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, ErrInvalidResponse
+	}
+	auth.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	auth.publicKey = rest[:len(rest)-len(after)]
+	return auth, nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(auth *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.config.RPID))
+	if !bytes.Equal(auth.rpIDHash, rpIDHash[:]) {
+		return ErrRPID
+	}
+	if auth.flags&flagUserPresent == 0 || auth.flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+// VerifyRegistration checks a registration response against the challenge
+// that was issued and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(response *AttestationResponse, challenge []byte) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, ErrInvalidResponse
+	}
+	if _, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := Encoding.DecodeString(response.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	item, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+
+	auth, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(auth); err != nil {
+		return nil, err
+	}
+	if auth.credentialID == nil {
+		return nil, ErrInvalidResponse
+	}
+	if _, _, err := parsePublicKey(auth.publicKey); err != nil {
+		return nil, err
+	}
+
+	if id, err := Encoding.DecodeString(response.ID); err != nil || !bytes.Equal(id, auth.credentialID) {
+		return nil, ErrInvalidResponse
+	}
+
+	return &Credential{
+		ID:         auth.credentialID,
+		PublicKey:  auth.publicKey,
+		SignCount:  auth.signCount,
+		AAGUID:     auth.aaguid,
+		Transports: response.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion checks a sign-in response for a stored credential and
+// returns the authenticator's new signature counter. Authenticators that
+// do not keep a counter always report zero, which is accepted.
+func (rp *RelyingParty) VerifyAssertion(response *AssertionResponse, challenge, publicKey []byte, storedSignCount uint32) (uint32, error) {
+	if response.Type != "public-key" {
+		return 0, ErrInvalidResponse
+	}
+	rawClientData, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	rawAuthData, err := Encoding.DecodeString(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	auth, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(auth); err != nil {
+		return 0, err
+	}
+
+	signature, err := Encoding.DecodeString(response.Response.Signature)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err := verifySignature(publicKey, signed, signature); err != nil {
+		return 0, err
+	}
+
+	if (auth.signCount != 0 || storedSignCount != 0) && auth.signCount <= storedSignCount {
+		return 0, fmt.Errorf("%w: got %d, stored %d", ErrSignCount, auth.signCount, storedSignCount)
+	}
+
+	return auth.signCount, nil
+}
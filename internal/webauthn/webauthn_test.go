@@ -0,0 +1,119 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"alsafwanmarine.com/todo-app/internal/webauthn"
+	"alsafwanmarine.com/todo-app/internal/webauthn/webauthntest"
+)
+
+var config = webauthn.Config{RPID: "tracker.example.com", RPName: "ASM Tracker", Origin: "https://tracker.example.com"}
+
+func register(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	challenge, _ := webauthn.NewChallenge()
+	options := rp.CreationOptions(challenge, []byte("42"), "jane@example.com", "Jane", nil)
+
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	credential, err := rp.VerifyRegistration(response, challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration failed: %v", err)
+	}
+	return credential
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := webauthn.New(config)
+	authenticator := webauthntest.New(config.RPID, config.Origin)
+	credential := register(t, rp, authenticator)
+
+	if len(credential.ID) == 0 || len(credential.PublicKey) == 0 {
+		t.Fatalf("Incomplete credential %+v", credential)
+	}
+
+	challenge, _ := webauthn.NewChallenge()
+	response, err := authenticator.Get(rp.RequestOptions(challenge))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	got, err := webauthn.Challenge(response.Response.ClientDataJSON)
+	if err != nil || string(got) != string(challenge) {
+		t.Errorf("Challenge() = %x, %v", got, err)
+	}
+
+	signCount, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, credential.SignCount)
+	if err != nil {
+		t.Fatalf("VerifyAssertion failed: %v", err)
+	}
+	if signCount != 1 {
+		t.Errorf("signCount = %d, want 1", signCount)
+	}
+
+	// Replaying the same response is caught by the counter.
+	if _, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, signCount); !errors.Is(err, webauthn.ErrSignCount) {
+		t.Errorf("Expected ErrSignCount for a replayed response, got %v", err)
+	}
+
+	// So is a cloned authenticator whose counter is behind.
+	authenticator.SetSignCount(0)
+	response, _ = authenticator.Get(rp.RequestOptions(challenge))
+	if _, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, 5); !errors.Is(err, webauthn.ErrSignCount) {
+		t.Errorf("Expected ErrSignCount for a counter that went backwards, got %v", err)
+	}
+}
+
+func TestAssertionChecks(t *testing.T) {
+	rp := webauthn.New(config)
+	authenticator := webauthntest.New(config.RPID, config.Origin)
+	credential := register(t, rp, authenticator)
+
+	challenge, _ := webauthn.NewChallenge()
+	other, _ := webauthn.NewChallenge()
+
+	response, _ := authenticator.Get(rp.RequestOptions(challenge))
+	if _, err := rp.VerifyAssertion(response, other, credential.PublicKey, 0); !errors.Is(err, webauthn.ErrChallenge) {
+		t.Errorf("Expected ErrChallenge, got %v", err)
+	}
+
+	response, _ = authenticator.Get(rp.RequestOptions(challenge))
+	response.Response.Signature = response.Response.AuthenticatorData
+	if _, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, 0); !errors.Is(err, webauthn.ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+
+	phished := webauthntest.New(config.RPID, "https://tracker.example.com.evil.test")
+	phishedCredential := register(t, webauthn.New(webauthn.Config{RPID: config.RPID, Origin: phished.Origin}), phished)
+	response, _ = phished.Get(rp.RequestOptions(challenge))
+	if _, err := rp.VerifyAssertion(response, challenge, phishedCredential.PublicKey, 0); !errors.Is(err, webauthn.ErrOrigin) {
+		t.Errorf("Expected ErrOrigin, got %v", err)
+	}
+
+	wrongRP := webauthntest.New("evil.test", config.Origin)
+	wrongCredential := register(t, webauthn.New(webauthn.Config{RPID: "evil.test", Origin: config.Origin}), wrongRP)
+	response, _ = wrongRP.Get(rp.RequestOptions(challenge))
+	if _, err := rp.VerifyAssertion(response, challenge, wrongCredential.PublicKey, 0); !errors.Is(err, webauthn.ErrRPID) {
+		t.Errorf("Expected ErrRPID, got %v", err)
+	}
+
+	authenticator.UserVerified = false
+	response, _ = authenticator.Get(rp.RequestOptions(challenge))
+	if _, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, 0); !errors.Is(err, webauthn.ErrUserNotVerified) {
+		t.Errorf("Expected ErrUserNotVerified, got %v", err)
+	}
+}
+
+func TestRegistrationRequiresUserVerification(t *testing.T) {
+	rp := webauthn.New(config)
+	authenticator := webauthntest.New(config.RPID, config.Origin)
+	authenticator.UserVerified = false
+
+	challenge, _ := webauthn.NewChallenge()
+	response, _ := authenticator.Create(rp.CreationOptions(challenge, []byte("42"), "jane@example.com", "Jane", nil))
+	if _, err := rp.VerifyRegistration(response, challenge); !errors.Is(err, webauthn.ErrUserNotVerified) {
+		t.Errorf("Expected ErrUserNotVerified, got %v", err)
+	}
+}
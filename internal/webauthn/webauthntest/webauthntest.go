@@ -0,0 +1,183 @@
+// Package webauthntest is a software authenticator for exercising passkey
+// registration and sign-in in tests.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"alsafwanmarine.com/todo-app/internal/webauthn"
+)
+
+// Authenticator holds ES256 passkeys for one origin. Sign-ins use the most
+// recently created passkey.
+type Authenticator struct {
+	RPID   string
+	Origin string
+
+	// UserVerified controls the UV flag, as if the user skipped the PIN or
+	// biometric check when false.
+	UserVerified bool
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	userHandle []byte
+	signCount  uint32
+}
+
+func New(rpID, origin string) *Authenticator {
+	return &Authenticator{RPID: rpID, Origin: origin, UserVerified: true}
+}
+
+// Create registers a new passkey, like navigator.credentials.create.
+func (a *Authenticator) Create(options *webauthn.CreationOptions) (*webauthn.AttestationResponse, error) {
+	userHandle, err := webauthn.Encoding.DecodeString(options.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	cred := &credential{id: id, key: key, userHandle: userHandle}
+	a.credentials = append(a.credentials, cred)
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.PublicKey.X.FillBytes(x)
+	key.PublicKey.Y.FillBytes(y)
+	coseKey := encodeMap(
+		[]interface{}{int64(1), int64(3), int64(-1), int64(-2), int64(-3)},
+		[]interface{}{int64(2), int64(webauthn.AlgES256), int64(1), x, y},
+	)
+
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(id)))
+	attested = append(append(attested, id...), coseKey...)
+	authData := append(a.authData(cred, 0x40), attested...)
+
+	attestation := encodeMap(
+		[]interface{}{"fmt", "attStmt", "authData"},
+		[]interface{}{"none", encodedMap{}, authData},
+	)
+
+	response := &webauthn.AttestationResponse{ID: webauthn.Encoding.EncodeToString(id), Type: "public-key"}
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", options.Challenge)
+	response.Response.AttestationObject = webauthn.Encoding.EncodeToString(attestation)
+	response.Response.Transports = []string{"internal"}
+	return response, nil
+}
+
+// Get signs in with the newest passkey, like navigator.credentials.get.
+func (a *Authenticator) Get(options *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	if len(a.credentials) == 0 {
+		return nil, errors.New("webauthntest: no passkeys registered")
+	}
+	cred := a.credentials[len(a.credentials)-1]
+	cred.signCount++
+
+	authData := a.authData(cred, 0)
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge)
+	rawClientData, _ := webauthn.Encoding.DecodeString(clientDataJSON)
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	response := &webauthn.AssertionResponse{ID: webauthn.Encoding.EncodeToString(cred.id), Type: "public-key"}
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AuthenticatorData = webauthn.Encoding.EncodeToString(authData)
+	response.Response.Signature = webauthn.Encoding.EncodeToString(signature)
+	response.Response.UserHandle = webauthn.Encoding.EncodeToString(cred.userHandle)
+	return response, nil
+}
+
+// SetSignCount rewinds or advances the newest passkey's counter, e.g. to
+// simulate a cloned authenticator.
+func (a *Authenticator) SetSignCount(count uint32) {
+	if len(a.credentials) > 0 {
+		a.credentials[len(a.credentials)-1].signCount = count
+	}
+}
+
+func (a *Authenticator) authData(cred *credential, extraFlags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	flags := byte(0x01) | extraFlags
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], cred.signCount)
+	return data
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) string {
+	raw, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return webauthn.Encoding.EncodeToString(raw)
+}
+
+// encodedMap is already-encoded CBOR, used for nested maps.
+type encodedMap []byte
+
+func encodeMap(keys, values []interface{}) []byte {
+	out := encodeHead(5, uint64(len(keys)))
+	for i := range keys {
+		out = append(out, encodeItem(keys[i])...)
+		out = append(out, encodeItem(values[i])...)
+	}
+	return out
+}
+
+func encodeItem(item interface{}) []byte {
+	switch v := item.(type) {
+	case int64:
+		if v >= 0 {
+			return encodeHead(0, uint64(v))
+		}
+		return encodeHead(1, uint64(-1-v))
+	case string:
+		return append(encodeHead(3, uint64(len(v))), v...)
+	case []byte:
+		return append(encodeHead(2, uint64(len(v))), v...)
+	case encodedMap:
+		if len(v) == 0 {
+			return encodeHead(5, 0)
+		}
+		return v
+	}
+	panic("webauthntest: cannot encode value")
+}
+
+func encodeHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	default:
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+}
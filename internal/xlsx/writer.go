@@ -0,0 +1,192 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Writer streams a workbook with a single worksheet. Rows are written as
+// they come, so large sheets are not held in memory.
+type Writer struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	rows    int
+	err     error
+}
+
+// NewWriter starts a workbook whose only sheet is named sheetName. Sheet
+// names are at most 31 characters and cannot contain []:*?/\.
+func NewWriter(w io.Writer, sheetName string) (*Writer, error) {
+	archive := zip.NewWriter(w)
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypesXML},
+		{"_rels/.rels", packageRelsXML},
+		{"xl/workbook.xml", fmt.Sprintf(workbookTemplate, escape(sheetName))},
+		{"xl/_rels/workbook.xml.rels", workbookRelsXML},
+		{"xl/styles.xml", stylesXML},
+	}
+	for _, part := range parts {
+		writer, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(writer, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	writer := &Writer{archive: archive, sheet: bufio.NewWriter(sheet)}
+	writer.write(sheetHeader)
+	return writer, writer.err
+}
+
+// WriteHeader writes a row of bold column headings.
+func (w *Writer) WriteHeader(headings ...string) error {
+	values := make([]interface{}, len(headings))
+	for i, heading := range headings {
+		values[i] = heading
+	}
+	return w.writeRow(values, styleBold)
+}
+
+// WriteRow writes the next row. Integers and floats become numeric cells,
+// bools become TRUE or FALSE, nil leaves the cell empty and anything else
+// is written as text.
+func (w *Writer) WriteRow(values ...interface{}) error {
+	return w.writeRow(values, 0)
+}
+
+func (w *Writer) writeRow(values []interface{}, style int) error {
+	if w.rows >= maxRow {
+		return ErrTooLarge
+	}
+	w.rows++
+	w.write(`<row r="` + strconv.Itoa(w.rows) + `">`)
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		ref := columnName(i) + strconv.Itoa(w.rows)
+		attrs := `r="` + ref + `"`
+		if style != 0 {
+			attrs += ` s="` + strconv.Itoa(style) + `"`
+		}
+
+		switch v := value.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			w.write(fmt.Sprintf(`<c %s><v>%d</v></c>`, attrs, v))
+		case float32, float64:
+			w.write(fmt.Sprintf(`<c %s><v>%v</v></c>`, attrs, v))
+		case bool:
+			bit := "0"
+			if v {
+				bit = "1"
+			}
+			w.write(`<c ` + attrs + ` t="b"><v>` + bit + `</v></c>`)
+		default:
+			w.write(`<c ` + attrs + ` t="inlineStr"><is><t xml:space="preserve">` + escape(fmt.Sprint(v)) + `</t></is></c>`)
+		}
+	}
+	w.write(`</row>`)
+	return w.err
+}
+
+// Close finishes the workbook. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.write(sheetFooter)
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.sheet.Flush(); err != nil {
+		return err
+	}
+	return w.archive.Close()
+}
+
+func (w *Writer) write(s string) {
+	if w.err == nil {
+		_, w.err = w.sheet.WriteString(s)
+	}
+}
+
+// columnName returns the letters of a zero-based column, e.g. 27 is "AB".
+func columnName(column int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		// Control characters other than tab and newline are not allowed
+		// in XML 1.0.
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			continue
+		}
+		switch r {
+		case '&':
+			b.WriteString("&amp;")
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '"':
+			b.WriteString("&quot;")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// styleBold is the cell format index of bold text in stylesXML.
+const styleBold = 1
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const contentTypesXML = xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+	`</Types>`
+
+const packageRelsXML = xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const workbookTemplate = xmlHeader + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>` +
+	`</workbook>`
+
+const workbookRelsXML = xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+	`</Relationships>`
+
+const stylesXML = xmlHeader + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`</styleSheet>`
+
+const sheetHeader = xmlHeader + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const sheetFooter = `</sheetData></worksheet>`
@@ -0,0 +1,273 @@
+// Package xlsx reads and writes the cell values of Office Open XML
+// spreadsheets (.xlsx). It covers what imports and exports need: reading
+// the first worksheet's shared, inline and plain values as text, and
+// streaming a single sheet of text, numbers and booleans. Formatting,
+// formulas and dates are not interpreted.
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalid is returned when the file is not a readable workbook.
+	ErrInvalid = errors.New("xlsx: not a valid workbook")
+	// ErrTooLarge is returned when a part of the workbook unpacks to more
+	// than MaxPartSize bytes.
+	ErrTooLarge = errors.New("xlsx: workbook is too large")
+)
+
+// MaxPartSize bounds how much of one workbook part is unpacked, so a small
+// compressed upload cannot expand without limit.
+const MaxPartSize = 32 << 20
+
+// maxColumn and maxRow bound cell references to Excel's own limits, so a
+// hostile reference cannot make ReadRows allocate without limit.
+const (
+	maxColumn = 16384
+	maxRow    = 1048576
+)
+
+// ReadRows returns the rows of the workbook's first worksheet. Each row
+// holds the cell values by column, with empty strings for gaps; trailing
+// empty rows are dropped.
+func ReadRows(data []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[strings.TrimPrefix(file.Name, "/")] = file
+	}
+
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, err
+	}
+	var sharedStrings []string
+	if file, ok := files["xl/sharedStrings.xml"]; ok {
+		if sharedStrings, err = readSharedStrings(file); err != nil {
+			return nil, err
+		}
+	}
+	file, ok := files[sheetPath]
+	if !ok {
+		return nil, ErrInvalid
+	}
+	return readSheet(file, sharedStrings)
+}
+
+// firstSheetPath finds the first worksheet through the workbook and its
+// relationships, falling back to the conventional name.
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	workbookFile, ok := files["xl/workbook.xml"]
+	relsFile, relsOK := files["xl/_rels/workbook.xml.rels"]
+	if !ok || !relsOK {
+		return fallback, nil
+	}
+	if err := decodePart(workbookFile, &workbook); err != nil {
+		return "", err
+	}
+	if err := decodePart(relsFile, &rels); err != nil {
+		return "", err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", ErrInvalid
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].ID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return fallback, nil
+}
+
+func readSharedStrings(file *zip.File) ([]string, error) {
+	var table struct {
+		Items []richText `xml:"si"`
+	}
+	if err := decodePart(file, &table); err != nil {
+		return nil, err
+	}
+	values := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		values[i] = item.String()
+	}
+	return values, nil
+}
+
+// richText is a string item: plain text, or runs of formatted text.
+type richText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (r richText) String() string {
+	if len(r.Runs) == 0 {
+		return r.Text
+	}
+	var text strings.Builder
+	for _, run := range r.Runs {
+		text.WriteString(run.Text)
+	}
+	return text.String()
+}
+
+func readSheet(file *zip.File, sharedStrings []string) ([][]string, error) {
+	var sheet struct {
+		Rows []struct {
+			Number int `xml:"r,attr"`
+			Cells  []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline richText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodePart(file, &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		// Rows without a number follow the previous one; numbered rows may
+		// skip empty ones.
+		number := row.Number
+		if number == 0 {
+			number = len(rows) + 1
+		}
+		if number < len(rows)+1 || number > maxRow {
+			return nil, ErrInvalid
+		}
+		for len(rows) < number-1 {
+			rows = append(rows, nil)
+		}
+
+		var values []string
+		for _, cell := range row.Cells {
+			column := len(values)
+			if cell.Ref != "" {
+				var ok bool
+				if column, ok = columnIndex(cell.Ref); !ok {
+					return nil, ErrInvalid
+				}
+			}
+			for len(values) < column {
+				values = append(values, "")
+			}
+
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index < 0 || index >= len(sharedStrings) {
+					return nil, ErrInvalid
+				}
+				value = sharedStrings[index]
+			case "inlineStr":
+				value = cell.Inline.String()
+			case "b":
+				if value == "1" {
+					value = "TRUE"
+				} else {
+					value = "FALSE"
+				}
+			}
+			if column < len(values) {
+				values[column] = value
+			} else {
+				values = append(values, value)
+			}
+		}
+		rows = append(rows, values)
+	}
+
+	for len(rows) > 0 && isEmpty(rows[len(rows)-1]) {
+		rows = rows[:len(rows)-1]
+	}
+	return rows, nil
+}
+
+// columnIndex returns the zero-based column of a cell reference like "C7".
+func columnIndex(ref string) (int, bool) {
+	column := 0
+	i := 0
+	for ; i < len(ref); i++ {
+		c := ref[i]
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		column = column*26 + int(c-'A'+1)
+		if column > maxColumn {
+			return 0, false
+		}
+	}
+	if i == 0 || i == len(ref) {
+		return 0, false
+	}
+	return column - 1, true
+}
+
+func isEmpty(row []string) bool {
+	for _, value := range row {
+		if value != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func decodePart(file *zip.File, v interface{}) error {
+	if file.UncompressedSize64 > MaxPartSize {
+		return ErrTooLarge
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return ErrInvalid
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, MaxPartSize+1))
+	if err != nil {
+		return ErrInvalid
+	}
+	if len(data) > MaxPartSize {
+		return ErrTooLarge
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func buildWorkbook(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range parts {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		writer.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to build workbook: %v", err)
+	}
+	return buf.Bytes()
+}
+
+const workbookXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Users" sheetId="1" r:id="rId3"/><sheet name="Other" sheetId="2" r:id="rId1"/></sheets>
+</workbook>`
+
+const relsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/users.xml"/>
+</Relationships>`
+
+func TestReadRows(t *testing.T) {
+	data := buildWorkbook(t, map[string]string{
+		"xl/workbook.xml":            workbookXML,
+		"xl/_rels/workbook.xml.rels": relsXML,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" count="3" uniqueCount="3">
+<si><t>name</t></si><si><t>email</t></si><si><r><t>Jane </t></r><r><rPr><b/></rPr><t>Doe</t></r></si>
+</sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData><row r="1"><c r="A1"><v>wrong sheet</v></c></row></sheetData></worksheet>`,
+		"xl/worksheets/users.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="s"><v>2</v></c><c r="C2" t="inlineStr"><is><t>jane@example.com</t></is></c></row>
+<row r="4"><c r="B4"><v>42</v></c><c r="C4" t="b"><v>1</v></c></row>
+<row r="5"></row>
+</sheetData></worksheet>`,
+	})
+
+	rows, err := ReadRows(data)
+	if err != nil {
+		t.Fatalf("ReadRows failed: %v", err)
+	}
+
+	want := [][]string{
+		{"name", "email"},
+		{"Jane Doe", "", "jane@example.com"},
+		nil,
+		{"", "42", "TRUE"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ReadRows() = %q, want %q", rows, want)
+	}
+}
+
+func TestReadRowsWithoutWorkbook(t *testing.T) {
+	data := buildWorkbook(t, map[string]string{
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row><c t="inlineStr"><is><t>a</t></is></c><c><v>1</v></c></row></sheetData></worksheet>`,
+	})
+
+	rows, err := ReadRows(data)
+	if err != nil {
+		t.Fatalf("ReadRows failed: %v", err)
+	}
+	if !reflect.DeepEqual(rows, [][]string{{"a", "1"}}) {
+		t.Errorf("Expected the conventional first sheet to be read, got %q", rows)
+	}
+}
+
+func TestReadRowsInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"not a zip": []byte("name,email\n"),
+		"bad shared string": buildWorkbook(t, map[string]string{
+			"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="s"><v>3</v></c></row></sheetData></worksheet>`,
+		}),
+		"huge column": buildWorkbook(t, map[string]string{
+			"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="ZZZZ1"><v>1</v></c></row></sheetData></worksheet>`,
+		}),
+		"rows out of order": buildWorkbook(t, map[string]string{
+			"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="2"></row><row r="1"></row></sheetData></worksheet>`,
+		}),
+		"no sheet": buildWorkbook(t, map[string]string{
+			"xl/styles.xml": `<styleSheet/>`,
+		}),
+	}
+
+	for name, data := range tests {
+		if _, err := ReadRows(data); err != ErrInvalid {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref    string
+		column int
+		ok     bool
+	}{
+		{"A1", 0, true},
+		{"z9", 25, true},
+		{"AA10", 26, true},
+		{"XFD1048576", 16383, true},
+		{"XFE1", 0, false},
+		{"12", 0, false},
+		{"B", 0, false},
+	}
+
+	for _, test := range tests {
+		column, ok := columnIndex(test.ref)
+		if column != test.column || ok != test.ok {
+			t.Errorf("columnIndex(%q) = %d, %v; want %d, %v", test.ref, column, ok, test.column, test.ok)
+		}
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewWriter(&buf, "Users")
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if err := writer.WriteHeader("Name", "Count", "Enabled"); err != nil {
+		t.Fatalf("WriteHeader failed: %v", err)
+	}
+	if err := writer.WriteRow("Tom & <Jerry> \"Ltd\"", 42, true); err != nil {
+		t.Fatalf("WriteRow failed: %v", err)
+	}
+	if err := writer.WriteRow(nil, 1.5, false, "  padded\x01"); err != nil {
+		t.Fatalf("WriteRow failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rows, err := ReadRows(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ReadRows failed: %v", err)
+	}
+	want := [][]string{
+		{"Name", "Count", "Enabled"},
+		{"Tom & <Jerry> \"Ltd\"", "42", "TRUE"},
+		{"", "1.5", "FALSE", "  padded"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Round trip = %q, want %q", rows, want)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA", 16383: "XFD"}
+	for column, want := range tests {
+		if got := columnName(column); got != want {
+			t.Errorf("columnName(%d) = %q, want %q", column, got, want)
+		}
+		if index, ok := columnIndex(want + "1"); !ok || index != column {
+			t.Errorf("columnIndex(%q) = %d, want %d", want+"1", index, column)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"alsafwanmarine.com/todo-app/internal/app"
+	"alsafwanmarine.com/todo-app/internal/config"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -68,14 +69,10 @@ func main() {
 	}
 	defer application.Close()
 
-	r := gin.Default()
-	
-	// Add a simple test endpoint that always works
-	r.GET("/test", func(c *gin.Context) {
-		c.String(200, "OK")
-	})
-	
-	application.SetupRoutes(r)
+	r, err := newRouter(application)
+	if err != nil {
+		log.Fatalf("Failed to configure routes: %v", err)
+	}
 
 	log.Printf("ASM Tracker routes configured successfully")
 	log.Printf("ASM Tracker User Management System starting on port %s", port)
@@ -84,3 +81,22 @@ func main() {
 		log.Fatalf("Failed to start server on port %s: %v", port, err)
 	}
 }
+
+// newRouter builds the engine serving the application.
+func newRouter(application *app.Application) (*gin.Engine, error) {
+	r := gin.Default()
+
+	// Client addresses feed the IP rules, rate limits and sign-in alerts,
+	// so forwarded addresses are only taken from known proxies.
+	if err := r.SetTrustedProxies(config.TrustedProxies()); err != nil {
+		return nil, err
+	}
+
+	// Add a simple test endpoint that always works
+	r.GET("/test", func(c *gin.Context) {
+		c.String(200, "OK")
+	})
+
+	application.SetupRoutes(r)
+	return r, nil
+}
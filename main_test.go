@@ -0,0 +1,525 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"alsafwanmarine.com/todo-app/internal/app"
+	"alsafwanmarine.com/todo-app/internal/models"
+	"alsafwanmarine.com/todo-app/internal/oidc/oidctest"
+	"github.com/gin-gonic/gin"
+)
+
+func setupTestApp(t *testing.T) (*app.Application, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	application, err := app.New(filepath.Join(t.TempDir(), "test.db"), templatesFS, staticFS)
+	if err != nil {
+		t.Fatalf("Failed to initialize application: %v", err)
+	}
+	t.Cleanup(func() { application.Close() })
+
+	r, err := newRouter(application)
+	if err != nil {
+		t.Fatalf("Failed to set up routes: %v", err)
+	}
+	return application, r
+}
+
+func findUser(t *testing.T, application *app.Application, email string) *models.User {
+	var user models.User
+	if err := application.Database.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		t.Fatalf("Failed to load %s: %v", email, err)
+	}
+	return &user
+}
+
+func TestImpersonationCannotChangeCredentials(t *testing.T) {
+	application, r := setupTestApp(t)
+
+	admin := findUser(t, application, "admin@example.com")
+	target := findUser(t, application, "sales1@alsafwanmarine.com")
+	session, token, err := application.SessionService.CreateImpersonationSession(target, admin, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateImpersonationSession failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		method, path, contentType, body string
+	}{
+		{http.MethodPost, "/profile/tokens", "application/x-www-form-urlencoded", "name=kept&scopes=users:read&expires_in=0"},
+		{http.MethodPost, "/profile/tokens/1/revoke", "application/x-www-form-urlencoded", ""},
+		{http.MethodPost, "/profile/passkeys/options", "application/json", "{}"},
+		{http.MethodPost, "/profile/passkeys", "application/json", `{"name":"Admin's key"}`},
+		{http.MethodPost, "/profile/passkeys/1/delete", "application/x-www-form-urlencoded", ""},
+		{http.MethodGet, "/profile/two-factor", "", ""},
+		{http.MethodPost, "/profile/two-factor", "application/x-www-form-urlencoded", "code=123456"},
+		{http.MethodPost, "/profile/two-factor/disable", "application/x-www-form-urlencoded", "password=Welcome@2024"},
+		{http.MethodPost, "/profile/sessions/revoke-others", "application/x-www-form-urlencoded", ""},
+		{http.MethodPost, "/profile/sessions/1/revoke", "application/x-www-form-urlencoded", ""},
+		{http.MethodPost, "/profile/password", "application/x-www-form-urlencoded", ""},
+		{http.MethodPost, "/profile/sso/asm/link", "application/x-www-form-urlencoded", ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		req.Header.Set("X-CSRF-Token", session.CSRFToken)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if tc.contentType == "application/json" {
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %s while impersonating = %d, want 403", tc.method, tc.path, w.Code)
+			}
+		} else if w.Code != http.StatusFound || w.Header().Get("Location") != "/profile" {
+			t.Errorf("%s %s while impersonating = %d to %q, want a redirect to /profile", tc.method, tc.path, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	var tokens, challenges int64
+	application.Database.DB.Model(&models.APIToken{}).Where("user_id = ?", target.ID).Count(&tokens)
+	application.Database.DB.Model(&models.PasskeyChallenge{}).Count(&challenges)
+	if tokens != 0 || challenges != 0 {
+		t.Errorf("Expected no token or passkey challenge for the impersonated user, got %d and %d", tokens, challenges)
+	}
+}
+
+func TestLogoutWhileImpersonatingEndsBothSessions(t *testing.T) {
+	application, r := setupTestApp(t)
+
+	admin := findUser(t, application, "admin@example.com")
+	target := findUser(t, application, "sales1@alsafwanmarine.com")
+	_, adminToken, err := application.SessionService.CreateSession(admin, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	result, err := application.ImpersonationService.Start(admin, adminToken, target, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set("X-CSRF-Token", result.Session.CSRFToken)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: result.Token})
+	req.AddCookie(&http.Cookie{Name: "impersonator_session_token", Value: adminToken})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/login" {
+		t.Fatalf("Expected a redirect to the login page, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	if application.AuthService.IsAuthenticated(result.Token) {
+		t.Error("Logging out should end the impersonated session")
+	}
+	if application.AuthService.IsAuthenticated(adminToken) {
+		t.Error("Logging out while impersonating should end the admin's own session")
+	}
+	var stopped int64
+	application.Database.DB.Model(&models.UserActivity{}).Where("activity_type = ? AND user_id = ?", "impersonation_stopped", admin.ID).Count(&stopped)
+	if stopped != 1 {
+		t.Errorf("Expected the end of the impersonation to be logged, got %d", stopped)
+	}
+}
+
+func TestSignInFormsRequireCSRFToken(t *testing.T) {
+	application, r := setupTestApp(t)
+
+	for _, tc := range []struct {
+		path, contentType, body string
+	}{
+		{"/login/two-factor", "application/x-www-form-urlencoded", "code=123456"},
+		{"/login/passkey/options", "application/json", "{}"},
+		{"/login/passkey", "application/json", "{}"},
+		{"/forgot-password", "application/x-www-form-urlencoded", "email=sales1@alsafwanmarine.com"},
+		{"/reset-password/abc", "application/x-www-form-urlencoded", "password=N3w!Password&confirm_password=N3w!Password"},
+		{"/invite/abc", "application/x-www-form-urlencoded", "password=N3w!Password&confirm_password=N3w!Password"},
+		{"/logout", "application/x-www-form-urlencoded", ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if tc.contentType == "application/json" {
+			if w.Code != http.StatusForbidden {
+				t.Errorf("POST %s without a CSRF token = %d, want 403", tc.path, w.Code)
+			}
+		} else if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+			t.Errorf("POST %s without a CSRF token = %d to %q, want it refused", tc.path, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	var resets int64
+	application.Database.DB.Model(&models.PasswordResetEvent{}).Count(&resets)
+	if resets != 0 {
+		t.Errorf("Expected no reset link to be sent without a CSRF token, got %d", resets)
+	}
+
+	user := findUser(t, application, "sales1@alsafwanmarine.com")
+	_, token, err := application.SessionService.CreateSession(user, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET /logout = %d, want 405 with Allow: POST", w.Code)
+	}
+	if !application.AuthService.IsAuthenticated(token) {
+		t.Error("GET /logout should not end the session")
+	}
+}
+
+func TestCSRFCookieFollowsSecureCookies(t *testing.T) {
+	for _, tc := range []struct {
+		setting string
+		secure  bool
+	}{
+		{"", true},
+		{"false", false},
+	} {
+		t.Setenv("SECURE_COOKIES", tc.setting)
+		_, r := setupTestApp(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/login", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var csrfCookie *http.Cookie
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "csrf_token" {
+				csrfCookie = cookie
+			}
+		}
+		if csrfCookie == nil {
+			t.Fatalf("SECURE_COOKIES=%q: expected the login page to set a CSRF cookie", tc.setting)
+		}
+		if csrfCookie.Secure != tc.secure {
+			t.Errorf("SECURE_COOKIES=%q: CSRF cookie Secure = %v, want %v", tc.setting, csrfCookie.Secure, tc.secure)
+		}
+	}
+}
+
+func TestInvitedUsersAreEnabledOnlyByAccepting(t *testing.T) {
+	application, r := setupTestApp(t)
+
+	admin := findUser(t, application, "admin@example.com")
+	// Admins have to enrol in two-factor authentication before anything else.
+	application.Database.DB.Model(admin).Update("totp_enabled", true)
+	session, token, err := application.SessionService.CreateSession(admin, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	invited := &models.User{Email: "new.manager@alsafwanmarine.com", Name: "New Manager", Role: models.RoleManager, CompanyID: admin.CompanyID}
+	if _, err := application.InvitationService.Invite(admin, invited, "127.0.0.1", "test-agent"); err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	path := fmt.Sprintf("/users/%d", invited.ID)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-CSRF-Token", session.CSRFToken)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The form has no enabled checkbox for invitees, and saving it must not
+	// count as disabling a manager.
+	w := post(path, "name=Renamed+Manager&email="+invited.Email+"&role=1&enabled=true")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != path {
+		t.Fatalf("Expected editing an invited manager to succeed, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	stored := findUser(t, application, invited.Email)
+	if stored.Name != "Renamed Manager" || stored.Enabled {
+		t.Errorf("Expected the edit to apply and leave the account disabled, got %q enabled=%v", stored.Name, stored.Enabled)
+	}
+
+	application.Database.DB.Model(stored).Update("role", models.RoleSalesperson)
+	post(path+"/toggle-status", "")
+	if stored = findUser(t, application, invited.Email); stored.Enabled {
+		t.Error("Enabling a user with a pending invitation should be refused")
+	}
+}
+
+func TestPasswordRevealTokenStaysOutOfURLs(t *testing.T) {
+	application, r := setupTestApp(t)
+
+	admin := findUser(t, application, "admin@example.com")
+	application.Database.DB.Model(admin).Update("totp_enabled", true)
+	session, token, err := application.SessionService.CreateSession(admin, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	target := findUser(t, application, "sales1@alsafwanmarine.com")
+	path := fmt.Sprintf("/users/%d", target.ID)
+
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-CSRF-Token", session.CSRFToken)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	form := "application/x-www-form-urlencoded"
+
+	// The reset answers with the reveal page itself rather than redirecting
+	// to a URL that carries the token.
+	w := post(path+"/reset-password", form, "reason=test")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the reveal page, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	match := regexp.MustCompile(`name="token" value="([0-9a-f]+)"`).FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatal("Expected the reveal token in a hidden form field")
+	}
+	revealToken := match[1]
+
+	w = post(path+"/password-reveal", form, "token="+revealToken)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "temporaryPassword") {
+		t.Fatalf("Expected the temporary password to be shown, got %d", w.Code)
+	}
+	if w = post(path+"/password-reveal", form, "token="+revealToken); w.Code != http.StatusFound {
+		t.Errorf("Expected the password to be shown only once, got %d", w.Code)
+	}
+
+	// The API hands out reveal tokens, never passwords.
+	json := "application/json"
+	w = post("/api/v1/users/bulk_reset_passwords", json, fmt.Sprintf(`{"user_ids":[%d],"reason":"test"}`, target.ID))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "password\"") {
+		t.Fatalf("Expected bulk reset to return reveal tokens only, got %d: %s", w.Code, w.Body.String())
+	}
+	match = regexp.MustCompile(`"reveal_token":"([0-9a-f]+)"`).FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("Expected a reveal token, got %s", w.Body.String())
+	}
+	w = post(fmt.Sprintf("/api/v1/users/%d/password_reveal", target.ID), json, `{"token":"`+match[1]+`"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "new_password") {
+		t.Fatalf("Expected the API to reveal the password, got %d: %s", w.Code, w.Body.String())
+	}
+	if w = post(path+"/password-reveal", form, "token="+match[1]); w.Code != http.StatusFound {
+		t.Errorf("Expected a used reveal token to be refused, got %d", w.Code)
+	}
+
+	var leaked int64
+	application.Database.DB.Model(&models.UserActivity{}).
+		Where("metadata LIKE ? OR metadata LIKE ?", "%"+revealToken+"%", "%"+match[1]+"%").
+		Count(&leaked)
+	if leaked != 0 {
+		t.Errorf("Expected no activity to record a reveal token, found %d", leaked)
+	}
+}
+
+func TestForwardedForIsNotTrusted(t *testing.T) {
+	application, r := setupTestApp(t)
+
+	role := models.RoleSalesperson
+	application.Database.DB.Create(&models.IPRule{Action: models.IPRuleDeny, CIDR: "192.0.2.0/24", Role: &role})
+	target := findUser(t, application, "sales1@alsafwanmarine.com")
+	_, token, err := application.SessionService.CreateSession(target, "10.1.2.3", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	request := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		req.Header.Set("User-Agent", "test-agent")
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request("10.1.2.3:4000", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected an allowed address to reach the page, got %d", w.Code)
+	}
+	if w := request("192.0.2.10:4000", "10.1.2.3"); w.Code == http.StatusOK {
+		t.Error("A spoofed X-Forwarded-For header should not get past a deny rule")
+	}
+}
+
+func TestSSOLinkFromProfile(t *testing.T) {
+	issuer := oidctest.NewIssuer("todo-app", "s3cret")
+	defer issuer.Close()
+	t.Setenv("SSO_PROVIDERS", "asm")
+	t.Setenv("SSO_ASM_ISSUER", issuer.URL)
+	t.Setenv("SSO_ASM_CLIENT_ID", "todo-app")
+	t.Setenv("SSO_ASM_CLIENT_SECRET", "s3cret")
+	application, r := setupTestApp(t)
+
+	user := findUser(t, application, "sales1@alsafwanmarine.com")
+	session, token, err := application.SessionService.CreateSession(user, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	issuer.SetClaims(map[string]interface{}{"sub": "sub-sales1", "email": user.Email, "email_verified": true})
+
+	req := httptest.NewRequest(http.MethodPost, "/profile/sso/asm/link", nil)
+	req.Header.Set("X-CSRF-Token", session.CSRFToken)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), issuer.URL) {
+		t.Fatalf("Expected a redirect to the provider, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	var stateCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "sso_state" {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("Expected the state to be bound to the browser")
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Authorization request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	req = httptest.NewRequest(http.MethodGet, resp.Header.Get("Location"), nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	req.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/profile" {
+		t.Fatalf("Expected the callback to return to the profile, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	identities, _ := application.SSOService.GetUserIdentities(user.ID)
+	if len(identities) != 1 || identities[0].Subject != "sub-sales1" {
+		t.Errorf("Expected the account to be linked to sub-sales1, got %+v", identities)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Linked to "+user.Email) {
+		t.Errorf("Expected the profile to show the linked identity, got %d", w.Code)
+	}
+}
+
+func TestAPIRequiresPasswordChange(t *testing.T) {
+	application, r := setupTestApp(t)
+
+	user := findUser(t, application, "sales1@alsafwanmarine.com")
+	application.Database.DB.Model(user).Update("must_change_password", true)
+	_, token, err := application.SessionService.CreateSession(user, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request(http.MethodGet, "/api/v1/auth/me", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "password_change_required") {
+		t.Errorf("Expected 403 before the password is changed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodGet, "/api/v1/activities/users/1", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for other endpoints, got %d", w.Code)
+	}
+	if w := request(http.MethodPatch, "/api/v1/auth/password", `{"current_password":"Welcome@2024","new_password":"N3w!Passw0rd#"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the password change to be allowed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodGet, "/api/v1/auth/me", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the API to be usable after the change, got %d", w.Code)
+	}
+}
+
+func TestRateLimitCountsUnacceptedTokensByIP(t *testing.T) {
+	t.Setenv("RATE_LIMIT_AUTHENTICATED", "3/1m")
+	application, r := setupTestApp(t)
+
+	user := findUser(t, application, "sales1@alsafwanmarine.com")
+	_, token, err := application.APITokenService.CreateToken(user, "Script", []string{models.ScopeUsersRead}, nil, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	request := func(bearer string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/me", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Every made-up token is counted against the same address.
+	for i := 0; i < 3; i++ {
+		if code := request(fmt.Sprintf("asm_madeup%d", i)); code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for a made-up token, got %d", code)
+		}
+	}
+	if code := request("asm_madeup3"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected made-up tokens to share the address's limit, got %d", code)
+	}
+
+	// An accepted token has its own allowance.
+	if code := request(token); code != http.StatusOK {
+		t.Errorf("Expected a valid token to be counted on its own, got %d", code)
+	}
+}
+
+func TestRememberedSessionBindsCSRFToken(t *testing.T) {
+	application, r := setupTestApp(t)
+
+	user := findUser(t, application, "sales1@alsafwanmarine.com")
+	_, _, rememberToken, err := application.SessionService.CreateRememberedSession(user, "127.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatalf("CreateRememberedSession failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.AddCookie(&http.Cookie{Name: "remember_token", Value: rememberToken})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the remembered device to reach the profile, got %d", w.Code)
+	}
+
+	var sessionToken string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session_token" {
+			sessionToken = cookie.Value
+		}
+	}
+	session, err := application.SessionService.GetSessionByToken(sessionToken)
+	if err != nil || session == nil {
+		t.Fatalf("Expected a new session for the remembered device: %v", err)
+	}
+
+	// Forms on the first page must carry the new session's token, or the
+	// next POST is rejected as expired.
+	if !strings.Contains(w.Body.String(), session.CSRFToken) {
+		t.Error("Expected the page to use the resumed session's CSRF token")
+	}
+}
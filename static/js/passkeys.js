@@ -0,0 +1,84 @@
+// ASM Tracker - Passkey registration and sign-in
+
+window.Passkeys = (function() {
+    function toBuffer(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const padded = base64 + '='.repeat((4 - base64.length % 4) % 4);
+        return Uint8Array.from(atob(padded), c => c.charCodeAt(0)).buffer;
+    }
+
+    function toBase64URL(buffer) {
+        if (!buffer) {
+            return '';
+        }
+        const bytes = new Uint8Array(buffer);
+        let binary = '';
+        bytes.forEach(b => binary += String.fromCharCode(b));
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function csrfToken() {
+        const meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.content : '';
+    }
+
+    async function postJSON(url, body) {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken()},
+            credentials: 'same-origin',
+            body: body ? JSON.stringify(body) : '{}'
+        });
+        const data = await response.json().catch(() => ({}));
+        if (!response.ok) {
+            throw new Error(data.error || 'Request failed');
+        }
+        return data;
+    }
+
+    return {
+        supported: function() {
+            return window.PublicKeyCredential !== undefined && navigator.credentials !== undefined;
+        },
+
+        // register adds a passkey to the signed-in user's account.
+        register: async function(name) {
+            const options = await postJSON('/profile/passkeys/options');
+            options.challenge = toBuffer(options.challenge);
+            options.user.id = toBuffer(options.user.id);
+            options.excludeCredentials.forEach(c => c.id = toBuffer(c.id));
+
+            const credential = await navigator.credentials.create({publicKey: options});
+            return postJSON('/profile/passkeys', {
+                name: name,
+                credential: {
+                    id: credential.id,
+                    type: credential.type,
+                    response: {
+                        clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                        attestationObject: toBase64URL(credential.response.attestationObject),
+                        transports: credential.response.getTransports ? credential.response.getTransports() : []
+                    }
+                }
+            });
+        },
+
+        // signIn lets the user pick one of their passkeys for this site.
+        signIn: async function() {
+            const options = await postJSON('/login/passkey/options');
+            options.challenge = toBuffer(options.challenge);
+
+            const credential = await navigator.credentials.get({publicKey: options});
+            return postJSON('/login/passkey', {
+                id: credential.id,
+                type: credential.type,
+                response: {
+                    clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                    authenticatorData: toBase64URL(credential.response.authenticatorData),
+                    signature: toBase64URL(credential.response.signature),
+                    userHandle: toBase64URL(credential.response.userHandle)
+                }
+            });
+        }
+    };
+})();